@@ -0,0 +1,143 @@
+// Package auth signs users in
+//
+// passwords are stored as bcrypt hashes. a user who logs in gets a short lived access
+// token, a JWT signed by the Issuer, and a refresh token. refresh tokens are random and
+// only their hash is stored, each one can be exchanged for a new pair of tokens once.
+// the tokens issued for one login form a family, reusing a spent refresh token
+// revokes the whole family because it may have been stolen.
+//
+// scripts that cannot log in use personal access tokens instead, random tokens that
+// start with PersonalTokenPrefix and are stored as a hash like refresh tokens
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+)
+
+// bcrypt ignores everything after the first 72 bytes of a password
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 72
+)
+
+type (
+	userKey  struct{}
+	ownerKey struct{}
+)
+
+// WithUser returns a copy of ctx that is signed in as the user userID
+func WithUser(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userKey{}, userID)
+}
+
+// UserID returns the user ctx is signed in as, or "" for anonymous requests
+func UserID(ctx context.Context) string {
+	userID, _ := ctx.Value(userKey{}).(string)
+	return userID
+}
+
+// WithOwner returns a copy of ctx that reaches the data of owner rather than the data of its user,
+// it is how a collaborator works on a project that was shared with them
+func WithOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, ownerKey{}, owner)
+}
+
+// Owner returns the user whose data ctx reaches, the one set by WithOwner or else UserID
+//
+// the stores only read and write the data owned by this user, anonymous requests share the data owned by ""
+func Owner(ctx context.Context) string {
+	owner, ok := ctx.Value(ownerKey{}).(string)
+	if !ok {
+		return UserID(ctx)
+	}
+	return owner
+}
+
+// HashPassword returns the bcrypt hash of password
+//
+// - returns errs.ErrValidation if password is too short or too long
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return "", fmt.Errorf("%w: password has to be %d to %d bytes long", errs.ErrValidation, MinPasswordLength, MaxPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// dummyHash is compared against when there is no user to compare against,
+// so that a login takes as long whether or not the user exists
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// CheckPassword returns errs.ErrUnauthorized unless password matches hash
+//
+// - an empty hash never matches, but takes as long to check as one that does not match
+func CheckPassword(hash, password string) error {
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return fmt.Errorf("%w: wrong email or password", errs.ErrUnauthorized)
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return fmt.Errorf("%w: wrong email or password", errs.ErrUnauthorized)
+	}
+	return err
+}
+
+// PersonalTokenPrefix starts every personal access token, it tells them apart
+// from access tokens and makes a leaked one easy to spot
+const PersonalTokenPrefix = "tdpat_"
+
+// NewRefreshToken returns a random refresh token and the hash it is stored under
+func NewRefreshToken() (token, hash string, err error) {
+	token, err = randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashToken(token), nil
+}
+
+// NewPersonalToken returns a random personal access token and the hash it is stored under
+func NewPersonalToken() (token, hash string, err error) {
+	token, err = randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	token = PersonalTokenPrefix + token
+	return token, HashToken(token), nil
+}
+
+// IsPersonalToken reports whether token is a personal access token rather than an access token
+func IsPersonalToken(token string) bool {
+	return strings.HasPrefix(token, PersonalTokenPrefix)
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hash a refresh token or personal access token is stored under
+//
+// the tokens are random, so a plain sha256 is enough
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+)
+
+var now = time.Date(2025, 1, 6, 8, 30, 0, 0, time.UTC)
+
+func newIssuer(secret string) *Issuer {
+	issuer := NewIssuer([]byte(secret), DefaultAccessTTL, DefaultRefreshTTL)
+	issuer.Now = func() time.Time { return now }
+	return issuer
+}
+
+func TestSignVerify(t *testing.T) {
+	issuer := newIssuer("secret")
+	token, expiresAt, err := issuer.Sign("ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expiresAt.Equal(now.Add(DefaultAccessTTL)) {
+		t.Errorf("token expires at %s", expiresAt)
+	}
+
+	claims, err := issuer.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "ada" || claims.IssuedAt != now.Unix() || claims.ExpiresAt != expiresAt.Unix() {
+		t.Errorf("claims are %+v", claims)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	issuer := newIssuer("secret")
+	token, _, err := issuer.Sign("ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	other, _, err := newIssuer("another secret").Sign("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, token := range map[string]string{
+		"empty":          "",
+		"not a jwt":      "abc",
+		"other secret":   other,
+		"alg none":       "eyJhbGciOiJub25lIiwidHlwIjoiSldUIn0." + parts[1] + ".",
+		"other payload":  parts[0] + "." + strings.Split(other, ".")[1] + "." + parts[2],
+		"no signature":   parts[0] + "." + parts[1] + ".",
+		"garbage claims": parts[0] + ".e30." + parts[2],
+	} {
+		_, err := issuer.Verify(token)
+		if !errors.Is(err, errs.ErrUnauthorized) {
+			t.Errorf("%s: err is %v, want errs.ErrUnauthorized", name, err)
+		}
+	}
+
+	issuer.Now = func() time.Time { return now.Add(DefaultAccessTTL) }
+	_, err = issuer.Verify(token)
+	if !errors.Is(err, errs.ErrUnauthorized) {
+		t.Errorf("expired token: err is %v, want errs.ErrUnauthorized", err)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckPassword(hash, "correct horse"); err != nil {
+		t.Errorf("correct password: %v", err)
+	}
+	if err := CheckPassword(hash, "wrong horse"); !errors.Is(err, errs.ErrUnauthorized) {
+		t.Errorf("wrong password: err is %v, want errs.ErrUnauthorized", err)
+	}
+	if err := CheckPassword("", "correct horse"); !errors.Is(err, errs.ErrUnauthorized) {
+		t.Errorf("no hash: err is %v, want errs.ErrUnauthorized", err)
+	}
+
+	for _, password := range []string{"short", strings.Repeat("x", MaxPasswordLength+1)} {
+		_, err := HashPassword(password)
+		if !errors.Is(err, errs.ErrValidation) {
+			t.Errorf("password of %d bytes: err is %v, want errs.ErrValidation", len(password), err)
+		}
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	token, hash, err := NewRefreshToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := NewRefreshToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == other {
+		t.Error("two refresh tokens are the same")
+	}
+	if hash != HashToken(token) || hash == token {
+		t.Errorf("hash of %q is %q", token, hash)
+	}
+}
+
+func TestPersonalToken(t *testing.T) {
+	token, hash, err := NewPersonalToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsPersonalToken(token) || !strings.HasPrefix(token, PersonalTokenPrefix) {
+		t.Errorf("%q is not a personal access token", token)
+	}
+	if hash != HashToken(token) {
+		t.Errorf("hash of %q is %q", token, hash)
+	}
+
+	accessToken, _, err := newIssuer("secret").Sign("ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsPersonalToken(accessToken) {
+		t.Errorf("access token %q is a personal access token", accessToken)
+	}
+}
+
+func TestOwner(t *testing.T) {
+	ctx := WithUser(context.Background(), "ada")
+	if owner := Owner(ctx); owner != "ada" {
+		t.Errorf("owner is %q, want the user", owner)
+	}
+
+	ctx = WithOwner(ctx, "bob")
+	if owner, userID := Owner(ctx), UserID(ctx); owner != "bob" || userID != "ada" {
+		t.Errorf("owner is %q and user %q", owner, userID)
+	}
+	if owner := Owner(WithOwner(ctx, "")); owner != "" {
+		t.Errorf("owner is %q, want the anonymous owner", owner)
+	}
+}
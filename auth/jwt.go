@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+)
+
+const (
+	DefaultAccessTTL  = 15 * time.Minute
+	DefaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+// header is the only JWT header the Issuer signs and accepts
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Claims are the claims of an access token
+type Claims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Issuer signs and verifies access tokens, JWTs signed with HMAC-SHA256
+type Issuer struct {
+	Secret     []byte
+	AccessTTL  time.Duration    // how long an access token is valid for
+	RefreshTTL time.Duration    // how long a refresh token is valid for
+	Now        func() time.Time // defaults to time.Now
+}
+
+// NewIssuer returns an Issuer that signs tokens with secret
+func NewIssuer(secret []byte, accessTTL, refreshTTL time.Duration) *Issuer {
+	return &Issuer{
+		Secret:     secret,
+		AccessTTL:  accessTTL,
+		RefreshTTL: refreshTTL,
+		Now:        time.Now,
+	}
+}
+
+// RandomSecret returns a secret for an Issuer whose tokens only have to last until the process exits
+func RandomSecret() []byte {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+// Sign returns an access token for the user userID and the time it expires
+func (iss *Issuer) Sign(userID string) (string, time.Time, error) {
+	now := iss.Now()
+	expiresAt := now.Add(iss.AccessTTL)
+
+	claims, err := json.Marshal(Claims{Subject: userID, IssuedAt: now.Unix(), ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return unsigned + "." + iss.signature(unsigned), expiresAt, nil
+}
+
+// Verify returns the claims of token
+//
+// - returns errs.ErrUnauthorized if token is malformed, was not signed by the Issuer or has expired
+func (iss *Issuer) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != header {
+		return Claims{}, fmt.Errorf("%w: malformed access token", errs.ErrUnauthorized)
+	}
+	unsigned := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(iss.signature(unsigned))) {
+		return Claims{}, fmt.Errorf("%w: invalid access token signature", errs.ErrUnauthorized)
+	}
+
+	encoded, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed access token: %w", errs.ErrUnauthorized, err)
+	}
+	claims := Claims{}
+	err = json.Unmarshal(encoded, &claims)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed access token: %w", errs.ErrUnauthorized, err)
+	}
+	if claims.Subject == "" {
+		return Claims{}, fmt.Errorf("%w: access token has no subject", errs.ErrUnauthorized)
+	}
+	if iss.Now().Unix() >= claims.ExpiresAt {
+		return Claims{}, fmt.Errorf("%w: access token has expired", errs.ErrUnauthorized)
+	}
+	return claims, nil
+}
+
+func (iss *Issuer) signature(unsigned string) string {
+	mac := hmac.New(sha256.New, iss.Secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
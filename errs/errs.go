@@ -1,9 +1,29 @@
 package errs
 
+/*
+* the data stores wrap their driver errors into one of these
+* so that the server can map them onto a status code without knowing
+* which database is behind the TodoStore
+*
+* e.g. fmt.Errorf("%w: %w", errs.ErrNotFound, sql.ErrNoRows)
+*
+* errors.Is(err, errs.ErrNotFound) will still match after wrapping
+ */
 const (
-	ErrNotFound       = TodoErr("cannot find todo user that user has specified")
-	ErrIdAlreadyInUse = TodoErr("unexpected error: ID is already in use. to prevent unintentional overwrite, we have blocked this request")
-	ErrEnvVarNotFound = TodoErr("cannot find environment variable, please check .env file")
+	ErrNotFound           = TodoErr("cannot find todo user that user has specified")
+	ErrIdAlreadyInUse     = TodoErr("unexpected error: ID is already in use. to prevent unintentional overwrite, we have blocked this request")
+	ErrEnvVarNotFound     = TodoErr("cannot find environment variable, please check .env file")
+	ErrInvalidID          = TodoErr("the ID specified is not a valid ID")
+	ErrValidation         = TodoErr("the request failed validation")
+	ErrConflict           = TodoErr("the request conflicts with an existing resource")
+	ErrPreconditionFailed = TodoErr("the resource has been modified since it was last fetched")
+	ErrUnavailable        = TodoErr("the data store is currently unavailable, please try again later")
+	ErrAborted            = TodoErr("the operation was rolled back because another operation in the batch failed")
+	ErrIdempotencyReused  = TodoErr("the idempotency key was already used for a different request")
+	ErrUnauthorized       = TodoErr("the request is not signed in")
+	ErrForbidden          = TodoErr("the request is signed in but not allowed to do this")
+	ErrInvalidFields      = TodoErr("the request has invalid fields")
+	ErrUnsupportedMedia   = TodoErr("the request body is not in a media type the endpoint accepts")
 )
 
 type TodoErr string
@@ -0,0 +1,130 @@
+// Package history builds the entries of the change history of todos and projects
+//
+// the stores record an entry for every change made through the server, in the same
+// transaction as the change where they can. they load the todo or project before and
+// after the change and Diff works out which fields changed. an entry belongs to the
+// owner of the context, like the todo or project it is about
+package history
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// Anonymous is the actor of changes made by requests that are not attributed to anyone
+const Anonymous = "anonymous"
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx whose changes are recorded as made by actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the actor of ctx, or Anonymous
+func Actor(ctx context.Context) string {
+	actor, ok := ctx.Value(actorKey{}).(string)
+	if !ok || actor == "" {
+		return Anonymous
+	}
+	return actor
+}
+
+// ignored fields change on every write or are derived from other fields,
+// the tasks of a project have their own history
+var (
+	ignoredTodoFields    = []string{"_id", "id", "version", "updated_at", "progress"}
+	ignoredProjectFields = []string{"_id", "id", "version", "tasks"}
+)
+
+// TodoEntry records the change of the todo ID from before to after
+//
+// before is the zero value for a create
+func TodoEntry(ctx context.Context, operation, ID string, before, after models.TODO) (models.HistoryEntry, error) {
+	return entry(ctx, models.HistoryTodo, operation, ID, before, after, ignoredTodoFields)
+}
+
+// ProjectEntry records the change of the project ID from before to after
+//
+// before is the zero value for a create
+func ProjectEntry(ctx context.Context, operation, ID string, before, after models.PROJECT) (models.HistoryEntry, error) {
+	return entry(ctx, models.HistoryProject, operation, ID, before, after, ignoredProjectFields)
+}
+
+func entry(ctx context.Context, kind, operation, ID string, before, after any, ignored []string) (models.HistoryEntry, error) {
+	changes, err := Diff(before, after, ignored...)
+	if err != nil {
+		return models.HistoryEntry{}, err
+	}
+	return models.HistoryEntry{
+		Kind:      kind,
+		EntityID:  ID,
+		Operation: operation,
+		Actor:     Actor(ctx),
+		Time:      time.Now().UTC(),
+		Changes:   changes,
+		Owner:     auth.Owner(ctx),
+	}, nil
+}
+
+// Diff compares the json encodings of before and after field by field
+//
+// - the fields in ignored are left out
+// - the changes are sorted by field name
+func Diff(before, after any, ignored ...string) ([]models.FieldChange, error) {
+	beforeFields, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name := range beforeFields {
+		names = append(names, name)
+	}
+	for name := range afterFields {
+		if _, ok := beforeFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	changes := []models.FieldChange{}
+	for _, name := range names {
+		if slices.Contains(ignored, name) {
+			continue
+		}
+		b, a := orNull(beforeFields[name]), orNull(afterFields[name])
+		if bytes.Equal(b, a) {
+			continue
+		}
+		changes = append(changes, models.FieldChange{Field: name, Before: b, After: a})
+	}
+	return changes, nil
+}
+
+func fields(v any) (map[string]json.RawMessage, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	err = json.Unmarshal(encoded, &fields)
+	return fields, err
+}
+
+func orNull(value json.RawMessage) json.RawMessage {
+	if value == nil {
+		return json.RawMessage("null")
+	}
+	return value
+}
@@ -0,0 +1,64 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+func TestTodoEntry(t *testing.T) {
+	dueDate := time.Date(2025, 1, 6, 8, 30, 0, 0, time.UTC)
+	before := models.TODO{Id: 1, Name: "Dentist", DueDate: &dueDate, Version: 1}
+	after := before
+	after.Name = "Dentist appointment"
+	after.Completed = true
+	after.Version = 2
+
+	entry, err := TodoEntry(WithActor(context.Background(), "ada"), models.OpUpdate, "1", before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Kind != models.HistoryTodo || entry.EntityID != "1" || entry.Operation != models.OpUpdate || entry.Actor != "ada" {
+		t.Errorf("entry is %+v", entry)
+	}
+
+	// the version always changes, it is left out
+	want := []models.FieldChange{
+		{Field: "completed", Before: json.RawMessage(`false`), After: json.RawMessage(`true`)},
+		{Field: "name", Before: json.RawMessage(`"Dentist"`), After: json.RawMessage(`"Dentist appointment"`)},
+	}
+	if got, _ := json.Marshal(entry.Changes); string(got) != string(mustMarshal(t, want)) {
+		t.Errorf("changes are %s, want %s", got, mustMarshal(t, want))
+	}
+}
+
+func TestDiffCreate(t *testing.T) {
+	changes, err := Diff(models.PROJECT{}, models.PROJECT{Id: 1, ProjName: "Home", Version: 1}, ignoredProjectFields...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Field != "projname" || string(changes[0].Before) != `""` || string(changes[0].After) != `"Home"` {
+		t.Errorf("changes are %+v", changes)
+	}
+}
+
+func TestActor(t *testing.T) {
+	if actor := Actor(context.Background()); actor != Anonymous {
+		t.Errorf("actor without one in the context is %q", actor)
+	}
+	if actor := Actor(WithActor(context.Background(), "ada")); actor != "ada" {
+		t.Errorf("actor is %q, want ada", actor)
+	}
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
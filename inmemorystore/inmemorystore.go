@@ -1,8 +1,16 @@
 package inmemorystore
 
 import (
+	"cmp"
+	"context"
+	"slices"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+
 	"github.com/ganglinwu/todoapp-backend-v1/errs"
 	"github.com/ganglinwu/todoapp-backend-v1/models"
+	"github.com/ganglinwu/todoapp-backend-v1/textsearch"
 )
 
 type InMemoryStore struct {
@@ -65,3 +73,56 @@ func (i *InMemoryStore) DeleteTodoByID(ID string) error {
 	}
 	return errs.ErrNotFound
 }
+
+// Search is a simple fallback for stores without a full-text index
+//
+// - the query and descriptions are split into words with textsearch.Tokenize
+// - todos are ranked by textsearch.Score, todos that do not match are left out
+// - every hit is a todo, the store has no projects so the Project of a hit is empty
+// - returns at most limit hits
+func (i *InMemoryStore) Search(ctx context.Context, query string, limit int) ([]models.SearchHit, error) {
+	terms := textsearch.Tokenize(query)
+
+	type hit struct {
+		key string
+		hit models.SearchHit
+	}
+	hits := []hit{}
+
+	for key, value := range i.Store {
+		score := textsearch.Score(terms, value)
+		if score > 0 {
+			todo := mockTodo(key, value)
+			hits = append(hits, hit{key, models.SearchHit{Kind: models.SearchHitTodo, Score: score, Todo: &todo}})
+		}
+	}
+
+	// sort by score, then by ID so that results do not depend on map order
+	slices.SortFunc(hits, func(a, b hit) int {
+		if c := cmp.Compare(b.hit.Score, a.hit.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.key, b.key)
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	searchHits := []models.SearchHit{}
+	for _, h := range hits {
+		searchHits = append(searchHits, h.hit)
+	}
+	return searchHits, nil
+}
+
+// mockTodo turns a stored description into a models.TODO,
+// an ID that is a mongodb or postgresql id becomes the id of the todo
+func mockTodo(ID, description string) models.TODO {
+	todo := models.TODO{Description: description}
+	if objID, err := bson.ObjectIDFromHex(ID); err == nil {
+		todo.ID = &objID
+	} else if intID, err := strconv.Atoi(ID); err == nil {
+		todo.Id = intID
+	}
+	return todo
+}
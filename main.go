@@ -3,17 +3,24 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"net/smtp"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
 	"github.com/ganglinwu/todoapp-backend-v1/mongostore"
 	"github.com/ganglinwu/todoapp-backend-v1/postgres_store"
+	"github.com/ganglinwu/todoapp-backend-v1/reminder"
 	"github.com/ganglinwu/todoapp-backend-v1/server"
+	"github.com/ganglinwu/todoapp-backend-v1/trash"
+	"github.com/ganglinwu/todoapp-backend-v1/webhook"
 )
 
 func main() {
@@ -23,10 +30,51 @@ func main() {
 	mongodbname := flag.String("mongoDBname", "", "mongoDB database name")
 	mongocollectionname := flag.String("mongoCollection", "", "mongoDB collecton name")
 	postgresDSN := flag.String("postgresDSN", "", "postgreSQL DSN")
+	notifierName := flag.String("notifier", "log", "reminder notifier: log, webhook or smtp, webhook sends reminder.due events to the webhooks of the owner of each todo and smtp emails the owner")
+	smtpAddr := flag.String("smtpAddr", "", "host:port of the smtp notifier's mail server, SMTP_USERNAME and SMTP_PASSWORD are used to log in when set")
+	smtpFrom := flag.String("smtpFrom", "", "sender address of reminder emails")
+	smtpTo := flag.String("smtpTo", "", "comma separated recipients of the reminder emails of todos without an owner, created while sign in was off")
+	checkDueDates := flag.Bool("checkDueDates", false, "reject todos created or updated with a due date in the past")
+	reminderInterval := flag.Duration("reminderInterval", 30*time.Second, "how often due reminders are checked")
+	trashRetention := flag.Duration("trashRetention", 30*24*time.Hour, "how long deleted todos and projects are kept in the trash")
+	purgeInterval := flag.Duration("purgeInterval", time.Hour, "how often the trash is purged")
+	accessTTL := flag.Duration("accessTTL", auth.DefaultAccessTTL, "how long an access token is valid for, JWT_SECRET is used to sign them")
+	refreshTTL := flag.Duration("refreshTTL", auth.DefaultRefreshTTL, "how long a refresh token is valid for")
+	corsOrigins := flag.String("corsOrigins", envOr("CORS_ORIGINS", strings.Join(server.DefaultCORSConfig.AllowedOrigins, ",")), "comma separated origins allowed to call the api, https://*.example.com allows the subdomains of example.com and * every origin, defaults to CORS_ORIGINS")
+	corsMethods := flag.String("corsMethods", envOr("CORS_METHODS", strings.Join(server.DefaultCORSConfig.AllowedMethods, ",")), "comma separated methods cross-origin requests may use, defaults to CORS_METHODS")
+	corsHeaders := flag.String("corsHeaders", envOr("CORS_HEADERS", strings.Join(server.DefaultCORSConfig.AllowedHeaders, ",")), "comma separated headers cross-origin requests may send, * allows every header, defaults to CORS_HEADERS")
+	corsCredentials := flag.Bool("corsCredentials", os.Getenv("CORS_CREDENTIALS") == "true", "let cross-origin requests send cookies and HTTP authentication, defaults to CORS_CREDENTIALS")
+	corsMaxAge := flag.Duration("corsMaxAge", envDuration("CORS_MAX_AGE", server.DefaultCORSConfig.MaxAge), "how long browsers may cache a preflight response, defaults to CORS_MAX_AGE")
 
 	flag.Parse()
 
+	cors := server.CORSConfig{
+		AllowedOrigins:   splitList(*corsOrigins),
+		AllowedMethods:   splitList(strings.ToUpper(*corsMethods)),
+		AllowedHeaders:   splitList(*corsHeaders),
+		AllowCredentials: *corsCredentials,
+		MaxAge:           *corsMaxAge,
+	}
+	if cors.AllowCredentials && slices.Contains(cors.AllowedOrigins, "*") {
+		log.Fatal("-corsCredentials cannot be used with -corsOrigins *, every website could make requests as the signed in user")
+	}
+	options := []server.Option{server.WithCORS(cors)}
+	if *checkDueDates {
+		options = append(options, server.WithDueDateCheck())
+	}
+
+	issuer := auth.NewIssuer([]byte(os.Getenv("JWT_SECRET")), *accessTTL, *refreshTTL)
+	if len(issuer.Secret) == 0 {
+		log.Println("JWT_SECRET is not set, access tokens will stop working when the server restarts")
+		issuer.Secret = auth.RandomSecret()
+	}
+	options = append(options, server.WithAuth(issuer))
+
 	handler := &server.TodoServer{}
+	var reminderStore reminder.Store
+	var users reminder.Users
+	var trashStore trash.Store
+	var webhooks *webhook.Dispatcher
 
 	switch strings.ToLower(*datastore) {
 	case "mongo":
@@ -45,7 +93,16 @@ func main() {
 		store.Conn = conn
 		store.Collection = conn.Database(*dbName).Collection(*collName)
 
-		handler = server.NewTodoServer(store)
+		err = store.EnsureIndexes()
+		if err != nil {
+			log.Fatal("error creating mongo indexes: ", err)
+		}
+
+		webhooks = webhook.NewDispatcher(store)
+		handler = server.NewTodoServer(store, append(options, server.WithEvents(webhooks))...)
+		reminderStore = store
+		users = store
+		trashStore = store
 	case "postgres":
 		db, err := postgres_store.NewConnection(*postgresDSN)
 		if err != nil {
@@ -58,18 +115,41 @@ func main() {
 			log.Fatal("error sending PING to postgres DB: ", err)
 		}
 		newPostgresStore := &postgres_store.PostGresStore{DB: db}
-		handler = server.NewTodoServer(newPostgresStore)
+
+		err = newPostgresStore.Migrate()
+		if err != nil {
+			log.Fatal("error migrating postgres schema: ", err)
+		}
+		webhooks = webhook.NewDispatcher(newPostgresStore)
+		handler = server.NewTodoServer(newPostgresStore, append(options, server.WithEvents(webhooks))...)
+		reminderStore = newPostgresStore
+		users = newPostgresStore
+		trashStore = newPostgresStore
 
 	default:
 		log.Fatalf("the datastore %s, is not supported \n", *datastore)
 	}
+
+	notifier, err := newNotifier(*notifierName, webhooks, users, *smtpAddr, *smtpFrom, *smtpTo)
+	if err != nil {
+		log.Fatal("error initializing reminder notifier: ", err)
+	}
 	s := http.Server{
 		Addr:              *addr,
 		Handler:           handler,
 		ReadHeaderTimeout: 1 * time.Second,
-		WriteTimeout:      2 * time.Second,
+		// "GET /events" clears its own write deadline, the timeout still applies everywhere else
+		WriteTimeout: 2 * time.Second,
 		// ErrorLog errLogger,
 	}
+	// event streams never end on their own, Shutdown would wait for them until its deadline
+	s.RegisterOnShutdown(handler.CloseEventStreams)
+
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go reminder.NewScheduler(reminderStore, notifier, *reminderInterval).Run(backgroundCtx)
+	go trash.NewPurger(trashStore, *trashRetention, *purgeInterval).Run(backgroundCtx)
+	go webhooks.Run(backgroundCtx)
 
 	go func() {
 		err := s.ListenAndServe()
@@ -84,8 +164,69 @@ func main() {
 
 	sig := <-sigChan
 	log.Println("received terminate, shutting down gracefully. Signal received:", sig)
+	stopBackground()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 	s.Shutdown(ctx)
 }
+
+// newNotifier builds the reminder notifier selected with the -notifier flag
+//
+// reminders are routed to the owner of their todo, through the owner's webhooks or to the owner's email
+func newNotifier(name string, webhooks *webhook.Dispatcher, users reminder.Users, smtpAddr, smtpFrom, smtpTo string) (reminder.Notifier, error) {
+	switch strings.ToLower(name) {
+	case "log":
+		return reminder.LogNotifier{}, nil
+	case "webhook":
+		return reminder.EventNotifier{Publisher: webhooks}, nil
+	case "smtp":
+		if smtpAddr == "" || smtpFrom == "" {
+			return nil, fmt.Errorf("the smtp notifier needs -smtpAddr and -smtpFrom")
+		}
+		notifier := reminder.SMTPNotifier{Addr: smtpAddr, From: smtpFrom, Users: users, To: splitList(smtpTo)}
+
+		username, password := os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD")
+		if username != "" {
+			host, _, _ := strings.Cut(smtpAddr, ":")
+			notifier.Auth = smtp.PlainAuth("", username, password, host)
+		}
+		return notifier, nil
+	default:
+		return nil, fmt.Errorf("the notifier %s, is not supported", name)
+	}
+}
+
+// envOr returns the environment variable key, or fallback when it is not set
+func envOr(key, fallback string) string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	return value
+}
+
+// envDuration is envOr for durations like "10m"
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s is not a duration: %s", key, err)
+	}
+	return duration
+}
+
+// splitList splits a comma separated flag, blank entries are dropped
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
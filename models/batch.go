@@ -0,0 +1,43 @@
+package models
+
+import "github.com/ganglinwu/todoapp-backend-v1/errs"
+
+// kinds of operation accepted by a todo batch
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// BatchOp is a single create, update or delete inside a todo batch
+//
+// - ProjID is required by create, the todo is added to that project
+// - ID is required by update and delete
+// - Todo holds the todo to create, or the complete todo to replace an existing one with
+type BatchOp struct {
+	Op     string `json:"op"`
+	ID     string `json:"id,omitempty"`
+	ProjID string `json:"projId,omitempty"`
+	Todo   TODO   `json:"todo"`
+}
+
+// BatchResult is the outcome of the BatchOp at the same index
+//
+// - ID is the id of the created, updated or deleted todo
+// - Err is nil when the operation succeeded
+type BatchResult struct {
+	ID  string
+	Err error
+}
+
+// AbortBatch marks every result except the one at index failed as errs.ErrAborted
+//
+// used by the stores when an atomic batch is rolled back
+func AbortBatch(ops []BatchOp, results []BatchResult, failed int) []BatchResult {
+	for i, op := range ops {
+		if i != failed {
+			results[i] = BatchResult{ID: op.ID, Err: errs.ErrAborted}
+		}
+	}
+	return results
+}
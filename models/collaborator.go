@@ -0,0 +1,41 @@
+package models
+
+import "slices"
+
+// roles of the users that can reach a project, from the least to the most allowed
+//
+// - viewers read the project, its todos and their history
+// - editors also change the project and its todos, and create and delete todos
+// - owners also delete and restore the project and manage its collaborators
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
+// Roles lists the roles from the least to the most allowed
+var Roles = []string{RoleViewer, RoleEditor, RoleOwner}
+
+// RoleAtLeast reports whether role allows everything least allows, an unknown role allows nothing
+func RoleAtLeast(role, least string) bool {
+	index := slices.Index(Roles, role)
+	return index != -1 && index >= slices.Index(Roles, least)
+}
+
+// Collaborator is a user a project is shared with
+//
+// the user who created a project is its owner without being a collaborator
+type Collaborator struct {
+	UserID string `json:"userId" bson:"userId"`
+	Role   string `json:"role"`
+}
+
+// Access is what the user of a request may do with a project, see TodoStore.GetProjAccess
+//
+// - Owner is the user who created the project, the project and its todos are stored as theirs
+// - Role is RoleOwner for that user and the role of a collaborator otherwise
+type Access struct {
+	ProjID string
+	Owner  string
+	Role   string
+}
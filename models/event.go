@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// types of change event, see Event
+const (
+	EventTodoCreated     = "todo.created"
+	EventTodoUpdated     = "todo.updated"
+	EventTodoCompleted   = "todo.completed"
+	EventTodoDeleted     = "todo.deleted"
+	EventTodoRestored    = "todo.restored"
+	EventProjectCreated  = "project.created"
+	EventProjectUpdated  = "project.updated"
+	EventProjectDeleted  = "project.deleted"
+	EventProjectRestored = "project.restored"
+	EventReminderDue     = "reminder.due"
+)
+
+// EventTypes lists every event type in the order they are documented
+var EventTypes = []string{
+	EventTodoCreated,
+	EventTodoUpdated,
+	EventTodoCompleted,
+	EventTodoDeleted,
+	EventTodoRestored,
+	EventProjectCreated,
+	EventProjectUpdated,
+	EventProjectDeleted,
+	EventProjectRestored,
+	EventReminderDue,
+}
+
+// Event describes a change made through the API
+//
+// - ProjID is the project the change happened in, the project itself for project events
+// - Data is the todo or project after the change, deleted ones only carry their id.
+// a deleted todo or project is moved to the trash, restoring it emits todo.restored or project.restored
+// - completing a todo emits both todo.updated and todo.completed
+// - reminder.due is emitted when a reminder of a todo fires, Data is the models.DueReminder
+type Event struct {
+	ID     string    `json:"id"`
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	ProjID string    `json:"projId,omitempty"`
+	Data   any       `json:"data"`
+	Owner  string    `json:"-"` // user whose data changed, only their subscribers get the event
+}
+
+// DeletedData is the Data of a delete event
+type DeletedData struct {
+	ID string `json:"id"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// kinds of HistoryEntry
+const (
+	HistoryTodo    = "todo"
+	HistoryProject = "project"
+)
+
+// operations recorded in the history
+const (
+	OpCreate      = "create"
+	OpUpdate      = "update"
+	OpDelete      = "delete"
+	OpRestore     = "restore"
+	OpMove        = "move"
+	OpReorder     = "reorder"
+	OpAddItem     = "item.add"
+	OpUpdateItem  = "item.update"
+	OpReorderItem = "item.reorder"
+	OpDeleteItem  = "item.delete"
+)
+
+// HistoryEntry records one change to a todo or project, see package history
+//
+// - entries are only ever appended, they outlive the todo or project they are about
+// - Changes lists the fields that differ between before and after the change
+type HistoryEntry struct {
+	ID        string        `json:"id" bson:"_id"`
+	Kind      string        `json:"kind"`
+	EntityID  string        `json:"entityId"`
+	Operation string        `json:"operation"`
+	Actor     string        `json:"actor"`
+	Time      time.Time     `json:"time"`
+	Changes   []FieldChange `json:"changes"`
+	Owner     string        `json:"-" bson:"owner"`
+}
+
+// FieldChange is the json value of a field before and after a change
+//
+// - Before is null for fields that were not set before, After for fields that are not set after
+type FieldChange struct {
+	Field  string          `json:"field"`
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// IdempotencyRecord is the stored response to a request sent with an Idempotency-Key header
+//
+// - Fingerprint is a hash of the method, path and body of the request, a retry has to send the same request
+// - StatusCode is 0 while the request is still in progress
+// - Header only holds the response headers that are replayed, e.g. Location and ETag
+// - the record is dropped once it expires, the key can then be used again
+type IdempotencyRecord struct {
+	Key         string            `json:"key" bson:"_id"`
+	Fingerprint string            `json:"fingerprint"`
+	StatusCode  int               `json:"statusCode"`
+	Header      map[string]string `json:"header,omitempty"`
+	Body        []byte            `json:"body,omitempty"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	ExpiresAt   time.Time         `json:"expiresAt"`
+}
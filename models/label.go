@@ -0,0 +1,11 @@
+package models
+
+// Label is an entry in the label catalogue
+//
+// todos refer to their labels by id, see TODO.Labels
+type Label struct {
+	ID    string `json:"id" bson:"_id"`
+	Name  string `json:"name"`
+	Color string `json:"color,omitempty"` // "#rrggbb"
+	Owner string `json:"-" bson:"owner"`
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"cmp"
+	"encoding/json"
+	"slices"
 	"time"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
@@ -25,23 +28,107 @@ type MockTODO struct {
 		DueDate     time.Time     `json:"dueDate,omitempty"`
 	}
 */
+
+// priorities a todo can have, a todo without a priority has ""
+//
+// the validate tag of TODO.Priority lists them too
+const (
+	PriorityLow    = "low"
+	PriorityMedium = "medium"
+	PriorityHigh   = "high"
+)
+
+// the validate tags of TODO and PROJECT declare what a request may set, see package validate.
+// the maximum lengths are the ones of the postgres columns
 type TODO struct {
 	ID            *bson.ObjectID  `json:"_id,omitempty" db:"-"` // mongodb id
-	Id            int             `json:"-" db:"id"`            // postgresql id
-	Name          string          `json:"name" db:"name"`
-	Description   string          `json:"description,omitempty" db:"description"`
+	Id            int             `json:"id,omitempty" db:"id"` // postgresql id
+	Name          string          `json:"name" db:"name" validate:"required,max=255"`
+	Description   string          `json:"description,omitempty" db:"description" validate:"max=255"`
 	DueDate       *time.Time      `json:"dueDate,omitempty" db:"duedate"`
 	DueDateString string          `json:"dueDateString,omitempty" db:"-"`
-	Priority      string          `json:"priority,omitempty" db:"priority"`
+	Priority      string          `json:"priority,omitempty" db:"priority" validate:"oneof=low medium high"`
 	Completed     bool            `json:"completed" db:"completed"`
 	Updated_at    *bson.Timestamp `json:"updated_at" db:"-"`
-	Updated_At    *time.Time      `json:"-" db:"updated_at`
+	Updated_At    *time.Time      `json:"-" db:"updated_at"`
 	ProjName      string          `json:"-" db:"projname"`
+	ProjID        string          `json:"projId,omitempty" bson:"-" db:"-"` // filled in on reads, never stored on the todo
+	Rank          string          `json:"rank,omitempty" db:"rank"`         // position within the project, see package rank
+	Items         []ChecklistItem `json:"items,omitempty" db:"-"`
+	Labels        []string        `json:"labels,omitempty" db:"-"`              // ids of the todo's labels, see Label
+	Recurrence    string          `json:"recurrence,omitempty" db:"recurrence"` // RFC 5545 RRULE, see package rrule
+	Occurrence    int             `json:"occurrence,omitempty" db:"occurrence"` // 1-based number of this occurrence of a recurring todo
+	Reminders     []Reminder      `json:"reminders,omitempty" db:"-"`
+	Version       int             `json:"version" db:"version"`                // incremented on every change, see the ETag of the todo
+	DeletedAt     *time.Time      `json:"deletedAt,omitempty" db:"deleted_at"` // set while the todo is in the trash
+}
+
+// ChecklistItem is a single entry in a todo's checklist
+//
+// - mongo embeds the items in the task, postgres keeps them in the todo_items table
+// - Rank is the position within the checklist, see package rank
+type ChecklistItem struct {
+	ID        string `json:"id" bson:"_id"`
+	Name      string `json:"name"`
+	Completed bool   `json:"completed"`
+	Rank      string `json:"rank,omitempty"`
+}
+
+// Progress returns the percentage of checklist items that are completed
+//
+// - returns nil if the todo has no checklist
+func (todo TODO) Progress() *int {
+	if len(todo.Items) == 0 {
+		return nil
+	}
+	completed := 0
+	for _, item := range todo.Items {
+		if item.Completed {
+			completed++
+		}
+	}
+	progress := completed * 100 / len(todo.Items)
+	return &progress
+}
+
+// MarshalJSON adds the computed "progress" field to the todo
+//
+// items are written in their manual order whatever order the store returned them in
+func (todo TODO) MarshalJSON() ([]byte, error) {
+	todo.Items = slices.Clone(todo.Items)
+	SortItems(todo.Items)
+
+	// plain has the same fields as TODO but not this method
+	type plain TODO
+	return json.Marshal(struct {
+		plain
+		Progress *int `json:"progress,omitempty"`
+	}{plain(todo), todo.Progress()})
+}
+
+// SortItems puts checklist items in their manual order
+func SortItems(items []ChecklistItem) {
+	slices.SortStableFunc(items, func(a, b ChecklistItem) int {
+		return cmp.Compare(a.Rank, b.Rank)
+	})
 }
 
 type PROJECT struct {
-	ID       *bson.ObjectID `json:"_id,omitempty" db:"-"`
-	Id       int            `json:"-" db:"id"`
-	ProjName string         `json:"projname" db:"projname"`
-	Tasks    []TODO         `json:"tasks" db:"-"`
+	ID        *bson.ObjectID `json:"_id,omitempty" db:"-"`
+	Id        int            `json:"id,omitempty" db:"id"`
+	ProjName  string         `json:"projname" db:"projname" validate:"required,max=255"`
+	Tasks     []TODO         `json:"tasks" db:"-"`
+	Version   int            `json:"version" db:"version"`                // incremented when the project itself changes, not its todos
+	DeletedAt *time.Time     `json:"deletedAt,omitempty" db:"deleted_at"` // set while the project is in the trash
+	Owner     string         `json:"-" bson:"owner" db:"owner"`           // id of the user the project and its todos belong to
+	// the users the project is shared with, only stored on the project in mongo, see Collaborator
+	Collaborators []Collaborator `json:"-" bson:"collaborators,omitempty" db:"-"`
+}
+
+// Placement says where a todo is moved to within its project
+//
+// exactly one of Before and After holds the ID of another todo in the same project
+type Placement struct {
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
 }
@@ -0,0 +1,64 @@
+package models
+
+import "encoding/json"
+
+// MergePatchType is the media type of a JSON merge patch, see RFC 7396
+const MergePatchType = "application/merge-patch+json"
+
+// Patch is a field of a JSON merge patch
+//
+// - a field left out of the patch is not Set, it keeps its value
+// - a field that is null is Set and Null, it is cleared
+// - otherwise the field is Set to Value
+type Patch[T any] struct {
+	Set   bool
+	Null  bool
+	Value T
+}
+
+// UnmarshalJSON is only called for the fields that are in the patch, null included
+func (p *Patch[T]) UnmarshalJSON(data []byte) error {
+	p.Set = true
+	if string(data) == "null" {
+		p.Null = true
+		return nil
+	}
+	return json.Unmarshal(data, &p.Value)
+}
+
+// TodoPatch is the body of "PATCH /todo/{ID}", a JSON merge patch of the todo
+//
+// - DueDate and DueDateString both take an RFC3339 time, a patch sets one of them
+// - the fields that are not in TodoPatch only change through their own endpoints,
+// Rank through reordering, Items through the checklist and ProjID by moving the todo
+type TodoPatch struct {
+	Name          Patch[string]     `json:"name"`
+	Description   Patch[string]     `json:"description"`
+	DueDate       Patch[string]     `json:"dueDate"`
+	DueDateString Patch[string]     `json:"dueDateString"`
+	Priority      Patch[string]     `json:"priority"`
+	Completed     Patch[bool]       `json:"completed"`
+	Labels        Patch[[]string]   `json:"labels"`
+	Recurrence    Patch[string]     `json:"recurrence"`
+	Reminders     Patch[[]Reminder] `json:"reminders"`
+}
+
+// ProjPatch is the body of "PATCH /proj/{ID}", a JSON merge patch of the project
+type ProjPatch struct {
+	ProjName Patch[string] `json:"projname"`
+}
+
+// the fields of a todo that TodoStore.PatchTodo writes, by their json name
+//
+// Occurrence and Reminders also change when the recurrence or the due date does
+const (
+	FieldName        = "name"
+	FieldDescription = "description"
+	FieldDueDate     = "dueDate"
+	FieldPriority    = "priority"
+	FieldCompleted   = "completed"
+	FieldLabels      = "labels"
+	FieldRecurrence  = "recurrence"
+	FieldOccurrence  = "occurrence"
+	FieldReminders   = "reminders"
+)
@@ -0,0 +1,214 @@
+package models
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+)
+
+// fields that a list of todos can be sorted by
+const (
+	SortByDueDate   = "dueDate"
+	SortByPriority  = "priority"
+	SortByUpdatedAt = "updated_at"
+	SortByRank      = "rank"
+)
+
+// Page holds the pagination parameters of a list request
+//
+// - Limit caps the number of items, the stores leave a Limit of 0 uncapped
+// but the list endpoints always set one, see parsePage in package server
+// - Cursor is the opaque next cursor returned with the previous page
+type Page struct {
+	Limit  int
+	Cursor string
+}
+
+// TodoQuery holds the filters, sort order and page used when listing todos
+//
+// zero values mean "do not filter"
+type TodoQuery struct {
+	Completed *bool
+	Priority  []string
+	ProjID    string
+	DueBefore *time.Time
+	DueAfter  *time.Time
+	Name      string // case-insensitive substring match on the todo name
+	Labels    []string
+	AllLabels bool   // todos need every label in Labels, otherwise any one of them
+	SortBy    string // one of SortByDueDate, SortByPriority, SortByUpdatedAt, SortByRank
+	Desc      bool
+	Page
+}
+
+// Cursor is the keyset position that the next page starts after
+//
+// it is handed to clients as an opaque string, see EncodeCursor
+type Cursor struct {
+	SortBy string `json:"s,omitempty"` // the sort the cursor was created for
+	Desc   bool   `json:"d,omitempty"`
+	Key    string `json:"k,omitempty"` // sort key of the last item, in the store's own format
+	ID     string `json:"id"`          // id of the last item, breaks ties between equal keys
+}
+
+// EncodeCursor turns c into an opaque, url safe string
+func EncodeCursor(c Cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor reverses EncodeCursor
+//
+// - returns errs.ErrValidation if the cursor is malformed
+func DecodeCursor(s string) (Cursor, error) {
+	c := Cursor{}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("%w: malformed cursor", errs.ErrValidation)
+	}
+	err = json.Unmarshal(b, &c)
+	if err != nil || c.ID == "" {
+		return Cursor{}, fmt.Errorf("%w: malformed cursor", errs.ErrValidation)
+	}
+	return c, nil
+}
+
+// DecodeCursor decodes q.Cursor and checks that it was created for the same sort as q
+//
+// - returns nil if q has no cursor
+func (q TodoQuery) DecodeCursor() (*Cursor, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+	c, err := DecodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if c.SortBy != q.SortBy || c.Desc != q.Desc {
+		return nil, fmt.Errorf("%w: cursor was created for a different sort order", errs.ErrValidation)
+	}
+	return &c, nil
+}
+
+// NextCursor returns the cursor for the page that starts after the item with the given sort key and id
+func (q TodoQuery) NextCursor(key, ID string) string {
+	return EncodeCursor(Cursor{SortBy: q.SortBy, Desc: q.Desc, Key: key, ID: ID})
+}
+
+// PriorityRank orders the priorities so that they can be sorted
+//
+// - "hi" and "mid" were stored before priorities were validated, they sort with "high" and "medium"
+// - unknown priorities sort before "low"
+func PriorityRank(priority string) int {
+	switch strings.ToLower(priority) {
+	case "high", "hi":
+		return 3
+	case "mid", "medium":
+		return 2
+	case "low":
+		return 1
+	}
+	return 0
+}
+
+// Match reports whether todo passes every filter in q, except ProjID
+// which the caller has to check against the project that owns the todo
+func (q TodoQuery) Match(todo TODO) bool {
+	if q.Completed != nil && todo.Completed != *q.Completed {
+		return false
+	}
+	if len(q.Priority) > 0 && !slices.Contains(q.Priority, todo.Priority) {
+		return false
+	}
+	if q.DueBefore != nil && (todo.DueDate == nil || !todo.DueDate.Before(*q.DueBefore)) {
+		return false
+	}
+	if q.DueAfter != nil && (todo.DueDate == nil || !todo.DueDate.After(*q.DueAfter)) {
+		return false
+	}
+	if q.Name != "" && !strings.Contains(strings.ToLower(todo.Name), strings.ToLower(q.Name)) {
+		return false
+	}
+	if len(q.Labels) > 0 && !q.matchLabels(todo.Labels) {
+		return false
+	}
+	return true
+}
+
+// matchLabels reports whether labels has all or any of q.Labels, depending on q.AllLabels
+func (q TodoQuery) matchLabels(labels []string) bool {
+	for _, label := range q.Labels {
+		has := slices.Contains(labels, label)
+		if has && !q.AllLabels {
+			return true
+		}
+		if !has && q.AllLabels {
+			return false
+		}
+	}
+	return q.AllLabels
+}
+
+// Sort sorts todos in place according to q.SortBy and q.Desc
+//
+// todos are left in their original order when q.SortBy is empty
+func (q TodoQuery) Sort(todos []TODO) {
+	var compare func(a, b TODO) int
+
+	switch q.SortBy {
+	case SortByDueDate:
+		compare = func(a, b TODO) int {
+			return cmp.Compare(unixOrZero(a.DueDate), unixOrZero(b.DueDate))
+		}
+	case SortByPriority:
+		compare = func(a, b TODO) int {
+			return cmp.Compare(PriorityRank(a.Priority), PriorityRank(b.Priority))
+		}
+	case SortByUpdatedAt:
+		compare = func(a, b TODO) int {
+			return cmp.Compare(a.updatedAtUnix(), b.updatedAtUnix())
+		}
+	case SortByRank:
+		compare = func(a, b TODO) int {
+			return cmp.Compare(a.Rank, b.Rank)
+		}
+	default:
+		return
+	}
+
+	slices.SortStableFunc(todos, func(a, b TODO) int {
+		if q.Desc {
+			return compare(b, a)
+		}
+		return compare(a, b)
+	})
+}
+
+// SortTasks puts the tasks of a project in their manual order
+//
+// tasks without a rank were created before ranks existed,
+// they come first and keep their insertion order
+func SortTasks(todos []TODO) {
+	TodoQuery{SortBy: SortByRank}.Sort(todos)
+}
+
+func unixOrZero(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+// updatedAtUnix returns whichever of the mongo/postgres timestamps is set
+func (todo TODO) updatedAtUnix() int64 {
+	if todo.Updated_at != nil {
+		return int64(todo.Updated_at.T)
+	}
+	return unixOrZero(todo.Updated_At)
+}
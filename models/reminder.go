@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Reminder fires a notification some time before a todo's due date
+//
+// - Before is a duration such as "30m" or "24h", it is unique per todo
+// - FireAt is worked out from the due date whenever the todo is stored
+// - SentAt is set once the notification went out, a pending reminder has none
+type Reminder struct {
+	Before string     `json:"before"`
+	FireAt *time.Time `json:"fireAt,omitempty"`
+	SentAt *time.Time `json:"sentAt,omitempty"`
+}
+
+// DueReminder is a pending reminder whose time has come, with the todo it belongs to
+type DueReminder struct {
+	TodoID   string   `json:"todoId"`
+	Todo     TODO     `json:"todo"`
+	Reminder Reminder `json:"reminder"`
+	Owner    string   `json:"-"` // user the todo belongs to, the reminder is sent to them
+}
@@ -0,0 +1,19 @@
+package models
+
+// kinds of SearchHit
+const (
+	SearchHitTodo    = "todo"
+	SearchHitProject = "project"
+)
+
+// SearchHit is a single result of a full-text search
+//
+// - Todo is only set when Kind is SearchHitTodo
+// - Project is the matching project, or the project that owns the matching todo.
+// its Tasks are not filled in
+type SearchHit struct {
+	Kind    string  `json:"kind"`
+	Score   float64 `json:"score"`
+	Todo    *TODO   `json:"todo,omitempty"`
+	Project PROJECT `json:"project"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// kinds of TrashItem
+const (
+	TrashTodo    = "todo"
+	TrashProject = "project"
+)
+
+// TrashItem is a deleted todo or project that can still be restored
+//
+// - Todo is only set when Kind is TrashTodo, Project only when Kind is TrashProject
+// - a trashed project holds the tasks that were trashed together with it
+type TrashItem struct {
+	Kind      string    `json:"kind"`
+	ID        string    `json:"id"`
+	DeletedAt time.Time `json:"deletedAt"`
+	Todo      *TODO     `json:"todo,omitempty"`
+	Project   *PROJECT  `json:"project,omitempty"`
+}
@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// User is an account that signs in with its email and password, see package auth
+//
+// - Email is stored lowercased, it is unique
+// - PasswordHash is a bcrypt hash and never leaves the server
+type User struct {
+	ID           string    `json:"id" bson:"_id"`
+	Email        string    `json:"email"`
+	PasswordHash string    `json:"-" bson:"passwordHash"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// RefreshToken is a stored refresh token, only its hash is kept
+//
+// - the tokens issued for one login share a Family
+// - UsedAt is set once the token has been exchanged, a token can only be exchanged once
+type RefreshToken struct {
+	Hash      string     `json:"-" bson:"_id"`
+	UserID    string     `json:"userId"`
+	Family    string     `json:"family"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`
+}
+
+// Credentials is the body of a register or login request
+type Credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// TokenPair is returned by a login or refresh, see package auth
+type TokenPair struct {
+	AccessToken  string    `json:"accessToken"`
+	TokenType    string    `json:"tokenType"` // always "Bearer"
+	ExpiresAt    time.Time `json:"expiresAt"` // when AccessToken expires
+	RefreshToken string    `json:"refreshToken"`
+}
+
+// scopes of a personal access token
+const (
+	ScopeRead      = "read"       // only GET requests
+	ScopeReadWrite = "read-write" // every request
+)
+
+// PersonalToken is a personal access token a user created for a script or an integration,
+// only its hash is stored, see package auth
+//
+// - Token is only returned when the token is created
+// - Prefix is the start of Token, enough to recognize it in a list
+// - ProjectID limits the token to a single project and its todos
+// - LastUsedAt is updated every time the token signs a request in
+type PersonalToken struct {
+	ID         string     `json:"id" bson:"_id"`
+	UserID     string     `json:"-" bson:"userId"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`
+	ProjectID  string     `json:"projectId,omitempty"`
+	Token      string     `json:"token,omitempty" bson:"-"`
+	Prefix     string     `json:"prefix"`
+	Hash       string     `json:"-" bson:"hash"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
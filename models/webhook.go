@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Webhook is a subscription that receives the events listed in Events as signed POST requests
+//
+// - Secret signs the payloads, it is only returned when the webhook is created
+// - Failures counts the deliveries in a row that failed every attempt,
+// the webhook is disabled once it reaches the dispatcher's limit
+type Webhook struct {
+	ID        string    `json:"id" bson:"_id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Failures  int       `json:"failures"`
+	CreatedAt time.Time `json:"createdAt"`
+	Owner     string    `json:"-" bson:"owner"`
+}
+
+// Delivery is an entry in the delivery log of a webhook, one per attempt
+type Delivery struct {
+	ID          string    `json:"id" bson:"_id"`
+	WebhookID   string    `json:"webhookId"`
+	EventID     string    `json:"eventId"`
+	Event       string    `json:"event"`
+	Attempt     int       `json:"attempt"`
+	StatusCode  int       `json:"statusCode,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Success     bool      `json:"success"`
+	DeliveredAt time.Time `json:"deliveredAt"`
+}
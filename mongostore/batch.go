@@ -0,0 +1,316 @@
+package mongostore
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/history"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+	"github.com/ganglinwu/todoapp-backend-v1/rank"
+)
+
+// BatchTodos
+//
+// every operation is a single update of its project, written in the order of the batch
+//
+// the projects and todos the batch refers to are looked up first and missing ones fail
+// with errs.ErrNotFound before anything is written, the same goes for labels
+// which fail with errs.ErrValidation, and for updates and deletes of a todo
+// that is no longer at the version of op.Todo, which fail with errs.ErrPreconditionFailed.
+// the version is also part of the update, an update or delete that matches nothing
+// lost a race with another write, or an earlier op of the batch, and fails with errs.ErrConflict
+//
+// - atomic: any failure found up front aborts the batch before it is written,
+// the writes and their history entries run in a transaction that is rolled back
+// at the first failing write, every other op is then errs.ErrAborted
+// - otherwise only the failing operations are skipped. the history entries are added
+// after the writes, failing to add them is only logged, the batch has been written by then
+func (ms *MongoStore) BatchTodos(ctx context.Context, ops []models.BatchOp, atomic bool) ([]models.BatchResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	results := make([]models.BatchResult, len(ops))
+
+	// targets holds the project id of a create, or the todo id of an update or delete
+	targets := make([]bson.ObjectID, len(ops))
+	projIDs := bson.A{}
+	todoIDs := bson.A{}
+	labels := []string{}
+
+	for i, op := range ops {
+		target, err := batchTarget(op)
+		if err != nil {
+			results[i] = models.BatchResult{ID: op.ID, Err: err}
+			if atomic {
+				return models.AbortBatch(ops, results, i), nil
+			}
+			continue
+		}
+
+		targets[i] = target
+		labels = append(labels, op.Todo.Labels...)
+		if op.Op == models.BatchCreate {
+			projIDs = append(projIDs, target)
+		} else {
+			todoIDs = append(todoIDs, target)
+		}
+	}
+
+	projExists, todoVersions, lastRanks, err := ms.existingIDs(ctx, projIDs, todoIDs)
+	if err != nil {
+		return nil, err
+	}
+	knownLabels, err := ms.existingLabels(ctx, labels)
+	if err != nil {
+		return nil, err
+	}
+	before, err := ms.findTasks(ctx, todoIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// writes holds the updates that are sent, index maps them back to their op
+	writes := []*mongo.UpdateOneModel{}
+	index := []int{}
+
+	for i, op := range ops {
+		if results[i].Err != nil {
+			continue
+		}
+
+		target := targets[i].Hex()
+		version, found := todoVersions[target]
+		if op.Op == models.BatchCreate {
+			found = projExists[target]
+		}
+		if !found {
+			results[i].Err = fmt.Errorf("%w: %q", errs.ErrNotFound, target)
+		} else if op.Op != models.BatchCreate && op.Todo.Version != 0 && op.Todo.Version != version {
+			results[i].Err = fmt.Errorf("%w: %q is no longer at version %d", errs.ErrPreconditionFailed, target, op.Todo.Version)
+		} else if unknown := slices.IndexFunc(op.Todo.Labels, func(label string) bool { return !knownLabels[label] }); unknown != -1 {
+			results[i].Err = fmt.Errorf("%w: unknown label %q", errs.ErrValidation, op.Todo.Labels[unknown])
+		}
+		if results[i].Err != nil {
+			if atomic {
+				return models.AbortBatch(ops, results, i), nil
+			}
+			continue
+		}
+
+		if op.Op == models.BatchCreate {
+			// creates go to the end of the project, in the order they are in the batch
+			op.Todo.Rank = rank.After(lastRanks[target])
+			lastRanks[target] = op.Todo.Rank
+		} else if op.Todo.Version == 0 {
+			op.Todo.Version = version
+		}
+
+		var write *mongo.UpdateOneModel
+		results[i], write = batchWrite(ctx, op, targets[i])
+		writes = append(writes, write)
+		index = append(index, i)
+	}
+
+	if len(writes) == 0 {
+		return results, nil
+	}
+
+	if atomic {
+		return ms.writeAtomicBatch(ctx, ops, results, writes, index, before)
+	}
+
+	for n, write := range writes {
+		err = ms.batchUpdate(ctx, write)
+		if err != nil {
+			results[index[n]].Err = err
+		}
+	}
+
+	err = ms.addBatchHistory(ctx, ops, results, index, before)
+	if err != nil {
+		log.Println("failed to add the history of a batch: ", err.Error())
+	}
+	return results, nil
+}
+
+// writeAtomicBatch sends the writes of an atomic batch and their history entries in a transaction
+//
+// - the first write that fails rolls the transaction back, the op it belongs to gets its error
+// and every other op errs.ErrAborted, see models.AbortBatch
+func (ms *MongoStore) writeAtomicBatch(ctx context.Context, ops []models.BatchOp, results []models.BatchResult, writes []*mongo.UpdateOneModel, index []int, before map[string]models.TODO) ([]models.BatchResult, error) {
+	session, err := ms.Conn.StartSession()
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer session.EndSession(ctx)
+
+	failed := -1
+	_, err = session.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+		// the transaction may be retried, so the failing write is found again
+		failed = -1
+		for n, write := range writes {
+			err := ms.batchUpdate(ctx, write)
+			if err != nil {
+				failed = index[n]
+				results[failed].Err = err
+				return nil, err
+			}
+		}
+		return nil, ms.addBatchHistory(ctx, ops, results, index, before)
+	})
+	if failed != -1 {
+		return models.AbortBatch(ops, results, failed), nil
+	}
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	return results, nil
+}
+
+// batchUpdate sends the update of a single batch operation
+//
+// - returns errs.ErrConflict if it matches nothing, the todo changed since the batch looked it up
+func (ms *MongoStore) batchUpdate(ctx context.Context, write *mongo.UpdateOneModel) error {
+	result, err := ms.Collection.UpdateOne(ctx, write.Filter, write.Update)
+	if err != nil {
+		return wrapErr(err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("%w: the todo changed while the batch was written", errs.ErrConflict)
+	}
+	return nil
+}
+
+// batchOperations maps batch ops onto the operations recorded in the history
+var batchOperations = map[string]string{
+	models.BatchCreate: models.OpCreate,
+	models.BatchUpdate: models.OpUpdate,
+	models.BatchDelete: models.OpDelete,
+}
+
+// addBatchHistory records the ops in index that succeeded, before holds the tasks as they were before the batch
+func (ms *MongoStore) addBatchHistory(ctx context.Context, ops []models.BatchOp, results []models.BatchResult, index []int, before map[string]models.TODO) error {
+	written := bson.A{}
+	for _, i := range index {
+		if results[i].Err == nil {
+			todoID, _ := parseObjectID(results[i].ID)
+			written = append(written, todoID)
+		}
+	}
+
+	after, err := ms.findTasks(ctx, written)
+	if err != nil {
+		return err
+	}
+
+	entries := []models.HistoryEntry{}
+	for _, i := range index {
+		if results[i].Err != nil {
+			continue
+		}
+		ID := results[i].ID
+		entry, err := history.TodoEntry(ctx, batchOperations[ops[i].Op], ID, before[ID], after[ID])
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+	return ms.addHistory(ctx, entries...)
+}
+
+// batchTarget checks op and parses the id it acts on,
+// the project id for a create and the todo id otherwise
+func batchTarget(op models.BatchOp) (bson.ObjectID, error) {
+	switch op.Op {
+	case models.BatchCreate:
+		return parseObjectID(op.ProjID)
+	case models.BatchUpdate, models.BatchDelete:
+		return parseObjectID(op.ID)
+	}
+	return bson.ObjectID{}, fmt.Errorf("%w: unknown op %q", errs.ErrValidation, op.Op)
+}
+
+// batchWrite builds the update for a single batch operation
+//
+// the returned result holds the id of the todo the operation acts on,
+// updates and deletes only match the task at op.Todo.Version
+func batchWrite(ctx context.Context, op models.BatchOp, target bson.ObjectID) (models.BatchResult, *mongo.UpdateOneModel) {
+	switch op.Op {
+	case models.BatchCreate:
+		todoID := bson.NewObjectID()
+		op.Todo.ID = &todoID
+		op.Todo.Version = 1
+
+		return models.BatchResult{ID: todoID.Hex()}, mongo.NewUpdateOneModel().
+			SetFilter(projQuery(ctx, target, 0)).
+			SetUpdate(bson.D{{Key: "$push", Value: bson.D{{Key: "tasks", Value: op.Todo}}}})
+
+	case models.BatchUpdate:
+		op.Todo.ID = &target
+		query := taskQuery(ctx, target, op.Todo.Version)
+		op.Todo.Version++
+
+		return models.BatchResult{ID: op.ID}, mongo.NewUpdateOneModel().
+			SetFilter(query).
+			SetUpdate(bson.D{{Key: "$set", Value: bson.D{{Key: "tasks.$", Value: op.Todo}}}})
+	}
+
+	// deletes move the task to the trash, see DeleteTodoByID
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	return models.BatchResult{ID: op.ID}, mongo.NewUpdateOneModel().
+		SetFilter(taskQuery(ctx, target, op.Todo.Version)).
+		SetUpdate(append(bson.D{{Key: "$set", Value: bson.D{{Key: "tasks.$.deletedAt", Value: now}}}}, bumpVersion("tasks.$")...))
+}
+
+// existingIDs looks up which of the given project and todo ids exist, in a single query
+//
+// projects and todos in the trash count as missing.
+//
+// existing todos are returned with their version, it also returns the highest
+// task rank of each project in projIDs
+func (ms *MongoStore) existingIDs(ctx context.Context, projIDs, todoIDs bson.A) (map[string]bool, map[string]int, map[string]string, error) {
+	projExists := map[string]bool{}
+	todoVersions := map[string]int{}
+	lastRanks := map[string]string{}
+
+	if len(projIDs) == 0 && len(todoIDs) == 0 {
+		return projExists, todoVersions, lastRanks, nil
+	}
+
+	filter := bson.D{owned(ctx), notDeleted, {Key: "$or", Value: bson.A{
+		bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: projIDs}}}},
+		bson.D{{Key: "tasks._id", Value: bson.D{{Key: "$in", Value: todoIDs}}}},
+	}}}
+	opts := options.Find().SetProjection(bson.D{{Key: "tasks._id", Value: 1}, {Key: "tasks.rank", Value: 1}, {Key: "tasks.version", Value: 1}, {Key: "tasks.deletedAt", Value: 1}})
+
+	cursor, err := ms.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, nil, nil, wrapErr(err)
+	}
+
+	projs := []models.PROJECT{}
+	err = cursor.All(ctx, &projs)
+	if err != nil {
+		return nil, nil, nil, wrapErr(err)
+	}
+
+	for _, proj := range projs {
+		projID := proj.ID.Hex()
+		projExists[projID] = true
+		for _, task := range proj.Tasks {
+			lastRanks[projID] = max(lastRanks[projID], task.Rank)
+			if task.DeletedAt == nil {
+				todoVersions[task.ID.Hex()] = task.Version
+			}
+		}
+	}
+	return projExists, todoVersions, lastRanks, nil
+}
@@ -0,0 +1,181 @@
+package mongostore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// the collaborators of a shared project are embedded in the project:
+//
+//	{_id, projname, owner, collaborators: [{userId, role}], tasks: [...]}
+
+// visible matches the projects the owner of ctx owns or that are shared with its user
+func visible(ctx context.Context) bson.E {
+	return bson.E{Key: "$or", Value: bson.A{
+		bson.D{owned(ctx)},
+		bson.D{{Key: "collaborators.userId", Value: auth.UserID(ctx)}},
+	}}
+}
+
+// GetProjAccess
+//
+// - returns errs.ErrNotFound if the project is neither owned by nor shared with the user of ctx
+func (ms *MongoStore) GetProjAccess(ctx context.Context, projID string) (models.Access, error) {
+	ID, err := parseObjectID(projID)
+	if err != nil {
+		return models.Access{}, err
+	}
+	return ms.access(ctx, bson.D{{Key: "_id", Value: ID}})
+}
+
+// GetTodoAccess is GetProjAccess for the project of the task todoID
+func (ms *MongoStore) GetTodoAccess(ctx context.Context, todoID string) (models.Access, error) {
+	ID, err := parseObjectID(todoID)
+	if err != nil {
+		return models.Access{}, err
+	}
+	return ms.access(ctx, bson.D{{Key: "tasks._id", Value: ID}})
+}
+
+// access looks up the project that matches filter whoever owns it and returns the access of the user of ctx
+func (ms *MongoStore) access(ctx context.Context, filter bson.D) (models.Access, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	proj := models.PROJECT{}
+	opts := options.FindOne().SetProjection(bson.D{{Key: "owner", Value: 1}, {Key: "collaborators", Value: 1}})
+
+	err := ms.Collection.FindOne(ctx, filter, opts).Decode(&proj)
+	if err != nil {
+		return models.Access{}, wrapErr(err)
+	}
+
+	userID := auth.UserID(ctx)
+	access := models.Access{ProjID: proj.ID.Hex(), Owner: proj.Owner, Role: models.RoleOwner}
+	if proj.Owner == userID {
+		return access, nil
+	}
+	i := slices.IndexFunc(proj.Collaborators, func(collaborator models.Collaborator) bool { return collaborator.UserID == userID })
+	if i == -1 {
+		return models.Access{}, errs.ErrNotFound
+	}
+	access.Role = proj.Collaborators[i].Role
+	return access, nil
+}
+
+// GetCollaborators lists the collaborators of the project projID by user id
+func (ms *MongoStore) GetCollaborators(ctx context.Context, projID string) ([]models.Collaborator, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	ID, err := parseObjectID(projID)
+	if err != nil {
+		return nil, err
+	}
+
+	proj := models.PROJECT{}
+	opts := options.FindOne().SetProjection(bson.D{{Key: "collaborators", Value: 1}})
+
+	err = ms.Collection.FindOne(ctx, projQuery(ctx, ID, 0), opts).Decode(&proj)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	collaborators := append([]models.Collaborator{}, proj.Collaborators...)
+	slices.SortFunc(collaborators, func(a, b models.Collaborator) int {
+		return strings.Compare(a.UserID, b.UserID)
+	})
+	return collaborators, nil
+}
+
+// AddCollaborator
+//
+// - returns errs.ErrNotFound if the project does not exist or is in the trash
+// - returns errs.ErrConflict if the user already is a collaborator
+func (ms *MongoStore) AddCollaborator(ctx context.Context, projID string, collaborator models.Collaborator) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	ID, err := parseObjectID(projID)
+	if err != nil {
+		return err
+	}
+
+	query := append(projQuery(ctx, ID, 0), bson.E{Key: "collaborators.userId", Value: bson.D{{Key: "$ne", Value: collaborator.UserID}}})
+	update := bson.D{{Key: "$push", Value: bson.D{{Key: "collaborators", Value: collaborator}}}}
+
+	result, err := ms.Collection.UpdateOne(ctx, query, update)
+	if err != nil {
+		return wrapErr(err)
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	// the update cannot tell a missing project from an existing collaborator
+	err = ms.Collection.FindOne(ctx, projQuery(ctx, ID, 0)).Err()
+	if err != nil {
+		return wrapErr(err)
+	}
+	return fmt.Errorf("%w: %q already is a collaborator", errs.ErrConflict, collaborator.UserID)
+}
+
+// UpdateCollaborator changes the role of a collaborator
+//
+// - returns errs.ErrNotFound if the user is not a collaborator of the project
+func (ms *MongoStore) UpdateCollaborator(ctx context.Context, projID string, collaborator models.Collaborator) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	ID, err := parseObjectID(projID)
+	if err != nil {
+		return err
+	}
+
+	query := append(projQuery(ctx, ID, 0), bson.E{Key: "collaborators.userId", Value: collaborator.UserID})
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "collaborators.$.role", Value: collaborator.Role}}}}
+
+	result, err := ms.Collection.UpdateOne(ctx, query, update)
+	if err != nil {
+		return wrapErr(err)
+	}
+	if result.MatchedCount == 0 {
+		return errs.ErrNotFound
+	}
+	return nil
+}
+
+// RemoveCollaborator
+//
+// - returns errs.ErrNotFound if the user is not a collaborator of the project
+func (ms *MongoStore) RemoveCollaborator(ctx context.Context, projID, userID string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	ID, err := parseObjectID(projID)
+	if err != nil {
+		return 0, err
+	}
+
+	query := bson.D{{Key: "_id", Value: ID}, owned(ctx), {Key: "collaborators.userId", Value: userID}}
+	update := bson.D{{Key: "$pull", Value: bson.D{{Key: "collaborators", Value: bson.D{{Key: "userId", Value: userID}}}}}}
+
+	result, err := ms.Collection.UpdateOne(ctx, query, update)
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+	if result.MatchedCount == 0 {
+		return 0, errs.ErrNotFound
+	}
+	return 1, nil
+}
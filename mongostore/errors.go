@@ -0,0 +1,41 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+)
+
+// wrapErr wraps errors returned by the mongo driver into the errs taxonomy
+//
+// the original error is kept in the chain so it can still be inspected with errors.Is/As
+func wrapErr(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return fmt.Errorf("%w: %w", errs.ErrNotFound, err)
+	case mongo.IsDuplicateKeyError(err):
+		return fmt.Errorf("%w: %w", errs.ErrConflict, err)
+	case mongo.IsTimeout(err),
+		mongo.IsNetworkError(err),
+		errors.Is(err, mongo.ErrClientDisconnected),
+		errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", errs.ErrUnavailable, err)
+	}
+	return err
+}
+
+// parseObjectID converts the string ID used by the TodoStore interface into a bson.ObjectID
+func parseObjectID(ID string) (bson.ObjectID, error) {
+	objID, err := bson.ObjectIDFromHex(ID)
+	if err != nil {
+		return bson.ObjectID{}, fmt.Errorf("%w: %q", errs.ErrInvalidID, ID)
+	}
+	return objID, nil
+}
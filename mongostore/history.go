@@ -0,0 +1,195 @@
+package mongostore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/history"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// historyCollection holds the history entries of tasks and projects, next to the projects collection
+//
+// the changes are written in a transaction together with the entry,
+// see withHistory. only BatchTodos writes its entries after the change
+const historyCollection = "history"
+
+func (ms *MongoStore) historyEntries() *mongo.Collection {
+	return ms.Collection.Database().Collection(historyCollection)
+}
+
+// GetHistory lists the history entries of the task or project ID, oldest first
+func (ms *MongoStore) GetHistory(ctx context.Context, kind, ID string) ([]models.HistoryEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	_, err := parseObjectID(ID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.D{owned(ctx), {Key: "kind", Value: kind}, {Key: "entityId", Value: ID}}
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: 1}, {Key: "_id", Value: 1}})
+
+	cursor, err := ms.historyEntries().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	entries := []models.HistoryEntry{}
+	err = cursor.All(ctx, &entries)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	return entries, nil
+}
+
+// addHistory appends the entries that changed anything to the history
+func (ms *MongoStore) addHistory(ctx context.Context, entries ...models.HistoryEntry) error {
+	docs := []any{}
+	for _, entry := range entries {
+		if len(entry.Changes) == 0 {
+			continue
+		}
+		entry.ID = bson.NewObjectID().Hex()
+		docs = append(docs, entry)
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+
+	_, err := ms.historyEntries().InsertMany(ctx, docs)
+	return wrapErr(err)
+}
+
+// withHistory runs change inside a transaction and adds the history entry it returns
+// in the same transaction, nothing is recorded if change fails
+func (ms *MongoStore) withHistory(ctx context.Context, change func(ctx context.Context) (models.HistoryEntry, error)) error {
+	session, err := ms.Conn.StartSession()
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+		entry, err := change(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ms.addHistory(ctx, entry)
+	})
+	return err
+}
+
+// recordTask runs change with withHistory and records what it did to the task todoID
+func (ms *MongoStore) recordTask(ctx context.Context, operation string, todoID bson.ObjectID, change func(ctx context.Context) error) error {
+	return ms.withHistory(ctx, func(ctx context.Context) (models.HistoryEntry, error) {
+		before, err := ms.findTask(ctx, todoID)
+		if err != nil {
+			return models.HistoryEntry{}, err
+		}
+		err = change(ctx)
+		if err != nil {
+			return models.HistoryEntry{}, err
+		}
+		return ms.taskEntry(ctx, operation, todoID, before)
+	})
+}
+
+// taskEntry is the history entry for the change of the task todoID from before to how it is now
+func (ms *MongoStore) taskEntry(ctx context.Context, operation string, todoID bson.ObjectID, before models.TODO) (models.HistoryEntry, error) {
+	after, err := ms.findTask(ctx, todoID)
+	if err != nil {
+		return models.HistoryEntry{}, err
+	}
+	return history.TodoEntry(ctx, operation, todoID.Hex(), before, after)
+}
+
+// recordProj is recordTask for projects
+func (ms *MongoStore) recordProj(ctx context.Context, operation string, projID bson.ObjectID, change func(ctx context.Context) error) error {
+	return ms.withHistory(ctx, func(ctx context.Context) (models.HistoryEntry, error) {
+		before, err := ms.findProj(ctx, projID)
+		if err != nil {
+			return models.HistoryEntry{}, err
+		}
+		err = change(ctx)
+		if err != nil {
+			return models.HistoryEntry{}, err
+		}
+		return ms.projEntry(ctx, operation, projID, before)
+	})
+}
+
+// projEntry is taskEntry for projects
+func (ms *MongoStore) projEntry(ctx context.Context, operation string, projID bson.ObjectID, before models.PROJECT) (models.HistoryEntry, error) {
+	after, err := ms.findProj(ctx, projID)
+	if err != nil {
+		return models.HistoryEntry{}, err
+	}
+	return history.ProjectEntry(ctx, operation, projID.Hex(), before, after)
+}
+
+// findTask loads the task todoID whether or not it is in the trash
+func (ms *MongoStore) findTask(ctx context.Context, todoID bson.ObjectID) (models.TODO, error) {
+	tasks, err := ms.findTasks(ctx, bson.A{todoID})
+	if err != nil {
+		return models.TODO{}, err
+	}
+	task, found := tasks[todoID.Hex()]
+	if !found {
+		return models.TODO{}, fmt.Errorf("%w: todo %q", errs.ErrNotFound, todoID.Hex())
+	}
+	return task, nil
+}
+
+// findTasks loads the tasks in todoIDs whether or not they are in the trash, by task id
+//
+// tasks that do not exist are missing from the result
+func (ms *MongoStore) findTasks(ctx context.Context, todoIDs bson.A) (map[string]models.TODO, error) {
+	tasks := map[string]models.TODO{}
+	if len(todoIDs) == 0 {
+		return tasks, nil
+	}
+
+	cursor, err := ms.Collection.Find(ctx, bson.D{owned(ctx), {Key: "tasks._id", Value: bson.D{{Key: "$in", Value: todoIDs}}}})
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	projs := []models.PROJECT{}
+	err = cursor.All(ctx, &projs)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	for _, proj := range projs {
+		for _, task := range proj.Tasks {
+			if !slices.Contains(todoIDs, any(*task.ID)) {
+				continue
+			}
+			task.ProjID = proj.ID.Hex()
+			models.SortItems(task.Items)
+			tasks[task.ID.Hex()] = task
+		}
+	}
+	return tasks, nil
+}
+
+// findProj loads the project projID without its tasks, whether or not it is in the trash
+func (ms *MongoStore) findProj(ctx context.Context, projID bson.ObjectID) (models.PROJECT, error) {
+	proj := models.PROJECT{}
+	opts := options.FindOne().SetProjection(bson.D{{Key: "tasks", Value: 0}})
+
+	err := ms.Collection.FindOne(ctx, bson.D{{Key: "_id", Value: projID}, owned(ctx)}, opts).Decode(&proj)
+	if err != nil {
+		return models.PROJECT{}, wrapErr(err)
+	}
+	return proj, nil
+}
@@ -0,0 +1,91 @@
+package mongostore
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// idempotencyCollection holds the responses to requests sent with an Idempotency-Key,
+// a TTL index on expiresAt removes them, see EnsureIndexes
+const idempotencyCollection = "idempotency_keys"
+
+func (ms *MongoStore) idempotencyKeys() *mongo.Collection {
+	return ms.Collection.Database().Collection(idempotencyCollection)
+}
+
+// CreateIdempotencyRecord reserves record.Key for a request that is about to run
+//
+// - an expired record with the same key is replaced, the TTL index only removes them every minute or so
+// - returns errs.ErrConflict if the key is still in use
+func (ms *MongoStore) CreateIdempotencyRecord(record models.IdempotencyRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	// the upsert only matches an expired record, a live one makes it insert a duplicate _id
+	filter := bson.D{{Key: "_id", Value: record.Key}, {Key: "expiresAt", Value: bson.D{{Key: "$lte", Value: record.CreatedAt}}}}
+
+	_, err := ms.idempotencyKeys().ReplaceOne(ctx, filter, record, options.Replace().SetUpsert(true))
+	return wrapErr(err)
+}
+
+// GetIdempotencyRecord
+//
+// - returns errs.ErrNotFound if there is no record for key or it has expired
+func (ms *MongoStore) GetIdempotencyRecord(key string) (models.IdempotencyRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: key}, {Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now()}}}}
+
+	record := models.IdempotencyRecord{}
+	err := ms.idempotencyKeys().FindOne(ctx, filter).Decode(&record)
+	if err != nil {
+		return models.IdempotencyRecord{}, wrapErr(err)
+	}
+	return record, nil
+}
+
+// SaveIdempotencyRecord stores the response of the request that reserved record.Key
+//
+// - returns errs.ErrNotFound if the key is not reserved
+func (ms *MongoStore) SaveIdempotencyRecord(record models.IdempotencyRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "statusCode", Value: record.StatusCode},
+		{Key: "header", Value: record.Header},
+		{Key: "body", Value: record.Body},
+	}}}
+
+	result, err := ms.idempotencyKeys().UpdateOne(ctx, bson.D{{Key: "_id", Value: record.Key}}, update)
+	if err != nil {
+		return wrapErr(err)
+	}
+	if result.MatchedCount == 0 {
+		return errs.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteIdempotencyRecord releases key so that the request can be retried
+func (ms *MongoStore) DeleteIdempotencyRecord(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	result, err := ms.idempotencyKeys().DeleteOne(ctx, bson.D{{Key: "_id", Value: key}})
+	if err != nil {
+		return wrapErr(err)
+	}
+	if result.DeletedCount == 0 {
+		return errs.ErrNotFound
+	}
+	return nil
+}
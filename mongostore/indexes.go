@@ -0,0 +1,191 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// EnsureIndexes creates the indexes the store needs
+//
+// creating an index that already exists is a no-op, so this is safe to call on every start up.
+// it also gives projects and tasks stored before they had a version their first version,
+// and documents stored before they had an owner the anonymous owner ""
+func (ms *MongoStore) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	err := ms.backfillVersions(ctx)
+	if err != nil {
+		return err
+	}
+	err = ms.backfillOwners(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = ms.Collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("owner_id"),
+		},
+		{
+			// projects shared with a user are listed next to their own, see visible
+			Keys:    bson.D{{Key: "collaborators.userId", Value: 1}},
+			Options: options.Index().SetName("collaborators_userId"),
+		},
+		{
+			// a collection can only have one text index, so it covers both projects and tasks
+			Keys: bson.D{
+				{Key: "projname", Value: "text"},
+				{Key: "tasks.name", Value: "text"},
+				{Key: "tasks.description", Value: "text"},
+			},
+			Options: options.Index().SetName("search").SetWeights(bson.D{
+				{Key: "projname", Value: 2},
+				{Key: "tasks.name", Value: 2},
+				{Key: "tasks.description", Value: 1},
+			}),
+		},
+		{
+			Keys:    bson.D{{Key: "tasks.labels", Value: 1}},
+			Options: options.Index().SetName("tasks_labels"),
+		},
+		{
+			// pending reminders are looked up by fire time, see DueReminders
+			Keys:    bson.D{{Key: "tasks.reminders.fireAt", Value: 1}},
+			Options: options.Index().SetName("tasks_reminders_fireAt"),
+		},
+	})
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	// label names used to be unique across every user
+	err = dropIndex(ctx, ms.labels(), "name")
+	if err != nil {
+		return err
+	}
+	_, err = ms.labels().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "name", Value: 1}},
+		Options: options.Index().SetName("owner_name").SetUnique(true),
+	})
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	_, err = ms.webhooks().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "_id", Value: 1}},
+		Options: options.Index().SetName("owner_id"),
+	})
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	_, err = ms.deliveries().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "webhookId", Value: 1}, {Key: "deliveredAt", Value: -1}},
+		Options: options.Index().SetName("webhookId_deliveredAt"),
+	})
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	_, err = ms.historyEntries().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "kind", Value: 1}, {Key: "entityId", Value: 1}, {Key: "time", Value: 1}},
+		Options: options.Index().SetName("owner_kind_entityId_time"),
+	})
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	// mongo removes idempotency records once they expire
+	_, err = ms.idempotencyKeys().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetName("expiresAt").SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	_, err = ms.users().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetName("email").SetUnique(true),
+	})
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	// mongo removes refresh tokens once they expire
+	_, err = ms.refreshTokens().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "family", Value: 1}},
+			Options: options.Index().SetName("family"),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetName("expiresAt").SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	_, err = ms.personalTokens().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetName("hash").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("userId_id"),
+		},
+	})
+	return wrapErr(err)
+}
+
+// backfillVersions sets version 1 on projects and tasks that have no version yet
+func (ms *MongoStore) backfillVersions(ctx context.Context) error {
+	unversioned := bson.D{{Key: "$exists", Value: false}}
+
+	_, err := ms.Collection.UpdateMany(ctx,
+		bson.D{{Key: "version", Value: unversioned}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "version", Value: 1}}}})
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	_, err = ms.Collection.UpdateMany(ctx,
+		bson.D{{Key: "tasks", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "version", Value: unversioned}}}}}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "tasks.$[t].version", Value: 1}}}},
+		options.UpdateMany().SetArrayFilters([]any{bson.D{{Key: "t.version", Value: unversioned}}}))
+	return wrapErr(err)
+}
+
+// backfillOwners gives the projects, labels, webhooks and history entries that have no owner yet the anonymous owner ""
+func (ms *MongoStore) backfillOwners(ctx context.Context) error {
+	filter := bson.D{{Key: "owner", Value: bson.D{{Key: "$exists", Value: false}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "owner", Value: ""}}}}
+
+	for _, collection := range []*mongo.Collection{ms.Collection, ms.labels(), ms.webhooks(), ms.historyEntries()} {
+		_, err := collection.UpdateMany(ctx, filter, update)
+		if err != nil {
+			return wrapErr(err)
+		}
+	}
+	return nil
+}
+
+// dropIndex drops the index name of collection, an index that does not exist is not an error
+func dropIndex(ctx context.Context, collection *mongo.Collection, name string) error {
+	err := collection.Indexes().DropOne(ctx, name)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Code == 27 || cmdErr.Code == 26) {
+		// IndexNotFound, or NamespaceNotFound if the collection does not exist yet
+		return nil
+	}
+	return wrapErr(err)
+}
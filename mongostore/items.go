@@ -0,0 +1,197 @@
+package mongostore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+	"github.com/ganglinwu/todoapp-backend-v1/rank"
+)
+
+// checklist items are embedded in their task, which is itself embedded in a project:
+//
+//	{_id, projname, tasks: [{_id, ..., items: [{_id, name, completed, rank}]}]}
+//
+// the updates address the task with the arrayFilter "t" and the item with "i",
+// and they all bump the version of the task
+
+// AddItem
+//
+// - the item goes to the end of the checklist
+// - returns errs.ErrNotFound if the todo does not exist or is in the trash
+func (ms *MongoStore) AddItem(ctx context.Context, TodoID string, item models.ChecklistItem) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	todo, err := ms.GetTodoByID(ctx, TodoID)
+	if err != nil {
+		return "", err
+	}
+
+	lastRank := ""
+	for _, existing := range todo.Items {
+		lastRank = max(lastRank, existing.Rank)
+	}
+
+	item.ID = bson.NewObjectID().Hex()
+	item.Rank = rank.After(lastRank)
+
+	query := taskQuery(ctx, *todo.ID, 0)
+	update := append(bson.D{{Key: "$push", Value: bson.D{{Key: "tasks.$.items", Value: item}}}}, bumpVersion("tasks.$")...)
+
+	err = ms.recordTask(ctx, models.OpAddItem, *todo.ID, func(ctx context.Context) error {
+		result, err := ms.Collection.UpdateOne(ctx, query, update)
+		if err != nil {
+			return wrapErr(err)
+		}
+		if result.MatchedCount == 0 {
+			return errs.ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return item.ID, nil
+}
+
+// UpdateItem sets the name and completed state of an item
+//
+// - returns errs.ErrNotFound if the item is not on the todo's checklist
+func (ms *MongoStore) UpdateItem(ctx context.Context, TodoID, itemID string, item models.ChecklistItem) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	query, todoID, err := itemQuery(TodoID, itemID)
+	if err != nil {
+		return err
+	}
+	opts := options.UpdateOne().SetArrayFilters([]any{
+		bson.D{{Key: "t._id", Value: todoID}},
+		bson.D{{Key: "i._id", Value: itemID}},
+	})
+
+	update := append(bson.D{{Key: "$set", Value: bson.D{
+		{Key: "tasks.$[t].items.$[i].name", Value: item.Name},
+		{Key: "tasks.$[t].items.$[i].completed", Value: item.Completed},
+	}}}, bumpVersion("tasks.$[t]")...)
+
+	return ms.recordTask(ctx, models.OpUpdateItem, todoID, func(ctx context.Context) error {
+		result, err := ms.Collection.UpdateOne(ctx, query, update, opts)
+		if err != nil {
+			return wrapErr(err)
+		}
+		if result.MatchedCount == 0 {
+			return errs.ErrNotFound
+		}
+		return nil
+	})
+}
+
+// ReorderItem
+//
+// works the same way as ReorderTodo, within the todo's checklist
+func (ms *MongoStore) ReorderItem(ctx context.Context, TodoID, itemID string, place models.Placement) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	anchorID, after := place.Before, false
+	if place.After != "" {
+		anchorID, after = place.After, true
+	}
+
+	todo, err := ms.GetTodoByID(ctx, TodoID)
+	if err != nil {
+		return err
+	}
+
+	models.SortItems(todo.Items)
+
+	keys := make([]string, len(todo.Items))
+	for i, item := range todo.Items {
+		keys[i] = item.Rank
+	}
+
+	from := slices.IndexFunc(todo.Items, func(item models.ChecklistItem) bool { return item.ID == itemID })
+	anchor := slices.IndexFunc(todo.Items, func(item models.ChecklistItem) bool { return item.ID == anchorID })
+	if from == -1 || anchor == -1 {
+		return fmt.Errorf("%w: item is not on the checklist of todo %q", errs.ErrNotFound, TodoID)
+	}
+
+	set := bson.D{}
+	filters := []any{bson.D{{Key: "t._id", Value: todo.ID}}}
+	for i, key := range rank.Move(keys, from, anchor, after) {
+		name := "i" + strconv.Itoa(i)
+		set = append(set, bson.E{Key: "tasks.$[t].items.$[" + name + "].rank", Value: key})
+		filters = append(filters, bson.D{{Key: name + "._id", Value: todo.Items[i].ID}})
+	}
+
+	query := taskQuery(ctx, *todo.ID, 0)
+	update := append(bson.D{{Key: "$set", Value: set}}, bumpVersion("tasks.$[t]")...)
+
+	return ms.recordTask(ctx, models.OpReorderItem, *todo.ID, func(ctx context.Context) error {
+		result, err := ms.Collection.UpdateOne(ctx, query, update, options.UpdateOne().SetArrayFilters(filters))
+		if err != nil {
+			return wrapErr(err)
+		}
+		if result.MatchedCount == 0 {
+			return errs.ErrNotFound
+		}
+		return nil
+	})
+}
+
+// DeleteItem
+//
+// - returns errs.ErrNotFound if the item is not on the todo's checklist
+func (ms *MongoStore) DeleteItem(ctx context.Context, TodoID, itemID string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	query, todoID, err := itemQuery(TodoID, itemID)
+	if err != nil {
+		return 0, err
+	}
+
+	update := append(bson.D{{Key: "$pull", Value: bson.D{{Key: "tasks.$[t].items", Value: bson.D{{Key: "_id", Value: itemID}}}}}}, bumpVersion("tasks.$[t]")...)
+	opts := options.UpdateOne().SetArrayFilters([]any{bson.D{{Key: "t._id", Value: todoID}}})
+
+	deletedCount := 0
+	err = ms.recordTask(ctx, models.OpDeleteItem, todoID, func(ctx context.Context) error {
+		result, err := ms.Collection.UpdateOne(ctx, query, update, opts)
+		if err != nil {
+			return wrapErr(err)
+		}
+		if result.MatchedCount == 0 {
+			return errs.ErrNotFound
+		}
+		deletedCount = int(result.ModifiedCount)
+		return nil
+	})
+	return deletedCount, err
+}
+
+// itemQuery matches the project whose task TodoID has the item itemID,
+// unless the task is in the trash
+//
+// the parsed task id is returned for the "t" arrayFilter
+func itemQuery(TodoID, itemID string) (bson.D, bson.ObjectID, error) {
+	todoID, err := parseObjectID(TodoID)
+	if err != nil {
+		return nil, bson.ObjectID{}, err
+	}
+
+	query := bson.D{{Key: "tasks", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+		{Key: "_id", Value: todoID},
+		{Key: "items._id", Value: itemID},
+		notDeleted,
+	}}}}}
+	return query, todoID, nil
+}
@@ -0,0 +1,186 @@
+package mongostore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// labelsCollection holds the label catalogue, next to the projects collection
+//
+// tasks refer to their labels by id in tasks.labels
+const labelsCollection = "labels"
+
+func (ms *MongoStore) labels() *mongo.Collection {
+	return ms.Collection.Database().Collection(labelsCollection)
+}
+
+func (ms *MongoStore) GetAllLabels(ctx context.Context) ([]models.Label, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}})
+
+	cursor, err := ms.labels().Find(ctx, bson.D{owned(ctx)}, opts)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	labels := []models.Label{}
+	err = cursor.All(ctx, &labels)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	return labels, nil
+}
+
+func (ms *MongoStore) GetLabelByID(ctx context.Context, ID string) (models.Label, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	_, err := parseObjectID(ID)
+	if err != nil {
+		return models.Label{}, err
+	}
+
+	label := models.Label{}
+	err = ms.labels().FindOne(ctx, bson.D{{Key: "_id", Value: ID}, owned(ctx)}).Decode(&label)
+	if err != nil {
+		return models.Label{}, wrapErr(err)
+	}
+	return label, nil
+}
+
+// CreateLabel
+//
+// - returns errs.ErrConflict if a label of the same owner has the same name
+func (ms *MongoStore) CreateLabel(ctx context.Context, label models.Label) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	label.ID = bson.NewObjectID().Hex()
+	label.Owner = auth.Owner(ctx)
+
+	_, err := ms.labels().InsertOne(ctx, label)
+	if err != nil {
+		return "", wrapErr(err)
+	}
+	return label.ID, nil
+}
+
+// UpdateLabel
+//
+// - returns errs.ErrConflict if another label of the same owner has the same name
+func (ms *MongoStore) UpdateLabel(ctx context.Context, ID string, label models.Label) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	_, err := parseObjectID(ID)
+	if err != nil {
+		return err
+	}
+
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "name", Value: label.Name},
+		{Key: "color", Value: label.Color},
+	}}}
+
+	result, err := ms.labels().UpdateOne(ctx, bson.D{{Key: "_id", Value: ID}, owned(ctx)}, update)
+	if err != nil {
+		return wrapErr(err)
+	}
+	if result.MatchedCount == 0 {
+		return errs.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteLabel
+//
+// the label is deleted and pulled from every task that has it inside a transaction
+func (ms *MongoStore) DeleteLabel(ctx context.Context, ID string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	_, err := parseObjectID(ID)
+	if err != nil {
+		return 0, err
+	}
+
+	session, err := ms.Conn.StartSession()
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+	defer session.EndSession(ctx)
+
+	deletedCount, err := session.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+		result, err := ms.labels().DeleteOne(ctx, bson.D{{Key: "_id", Value: ID}, owned(ctx)})
+		if err != nil {
+			return 0, wrapErr(err)
+		}
+		if result.DeletedCount == 0 {
+			return 0, errs.ErrNotFound
+		}
+
+		query := bson.D{owned(ctx), {Key: "tasks.labels", Value: ID}}
+		update := bson.D{{Key: "$pull", Value: bson.D{{Key: "tasks.$[].labels", Value: ID}}}}
+
+		_, err = ms.Collection.UpdateMany(ctx, query, update)
+		if err != nil {
+			return 0, wrapErr(err)
+		}
+		return int(result.DeletedCount), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deletedCount.(int), nil
+}
+
+// checkLabels returns errs.ErrValidation if any of the labels does not exist
+func (ms *MongoStore) checkLabels(ctx context.Context, labels []string) error {
+	known, err := ms.existingLabels(ctx, labels)
+	if err != nil {
+		return err
+	}
+	for _, label := range labels {
+		if !known[label] {
+			return fmt.Errorf("%w: unknown label %q", errs.ErrValidation, label)
+		}
+	}
+	return nil
+}
+
+// existingLabels looks up which of the given label ids exist and belong to the owner of ctx, in a single query
+func (ms *MongoStore) existingLabels(ctx context.Context, labels []string) (map[string]bool, error) {
+	known := map[string]bool{}
+	if len(labels) == 0 {
+		return known, nil
+	}
+
+	filter := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: labels}}}, owned(ctx)}
+	opts := options.Find().SetProjection(bson.D{{Key: "_id", Value: 1}})
+
+	cursor, err := ms.labels().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	found := []models.Label{}
+	err = cursor.All(ctx, &found)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	for _, label := range found {
+		known[label.ID] = true
+	}
+	return known, nil
+}
@@ -1,17 +1,25 @@
 package mongostore
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"math"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
 	"time"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
 	"github.com/ganglinwu/todoapp-backend-v1/errs"
 	"github.com/ganglinwu/todoapp-backend-v1/models"
+	"github.com/ganglinwu/todoapp-backend-v1/rank"
+	"github.com/ganglinwu/todoapp-backend-v1/textsearch"
 	"github.com/joho/godotenv"
 )
 
@@ -71,51 +79,57 @@ func GetDBNameCollectionName(dbName, collName *string) (*string, *string, error)
 	return dbName, collName, nil
 }
 
-func (ms *MongoStore) GetProjByID(ID string) (models.PROJECT, error) {
+func (ms *MongoStore) GetProjByID(ctx context.Context, ID string) (models.PROJECT, error) {
 	if ID == "" {
 		return models.PROJECT{}, errs.ErrNotFound
 	}
-	objectID, err := bson.ObjectIDFromHex(ID)
+	objectID, err := parseObjectID(ID)
 	if err != nil {
 		return models.PROJECT{}, err
 	}
-	filter := bson.D{{Key: "_id", Value: &objectID}}
+	filter := projQuery(ctx, objectID, 0)
 
 	proj := models.PROJECT{}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
 	err = ms.Collection.FindOne(ctx, filter).Decode(&proj)
 	if err != nil {
-		return models.PROJECT{}, errs.ErrNotFound
+		return models.PROJECT{}, wrapErr(err)
 	}
 
+	proj.Tasks = liveTasks(proj.Tasks)
+	models.SortTasks(proj.Tasks)
 	return proj, nil
 }
 
-func (ms *MongoStore) GetAllProjs() ([]models.PROJECT, error) {
+func (ms *MongoStore) GetAllProjs(ctx context.Context) ([]models.PROJECT, error) {
 	projs := []models.PROJECT{}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
-	filter := bson.D{{}}
+	filter := bson.D{visible(ctx), notDeleted}
 
 	cursor, err := ms.Collection.Find(ctx, filter)
 	if err != nil {
-		return []models.PROJECT{}, err
+		return []models.PROJECT{}, wrapErr(err)
 	}
 
 	err = cursor.All(ctx, &projs)
 	if err != nil {
-		return []models.PROJECT{}, err
+		return []models.PROJECT{}, wrapErr(err)
 	}
 
+	for i := range projs {
+		projs[i].Tasks = liveTasks(projs[i].Tasks)
+		models.SortTasks(projs[i].Tasks)
+	}
 	return projs, nil
 }
 
-func (ms *MongoStore) GetAllTodos() ([]models.TODO, error) {
-	projs, err := ms.GetAllProjs()
+func (ms *MongoStore) GetAllTodos(ctx context.Context) ([]models.TODO, error) {
+	projs, err := ms.GetAllProjs(ctx)
 	if err != nil {
 		return []models.TODO{}, err
 	}
@@ -126,16 +140,26 @@ func (ms *MongoStore) GetAllTodos() ([]models.TODO, error) {
 	return todos, nil
 }
 
-func (ms *MongoStore) CreateTodo(projID string, newTodoWithoutID models.TODO) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+// CreateTodo
+//
+// - returns errs.ErrNotFound if the project does not exist or is in the trash
+// (we no longer upsert, that used to create a nameless project)
+// - returns errs.ErrValidation if any of the todo's labels does not exist
+func (ms *MongoStore) CreateTodo(ctx context.Context, projID string, newTodoWithoutID models.TODO) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
-	objID, err := bson.ObjectIDFromHex(projID)
+	objID, err := parseObjectID(projID)
 	if err != nil {
 		return "", err
 	}
 
-	query := bson.D{{Key: "_id", Value: &objID}}
+	err = ms.checkLabels(ctx, newTodoWithoutID.Labels)
+	if err != nil {
+		return "", err
+	}
+
+	query := projQuery(ctx, objID, 0)
 
 	// generate new ObjectID for created todo
 	todoID := bson.NewObjectID()
@@ -150,149 +174,920 @@ func (ms *MongoStore) CreateTodo(projID string, newTodoWithoutID models.TODO) (s
 		upsertedID = newTodoWithoutID.ID.Hex()
 	}
 
-	update := bson.D{{Key: "$push", Value: bson.D{{Key: "tasks", Value: newTodoWithoutID}}}}
+	// new todos go to the end of the project
+	lastRanks, err := ms.lastRanks(ctx, bson.A{objID})
+	if err != nil {
+		return "", err
+	}
+	newTodoWithoutID.Rank = rank.After(lastRanks[projID])
+	newTodoWithoutID.Version = 1
 
-	opts := options.UpdateOne().SetUpsert(true)
+	update := bson.D{{Key: "$push", Value: bson.D{{Key: "tasks", Value: newTodoWithoutID}}}}
 
-	_, err = ms.Collection.UpdateOne(ctx, query, update, opts)
+	err = ms.withHistory(ctx, func(ctx context.Context) (models.HistoryEntry, error) {
+		result, err := ms.Collection.UpdateOne(ctx, query, update)
+		if err != nil {
+			return models.HistoryEntry{}, wrapErr(err)
+		}
+		if result.MatchedCount == 0 {
+			return models.HistoryEntry{}, errs.ErrNotFound
+		}
+		return ms.taskEntry(ctx, models.OpCreate, *newTodoWithoutID.ID, models.TODO{})
+	})
 	if err != nil {
 		return "", err
 	}
-
 	return upsertedID, nil
 }
 
-func (ms *MongoStore) CreateProj(ProjName string, Tasks []models.TODO) (string, error) {
+func (ms *MongoStore) CreateProj(ctx context.Context, ProjName string, Tasks []models.TODO) (string, error) {
 	// TODO: check if duplicate proj exists
-	proj := models.PROJECT{ProjName: ProjName, Tasks: Tasks}
+	proj := models.PROJECT{ProjName: ProjName, Tasks: Tasks, Version: 1, Owner: auth.Owner(ctx)}
+
+	// tasks keep the order they were given in
+	for i, key := range rank.Spread(len(Tasks)) {
+		proj.Tasks[i].Rank = key
+		proj.Tasks[i].Version = 1
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
-	result, err := ms.Collection.InsertOne(ctx, proj)
+	objID := bson.NewObjectID()
+	proj.ID = &objID
+
+	err := ms.withHistory(ctx, func(ctx context.Context) (models.HistoryEntry, error) {
+		_, err := ms.Collection.InsertOne(ctx, proj)
+		if err != nil {
+			return models.HistoryEntry{}, wrapErr(err)
+		}
+		return ms.projEntry(ctx, models.OpCreate, objID, models.PROJECT{})
+	})
 	if err != nil {
 		return "", err
 	}
 
-	objID := result.InsertedID.(bson.ObjectID)
 	IDstr := objID.Hex()
 
 	return IDstr, nil
 }
 
-func (ms *MongoStore) UpdateTodoByID(ID string, newTodoWithoutID models.TODO) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+// lastRanks returns the highest task rank of each of the given projects, by project id
+//
+// projects without tasks, or that do not exist, are missing from the result
+func (ms *MongoStore) lastRanks(ctx context.Context, projIDs bson.A) (map[string]string, error) {
+	filter := bson.D{owned(ctx), {Key: "_id", Value: bson.D{{Key: "$in", Value: projIDs}}}}
+	opts := options.Find().SetProjection(bson.D{{Key: "tasks.rank", Value: 1}})
+
+	cursor, err := ms.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	projs := []models.PROJECT{}
+	err = cursor.All(ctx, &projs)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	last := map[string]string{}
+	for _, proj := range projs {
+		for _, task := range proj.Tasks {
+			last[proj.ID.Hex()] = max(last[proj.ID.Hex()], task.Rank)
+		}
+	}
+	return last, nil
+}
+
+// UpdateTodoByID replaces the stored task with newTodoWithoutID
+//
+// - only replaces the task while it is at newTodoWithoutID.Version, 0 stands for the
+// version it is at now. the version is part of the query so the check and the write are atomic
+// - returns errs.ErrPreconditionFailed if the task is at another version
+// - returns errs.ErrValidation if any of the todo's labels does not exist
+func (ms *MongoStore) UpdateTodoByID(ctx context.Context, ID string, newTodoWithoutID models.TODO) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
-	objID, err := bson.ObjectIDFromHex(ID)
+	objID, err := parseObjectID(ID)
 	if err != nil {
 		return err
 	}
 
-	query := bson.D{{Key: "tasks._id", Value: &objID}}
+	err = ms.checkLabels(ctx, newTodoWithoutID.Labels)
+	if err != nil {
+		return err
+	}
+
+	version := newTodoWithoutID.Version
+	if version == 0 {
+		current, err := ms.GetTodoByID(ctx, ID)
+		if err != nil {
+			return err
+		}
+		version = current.Version
+	}
+
+	query := taskQuery(ctx, objID, version)
 
 	// we need to add in ID
 	// else we will be updating with an object without ID!
 	newTodoWithoutID.ID = &objID
+	newTodoWithoutID.Version = version + 1
 
 	update := bson.D{{Key: "$set", Value: bson.D{{Key: "tasks.$", Value: newTodoWithoutID}}}}
 
-	result, err := ms.Collection.UpdateOne(ctx, query, update)
-	if err != nil {
-		return err
-	}
-	// TODO: check result output
-	// e.g. 0 0 0 <ID>
-	// - MatchedCount, ModifiedCount, UpsertedCount, ObjectID of upserted document
-	fmt.Println(result)
-	return nil
+	return ms.recordTask(ctx, models.OpUpdate, objID, func(ctx context.Context) error {
+		result, err := ms.Collection.UpdateOne(ctx, query, update)
+		if err != nil {
+			return wrapErr(err)
+		}
+		if result.MatchedCount == 0 {
+			return ms.versionConflict(ctx, taskQuery(ctx, objID, 0), version)
+		}
+		return nil
+	})
 }
 
-func (ms *MongoStore) UpdateProjNameByID(ID, newProjName string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+// PatchTodo sets the fields of the stored task to their value in todo
+//
+// - a field that is empty is removed from the task, the way UpdateTodoByID leaves it out
+// - only patches the task while it is at todo.Version, 0 matches any version
+// - returns errs.ErrPreconditionFailed if the task is at another version
+// - returns errs.ErrValidation if any of the todo's labels does not exist,
+// or for a field that cannot be patched, see the models.Field constants
+func (ms *MongoStore) PatchTodo(ctx context.Context, ID string, todo models.TODO, fields []string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
-	projID, err := bson.ObjectIDFromHex(ID)
+	objID, err := parseObjectID(ID)
 	if err != nil {
 		return err
 	}
 
-	query := bson.D{{Key: "_id", Value: &projID}}
+	if slices.Contains(fields, models.FieldLabels) {
+		err = ms.checkLabels(ctx, todo.Labels)
+		if err != nil {
+			return err
+		}
+	}
+
+	set := bson.D{{Key: "tasks.$.updated_at", Value: todo.Updated_at}}
+	unset := bson.D{}
+	for _, field := range fields {
+		value, err := patchValue(todo, field)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			unset = append(unset, bson.E{Key: "tasks.$." + field, Value: ""})
+		} else {
+			set = append(set, bson.E{Key: "tasks.$." + field, Value: value})
+		}
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: set},
+		{Key: "$inc", Value: bson.D{{Key: "tasks.$.version", Value: 1}}},
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+
+	return ms.recordTask(ctx, models.OpUpdate, objID, func(ctx context.Context) error {
+		result, err := ms.Collection.UpdateOne(ctx, taskQuery(ctx, objID, todo.Version), update)
+		if err != nil {
+			return wrapErr(err)
+		}
+		if result.MatchedCount == 0 {
+			return ms.versionConflict(ctx, taskQuery(ctx, objID, 0), todo.Version)
+		}
+		return nil
+	})
+}
+
+// patchValue is the value PatchTodo sets field of the task to, nil if the field is removed from the task
+//
+// the task fields are stored under the json names of the todo, so field is also the key in the task
+func patchValue(todo models.TODO, field string) (any, error) {
+	switch field {
+	case models.FieldName:
+		return todo.Name, nil
+	case models.FieldCompleted:
+		return todo.Completed, nil
+	case models.FieldDescription:
+		return omitEmpty(todo.Description), nil
+	case models.FieldDueDate:
+		if todo.DueDate == nil {
+			return nil, nil
+		}
+		return todo.DueDate, nil
+	case models.FieldPriority:
+		return omitEmpty(todo.Priority), nil
+	case models.FieldRecurrence:
+		return omitEmpty(todo.Recurrence), nil
+	case models.FieldOccurrence:
+		return omitEmpty(todo.Occurrence), nil
+	case models.FieldLabels:
+		if len(todo.Labels) == 0 {
+			return nil, nil
+		}
+		return todo.Labels, nil
+	case models.FieldReminders:
+		if len(todo.Reminders) == 0 {
+			return nil, nil
+		}
+		return todo.Reminders, nil
+	}
+	return nil, fmt.Errorf("%w: %q cannot be patched", errs.ErrValidation, field)
+}
+
+// omitEmpty is nil for the zero value of T, omitempty leaves it out of a stored task
+func omitEmpty[T comparable](value T) any {
+	var zero T
+	if value == zero {
+		return nil
+	}
+	return value
+}
 
-	update := bson.D{{Key: "$set", Value: bson.D{{Key: "projname", Value: newProjName}}}}
+// notDeleted matches projects and tasks that are not in the trash,
+// documents stored before the trash existed have no deletedAt at all
+var notDeleted = bson.E{Key: "deletedAt", Value: nil}
+
+// owned matches the projects, labels, webhooks and history entries of the owner of ctx, see auth.Owner
+//
+// tasks are embedded in their project, they are owned by the owner of the project
+func owned(ctx context.Context) bson.E {
+	return bson.E{Key: "owner", Value: auth.Owner(ctx)}
+}
+
+// taskQuery matches the project of the owner of ctx that holds the task todoID while the task is at version,
+// 0 matches any version
+//
+// tasks in the trash are never matched. trashing a project trashes its tasks,
+// so a task that is not in the trash always belongs to a project that is not either
+func taskQuery(ctx context.Context, todoID bson.ObjectID, version int) bson.D {
+	task := bson.D{{Key: "_id", Value: todoID}, notDeleted}
+	if version != 0 {
+		task = append(task, bson.E{Key: "version", Value: version})
+	}
+	return bson.D{owned(ctx), {Key: "tasks", Value: bson.D{{Key: "$elemMatch", Value: task}}}}
+}
+
+// projQuery matches the project projID of the owner of ctx while it is at version, 0 matches any version
+//
+// projects in the trash are never matched
+func projQuery(ctx context.Context, projID bson.ObjectID, version int) bson.D {
+	if version == 0 {
+		return bson.D{{Key: "_id", Value: projID}, owned(ctx), notDeleted}
+	}
+	return bson.D{{Key: "_id", Value: projID}, owned(ctx), notDeleted, {Key: "version", Value: version}}
+}
+
+// liveTasks drops the tasks that are in the trash
+func liveTasks(tasks []models.TODO) []models.TODO {
+	return slices.DeleteFunc(tasks, func(task models.TODO) bool { return task.DeletedAt != nil })
+}
 
-	result, err := ms.Collection.UpdateOne(ctx, query, update)
+// versionConflict is called when a query that includes the version matched nothing,
+// it tells errs.ErrNotFound from errs.ErrPreconditionFailed by running query again
+// without the version
+func (ms *MongoStore) versionConflict(ctx context.Context, query bson.D, version int) error {
+	count, err := ms.Collection.CountDocuments(ctx, query, options.Count().SetLimit(1))
 	if err != nil {
-		return err
+		return wrapErr(err)
 	}
-	// TODO: check result output
-	// e.g. 0 0 0 <ID>
-	// - MatchedCount, ModifiedCount, UpsertedCount, ObjectID of upserted document
-	fmt.Println(result)
-	return nil
+	if count == 0 {
+		return errs.ErrNotFound
+	}
+	return fmt.Errorf("%w: no longer at version %d", errs.ErrPreconditionFailed, version)
 }
 
-func (ms *MongoStore) DeleteProjByID(ID string) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+// UpdateProjNameByID
+//
+// - only renames the project while it is at version, 0 matches any version
+// - returns errs.ErrPreconditionFailed if the project is at another version
+func (ms *MongoStore) UpdateProjNameByID(ctx context.Context, ID, newProjName string, version int) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
-	objID, err := bson.ObjectIDFromHex(ID)
+	projID, err := parseObjectID(ID)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	dr, err := ms.Collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: objID}})
-	if err != nil {
-		return 0, err
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "projname", Value: newProjName}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
 	}
-	return int(dr.DeletedCount), nil
+
+	return ms.recordProj(ctx, models.OpUpdate, projID, func(ctx context.Context) error {
+		result, err := ms.Collection.UpdateOne(ctx, projQuery(ctx, projID, version), update)
+		if err != nil {
+			return wrapErr(err)
+		}
+		if result.MatchedCount == 0 {
+			return ms.versionConflict(ctx, projQuery(ctx, projID, 0), version)
+		}
+		return nil
+	})
 }
 
-func (ms *MongoStore) DeleteTodoByID(TodoID string) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+// DeleteProjByID
+//
+// - moves the project and the tasks in it to the trash, they share the deletedAt
+// so that RestoreProj knows which tasks to bring back
+// - only deletes the project while it is at version, 0 matches any version
+// - returns errs.ErrPreconditionFailed if the project is at another version
+func (ms *MongoStore) DeleteProjByID(ctx context.Context, ID string, version int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
-	todoID, err := bson.ObjectIDFromHex(TodoID)
+	objID, err := parseObjectID(ID)
 	if err != nil {
 		return 0, err
 	}
 
-	query := bson.D{{Key: "tasks._id", Value: &todoID}}
+	// mongo keeps milliseconds, truncating keeps deletedAt equal to what is read back
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "deletedAt", Value: now}, {Key: "tasks.$[t].deletedAt", Value: now}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}, {Key: "tasks.$[t].version", Value: 1}}},
+	}
+	opts := options.UpdateOne().SetArrayFilters([]any{bson.D{{Key: "t.deletedAt", Value: nil}}})
 
-	update := bson.D{{Key: "$pull", Value: bson.D{{Key: "tasks", Value: bson.D{{Key: "_id", Value: &todoID}}}}}}
+	deletedCount := 0
+	err = ms.recordProj(ctx, models.OpDelete, objID, func(ctx context.Context) error {
+		result, err := ms.Collection.UpdateOne(ctx, projQuery(ctx, objID, version), update, opts)
+		if err != nil {
+			return wrapErr(err)
+		}
+		if result.MatchedCount == 0 {
+			return ms.versionConflict(ctx, projQuery(ctx, objID, 0), version)
+		}
+		deletedCount = int(result.MatchedCount)
+		return nil
+	})
+	return deletedCount, err
+}
 
-	updateResult, err := ms.Collection.UpdateOne(ctx, query, update)
+// DeleteTodoByID
+//
+// - moves the task to the trash, it stays embedded in its project
+// - only deletes the task while it is at version, 0 matches any version
+// - returns errs.ErrPreconditionFailed if the task is at another version
+func (ms *MongoStore) DeleteTodoByID(ctx context.Context, TodoID string, version int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	todoID, err := parseObjectID(TodoID)
 	if err != nil {
 		return 0, err
 	}
 
-	deletedCount := updateResult.ModifiedCount
+	query := taskQuery(ctx, todoID, version)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	update := append(bson.D{{Key: "$set", Value: bson.D{{Key: "tasks.$.deletedAt", Value: now}}}}, bumpVersion("tasks.$")...)
 
-	return int(deletedCount), nil
+	deletedCount := 0
+	err = ms.recordTask(ctx, models.OpDelete, todoID, func(ctx context.Context) error {
+		updateResult, err := ms.Collection.UpdateOne(ctx, query, update)
+		if err != nil {
+			return wrapErr(err)
+		}
+		if updateResult.MatchedCount == 0 {
+			return ms.versionConflict(ctx, taskQuery(ctx, todoID, 0), version)
+		}
+		deletedCount = int(updateResult.ModifiedCount)
+		return nil
+	})
+	return deletedCount, err
 }
 
-func (ms *MongoStore) GetTodoByID(TodoID string) (models.TODO, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+func (ms *MongoStore) GetTodoByID(ctx context.Context, TodoID string) (models.TODO, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
-	todoID, err := bson.ObjectIDFromHex(TodoID)
+	todoID, err := parseObjectID(TodoID)
 	if err != nil {
 		return models.TODO{}, err
 	}
 
 	projThatContainsTodo := models.PROJECT{}
 
-	query := bson.D{{Key: "tasks._id", Value: &todoID}}
+	query := taskQuery(ctx, todoID, 0)
 
 	err = ms.Collection.FindOne(ctx, query).Decode(&projThatContainsTodo)
 	if err != nil {
-		return models.TODO{}, err
+		return models.TODO{}, wrapErr(err)
 	}
 
 	for _, todo := range projThatContainsTodo.Tasks {
 		if todo.ID.Hex() == TodoID {
+			todo.ProjID = projThatContainsTodo.ID.Hex()
+			models.SortItems(todo.Items)
 			return todo, nil
 		}
 	}
 	return models.TODO{}, errs.ErrNotFound
 }
+
+// MoveTodo
+//
+// tasks are embedded in their project, so the task is pulled out of one project
+// document and pushed onto the other inside a transaction
+//
+// - the task is copied as it is stored, its ID and timestamps are kept
+// - the history entry is written in the same transaction
+// - the task goes to the end of the project and its version is bumped
+// - returns errs.ErrNotFound if either the todo or the project does not exist or is in the trash
+func (ms *MongoStore) MoveTodo(ctx context.Context, TodoID, ProjID string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	todoID, err := parseObjectID(TodoID)
+	if err != nil {
+		return err
+	}
+	projID, err := parseObjectID(ProjID)
+	if err != nil {
+		return err
+	}
+
+	return ms.recordTask(ctx, models.OpMove, todoID, func(ctx context.Context) error {
+		// the project that holds the task, with only that task projected
+		source := struct {
+			ID    bson.ObjectID `bson:"_id"`
+			Tasks []bson.D      `bson:"tasks"`
+		}{}
+
+		opts := options.FindOne().SetProjection(bson.D{{Key: "tasks.$", Value: 1}})
+
+		err := ms.Collection.FindOne(ctx, taskQuery(ctx, todoID, 0), opts).Decode(&source)
+		if err != nil {
+			return wrapErr(err)
+		}
+		if source.ID == projID {
+			return nil
+		}
+
+		pull := bson.D{{Key: "$pull", Value: bson.D{{Key: "tasks", Value: bson.D{{Key: "_id", Value: todoID}}}}}}
+		_, err = ms.Collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: source.ID}}, pull)
+		if err != nil {
+			return wrapErr(err)
+		}
+
+		lastRanks, err := ms.lastRanks(ctx, bson.A{projID})
+		if err != nil {
+			return err
+		}
+		task := setField(source.Tasks[0], "rank", rank.After(lastRanks[ProjID]))
+
+		push := bson.D{{Key: "$push", Value: bson.D{{Key: "tasks", Value: task}}}}
+		result, err := ms.Collection.UpdateOne(ctx, projQuery(ctx, projID, 0), push)
+		if err != nil {
+			return wrapErr(err)
+		}
+		if result.MatchedCount == 0 {
+			return fmt.Errorf("%w: project %q", errs.ErrNotFound, ProjID)
+		}
+
+		_, err = ms.Collection.UpdateOne(ctx, taskQuery(ctx, todoID, 0), bumpVersion("tasks.$"))
+		return wrapErr(err)
+	})
+}
+
+// bumpVersion increments the version of the task at path, e.g. "tasks.$" or "tasks.$[t]"
+//
+// it is part of every update that changes a task without replacing it
+func bumpVersion(path string) bson.D {
+	return bson.D{{Key: "$inc", Value: bson.D{{Key: path + ".version", Value: 1}}}}
+}
+
+// setField sets key in doc, replacing it if it is already there
+func setField(doc bson.D, key string, value any) bson.D {
+	for i := range doc {
+		if doc[i].Key == key {
+			doc[i].Value = value
+			return doc
+		}
+	}
+	return append(doc, bson.E{Key: key, Value: value})
+}
+
+// ReorderTodo
+//
+// the tasks of a project live in one document, so their ranks are read,
+// handed to rank.Move, and the changed ranks are written back with a single
+// update that addresses each task by its _id through arrayFilters
+//
+// - normally only the moved task's rank changes, every task's rank changes
+// when the ranks have to be spread out again. every task whose rank changes gets a new version
+// - only the moved task gets a history entry
+// - returns errs.ErrValidation if the todos are in different projects
+func (ms *MongoStore) ReorderTodo(ctx context.Context, TodoID string, place models.Placement) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	anchorID, after := place.Before, false
+	if place.After != "" {
+		anchorID, after = place.After, true
+	}
+
+	todoID, err := parseObjectID(TodoID)
+	if err != nil {
+		return err
+	}
+	_, err = parseObjectID(anchorID)
+	if err != nil {
+		return err
+	}
+
+	return ms.recordTask(ctx, models.OpReorder, todoID, func(ctx context.Context) error {
+		return ms.reorderTask(ctx, todoID, anchorID, after)
+	})
+}
+
+// reorderTask moves the task todoID next to the task anchorID, see ReorderTodo
+func (ms *MongoStore) reorderTask(ctx context.Context, todoID bson.ObjectID, anchorID string, after bool) error {
+	proj := models.PROJECT{}
+	opts := options.FindOne().SetProjection(bson.D{{Key: "tasks._id", Value: 1}, {Key: "tasks.rank", Value: 1}, {Key: "tasks.deletedAt", Value: 1}})
+
+	err := ms.Collection.FindOne(ctx, taskQuery(ctx, todoID, 0), opts).Decode(&proj)
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	proj.Tasks = liveTasks(proj.Tasks)
+	models.SortTasks(proj.Tasks)
+
+	keys := make([]string, len(proj.Tasks))
+	from, anchor := -1, -1
+	for i, task := range proj.Tasks {
+		keys[i] = task.Rank
+		switch task.ID.Hex() {
+		case todoID.Hex():
+			from = i
+		case anchorID:
+			anchor = i
+		}
+	}
+	if anchor == -1 {
+		_, err := ms.GetTodoByID(ctx, anchorID)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: todo %q is in another project", errs.ErrValidation, anchorID)
+	}
+
+	set := bson.D{}
+	inc := bson.D{}
+	filters := []any{}
+	for i, key := range rank.Move(keys, from, anchor, after) {
+		name := "t" + strconv.Itoa(i)
+		set = append(set, bson.E{Key: "tasks.$[" + name + "].rank", Value: key})
+		inc = append(inc, bson.E{Key: "tasks.$[" + name + "].version", Value: 1})
+		filters = append(filters, bson.D{{Key: name + "._id", Value: proj.Tasks[i].ID}})
+	}
+
+	update := bson.D{{Key: "$set", Value: set}, {Key: "$inc", Value: inc}}
+	updateOpts := options.UpdateOne().SetArrayFilters(filters)
+
+	result, err := ms.Collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: proj.ID}}, update, updateOpts)
+	if err != nil {
+		return wrapErr(err)
+	}
+	if result.MatchedCount == 0 {
+		return errs.ErrNotFound
+	}
+	return nil
+}
+
+// priorityRankExpr mirrors models.PriorityRank as an aggregation expression
+var priorityRankExpr = bson.D{{Key: "$switch", Value: bson.D{
+	{Key: "branches", Value: bson.A{
+		bson.D{{Key: "case", Value: bson.D{{Key: "$in", Value: bson.A{bson.D{{Key: "$toLower", Value: "$tasks.priority"}}, bson.A{"high", "hi"}}}}}, {Key: "then", Value: 3}},
+		bson.D{{Key: "case", Value: bson.D{{Key: "$in", Value: bson.A{bson.D{{Key: "$toLower", Value: "$tasks.priority"}}, bson.A{"mid", "medium"}}}}}, {Key: "then", Value: 2}},
+		bson.D{{Key: "case", Value: bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$toLower", Value: "$tasks.priority"}}, "low"}}}}, {Key: "then", Value: 1}},
+	}},
+	{Key: "default", Value: 0},
+}}}
+
+// sortKeyExprs maps models.TodoQuery.SortBy onto an aggregation expression that
+// converts the field into a long, so that a single numeric key can be compared
+// for keyset pagination
+//
+// - dueDate and updated_at become milliseconds since epoch
+// - missing values sort last
+var sortKeyExprs = map[string]any{
+	models.SortByDueDate:  bson.D{{Key: "$toLong", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$tasks.dueDate", time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)}}}}},
+	models.SortByPriority: priorityRankExpr,
+	models.SortByUpdatedAt: bson.D{{Key: "$ifNull", Value: bson.A{
+		bson.D{{Key: "$toLong", Value: bson.D{{Key: "$toDate", Value: "$tasks.updated_at"}}}},
+		int64(math.MaxInt64),
+	}}},
+	models.SortByRank: bson.D{{Key: "$ifNull", Value: bson.A{"$tasks.rank", ""}}},
+}
+
+// unwoundTask is the shape of a document after the tasks array has been $unwind-ed
+//
+// _id is the id of the project that owns the task
+// sortKey is a long, except for SortByRank where it is a string
+type unwoundTask struct {
+	ProjID  bson.ObjectID `bson:"_id"`
+	Owner   string        `bson:"owner"`
+	Task    models.TODO   `bson:"tasks"`
+	SortKey bson.RawValue `bson:"sortKey"`
+}
+
+// sortKeyString formats the sort key for a cursor
+func (t unwoundTask) sortKeyString() string {
+	if key, ok := t.SortKey.StringValueOK(); ok {
+		return key
+	}
+	return strconv.FormatInt(t.SortKey.AsInt64(), 10)
+}
+
+// QueryTodos
+//
+// instead of loading every project and flattening tasks in Go,
+// the filters are pushed into an aggregation pipeline
+//
+// - $match the projects of the user (and the project, if filtering by project)
+// - $match projects with a task that has the labels (if filtering by label)
+// - $unwind tasks so that each task becomes its own document
+// - $match the task filters
+// - $sort, ties are broken by the task _id
+// - $match everything after the cursor and $limit
+//
+// returns the next cursor, or "" on the last page
+func (ms *MongoStore) QueryTodos(ctx context.Context, q models.TodoQuery) ([]models.TODO, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	cursor, err := q.DecodeCursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	projFilter := bson.D{visible(ctx)}
+	if q.ProjID != "" {
+		projID, err := parseObjectID(q.ProjID)
+		if err != nil {
+			return nil, "", err
+		}
+		projFilter = append(projFilter, bson.E{Key: "_id", Value: projID})
+	}
+	pipeline := mongo.Pipeline{bson.D{{Key: "$match", Value: projFilter}}}
+
+	labelFilter := bson.D{{Key: "$in", Value: q.Labels}}
+	if q.AllLabels {
+		labelFilter = bson.D{{Key: "$all", Value: q.Labels}}
+	}
+	if len(q.Labels) > 0 {
+		// matching the projects before $unwind lets the tasks.labels index narrow them down
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{
+			{Key: "tasks", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "labels", Value: labelFilter}}}}},
+		}}})
+	}
+
+	pipeline = append(pipeline,
+		bson.D{{Key: "$unwind", Value: "$tasks"}},
+		bson.D{{Key: "$project", Value: bson.D{{Key: "tasks", Value: 1}}}},
+	)
+
+	taskFilter := bson.D{{Key: "tasks.deletedAt", Value: nil}}
+	if q.Completed != nil {
+		taskFilter = append(taskFilter, bson.E{Key: "tasks.completed", Value: *q.Completed})
+	}
+	if len(q.Priority) > 0 {
+		taskFilter = append(taskFilter, bson.E{Key: "tasks.priority", Value: bson.D{{Key: "$in", Value: q.Priority}}})
+	}
+	dueDateFilter := bson.D{}
+	if q.DueBefore != nil {
+		dueDateFilter = append(dueDateFilter, bson.E{Key: "$lt", Value: *q.DueBefore})
+	}
+	if q.DueAfter != nil {
+		dueDateFilter = append(dueDateFilter, bson.E{Key: "$gt", Value: *q.DueAfter})
+	}
+	if len(dueDateFilter) > 0 {
+		taskFilter = append(taskFilter, bson.E{Key: "tasks.dueDate", Value: dueDateFilter})
+	}
+	if q.Name != "" {
+		taskFilter = append(taskFilter, bson.E{Key: "tasks.name", Value: bson.D{
+			{Key: "$regex", Value: regexp.QuoteMeta(q.Name)},
+			{Key: "$options", Value: "i"},
+		}})
+	}
+	if len(q.Labels) > 0 {
+		taskFilter = append(taskFilter, bson.E{Key: "tasks.labels", Value: labelFilter})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$match", Value: taskFilter}})
+
+	comparison := "$gt"
+	direction := 1
+	if q.Desc {
+		comparison = "$lt"
+		direction = -1
+	}
+
+	sortKeyExpr, sorted := sortKeyExprs[q.SortBy]
+	paginated := q.Limit > 0 || cursor != nil
+
+	if sorted {
+		pipeline = append(pipeline,
+			bson.D{{Key: "$addFields", Value: bson.D{{Key: "sortKey", Value: sortKeyExpr}}}},
+			bson.D{{Key: "$sort", Value: bson.D{{Key: "sortKey", Value: direction}, {Key: "tasks._id", Value: direction}}}},
+		)
+	} else if paginated {
+		// pages need a stable order, so fall back to the task _id
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: bson.D{{Key: "tasks._id", Value: direction}}}})
+	}
+
+	if cursor != nil {
+		afterID, err := parseObjectID(cursor.ID)
+		if err != nil {
+			return nil, "", err
+		}
+		afterFilter := bson.D{{Key: "tasks._id", Value: bson.D{{Key: comparison, Value: afterID}}}}
+		if sorted {
+			var afterKey any = cursor.Key
+			if q.SortBy != models.SortByRank {
+				afterKey, err = strconv.ParseInt(cursor.Key, 10, 64)
+				if err != nil {
+					return nil, "", fmt.Errorf("%w: malformed cursor", errs.ErrValidation)
+				}
+			}
+			afterFilter = bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "sortKey", Value: bson.D{{Key: comparison, Value: afterKey}}}},
+				bson.D{{Key: "sortKey", Value: afterKey}, {Key: "tasks._id", Value: bson.D{{Key: comparison, Value: afterID}}}},
+			}}}
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: afterFilter}})
+	}
+
+	// fetch one extra document to find out if there is a next page
+	if q.Limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: q.Limit + 1}})
+	}
+
+	aggCursor, err := ms.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, "", wrapErr(err)
+	}
+
+	results := []unwoundTask{}
+	err = aggCursor.All(ctx, &results)
+	if err != nil {
+		return nil, "", wrapErr(err)
+	}
+
+	next := ""
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[:q.Limit]
+		last := results[q.Limit-1]
+		next = q.NextCursor(last.sortKeyString(), last.Task.ID.Hex())
+	}
+
+	todos := make([]models.TODO, 0, len(results))
+	for _, result := range results {
+		result.Task.ProjID = result.ProjID.Hex()
+		todos = append(todos, result.Task)
+	}
+	return todos, next, nil
+}
+
+// QueryProjs
+//
+// - projects are returned in _id order
+// - pagination is keyset based on _id
+//
+// returns the next cursor, or "" on the last page
+func (ms *MongoStore) QueryProjs(ctx context.Context, page models.Page) ([]models.PROJECT, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	filter := bson.D{visible(ctx), notDeleted}
+	if page.Cursor != "" {
+		cursor, err := models.DecodeCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		afterID, err := parseObjectID(cursor.ID)
+		if err != nil {
+			return nil, "", err
+		}
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$gt", Value: afterID}}})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if page.Limit > 0 {
+		opts.SetLimit(int64(page.Limit + 1))
+	}
+
+	findCursor, err := ms.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", wrapErr(err)
+	}
+
+	projs := []models.PROJECT{}
+	err = findCursor.All(ctx, &projs)
+	if err != nil {
+		return nil, "", wrapErr(err)
+	}
+
+	next := ""
+	if page.Limit > 0 && len(projs) > page.Limit {
+		projs = projs[:page.Limit]
+		next = models.EncodeCursor(models.Cursor{ID: projs[page.Limit-1].ID.Hex()})
+	}
+
+	for i := range projs {
+		projs[i].Tasks = liveTasks(projs[i].Tasks)
+		models.SortTasks(projs[i].Tasks)
+	}
+	return projs, next, nil
+}
+
+// searchResult is a project matched by $text along with its relevance
+type searchResult struct {
+	ID       bson.ObjectID `bson:"_id"`
+	ProjName string        `bson:"projname"`
+	Version  int           `bson:"version"`
+	Tasks    []models.TODO `bson:"tasks"`
+	Score    float64       `bson:"score"`
+}
+
+// Search
+//
+// $text matches and scores whole project documents, not the tasks embedded in them,
+// so the matched projects are narrowed down to their matching tasks in Go
+//
+// - each task's score is the project's text score weighted by how many of the query terms it contains
+// - the project itself is a hit if its name contains any of the query terms
+func (ms *MongoStore) Search(ctx context.Context, query string, limit int) ([]models.SearchHit, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	filter := bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: query}}}, visible(ctx), notDeleted}
+	opts := options.Find().
+		SetProjection(bson.D{
+			{Key: "projname", Value: 1},
+			{Key: "version", Value: 1},
+			{Key: "tasks", Value: 1},
+			{Key: "score", Value: bson.D{{Key: "$meta", Value: "textScore"}}},
+		}).
+		SetSort(bson.D{{Key: "score", Value: bson.D{{Key: "$meta", Value: "textScore"}}}}).
+		SetLimit(int64(limit))
+
+	cursor, err := ms.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	results := []searchResult{}
+	err = cursor.All(ctx, &results)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	terms := textsearch.Tokenize(query)
+	hits := []models.SearchHit{}
+
+	for _, result := range results {
+		project := models.PROJECT{ID: &result.ID, ProjName: result.ProjName, Version: result.Version}
+
+		if score := textsearch.Score(terms, result.ProjName); score > 0 {
+			hits = append(hits, models.SearchHit{
+				Kind:    models.SearchHitProject,
+				Score:   result.Score * score,
+				Project: project,
+			})
+		}
+
+		for _, task := range liveTasks(result.Tasks) {
+			score := textsearch.Score(terms, task.Name, task.Description)
+			if score == 0 {
+				continue
+			}
+			task.ProjID = result.ID.Hex()
+			hits = append(hits, models.SearchHit{
+				Kind:    models.SearchHitTodo,
+				Score:   result.Score * score,
+				Todo:    &task,
+				Project: project,
+			})
+		}
+	}
+
+	slices.SortStableFunc(hits, func(a, b models.SearchHit) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
@@ -2,9 +2,13 @@ package mongostore
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
 	"time"
 
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/history"
 	"github.com/ganglinwu/todoapp-backend-v1/models"
 	"github.com/stretchr/testify/suite"
 	"go.mongodb.org/mongo-driver/v2/bson"
@@ -31,12 +35,14 @@ func TestMongoTestSuite(t *testing.T) {
 // This runs only once per suite
 func (ts *TestSuite) SetupSuite() {
 	// connect
-	conn, err := NewConnection()
+	connString, dbName, collName := "", "", ""
+
+	conn, err := NewConnection(&connString)
 	if err != nil {
 		ts.FailNowf("unable to connect to mongoDB Atlas", err.Error())
 	}
 
-	dbName, _, err := GetDBNameCollectionName()
+	_, _, err = GetDBNameCollectionName(&dbName, &collName)
 	if err != nil {
 		ts.FailNowf("unable to load env variables", err.Error())
 	}
@@ -44,6 +50,11 @@ func (ts *TestSuite) SetupSuite() {
 	ts.collection = conn.Database(dbName).Collection("testTodo")
 
 	ts.server = &MockTodoServer{(&MongoStore{conn, ts.collection})}
+
+	err = ts.server.store.EnsureIndexes()
+	if err != nil {
+		ts.FailNowf("unable to create indexes", err.Error())
+	}
 }
 
 // This runs before EVERY test
@@ -59,6 +70,35 @@ func (ts *TestSuite) SetupTest() {
 		ts.FailNowf("unable to drop all entries from database", err.Error())
 	}
 
+	_, err = ts.server.store.labels().DeleteMany(ctx, filter)
+	if err != nil {
+		ts.FailNowf("unable to drop all labels from database", err.Error())
+	}
+
+	for _, collection := range []*mongo.Collection{ts.server.store.webhooks(), ts.server.store.deliveries()} {
+		_, err = collection.DeleteMany(ctx, filter)
+		if err != nil {
+			ts.FailNowf("unable to drop all webhooks from database", err.Error())
+		}
+	}
+
+	_, err = ts.server.store.idempotencyKeys().DeleteMany(ctx, filter)
+	if err != nil {
+		ts.FailNowf("unable to drop all idempotency keys from database", err.Error())
+	}
+
+	_, err = ts.server.store.historyEntries().DeleteMany(ctx, filter)
+	if err != nil {
+		ts.FailNowf("unable to drop all history entries from database", err.Error())
+	}
+
+	for _, collection := range []*mongo.Collection{ts.server.store.users(), ts.server.store.refreshTokens(), ts.server.store.personalTokens()} {
+		_, err = collection.DeleteMany(ctx, filter)
+		if err != nil {
+			ts.FailNowf("unable to drop all users from database", err.Error())
+		}
+	}
+
 	objID1, _ := bson.ObjectIDFromHex("67bc5c4f1e8db0c9a17efca0")
 	objID2, _ := bson.ObjectIDFromHex("67e0c98b2c3e82a398cdbb16")
 	objID3, _ := bson.ObjectIDFromHex("682571d1dafbee2eecbf4913")
@@ -92,7 +132,7 @@ func (ts *TestSuite) TearDownTest() {
 }
 
 func (ts *TestSuite) TestGetProjByID() {
-	got, err := ts.server.store.GetProjByID("682571d1dafbee2eecbf4913")
+	got, err := ts.server.store.GetProjByID(context.Background(), "682571d1dafbee2eecbf4913")
 
 	objID1, _ := bson.ObjectIDFromHex("67bc5c4f1e8db0c9a17efca0")
 	objID2, _ := bson.ObjectIDFromHex("67e0c98b2c3e82a398cdbb16")
@@ -114,7 +154,7 @@ func (ts *TestSuite) TestGetProjByID() {
 }
 
 func (ts *TestSuite) TestGetAllProjs() {
-	got, err := ts.server.store.GetAllProjs()
+	got, err := ts.server.store.GetAllProjs(context.Background())
 	if err != nil {
 		ts.FailNowf("err on GetAllProjs: ", err.Error())
 	}
@@ -146,7 +186,7 @@ func (ts *TestSuite) TestGetAllProjs() {
 }
 
 func (ts *TestSuite) TestGetAllTodos() {
-	got, err := ts.server.store.GetAllTodos()
+	got, err := ts.server.store.GetAllTodos(context.Background())
 	if err != nil {
 		ts.FailNowf("err on GetAllProjs: ", err.Error())
 	}
@@ -171,7 +211,7 @@ func (ts *TestSuite) TestGetAllTodos() {
 func (ts *TestSuite) TestCreateProj() {
 	name := "new proj to be inserted"
 	tasks := []models.TODO{}
-	insertedID, err := ts.server.store.CreateProj(name, tasks)
+	insertedID, err := ts.server.store.CreateProj(context.Background(), name, tasks)
 	if err != nil {
 		ts.FailNowf("err on CreateProj: ", err.Error())
 	}
@@ -181,7 +221,7 @@ func (ts *TestSuite) TestCreateProj() {
 		ts.FailNowf("failed to convert to bson.ObjectID from Hex string:", err.Error())
 	}
 
-	got, err := ts.server.store.GetProjByID(insertedID)
+	got, err := ts.server.store.GetProjByID(context.Background(), insertedID)
 	if err != nil {
 		ts.FailNowf("failed to GetProjByID:", err.Error())
 	}
@@ -214,7 +254,7 @@ func (ts *TestSuite) TestCreateTodo() {
 	// Or could it be a setting we forgot to set?
 	// In any case we will have to insert our own objID for this test to pass the assertions
 	/*
-		updatedResult, err := ts.server.store.CreateTodo(projID, newTodoWithoutID)
+		updatedResult, err := ts.server.store.CreateTodo(context.Background(), projID, newTodoWithoutID)
 		if err != nil {
 			ts.FailNowf("err on CreateTodo: ", err.Error())
 		}
@@ -230,12 +270,12 @@ func (ts *TestSuite) TestCreateTodo() {
 
 	newTodoWithoutID.ID = &objID5
 
-	_, err := ts.server.store.CreateTodo(projID, newTodoWithoutID)
+	_, err := ts.server.store.CreateTodo(context.Background(), projID, newTodoWithoutID)
 	if err != nil {
 		ts.FailNowf("err on CreateTodo: ", err.Error())
 	}
 
-	got, err := ts.server.store.GetProjByID(projID)
+	got, err := ts.server.store.GetProjByID(context.Background(), projID)
 	if err != nil {
 		ts.FailNowf("error from GetProjByID", err.Error())
 	}
@@ -263,16 +303,48 @@ func (ts *TestSuite) TestUpdateTodoByID() {
 		Priority:    "low",
 	}
 
-	err := ts.server.store.UpdateTodoByID("682996bc78d219298228c10a", todo)
+	err := ts.server.store.UpdateTodoByID(context.Background(), "682996bc78d219298228c10a", todo)
 	if err != nil {
 		ts.FailNowf("err on UpdateTodoByID: ", err.Error())
 	}
 
-	got, _ := ts.server.store.GetProjByID("68299585e7b6718ddf79b567")
+	got, _ := ts.server.store.GetProjByID(context.Background(), "68299585e7b6718ddf79b567")
 
 	ts.compareProjStructFields(want, got)
 }
 
+func (ts *TestSuite) TestPatchTodo() {
+	ctx := context.Background()
+	todoID := "682996bc78d219298228c10a"
+
+	current, err := ts.server.store.GetTodoByID(ctx, todoID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+
+	// only the fields named are written, an empty one is cleared
+	patch := models.TODO{Name: "not written", Completed: true, Version: current.Version}
+	err = ts.server.store.PatchTodo(ctx, todoID, patch, []string{models.FieldDescription, models.FieldCompleted})
+	if err != nil {
+		ts.FailNowf("err on PatchTodo: ", err.Error())
+	}
+
+	got, err := ts.server.store.GetTodoByID(ctx, todoID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Equal(current.Name, got.Name)
+	ts.Equal("", got.Description)
+	ts.True(got.Completed)
+	ts.NotNil(got.DueDate)
+	ts.Equal(current.Version+1, got.Version)
+
+	err = ts.server.store.PatchTodo(ctx, todoID, patch, []string{models.FieldCompleted})
+	ts.ErrorIs(err, errs.ErrPreconditionFailed)
+	err = ts.server.store.PatchTodo(ctx, todoID, models.TODO{}, []string{"rank"})
+	ts.ErrorIs(err, errs.ErrValidation)
+}
+
 func (ts *TestSuite) TestUpdateProjNameByID() {
 	objID4, _ := bson.ObjectIDFromHex("682996bc78d219298228c10a")
 	objID5, _ := bson.ObjectIDFromHex("68299585e7b6718ddf79b567")
@@ -283,12 +355,12 @@ func (ts *TestSuite) TestUpdateProjNameByID() {
 	}
 	want := models.PROJECT{ID: &objID5, ProjName: "updated proj2", Tasks: todos2}
 
-	err := ts.server.store.UpdateProjNameByID("68299585e7b6718ddf79b567", "updated proj2")
+	err := ts.server.store.UpdateProjNameByID(context.Background(), "68299585e7b6718ddf79b567", "updated proj2", 0)
 	if err != nil {
 		ts.FailNowf("err on UpdateProjNameByID: ", err.Error())
 	}
 
-	got, _ := ts.server.store.GetProjByID("68299585e7b6718ddf79b567")
+	got, _ := ts.server.store.GetProjByID(context.Background(), "68299585e7b6718ddf79b567")
 
 	ts.compareProjStructFields(want, got)
 }
@@ -297,7 +369,7 @@ func (ts *TestSuite) TestUpdateProjNameByID() {
 // testing DeletedCount and ModifiedCount may not be accurate enough
 func (ts *TestSuite) TestDeleteProjByID() {
 	ID := "68299585e7b6718ddf79b567"
-	deletedCount, err := ts.server.store.DeleteProjByID(ID)
+	deletedCount, err := ts.server.store.DeleteProjByID(context.Background(), ID, 0)
 	if err != nil {
 		ts.FailNowf("err on DeleteProjByID: ", err.Error())
 	}
@@ -309,7 +381,7 @@ func (ts *TestSuite) TestDeleteProjByID() {
 
 func (ts *TestSuite) TestDeleteTodoByID() {
 	todoID := "682996bc78d219298228c10a"
-	deletedCount, err := ts.server.store.DeleteTodoByID(todoID)
+	deletedCount, err := ts.server.store.DeleteTodoByID(context.Background(), todoID, 0)
 	if err != nil {
 		ts.FailNowf("err on DeleteTodoByID: ", err.Error())
 	}
@@ -323,7 +395,7 @@ func (ts *TestSuite) TestGetTodoByID() {
 	objID4, _ := bson.ObjectIDFromHex("682996bc78d219298228c10a")
 	dueDate4 := time.Now().AddDate(0, 0, 3)
 	ID := "682996bc78d219298228c10a"
-	todo, err := ts.server.store.GetTodoByID(ID)
+	todo, err := ts.server.store.GetTodoByID(context.Background(), ID)
 	if err != nil {
 		ts.FailNowf("err on GetTodoByID: ", err.Error())
 	}
@@ -331,3 +403,1199 @@ func (ts *TestSuite) TestGetTodoByID() {
 	want := models.TODO{ID: &objID4, Name: "Test task 3", Description: "test description", DueDate: &dueDate4}
 	ts.compareTodoStructFields(want, got)
 }
+
+func (ts *TestSuite) TestQueryTodos() {
+	objID1, _ := bson.ObjectIDFromHex("67bc5c4f1e8db0c9a17efca0")
+	objID2, _ := bson.ObjectIDFromHex("67e0c98b2c3e82a398cdbb16")
+	objID4, _ := bson.ObjectIDFromHex("682996bc78d219298228c10a")
+	completed := false
+	inAMonth := time.Now().AddDate(0, 1, 0)
+
+	queryTests := []struct {
+		testname string
+		query    models.TodoQuery
+		wantIDs  []bson.ObjectID
+	}{
+		{"no filters", models.TodoQuery{}, []bson.ObjectID{objID1, objID2, objID4}},
+		{"by project", models.TodoQuery{ProjID: "682571d1dafbee2eecbf4913"}, []bson.ObjectID{objID1, objID2}},
+		{"not completed", models.TodoQuery{Completed: &completed}, []bson.ObjectID{objID1, objID2, objID4}},
+		{"due before", models.TodoQuery{DueBefore: &inAMonth}, []bson.ObjectID{objID2, objID4}},
+		{"due after", models.TodoQuery{DueAfter: &inAMonth}, []bson.ObjectID{objID1}},
+		{"name substring", models.TodoQuery{Name: "SOCK"}, []bson.ObjectID{objID2}},
+		{"sort by due date desc", models.TodoQuery{SortBy: models.SortByDueDate, Desc: true}, []bson.ObjectID{objID1, objID4, objID2}},
+	}
+
+	for _, test := range queryTests {
+		ts.Run(test.testname, func() {
+			got, _, err := ts.server.store.QueryTodos(context.Background(), test.query)
+			if err != nil {
+				ts.FailNowf("err on QueryTodos: ", err.Error())
+			}
+
+			gotIDs := []bson.ObjectID{}
+			for _, todo := range got {
+				gotIDs = append(gotIDs, *todo.ID)
+			}
+			ts.Equal(test.wantIDs, gotIDs)
+		})
+	}
+}
+
+func (ts *TestSuite) TestQueryTodosPagination() {
+	for _, q := range []models.TodoQuery{
+		{},
+		{SortBy: models.SortByDueDate},
+		{SortBy: models.SortByDueDate, Desc: true},
+	} {
+		all, _, err := ts.server.store.QueryTodos(context.Background(), q)
+		if err != nil {
+			ts.FailNowf("err on QueryTodos: ", err.Error())
+		}
+
+		got := []models.TODO{}
+		q.Limit = 2
+		for {
+			page, next, err := ts.server.store.QueryTodos(context.Background(), q)
+			if err != nil {
+				ts.FailNowf("err on QueryTodos: ", err.Error())
+			}
+			got = append(got, page...)
+			if next == "" {
+				break
+			}
+			q.Cursor = next
+		}
+
+		ts.Require().Len(got, len(all))
+		for i := range all {
+			ts.compareTodoStructFields(all[i], got[i])
+		}
+	}
+}
+
+func (ts *TestSuite) TestQueryProjsPagination() {
+	got, next, err := ts.server.store.QueryProjs(context.Background(), models.Page{Limit: 1})
+	if err != nil {
+		ts.FailNowf("err on QueryProjs: ", err.Error())
+	}
+	ts.Require().Len(got, 1)
+	ts.Equal("proj1", got[0].ProjName)
+	ts.NotEmpty(next)
+
+	got, next, err = ts.server.store.QueryProjs(context.Background(), models.Page{Limit: 1, Cursor: next})
+	if err != nil {
+		ts.FailNowf("err on QueryProjs: ", err.Error())
+	}
+	ts.Require().Len(got, 1)
+	ts.Equal("proj2", got[0].ProjName)
+	ts.Empty(next)
+}
+
+func (ts *TestSuite) TestSearch() {
+	objID2, _ := bson.ObjectIDFromHex("67e0c98b2c3e82a398cdbb16")
+	objID3, _ := bson.ObjectIDFromHex("682571d1dafbee2eecbf4913")
+
+	got, err := ts.server.store.Search(context.Background(), "socks", 10)
+	if err != nil {
+		ts.FailNowf("err on Search: ", err.Error())
+	}
+	ts.Require().Len(got, 1)
+	ts.Equal(models.SearchHitTodo, got[0].Kind)
+	ts.Equal(objID2, *got[0].Todo.ID)
+	ts.Equal(objID3, *got[0].Project.ID)
+
+	got, err = ts.server.store.Search(context.Background(), "nothing matches this", 10)
+	if err != nil {
+		ts.FailNowf("err on Search: ", err.Error())
+	}
+	ts.Empty(got)
+}
+
+func (ts *TestSuite) taskIDs(projID string) []string {
+	proj, err := ts.server.store.GetProjByID(context.Background(), projID)
+	if err != nil {
+		ts.FailNowf("err on GetProjByID: ", err.Error())
+	}
+	IDs := []string{}
+	for _, task := range proj.Tasks {
+		IDs = append(IDs, task.ID.Hex())
+	}
+	return IDs
+}
+
+func (ts *TestSuite) TestReorderTodo() {
+	proj1 := "682571d1dafbee2eecbf4913"
+	first, second := "67bc5c4f1e8db0c9a17efca0", "67e0c98b2c3e82a398cdbb16"
+
+	// seeded tasks have no rank yet and come back in insertion order
+	ts.Equal([]string{first, second}, ts.taskIDs(proj1))
+
+	last, err := ts.server.store.CreateTodo(context.Background(), proj1, models.TODO{Name: "last"})
+	if err != nil {
+		ts.FailNowf("err on CreateTodo: ", err.Error())
+	}
+	ts.Equal([]string{first, second, last}, ts.taskIDs(proj1))
+
+	err = ts.server.store.ReorderTodo(context.Background(), last, models.Placement{Before: first})
+	if err != nil {
+		ts.FailNowf("err on ReorderTodo: ", err.Error())
+	}
+	ts.Equal([]string{last, first, second}, ts.taskIDs(proj1))
+
+	err = ts.server.store.ReorderTodo(context.Background(), last, models.Placement{After: first})
+	if err != nil {
+		ts.FailNowf("err on ReorderTodo: ", err.Error())
+	}
+	ts.Equal([]string{first, last, second}, ts.taskIDs(proj1))
+
+	todos, _, err := ts.server.store.QueryTodos(context.Background(), models.TodoQuery{ProjID: proj1, SortBy: models.SortByRank, Page: models.Page{Limit: 2}})
+	if err != nil {
+		ts.FailNowf("err on QueryTodos: ", err.Error())
+	}
+	ts.Require().Len(todos, 2)
+	ts.Equal(first, todos[0].ID.Hex())
+	ts.Equal(last, todos[1].ID.Hex())
+
+	// updates keep the rank
+	updated, err := ts.server.store.GetTodoByID(context.Background(), last)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	updated.Name = "renamed"
+	err = ts.server.store.UpdateTodoByID(context.Background(), last, updated)
+	if err != nil {
+		ts.FailNowf("err on UpdateTodoByID: ", err.Error())
+	}
+	ts.Equal([]string{first, last, second}, ts.taskIDs(proj1))
+
+	err = ts.server.store.ReorderTodo(context.Background(), first, models.Placement{Before: "682996bc78d219298228c10a"})
+	ts.ErrorIs(err, errs.ErrValidation)
+
+	err = ts.server.store.ReorderTodo(context.Background(), first, models.Placement{Before: "682996bc78d219298228c999"})
+	ts.ErrorIs(err, errs.ErrNotFound)
+}
+
+func (ts *TestSuite) TestChecklistItems() {
+	todoID, otherTodoID := "67bc5c4f1e8db0c9a17efca0", "67e0c98b2c3e82a398cdbb16"
+
+	itemIDs := []string{}
+	for _, name := range []string{"aloe vera", "fern"} {
+		itemID, err := ts.server.store.AddItem(context.Background(), todoID, models.ChecklistItem{Name: name})
+		if err != nil {
+			ts.FailNowf("err on AddItem: ", err.Error())
+		}
+		itemIDs = append(itemIDs, itemID)
+	}
+
+	err := ts.server.store.UpdateItem(context.Background(), todoID, itemIDs[0], models.ChecklistItem{Name: "aloe", Completed: true})
+	if err != nil {
+		ts.FailNowf("err on UpdateItem: ", err.Error())
+	}
+
+	err = ts.server.store.ReorderItem(context.Background(), todoID, itemIDs[1], models.Placement{Before: itemIDs[0]})
+	if err != nil {
+		ts.FailNowf("err on ReorderItem: ", err.Error())
+	}
+
+	todo, err := ts.server.store.GetTodoByID(context.Background(), todoID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Require().Len(todo.Items, 2)
+	ts.Equal("fern", todo.Items[0].Name)
+	ts.Equal("aloe", todo.Items[1].Name)
+	ts.True(todo.Items[1].Completed)
+	ts.Equal(50, *todo.Progress())
+
+	// items belong to their todo
+	err = ts.server.store.UpdateItem(context.Background(), otherTodoID, itemIDs[0], models.ChecklistItem{Name: "aloe"})
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	_, err = ts.server.store.AddItem(context.Background(), "682996bc78d219298228c999", models.ChecklistItem{Name: "nope"})
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	deletedCount, err := ts.server.store.DeleteItem(context.Background(), todoID, itemIDs[1])
+	if err != nil {
+		ts.FailNowf("err on DeleteItem: ", err.Error())
+	}
+	ts.Equal(1, deletedCount)
+
+	_, err = ts.server.store.DeleteItem(context.Background(), todoID, itemIDs[1])
+	ts.ErrorIs(err, errs.ErrNotFound)
+}
+
+func (ts *TestSuite) TestLabels() {
+	projID, todoID := "682571d1dafbee2eecbf4913", "67bc5c4f1e8db0c9a17efca0"
+
+	urgent, err := ts.server.store.CreateLabel(context.Background(), models.Label{Name: "urgent", Color: "#ff0000"})
+	if err != nil {
+		ts.FailNowf("err on CreateLabel: ", err.Error())
+	}
+	home, err := ts.server.store.CreateLabel(context.Background(), models.Label{Name: "home"})
+	if err != nil {
+		ts.FailNowf("err on CreateLabel: ", err.Error())
+	}
+
+	_, err = ts.server.store.CreateLabel(context.Background(), models.Label{Name: "home"})
+	ts.ErrorIs(err, errs.ErrConflict)
+
+	err = ts.server.store.UpdateLabel(context.Background(), home, models.Label{Name: "house", Color: "#00ff00"})
+	if err != nil {
+		ts.FailNowf("err on UpdateLabel: ", err.Error())
+	}
+
+	labels, err := ts.server.store.GetAllLabels(context.Background())
+	if err != nil {
+		ts.FailNowf("err on GetAllLabels: ", err.Error())
+	}
+	ts.Equal([]models.Label{{ID: home, Name: "house", Color: "#00ff00"}, {ID: urgent, Name: "urgent", Color: "#ff0000"}}, labels)
+
+	todo, err := ts.server.store.GetTodoByID(context.Background(), todoID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	todo.Labels = []string{urgent, home}
+	err = ts.server.store.UpdateTodoByID(context.Background(), todoID, todo)
+	if err != nil {
+		ts.FailNowf("err on UpdateTodoByID: ", err.Error())
+	}
+
+	_, err = ts.server.store.CreateTodo(context.Background(), projID, models.TODO{Name: "fix sink", Labels: []string{home}})
+	if err != nil {
+		ts.FailNowf("err on CreateTodo: ", err.Error())
+	}
+
+	_, err = ts.server.store.CreateTodo(context.Background(), projID, models.TODO{Name: "nope", Labels: []string{"682996bc78d219298228c999"}})
+	ts.ErrorIs(err, errs.ErrValidation)
+
+	todos, _, err := ts.server.store.QueryTodos(context.Background(), models.TodoQuery{Labels: []string{urgent, home}})
+	if err != nil {
+		ts.FailNowf("err on QueryTodos: ", err.Error())
+	}
+	ts.Len(todos, 2)
+
+	todos, _, err = ts.server.store.QueryTodos(context.Background(), models.TodoQuery{Labels: []string{urgent, home}, AllLabels: true})
+	if err != nil {
+		ts.FailNowf("err on QueryTodos: ", err.Error())
+	}
+	ts.Require().Len(todos, 1)
+	ts.Equal(todoID, todos[0].ID.Hex())
+	ts.ElementsMatch([]string{urgent, home}, todos[0].Labels)
+
+	_, err = ts.server.store.DeleteLabel(context.Background(), urgent)
+	if err != nil {
+		ts.FailNowf("err on DeleteLabel: ", err.Error())
+	}
+	todo, err = ts.server.store.GetTodoByID(context.Background(), todoID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Equal([]string{home}, todo.Labels)
+}
+
+func (ts *TestSuite) TestRecurrence() {
+	todoID, err := ts.server.store.CreateTodo(context.Background(), "682571d1dafbee2eecbf4913", models.TODO{Name: "pay rent", Recurrence: "FREQ=MONTHLY", Occurrence: 1})
+	if err != nil {
+		ts.FailNowf("err on CreateTodo: ", err.Error())
+	}
+
+	todo, err := ts.server.store.GetTodoByID(context.Background(), todoID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Equal("FREQ=MONTHLY", todo.Recurrence)
+	ts.Equal(1, todo.Occurrence)
+
+	todo.Recurrence, todo.Occurrence = "FREQ=MONTHLY;COUNT=12", 2
+	err = ts.server.store.UpdateTodoByID(context.Background(), todoID, todo)
+	if err != nil {
+		ts.FailNowf("err on UpdateTodoByID: ", err.Error())
+	}
+
+	todo, err = ts.server.store.GetTodoByID(context.Background(), todoID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Equal("FREQ=MONTHLY;COUNT=12", todo.Recurrence)
+	ts.Equal(2, todo.Occurrence)
+}
+
+func (ts *TestSuite) TestReminders() {
+	dueDate := time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC)
+	early, late := dueDate.Add(-24*time.Hour), dueDate.Add(-30*time.Minute)
+	todoID, err := ts.server.store.CreateTodo(context.Background(), "682571d1dafbee2eecbf4913", models.TODO{Name: "dentist", DueDate: &dueDate, Reminders: []models.Reminder{
+		{Before: "24h0m0s", FireAt: &early},
+		{Before: "30m0s", FireAt: &late},
+	}})
+	if err != nil {
+		ts.FailNowf("err on CreateTodo: ", err.Error())
+	}
+
+	due, err := ts.server.store.DueReminders(early)
+	if err != nil {
+		ts.FailNowf("err on DueReminders: ", err.Error())
+	}
+	ts.Require().Len(due, 1)
+	ts.Equal(todoID, due[0].TodoID)
+	ts.Equal("dentist", due[0].Todo.Name)
+	ts.Equal("24h0m0s", due[0].Reminder.Before)
+
+	err = ts.server.store.MarkReminderSent(todoID, "24h0m0s", early)
+	if err != nil {
+		ts.FailNowf("err on MarkReminderSent: ", err.Error())
+	}
+	ts.ErrorIs(ts.server.store.MarkReminderSent(todoID, "24h0m0s", early), errs.ErrNotFound)
+
+	due, err = ts.server.store.DueReminders(late)
+	if err != nil {
+		ts.FailNowf("err on DueReminders: ", err.Error())
+	}
+	ts.Require().Len(due, 1)
+	ts.Equal("30m0s", due[0].Reminder.Before)
+
+	todo, err := ts.server.store.GetTodoByID(context.Background(), todoID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Require().Len(todo.Reminders, 2)
+	ts.NotNil(todo.Reminders[0].SentAt)
+	ts.Nil(todo.Reminders[1].SentAt)
+
+	// completed todos have no due reminders
+	todo.Completed = true
+	err = ts.server.store.UpdateTodoByID(context.Background(), todoID, todo)
+	if err != nil {
+		ts.FailNowf("err on UpdateTodoByID: ", err.Error())
+	}
+	due, err = ts.server.store.DueReminders(late)
+	if err != nil {
+		ts.FailNowf("err on DueReminders: ", err.Error())
+	}
+	ts.Empty(due)
+}
+
+func (ts *TestSuite) TestWebhooks() {
+	store := ts.server.store
+	created := time.Now().UTC().Truncate(time.Millisecond)
+
+	hookID, err := store.CreateWebhook(context.Background(), models.Webhook{URL: "https://example.com/hook", Secret: "s3cret", Events: []string{models.EventTodoCreated}, Active: true, CreatedAt: created})
+	if err != nil {
+		ts.FailNowf("err on CreateWebhook: ", err.Error())
+	}
+
+	hook, err := store.GetWebhookByID(context.Background(), hookID)
+	if err != nil {
+		ts.FailNowf("err on GetWebhookByID: ", err.Error())
+	}
+	ts.Equal(models.Webhook{ID: hookID, URL: "https://example.com/hook", Secret: "s3cret", Events: []string{models.EventTodoCreated}, Active: true, CreatedAt: created}, hook)
+
+	hook.Events, hook.Active, hook.Failures = []string{models.EventTodoDeleted, models.EventTodoUpdated}, false, 3
+	err = store.UpdateWebhook(context.Background(), hookID, hook)
+	if err != nil {
+		ts.FailNowf("err on UpdateWebhook: ", err.Error())
+	}
+
+	hooks, err := store.GetAllWebhooks(context.Background())
+	if err != nil {
+		ts.FailNowf("err on GetAllWebhooks: ", err.Error())
+	}
+	ts.Equal([]models.Webhook{hook}, hooks)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		err = store.AddDelivery(models.Delivery{ID: bson.NewObjectID().Hex(), WebhookID: hookID, EventID: "e1", Event: models.EventTodoDeleted, Attempt: attempt, StatusCode: 500, DeliveredAt: created.Add(time.Duration(attempt) * time.Second)})
+		if err != nil {
+			ts.FailNowf("err on AddDelivery: ", err.Error())
+		}
+	}
+
+	deliveries, err := store.GetDeliveries(context.Background(), hookID, 2)
+	if err != nil {
+		ts.FailNowf("err on GetDeliveries: ", err.Error())
+	}
+	ts.Require().Len(deliveries, 2)
+	ts.Equal(3, deliveries[0].Attempt)
+	ts.Equal(2, deliveries[1].Attempt)
+
+	deletedCount, err := store.DeleteWebhook(context.Background(), hookID)
+	if err != nil {
+		ts.FailNowf("err on DeleteWebhook: ", err.Error())
+	}
+	ts.Equal(1, deletedCount)
+
+	deliveries, err = store.GetDeliveries(context.Background(), hookID, 10)
+	if err != nil {
+		ts.FailNowf("err on GetDeliveries: ", err.Error())
+	}
+	ts.Empty(deliveries)
+
+	_, err = store.GetWebhookByID(context.Background(), hookID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+}
+
+func (ts *TestSuite) TestWebhookFailures() {
+	store := ts.server.store
+
+	hookID, err := store.CreateWebhook(context.Background(), models.Webhook{URL: "https://example.com/hook", Secret: "s3cret", Events: []string{models.EventTodoCreated}, Active: true, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		ts.FailNowf("err on CreateWebhook: ", err.Error())
+	}
+
+	hook, err := store.AddWebhookFailure(context.Background(), hookID, 2)
+	if err != nil {
+		ts.FailNowf("err on AddWebhookFailure: ", err.Error())
+	}
+	ts.True(hook.Active)
+	ts.Equal(1, hook.Failures)
+
+	hook, err = store.AddWebhookFailure(context.Background(), hookID, 2)
+	if err != nil {
+		ts.FailNowf("err on AddWebhookFailure: ", err.Error())
+	}
+	ts.False(hook.Active)
+	ts.Equal(2, hook.Failures)
+
+	err = store.ResetWebhookFailures(context.Background(), hookID)
+	if err != nil {
+		ts.FailNowf("err on ResetWebhookFailures: ", err.Error())
+	}
+	hook, err = store.GetWebhookByID(context.Background(), hookID)
+	if err != nil {
+		ts.FailNowf("err on GetWebhookByID: ", err.Error())
+	}
+	ts.False(hook.Active)
+	ts.Equal(0, hook.Failures)
+
+	_, err = store.AddWebhookFailure(auth.WithUser(context.Background(), "bob"), hookID, 2)
+	ts.ErrorIs(err, errs.ErrNotFound)
+}
+
+func (ts *TestSuite) TestMoveTodo() {
+	before, err := ts.server.store.GetTodoByID(context.Background(), "67bc5c4f1e8db0c9a17efca0")
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+
+	err = ts.server.store.MoveTodo(context.Background(), "67bc5c4f1e8db0c9a17efca0", "68299585e7b6718ddf79b567")
+	if err != nil {
+		ts.FailNowf("err on MoveTodo: ", err.Error())
+	}
+
+	got, err := ts.server.store.GetTodoByID(context.Background(), "67bc5c4f1e8db0c9a17efca0")
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Equal("68299585e7b6718ddf79b567", got.ProjID)
+	ts.Equal(before.Name, got.Name)
+	ts.Equal(before.Updated_at, got.Updated_at)
+
+	source, err := ts.server.store.GetProjByID(context.Background(), "682571d1dafbee2eecbf4913")
+	if err != nil {
+		ts.FailNowf("err on GetProjByID: ", err.Error())
+	}
+	ts.Len(source.Tasks, 1)
+
+	// the task is still in its project when the target does not exist
+	err = ts.server.store.MoveTodo(context.Background(), "67bc5c4f1e8db0c9a17efca0", "682571d1dafbee2eecbf4999")
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	got, err = ts.server.store.GetTodoByID(context.Background(), "67bc5c4f1e8db0c9a17efca0")
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Equal("68299585e7b6718ddf79b567", got.ProjID)
+}
+
+func (ts *TestSuite) TestBatchTodos() {
+	ops := []models.BatchOp{
+		{Op: models.BatchUpdate, ID: "67bc5c4f1e8db0c9a17efca0", Todo: models.TODO{Name: "Water Plants", Completed: true}},
+		{Op: models.BatchDelete, ID: "67e0c98b2c3e82a398cdbb16"},
+		{Op: models.BatchCreate, ProjID: "68299585e7b6718ddf79b567", Todo: models.TODO{Name: "batched"}},
+		{Op: models.BatchDelete, ID: "682996bc78d219298228c999"},
+		{Op: models.BatchDelete, ID: "not-an-object-id"},
+	}
+
+	got, err := ts.server.store.BatchTodos(context.Background(), ops, false)
+	if err != nil {
+		ts.FailNowf("err on BatchTodos: ", err.Error())
+	}
+	ts.Require().Len(got, 5)
+	ts.NoError(got[0].Err)
+	ts.NoError(got[1].Err)
+	ts.NoError(got[2].Err)
+	ts.ErrorIs(got[3].Err, errs.ErrNotFound)
+	ts.ErrorIs(got[4].Err, errs.ErrInvalidID)
+
+	updated, err := ts.server.store.GetTodoByID(context.Background(), "67bc5c4f1e8db0c9a17efca0")
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.True(updated.Completed)
+
+	_, err = ts.server.store.GetTodoByID(context.Background(), "67e0c98b2c3e82a398cdbb16")
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	created, err := ts.server.store.GetTodoByID(context.Background(), got[2].ID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Equal("68299585e7b6718ddf79b567", created.ProjID)
+}
+
+func (ts *TestSuite) TestBatchTodosAtomic() {
+	ops := []models.BatchOp{
+		{Op: models.BatchDelete, ID: "67bc5c4f1e8db0c9a17efca0"},
+		{Op: models.BatchCreate, ProjID: "682571d1dafbee2eecbf4999", Todo: models.TODO{Name: "orphan"}},
+	}
+
+	got, err := ts.server.store.BatchTodos(context.Background(), ops, true)
+	if err != nil {
+		ts.FailNowf("err on BatchTodos: ", err.Error())
+	}
+	ts.ErrorIs(got[0].Err, errs.ErrAborted)
+	ts.ErrorIs(got[1].Err, errs.ErrNotFound)
+
+	_, err = ts.server.store.GetTodoByID(context.Background(), "67bc5c4f1e8db0c9a17efca0")
+	ts.NoError(err)
+}
+
+func (ts *TestSuite) TestBatchTodosAtomicRollback() {
+	ctx := context.Background()
+	current, err := ts.server.store.GetTodoByID(ctx, "682996bc78d219298228c10a")
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+
+	// both updates pass the checks up front, but the second one no longer matches once the first is written
+	ops := []models.BatchOp{
+		{Op: models.BatchDelete, ID: "67bc5c4f1e8db0c9a17efca0"},
+		{Op: models.BatchUpdate, ID: "682996bc78d219298228c10a", Todo: models.TODO{Name: "first", Version: current.Version}},
+		{Op: models.BatchUpdate, ID: "682996bc78d219298228c10a", Todo: models.TODO{Name: "second", Version: current.Version}},
+	}
+
+	got, err := ts.server.store.BatchTodos(ctx, ops, true)
+	if err != nil {
+		ts.FailNowf("err on BatchTodos: ", err.Error())
+	}
+	ts.Require().Len(got, 3)
+	ts.ErrorIs(got[0].Err, errs.ErrAborted)
+	ts.ErrorIs(got[1].Err, errs.ErrAborted)
+	ts.ErrorIs(got[2].Err, errs.ErrConflict)
+
+	// nothing the batch wrote before the failing op is left behind
+	_, err = ts.server.store.GetTodoByID(ctx, "67bc5c4f1e8db0c9a17efca0")
+	ts.NoError(err)
+	after, err := ts.server.store.GetTodoByID(ctx, "682996bc78d219298228c10a")
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Equal(current.Name, after.Name)
+	ts.Equal(current.Version, after.Version)
+
+	entries, err := ts.server.store.GetHistory(ctx, models.HistoryTodo, "67bc5c4f1e8db0c9a17efca0")
+	if err != nil {
+		ts.FailNowf("err on GetHistory: ", err.Error())
+	}
+	ts.Empty(entries)
+}
+
+func (ts *TestSuite) TestVersions() {
+	todoID, err := ts.server.store.CreateTodo(context.Background(), "682571d1dafbee2eecbf4913", models.TODO{Name: "versioned"})
+	if err != nil {
+		ts.FailNowf("err on CreateTodo: ", err.Error())
+	}
+
+	todo, err := ts.server.store.GetTodoByID(context.Background(), todoID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Equal(1, todo.Version)
+
+	todo.Name = "renamed"
+	err = ts.server.store.UpdateTodoByID(context.Background(), todoID, todo)
+	if err != nil {
+		ts.FailNowf("err on UpdateTodoByID: ", err.Error())
+	}
+
+	// the todo is at version 2 now, an update or delete expecting version 1 is stale
+	todo.Name = "clobbered"
+	err = ts.server.store.UpdateTodoByID(context.Background(), todoID, todo)
+	ts.ErrorIs(err, errs.ErrPreconditionFailed)
+	_, err = ts.server.store.DeleteTodoByID(context.Background(), todoID, 1)
+	ts.ErrorIs(err, errs.ErrPreconditionFailed)
+
+	// item changes bump the version too
+	_, err = ts.server.store.AddItem(context.Background(), todoID, models.ChecklistItem{Name: "step"})
+	if err != nil {
+		ts.FailNowf("err on AddItem: ", err.Error())
+	}
+
+	todo, err = ts.server.store.GetTodoByID(context.Background(), todoID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Equal("renamed", todo.Name)
+	ts.Equal(3, todo.Version)
+
+	_, err = ts.server.store.DeleteTodoByID(context.Background(), todoID, 3)
+	if err != nil {
+		ts.FailNowf("err on DeleteTodoByID: ", err.Error())
+	}
+	_, err = ts.server.store.DeleteTodoByID(context.Background(), todoID, 3)
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	projID, err := ts.server.store.CreateProj(context.Background(), "versioned", []models.TODO{})
+	if err != nil {
+		ts.FailNowf("err on CreateProj: ", err.Error())
+	}
+
+	err = ts.server.store.UpdateProjNameByID(context.Background(), projID, "renamed", 1)
+	if err != nil {
+		ts.FailNowf("err on UpdateProjNameByID: ", err.Error())
+	}
+	err = ts.server.store.UpdateProjNameByID(context.Background(), projID, "clobbered", 1)
+	ts.ErrorIs(err, errs.ErrPreconditionFailed)
+
+	proj, err := ts.server.store.GetProjByID(context.Background(), projID)
+	if err != nil {
+		ts.FailNowf("err on GetProjByID: ", err.Error())
+	}
+	ts.Equal("renamed", proj.ProjName)
+	ts.Equal(2, proj.Version)
+
+	_, err = ts.server.store.DeleteProjByID(context.Background(), projID, 1)
+	ts.ErrorIs(err, errs.ErrPreconditionFailed)
+	_, err = ts.server.store.DeleteProjByID(context.Background(), projID, 2)
+	if err != nil {
+		ts.FailNowf("err on DeleteProjByID: ", err.Error())
+	}
+}
+
+func (ts *TestSuite) TestIdempotencyRecords() {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	record := models.IdempotencyRecord{Key: "key-1", Fingerprint: "abc", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+
+	err := ts.server.store.CreateIdempotencyRecord(record)
+	if err != nil {
+		ts.FailNowf("err on CreateIdempotencyRecord: ", err.Error())
+	}
+	err = ts.server.store.CreateIdempotencyRecord(record)
+	ts.ErrorIs(err, errs.ErrConflict)
+
+	got, err := ts.server.store.GetIdempotencyRecord("key-1")
+	if err != nil {
+		ts.FailNowf("err on GetIdempotencyRecord: ", err.Error())
+	}
+	ts.Equal("abc", got.Fingerprint)
+	ts.Equal(0, got.StatusCode)
+
+	record.StatusCode = 201
+	record.Header = map[string]string{"Location": "/proj/1"}
+	record.Body = []byte(`{"id":1}`)
+	err = ts.server.store.SaveIdempotencyRecord(record)
+	if err != nil {
+		ts.FailNowf("err on SaveIdempotencyRecord: ", err.Error())
+	}
+
+	got, err = ts.server.store.GetIdempotencyRecord("key-1")
+	if err != nil {
+		ts.FailNowf("err on GetIdempotencyRecord: ", err.Error())
+	}
+	ts.Equal(record, got)
+
+	err = ts.server.store.DeleteIdempotencyRecord("key-1")
+	if err != nil {
+		ts.FailNowf("err on DeleteIdempotencyRecord: ", err.Error())
+	}
+	_, err = ts.server.store.GetIdempotencyRecord("key-1")
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	// an expired record is not returned and its key can be reserved again
+	expired := models.IdempotencyRecord{Key: "key-2", Fingerprint: "abc", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)}
+	err = ts.server.store.CreateIdempotencyRecord(expired)
+	if err != nil {
+		ts.FailNowf("err on CreateIdempotencyRecord: ", err.Error())
+	}
+	_, err = ts.server.store.GetIdempotencyRecord("key-2")
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	err = ts.server.store.CreateIdempotencyRecord(models.IdempotencyRecord{Key: "key-2", Fingerprint: "def", CreatedAt: now, ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		ts.FailNowf("err on CreateIdempotencyRecord: ", err.Error())
+	}
+}
+
+func (ts *TestSuite) TestUsers() {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	user := models.User{Email: "ada@example.com", PasswordHash: "hash", CreatedAt: now}
+
+	userID, err := ts.server.store.CreateUser(user)
+	if err != nil {
+		ts.FailNowf("err on CreateUser: ", err.Error())
+	}
+	_, err = ts.server.store.CreateUser(user)
+	ts.ErrorIs(err, errs.ErrConflict)
+
+	got, err := ts.server.store.GetUserByEmail("ada@example.com")
+	if err != nil {
+		ts.FailNowf("err on GetUserByEmail: ", err.Error())
+	}
+	user.ID = userID
+	ts.Equal(user, got)
+	_, err = ts.server.store.GetUserByEmail("bob@example.com")
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	got, err = ts.server.store.GetUserByID(userID)
+	if err != nil {
+		ts.FailNowf("err on GetUserByID: ", err.Error())
+	}
+	ts.Equal(user, got)
+}
+
+func (ts *TestSuite) TestRefreshTokens() {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	userID, err := ts.server.store.CreateUser(models.User{Email: "ada@example.com", PasswordHash: "hash", CreatedAt: now})
+	if err != nil {
+		ts.FailNowf("err on CreateUser: ", err.Error())
+	}
+
+	token := models.RefreshToken{Hash: "hash-1", UserID: userID, Family: "family-1", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	for _, token := range []models.RefreshToken{token, {Hash: "hash-2", UserID: userID, Family: "family-1", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}} {
+		err = ts.server.store.CreateRefreshToken(token)
+		if err != nil {
+			ts.FailNowf("err on CreateRefreshToken: ", err.Error())
+		}
+	}
+
+	got, err := ts.server.store.UseRefreshToken("hash-1", now)
+	if err != nil {
+		ts.FailNowf("err on UseRefreshToken: ", err.Error())
+	}
+	token.UsedAt = &now
+	ts.Equal(token, got)
+
+	// a token can only be used once
+	got, err = ts.server.store.UseRefreshToken("hash-1", now)
+	ts.ErrorIs(err, errs.ErrConflict)
+	ts.Equal("family-1", got.Family)
+	_, err = ts.server.store.UseRefreshToken("hash-3", now)
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	deletedCount, err := ts.server.store.DeleteRefreshTokens("family-1")
+	if err != nil {
+		ts.FailNowf("err on DeleteRefreshTokens: ", err.Error())
+	}
+	ts.Equal(2, deletedCount)
+	_, err = ts.server.store.UseRefreshToken("hash-2", now)
+	ts.ErrorIs(err, errs.ErrNotFound)
+}
+
+func (ts *TestSuite) TestPersonalTokens() {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	userID, err := ts.server.store.CreateUser(models.User{Email: "ada@example.com", PasswordHash: "hash", CreatedAt: now})
+	if err != nil {
+		ts.FailNowf("err on CreateUser: ", err.Error())
+	}
+	otherID, err := ts.server.store.CreateUser(models.User{Email: "bob@example.com", PasswordHash: "hash", CreatedAt: now})
+	if err != nil {
+		ts.FailNowf("err on CreateUser: ", err.Error())
+	}
+
+	token := models.PersonalToken{UserID: userID, Name: "deploy", Scope: models.ScopeRead, ProjectID: "1", Prefix: "tdpat_abcd", Hash: "hash-1", CreatedAt: now}
+	token.ID, err = ts.server.store.CreatePersonalToken(token)
+	if err != nil {
+		ts.FailNowf("err on CreatePersonalToken: ", err.Error())
+	}
+	_, err = ts.server.store.CreatePersonalToken(models.PersonalToken{UserID: userID, Name: "again", Scope: models.ScopeRead, Prefix: "tdpat_abcd", Hash: "hash-1", CreatedAt: now})
+	ts.ErrorIs(err, errs.ErrConflict)
+
+	tokens, err := ts.server.store.GetPersonalTokens(userID)
+	if err != nil {
+		ts.FailNowf("err on GetPersonalTokens: ", err.Error())
+	}
+	ts.Equal([]models.PersonalToken{token}, tokens)
+	tokens, err = ts.server.store.GetPersonalTokens(otherID)
+	if err != nil {
+		ts.FailNowf("err on GetPersonalTokens: ", err.Error())
+	}
+	ts.Empty(tokens)
+
+	got, err := ts.server.store.GetPersonalToken("hash-1")
+	if err != nil {
+		ts.FailNowf("err on GetPersonalToken: ", err.Error())
+	}
+	ts.Equal(token, got)
+	_, err = ts.server.store.GetPersonalToken("hash-2")
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	usedAt := now.Add(time.Minute)
+	got, err = ts.server.store.UsePersonalToken("hash-1", usedAt)
+	if err != nil {
+		ts.FailNowf("err on UsePersonalToken: ", err.Error())
+	}
+	token.LastUsedAt = &usedAt
+	ts.Equal(token, got)
+	_, err = ts.server.store.UsePersonalToken("hash-2", usedAt)
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	// only the user who created a token can revoke it
+	_, err = ts.server.store.DeletePersonalToken(otherID, token.ID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	deletedCount, err := ts.server.store.DeletePersonalToken(userID, token.ID)
+	if err != nil {
+		ts.FailNowf("err on DeletePersonalToken: ", err.Error())
+	}
+	ts.Equal(1, deletedCount)
+	_, err = ts.server.store.UsePersonalToken("hash-1", usedAt)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = ts.server.store.DeletePersonalToken(userID, bson.NewObjectID().Hex())
+	ts.ErrorIs(err, errs.ErrNotFound)
+}
+
+func (ts *TestSuite) TestHistory() {
+	store := ts.server.store
+	ctx := history.WithActor(context.Background(), "ada")
+	projID := "682571d1dafbee2eecbf4913"
+
+	todoID, err := store.CreateTodo(ctx, projID, models.TODO{Name: "Repot cactus"})
+	if err != nil {
+		ts.FailNowf("err on CreateTodo: ", err.Error())
+	}
+	err = store.UpdateTodoByID(ctx, todoID, models.TODO{Name: "Repot all cacti"})
+	if err != nil {
+		ts.FailNowf("err on UpdateTodoByID: ", err.Error())
+	}
+	_, err = store.DeleteTodoByID(ctx, todoID, 0)
+	if err != nil {
+		ts.FailNowf("err on DeleteTodoByID: ", err.Error())
+	}
+
+	// a change that fails is rolled back together with its entry
+	err = store.UpdateTodoByID(ctx, todoID, models.TODO{Name: "renamed"})
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	entries, err := store.GetHistory(context.Background(), models.HistoryTodo, todoID)
+	if err != nil {
+		ts.FailNowf("err on GetHistory: ", err.Error())
+	}
+	ts.Require().Len(entries, 3)
+	ts.Equal([]string{models.OpCreate, models.OpUpdate, models.OpDelete}, []string{entries[0].Operation, entries[1].Operation, entries[2].Operation})
+	ts.Equal("ada", entries[0].Actor)
+	ts.Equal([]models.FieldChange{{Field: "name", Before: json.RawMessage(`"Repot cactus"`), After: json.RawMessage(`"Repot all cacti"`)}}, entries[1].Changes)
+
+	// batches record each operation that was written
+	_, err = store.BatchTodos(ctx, []models.BatchOp{{Op: models.BatchUpdate, ID: "67bc5c4f1e8db0c9a17efca0", Todo: models.TODO{Name: "Water cacti"}}}, false)
+	if err != nil {
+		ts.FailNowf("err on BatchTodos: ", err.Error())
+	}
+	entries, err = store.GetHistory(context.Background(), models.HistoryTodo, "67bc5c4f1e8db0c9a17efca0")
+	if err != nil {
+		ts.FailNowf("err on GetHistory: ", err.Error())
+	}
+	ts.Require().Len(entries, 1)
+	ts.Equal(models.OpUpdate, entries[0].Operation)
+
+	err = store.UpdateProjNameByID(ctx, projID, "renamed", 0)
+	if err != nil {
+		ts.FailNowf("err on UpdateProjNameByID: ", err.Error())
+	}
+	entries, err = store.GetHistory(context.Background(), models.HistoryProject, projID)
+	if err != nil {
+		ts.FailNowf("err on GetHistory: ", err.Error())
+	}
+	ts.Require().Len(entries, 1)
+	ts.Equal("projname", entries[0].Changes[0].Field)
+}
+
+func (ts *TestSuite) TestTrash() {
+	store := ts.server.store
+	todoID, projID := "67bc5c4f1e8db0c9a17efca0", "68299585e7b6718ddf79b567"
+
+	_, err := store.DeleteTodoByID(context.Background(), todoID, 0)
+	if err != nil {
+		ts.FailNowf("err on DeleteTodoByID: ", err.Error())
+	}
+	_, err = store.DeleteProjByID(context.Background(), projID, 0)
+	if err != nil {
+		ts.FailNowf("err on DeleteProjByID: ", err.Error())
+	}
+
+	// trashed tasks and projects are left out of every read and write
+	_, err = store.GetTodoByID(context.Background(), todoID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = store.GetTodoByID(context.Background(), "682996bc78d219298228c10a")
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = store.GetProjByID(context.Background(), projID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	err = store.UpdateTodoByID(context.Background(), todoID, models.TODO{Name: "renamed"})
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = store.AddItem(context.Background(), todoID, models.ChecklistItem{Name: "step"})
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = store.CreateTodo(context.Background(), projID, models.TODO{Name: "orphan"})
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	todos, err := store.GetAllTodos(context.Background())
+	if err != nil {
+		ts.FailNowf("err on GetAllTodos: ", err.Error())
+	}
+	ts.Len(todos, 1)
+
+	trash, err := store.GetTrash(context.Background())
+	if err != nil {
+		ts.FailNowf("err on GetTrash: ", err.Error())
+	}
+	ts.Require().Len(trash, 2)
+	ts.Equal(models.TrashProject, trash[0].Kind)
+	ts.Equal(projID, trash[0].ID)
+	ts.Require().Len(trash[0].Project.Tasks, 1)
+	ts.Equal("Test task 3", trash[0].Project.Tasks[0].Name)
+	ts.Equal(models.TrashTodo, trash[1].Kind)
+	ts.Equal(todoID, trash[1].ID)
+
+	// the name of a trashed project is free to take, project names are not unique in mongo
+	_, err = store.CreateProj(context.Background(), trash[0].Project.ProjName, []models.TODO{})
+	if err != nil {
+		ts.FailNowf("err on CreateProj: ", err.Error())
+	}
+
+	err = store.RestoreProj(context.Background(), projID)
+	if err != nil {
+		ts.FailNowf("err on RestoreProj: ", err.Error())
+	}
+	proj, err := store.GetProjByID(context.Background(), projID)
+	if err != nil {
+		ts.FailNowf("err on GetProjByID: ", err.Error())
+	}
+	ts.Len(proj.Tasks, 1)
+
+	err = store.RestoreTodo(context.Background(), todoID)
+	if err != nil {
+		ts.FailNowf("err on RestoreTodo: ", err.Error())
+	}
+	err = store.RestoreTodo(context.Background(), todoID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = store.GetTodoByID(context.Background(), todoID)
+	ts.NoError(err)
+
+	// only items trashed before the cutoff are purged
+	_, err = store.DeleteProjByID(context.Background(), "682571d1dafbee2eecbf4913", 0)
+	if err != nil {
+		ts.FailNowf("err on DeleteProjByID: ", err.Error())
+	}
+	purged, err := store.PurgeTrash(time.Now().Add(-time.Hour))
+	if err != nil {
+		ts.FailNowf("err on PurgeTrash: ", err.Error())
+	}
+	ts.Equal(0, purged)
+
+	purged, err = store.PurgeTrash(time.Now().Add(time.Hour))
+	if err != nil {
+		ts.FailNowf("err on PurgeTrash: ", err.Error())
+	}
+	ts.Equal(3, purged)
+	err = store.RestoreProj(context.Background(), "682571d1dafbee2eecbf4913")
+	ts.ErrorIs(err, errs.ErrNotFound)
+}
+
+func (ts *TestSuite) TestOwners() {
+	anonymous := context.Background()
+	bob := auth.WithUser(context.Background(), "bob")
+	store := ts.server.store
+	proj1, proj2, todo1 := "682571d1dafbee2eecbf4913", "68299585e7b6718ddf79b567", "67bc5c4f1e8db0c9a17efca0"
+
+	// the seeded projects and todos belong to the anonymous owner
+	projs, err := store.GetAllProjs(bob)
+	if err != nil {
+		ts.FailNowf("err on GetAllProjs: ", err.Error())
+	}
+	ts.Empty(projs)
+	todos, err := store.GetAllTodos(bob)
+	if err != nil {
+		ts.FailNowf("err on GetAllTodos: ", err.Error())
+	}
+	ts.Empty(todos)
+	todos, _, err = store.QueryTodos(bob, models.TodoQuery{})
+	if err != nil {
+		ts.FailNowf("err on QueryTodos: ", err.Error())
+	}
+	ts.Empty(todos)
+	hits, err := store.Search(bob, "water", 10)
+	if err != nil {
+		ts.FailNowf("err on Search: ", err.Error())
+	}
+	ts.Empty(hits)
+
+	_, err = store.GetProjByID(bob, proj1)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = store.GetTodoByID(bob, todo1)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = store.CreateTodo(bob, proj1, models.TODO{Name: "intruder"})
+	ts.ErrorIs(err, errs.ErrNotFound)
+	err = store.UpdateTodoByID(bob, todo1, models.TODO{Name: "mine now"})
+	ts.ErrorIs(err, errs.ErrNotFound)
+	err = store.UpdateProjNameByID(bob, proj1, "mine now", 0)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	err = store.MoveTodo(bob, todo1, proj2)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = store.DeleteTodoByID(bob, todo1, 0)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = store.DeleteProjByID(bob, proj1, 0)
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	bobsProjID, err := store.CreateProj(bob, "proj1", []models.TODO{})
+	if err != nil {
+		ts.FailNowf("err on CreateProj: ", err.Error())
+	}
+	_, err = store.GetProjByID(anonymous, bobsProjID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	err = store.MoveTodo(anonymous, todo1, bobsProjID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	// label names only have to be unique per owner
+	labelID, err := store.CreateLabel(anonymous, models.Label{Name: "urgent", Color: "#ff0000"})
+	if err != nil {
+		ts.FailNowf("err on CreateLabel: ", err.Error())
+	}
+	_, err = store.CreateLabel(bob, models.Label{Name: "urgent", Color: "#ff0000"})
+	ts.NoError(err)
+	_, err = store.GetLabelByID(bob, labelID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = store.CreateTodo(bob, bobsProjID, models.TODO{Name: "labelled", Labels: []string{labelID}})
+	ts.ErrorIs(err, errs.ErrValidation)
+	_, err = store.DeleteLabel(bob, labelID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	hookID, err := store.CreateWebhook(anonymous, models.Webhook{URL: "https://example.com/hook", Events: []string{models.EventTodoCreated}, Active: true, CreatedAt: time.Now()})
+	if err != nil {
+		ts.FailNowf("err on CreateWebhook: ", err.Error())
+	}
+	hooks, err := store.GetAllWebhooks(bob)
+	if err != nil {
+		ts.FailNowf("err on GetAllWebhooks: ", err.Error())
+	}
+	ts.Empty(hooks)
+	_, err = store.GetWebhookByID(bob, hookID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = store.GetDeliveries(bob, hookID, 10)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = store.DeleteWebhook(bob, hookID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	_, err = store.DeleteTodoByID(anonymous, todo1, 0)
+	if err != nil {
+		ts.FailNowf("err on DeleteTodoByID: ", err.Error())
+	}
+	trash, err := store.GetTrash(bob)
+	if err != nil {
+		ts.FailNowf("err on GetTrash: ", err.Error())
+	}
+	ts.Empty(trash)
+	err = store.RestoreTodo(bob, todo1)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	entries, err := store.GetHistory(bob, models.HistoryTodo, todo1)
+	if err != nil {
+		ts.FailNowf("err on GetHistory: ", err.Error())
+	}
+	ts.Empty(entries)
+
+	// nothing bob did touched the anonymous owner's data
+	projs, err = store.GetAllProjs(anonymous)
+	if err != nil {
+		ts.FailNowf("err on GetAllProjs: ", err.Error())
+	}
+	ts.Len(projs, 2)
+	entries, err = store.GetHistory(anonymous, models.HistoryTodo, todo1)
+	if err != nil {
+		ts.FailNowf("err on GetHistory: ", err.Error())
+	}
+	ts.Len(entries, 1)
+}
+
+func (ts *TestSuite) TestCollaborators() {
+	ada := auth.WithUser(context.Background(), "ada")
+	bob := auth.WithUser(context.Background(), "bob")
+	store := ts.server.store
+
+	projID, err := store.CreateProj(ada, "shared", []models.TODO{})
+	if err != nil {
+		ts.FailNowf("err on CreateProj: ", err.Error())
+	}
+	todoID, err := store.CreateTodo(ada, projID, models.TODO{Name: "shared task"})
+	if err != nil {
+		ts.FailNowf("err on CreateTodo: ", err.Error())
+	}
+
+	_, err = store.GetProjAccess(bob, projID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	access, err := store.GetProjAccess(ada, projID)
+	ts.NoError(err)
+	ts.Equal(models.Access{ProjID: projID, Owner: "ada", Role: models.RoleOwner}, access)
+
+	err = store.AddCollaborator(ada, projID, models.Collaborator{UserID: "bob", Role: models.RoleViewer})
+	ts.NoError(err)
+	err = store.AddCollaborator(ada, projID, models.Collaborator{UserID: "bob", Role: models.RoleEditor})
+	ts.ErrorIs(err, errs.ErrConflict)
+	err = store.AddCollaborator(bob, projID, models.Collaborator{UserID: "carol", Role: models.RoleViewer})
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	access, err = store.GetProjAccess(bob, projID)
+	ts.NoError(err)
+	ts.Equal(models.Access{ProjID: projID, Owner: "ada", Role: models.RoleViewer}, access)
+	access, err = store.GetTodoAccess(bob, todoID)
+	ts.NoError(err)
+	ts.Equal(models.Access{ProjID: projID, Owner: "ada", Role: models.RoleViewer}, access)
+
+	// shared projects are listed next to the user's own
+	projs, err := store.GetAllProjs(bob)
+	if err != nil {
+		ts.FailNowf("err on GetAllProjs: ", err.Error())
+	}
+	ts.Require().Len(projs, 1)
+	ts.Equal("shared", projs[0].ProjName)
+	todos, err := store.GetAllTodos(bob)
+	if err != nil {
+		ts.FailNowf("err on GetAllTodos: ", err.Error())
+	}
+	ts.Require().Len(todos, 1)
+	ts.Equal("shared task", todos[0].Name)
+
+	err = store.UpdateCollaborator(ada, projID, models.Collaborator{UserID: "bob", Role: models.RoleEditor})
+	ts.NoError(err)
+	err = store.UpdateCollaborator(ada, projID, models.Collaborator{UserID: "carol", Role: models.RoleEditor})
+	ts.ErrorIs(err, errs.ErrNotFound)
+	collaborators, err := store.GetCollaborators(ada, projID)
+	ts.NoError(err)
+	ts.Equal([]models.Collaborator{{UserID: "bob", Role: models.RoleEditor}}, collaborators)
+
+	removedCount, err := store.RemoveCollaborator(ada, projID, "bob")
+	ts.NoError(err)
+	ts.Equal(1, removedCount)
+	_, err = store.RemoveCollaborator(ada, projID, "bob")
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = store.GetProjAccess(bob, projID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	projs, err = store.GetAllProjs(bob)
+	if err != nil {
+		ts.FailNowf("err on GetAllProjs: ", err.Error())
+	}
+	ts.Empty(projs)
+}
+
+func (ts *TestSuite) TestErrorsAreWrapped() {
+	_, err := ts.server.store.GetProjByID(context.Background(), "not-an-object-id")
+	ts.ErrorIs(err, errs.ErrInvalidID)
+
+	_, err = ts.server.store.GetProjByID(context.Background(), "682571d1dafbee2eecbf4999")
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	_, err = ts.server.store.GetTodoByID(context.Background(), "682996bc78d219298228c999")
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	_, err = ts.server.store.CreateTodo(context.Background(), "682571d1dafbee2eecbf4999", models.TODO{Name: "orphan"})
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	err = ts.server.store.UpdateProjNameByID(context.Background(), "682571d1dafbee2eecbf4999", "ghost", 0)
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	_, err = ts.server.store.DeleteTodoByID(context.Background(), "682996bc78d219298228c999", 0)
+	ts.ErrorIs(err, errs.ErrNotFound)
+}
@@ -0,0 +1,102 @@
+package mongostore
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// reminders are embedded in their task like checklist items:
+//
+//	{_id, projname, tasks: [{_id, ..., reminders: [{before, fireAt, sentAt}]}]}
+//
+// a reminder is pending while it has no sentAt
+
+// dueReminder matches a pending reminder that fires at or before now
+func dueReminder(now time.Time) bson.D {
+	return bson.D{
+		{Key: "sentAt", Value: nil},
+		{Key: "fireAt", Value: bson.D{{Key: "$lte", Value: now}}},
+	}
+}
+
+// DueReminders returns the pending reminders of open todos outside the trash that fire at or before now, earliest first
+func (ms *MongoStore) DueReminders(now time.Time) ([]models.DueReminder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	hasDue := bson.D{{Key: "tasks.reminders", Value: bson.D{{Key: "$elemMatch", Value: dueReminder(now)}}}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: hasDue}},
+		{{Key: "$unwind", Value: "$tasks"}},
+		{{Key: "$match", Value: append(bson.D{{Key: "tasks.completed", Value: false}, {Key: "tasks.deletedAt", Value: nil}}, hasDue...)}},
+	}
+
+	cursor, err := ms.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	tasks := []unwoundTask{}
+	err = cursor.All(ctx, &tasks)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	due := []models.DueReminder{}
+	for _, task := range tasks {
+		task.Task.ProjID = task.ProjID.Hex()
+		for _, reminder := range task.Task.Reminders {
+			if reminder.SentAt == nil && reminder.FireAt != nil && !reminder.FireAt.After(now) {
+				due = append(due, models.DueReminder{TodoID: task.Task.ID.Hex(), Todo: task.Task, Reminder: reminder, Owner: task.Owner})
+			}
+		}
+	}
+	slices.SortStableFunc(due, func(a, b models.DueReminder) int {
+		return cmp.Or(a.Reminder.FireAt.Compare(*b.Reminder.FireAt), cmp.Compare(a.TodoID, b.TodoID))
+	})
+	return due, nil
+}
+
+// MarkReminderSent
+//
+// - bumps the version of the task, an update merged before the reminder was sent must not clear sentAt
+// - returns errs.ErrNotFound if the reminder is gone or was already sent
+func (ms *MongoStore) MarkReminderSent(TodoID, before string, sentAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	todoID, err := parseObjectID(TodoID)
+	if err != nil {
+		return err
+	}
+
+	pending := bson.D{{Key: "before", Value: before}, {Key: "sentAt", Value: nil}}
+	query := bson.D{{Key: "tasks", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+		{Key: "_id", Value: todoID},
+		{Key: "reminders", Value: bson.D{{Key: "$elemMatch", Value: pending}}},
+		notDeleted,
+	}}}}}
+	opts := options.UpdateOne().SetArrayFilters([]any{
+		bson.D{{Key: "t._id", Value: todoID}},
+		bson.D{{Key: "r.before", Value: before}, {Key: "r.sentAt", Value: nil}},
+	})
+	update := append(bson.D{{Key: "$set", Value: bson.D{{Key: "tasks.$[t].reminders.$[r].sentAt", Value: sentAt}}}}, bumpVersion("tasks.$[t]")...)
+
+	result, err := ms.Collection.UpdateOne(ctx, query, update, opts)
+	if err != nil {
+		return wrapErr(err)
+	}
+	if result.MatchedCount == 0 {
+		return errs.ErrNotFound
+	}
+	return nil
+}
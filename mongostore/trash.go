@@ -0,0 +1,213 @@
+package mongostore
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// trashed projects and tasks stay where they are and only get a deletedAt:
+//
+//	{_id, projname, deletedAt, tasks: [{_id, ..., deletedAt}]}
+//
+// trashing a project gives its tasks the same deletedAt, that is how
+// RestoreProj tells them apart from tasks that were trashed on their own before
+
+// inTrash matches a deletedAt that is set
+var inTrash = bson.D{{Key: "$ne", Value: nil}}
+
+// GetTrash
+//
+// - lists trashed projects with the tasks trashed together with them,
+// and trashed tasks of projects that are not in the trash
+// - most recently deleted first
+func (ms *MongoStore) GetTrash(ctx context.Context) ([]models.TrashItem, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	filter := bson.D{owned(ctx), {Key: "$or", Value: bson.A{
+		bson.D{{Key: "deletedAt", Value: inTrash}},
+		bson.D{{Key: "tasks", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "deletedAt", Value: inTrash}}}}}},
+	}}}
+
+	cursor, err := ms.Collection.Find(ctx, filter)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	projs := []models.PROJECT{}
+	err = cursor.All(ctx, &projs)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	trash := []models.TrashItem{}
+	for _, proj := range projs {
+		if proj.DeletedAt != nil {
+			// the tasks trashed before the project stay hidden until it is restored
+			proj.Tasks = slices.DeleteFunc(proj.Tasks, func(task models.TODO) bool {
+				return task.DeletedAt == nil || !task.DeletedAt.Equal(*proj.DeletedAt)
+			})
+			models.SortTasks(proj.Tasks)
+			trash = append(trash, models.TrashItem{Kind: models.TrashProject, ID: proj.ID.Hex(), DeletedAt: *proj.DeletedAt, Project: &proj})
+			continue
+		}
+
+		for _, task := range proj.Tasks {
+			if task.DeletedAt == nil {
+				continue
+			}
+			task.ProjID = proj.ID.Hex()
+			trash = append(trash, models.TrashItem{Kind: models.TrashTodo, ID: task.ID.Hex(), DeletedAt: *task.DeletedAt, Todo: &task})
+		}
+	}
+
+	slices.SortStableFunc(trash, func(a, b models.TrashItem) int {
+		return b.DeletedAt.Compare(a.DeletedAt)
+	})
+	return trash, nil
+}
+
+// RestoreTodo takes a task out of the trash
+//
+// - returns errs.ErrNotFound if the task is not in the trash or its project is
+func (ms *MongoStore) RestoreTodo(ctx context.Context, TodoID string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	todoID, err := parseObjectID(TodoID)
+	if err != nil {
+		return err
+	}
+
+	query := bson.D{owned(ctx), notDeleted, {Key: "tasks", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+		{Key: "_id", Value: todoID},
+		{Key: "deletedAt", Value: inTrash},
+	}}}}}
+	update := append(bson.D{{Key: "$unset", Value: bson.D{{Key: "tasks.$.deletedAt", Value: ""}}}}, bumpVersion("tasks.$")...)
+
+	return ms.recordTask(ctx, models.OpRestore, todoID, func(ctx context.Context) error {
+		result, err := ms.Collection.UpdateOne(ctx, query, update)
+		if err != nil {
+			return wrapErr(err)
+		}
+		if result.MatchedCount == 0 {
+			return errs.ErrNotFound
+		}
+		return nil
+	})
+}
+
+// RestoreProj takes a project and the tasks trashed together with it out of the trash
+//
+// - returns errs.ErrNotFound if the project is not in the trash
+func (ms *MongoStore) RestoreProj(ctx context.Context, ID string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	projID, err := parseObjectID(ID)
+	if err != nil {
+		return err
+	}
+
+	return ms.recordProj(ctx, models.OpRestore, projID, func(ctx context.Context) error {
+		return ms.restoreProj(ctx, projID)
+	})
+}
+
+func (ms *MongoStore) restoreProj(ctx context.Context, projID bson.ObjectID) error {
+	proj := models.PROJECT{}
+	opts := options.FindOne().SetProjection(bson.D{{Key: "deletedAt", Value: 1}})
+
+	err := ms.Collection.FindOne(ctx, bson.D{{Key: "_id", Value: projID}, owned(ctx), {Key: "deletedAt", Value: inTrash}}, opts).Decode(&proj)
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	update := bson.D{
+		{Key: "$unset", Value: bson.D{{Key: "deletedAt", Value: ""}, {Key: "tasks.$[t].deletedAt", Value: ""}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}, {Key: "tasks.$[t].version", Value: 1}}},
+	}
+	updateOpts := options.UpdateOne().SetArrayFilters([]any{bson.D{{Key: "t.deletedAt", Value: proj.DeletedAt}}})
+
+	// matching the deletedAt that was read makes sure the project was not restored in between
+	query := bson.D{{Key: "_id", Value: projID}, {Key: "deletedAt", Value: proj.DeletedAt}}
+
+	result, err := ms.Collection.UpdateOne(ctx, query, update, updateOpts)
+	if err != nil {
+		return wrapErr(err)
+	}
+	if result.MatchedCount == 0 {
+		return errs.ErrNotFound
+	}
+	return nil
+}
+
+// PurgeTrash permanently deletes the tasks and projects trashed before before
+//
+// - a purged project takes all of its tasks with it
+// - returns the number of deleted tasks and projects
+func (ms *MongoStore) PurgeTrash(before time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	expired := bson.D{{Key: "$lt", Value: before}}
+	filter := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "deletedAt", Value: expired}},
+		bson.D{{Key: "tasks", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "deletedAt", Value: expired}}}}}},
+	}}}
+	opts := options.Find().SetProjection(bson.D{{Key: "deletedAt", Value: 1}, {Key: "tasks._id", Value: 1}, {Key: "tasks.deletedAt", Value: 1}})
+
+	cursor, err := ms.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+
+	projs := []models.PROJECT{}
+	err = cursor.All(ctx, &projs)
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+
+	purged := 0
+	purgedProjs := bson.A{}
+	prunedProjs := bson.A{}
+	for _, proj := range projs {
+		if proj.DeletedAt != nil && proj.DeletedAt.Before(before) {
+			purged += 1 + len(proj.Tasks)
+			purgedProjs = append(purgedProjs, proj.ID)
+			continue
+		}
+		for _, task := range proj.Tasks {
+			if task.DeletedAt != nil && task.DeletedAt.Before(before) {
+				purged++
+			}
+		}
+		prunedProjs = append(prunedProjs, proj.ID)
+	}
+
+	if len(purgedProjs) > 0 {
+		_, err = ms.Collection.DeleteMany(ctx, bson.D{
+			{Key: "_id", Value: bson.D{{Key: "$in", Value: purgedProjs}}},
+			{Key: "deletedAt", Value: expired},
+		})
+		if err != nil {
+			return 0, wrapErr(err)
+		}
+	}
+
+	if len(prunedProjs) > 0 {
+		pull := bson.D{{Key: "$pull", Value: bson.D{{Key: "tasks", Value: bson.D{{Key: "deletedAt", Value: expired}}}}}}
+		_, err = ms.Collection.UpdateMany(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: prunedProjs}}}}, pull)
+		if err != nil {
+			return 0, wrapErr(err)
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,214 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// usersCollection holds the user accounts and refreshTokensCollection the refresh tokens
+// issued to them, a TTL index on expiresAt removes expired tokens, see EnsureIndexes.
+// personalTokensCollection holds the personal access tokens of the users
+const (
+	usersCollection          = "users"
+	refreshTokensCollection  = "refresh_tokens"
+	personalTokensCollection = "personal_tokens"
+)
+
+func (ms *MongoStore) users() *mongo.Collection {
+	return ms.Collection.Database().Collection(usersCollection)
+}
+
+func (ms *MongoStore) refreshTokens() *mongo.Collection {
+	return ms.Collection.Database().Collection(refreshTokensCollection)
+}
+
+func (ms *MongoStore) personalTokens() *mongo.Collection {
+	return ms.Collection.Database().Collection(personalTokensCollection)
+}
+
+// CreateUser
+//
+// - returns errs.ErrConflict if the email is already taken, the unique index on email takes care of concurrent requests
+func (ms *MongoStore) CreateUser(user models.User) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	user.ID = bson.NewObjectID().Hex()
+
+	_, err := ms.users().InsertOne(ctx, user)
+	if err != nil {
+		return "", wrapErr(err)
+	}
+	return user.ID, nil
+}
+
+func (ms *MongoStore) GetUserByEmail(email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	user := models.User{}
+	err := ms.users().FindOne(ctx, bson.D{{Key: "email", Value: email}}).Decode(&user)
+	if err != nil {
+		return models.User{}, wrapErr(err)
+	}
+	return user, nil
+}
+
+// GetUserByID
+//
+// - returns errs.ErrNotFound if there is no user ID
+func (ms *MongoStore) GetUserByID(ID string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	user := models.User{}
+	err := ms.users().FindOne(ctx, bson.D{{Key: "_id", Value: ID}}).Decode(&user)
+	if err != nil {
+		return models.User{}, wrapErr(err)
+	}
+	return user, nil
+}
+
+func (ms *MongoStore) CreateRefreshToken(token models.RefreshToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	_, err := ms.refreshTokens().InsertOne(ctx, token)
+	return wrapErr(err)
+}
+
+// UseRefreshToken marks the refresh token stored under hash as used and returns it
+//
+// - returns errs.ErrNotFound if there is no token under hash
+// - returns the token and errs.ErrConflict if it was already used
+func (ms *MongoStore) UseRefreshToken(hash string, usedAt time.Time) (models.RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: hash}, {Key: "usedAt", Value: nil}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "usedAt", Value: usedAt}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	token := models.RefreshToken{}
+	err := ms.refreshTokens().FindOneAndUpdate(ctx, filter, update, opts).Decode(&token)
+	if err == nil {
+		return token, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return models.RefreshToken{}, wrapErr(err)
+	}
+
+	err = ms.refreshTokens().FindOne(ctx, bson.D{{Key: "_id", Value: hash}}).Decode(&token)
+	if err != nil {
+		return models.RefreshToken{}, wrapErr(err)
+	}
+	return token, errs.ErrConflict
+}
+
+// DeleteRefreshTokens deletes every refresh token of family
+func (ms *MongoStore) DeleteRefreshTokens(family string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	result, err := ms.refreshTokens().DeleteMany(ctx, bson.D{{Key: "family", Value: family}})
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+	return int(result.DeletedCount), nil
+}
+
+func (ms *MongoStore) CreatePersonalToken(token models.PersonalToken) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	token.ID = bson.NewObjectID().Hex()
+
+	_, err := ms.personalTokens().InsertOne(ctx, token)
+	if err != nil {
+		return "", wrapErr(err)
+	}
+	return token.ID, nil
+}
+
+// GetPersonalTokens lists the personal access tokens of the user userID, oldest first
+func (ms *MongoStore) GetPersonalTokens(userID string) ([]models.PersonalToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	cursor, err := ms.personalTokens().Find(ctx, bson.D{{Key: "userId", Value: userID}}, opts)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	tokens := []models.PersonalToken{}
+	err = cursor.All(ctx, &tokens)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	return tokens, nil
+}
+
+// GetPersonalToken returns the personal access token stored under hash
+//
+// - returns errs.ErrNotFound if there is no token under hash
+func (ms *MongoStore) GetPersonalToken(hash string) (models.PersonalToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	token := models.PersonalToken{}
+	err := ms.personalTokens().FindOne(ctx, bson.D{{Key: "hash", Value: hash}}).Decode(&token)
+	if err != nil {
+		return models.PersonalToken{}, wrapErr(err)
+	}
+	return token, nil
+}
+
+// UsePersonalToken sets the last use of the personal access token stored under hash and returns it
+//
+// - returns errs.ErrNotFound if there is no token under hash
+func (ms *MongoStore) UsePersonalToken(hash string, usedAt time.Time) (models.PersonalToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "lastUsedAt", Value: usedAt}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	token := models.PersonalToken{}
+	err := ms.personalTokens().FindOneAndUpdate(ctx, bson.D{{Key: "hash", Value: hash}}, update, opts).Decode(&token)
+	if err != nil {
+		return models.PersonalToken{}, wrapErr(err)
+	}
+	return token, nil
+}
+
+// DeletePersonalToken revokes the personal access token ID of the user userID
+//
+// - returns errs.ErrNotFound if the user has no such token
+func (ms *MongoStore) DeletePersonalToken(userID, ID string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	_, err := parseObjectID(ID)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := ms.personalTokens().DeleteOne(ctx, bson.D{{Key: "_id", Value: ID}, {Key: "userId", Value: userID}})
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+	if result.DeletedCount == 0 {
+		return 0, errs.ErrNotFound
+	}
+	return int(result.DeletedCount), nil
+}
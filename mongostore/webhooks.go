@@ -0,0 +1,241 @@
+package mongostore
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// webhooksCollection holds the webhook subscriptions and deliveriesCollection their delivery log,
+// next to the projects collection
+const (
+	webhooksCollection   = "webhooks"
+	deliveriesCollection = "webhook_deliveries"
+)
+
+func (ms *MongoStore) webhooks() *mongo.Collection {
+	return ms.Collection.Database().Collection(webhooksCollection)
+}
+
+func (ms *MongoStore) deliveries() *mongo.Collection {
+	return ms.Collection.Database().Collection(deliveriesCollection)
+}
+
+func (ms *MongoStore) GetAllWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	cursor, err := ms.webhooks().Find(ctx, bson.D{owned(ctx)}, opts)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	hooks := []models.Webhook{}
+	err = cursor.All(ctx, &hooks)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	return hooks, nil
+}
+
+func (ms *MongoStore) GetWebhookByID(ctx context.Context, ID string) (models.Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	_, err := parseObjectID(ID)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+
+	hook := models.Webhook{}
+	err = ms.webhooks().FindOne(ctx, bson.D{{Key: "_id", Value: ID}, owned(ctx)}).Decode(&hook)
+	if err != nil {
+		return models.Webhook{}, wrapErr(err)
+	}
+	return hook, nil
+}
+
+func (ms *MongoStore) CreateWebhook(ctx context.Context, hook models.Webhook) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	hook.ID = bson.NewObjectID().Hex()
+	hook.Owner = auth.Owner(ctx)
+
+	_, err := ms.webhooks().InsertOne(ctx, hook)
+	if err != nil {
+		return "", wrapErr(err)
+	}
+	return hook.ID, nil
+}
+
+// UpdateWebhook replaces every field of a webhook except its id and creation time
+func (ms *MongoStore) UpdateWebhook(ctx context.Context, ID string, hook models.Webhook) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	_, err := parseObjectID(ID)
+	if err != nil {
+		return err
+	}
+
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "url", Value: hook.URL},
+		{Key: "secret", Value: hook.Secret},
+		{Key: "events", Value: hook.Events},
+		{Key: "active", Value: hook.Active},
+		{Key: "failures", Value: hook.Failures},
+	}}}
+
+	result, err := ms.webhooks().UpdateOne(ctx, bson.D{{Key: "_id", Value: ID}, owned(ctx)}, update)
+	if err != nil {
+		return wrapErr(err)
+	}
+	if result.MatchedCount == 0 {
+		return errs.ErrNotFound
+	}
+	return nil
+}
+
+// AddWebhookFailure counts a failed delivery of a webhook and disables it once
+// its failures in a row reach disableAfter, in a single update so that concurrent
+// deliveries and updates through the API are not lost
+//
+// - returns the webhook after the update
+func (ms *MongoStore) AddWebhookFailure(ctx context.Context, ID string, disableAfter int) (models.Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	_, err := parseObjectID(ID)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+
+	// the second stage sees the failures incremented by the first
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{{Key: "failures", Value: bson.D{{Key: "$add", Value: bson.A{"$failures", 1}}}}}}},
+		{{Key: "$set", Value: bson.D{{Key: "active", Value: bson.D{{Key: "$and", Value: bson.A{
+			"$active",
+			bson.D{{Key: "$lt", Value: bson.A{"$failures", disableAfter}}},
+		}}}}}}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	hook := models.Webhook{}
+	err = ms.webhooks().FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: ID}, owned(ctx)}, update, opts).Decode(&hook)
+	if err != nil {
+		return models.Webhook{}, wrapErr(err)
+	}
+	return hook, nil
+}
+
+// ResetWebhookFailures clears the failures in a row of a webhook after a successful delivery
+func (ms *MongoStore) ResetWebhookFailures(ctx context.Context, ID string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	_, err := parseObjectID(ID)
+	if err != nil {
+		return err
+	}
+
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "failures", Value: 0}}}}
+
+	result, err := ms.webhooks().UpdateOne(ctx, bson.D{{Key: "_id", Value: ID}, owned(ctx)}, update)
+	if err != nil {
+		return wrapErr(err)
+	}
+	if result.MatchedCount == 0 {
+		return errs.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteWebhook
+//
+// the webhook and its delivery log are deleted inside a transaction
+func (ms *MongoStore) DeleteWebhook(ctx context.Context, ID string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	_, err := parseObjectID(ID)
+	if err != nil {
+		return 0, err
+	}
+
+	session, err := ms.Conn.StartSession()
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+	defer session.EndSession(ctx)
+
+	deletedCount, err := session.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+		result, err := ms.webhooks().DeleteOne(ctx, bson.D{{Key: "_id", Value: ID}, owned(ctx)})
+		if err != nil {
+			return 0, wrapErr(err)
+		}
+		if result.DeletedCount == 0 {
+			return 0, errs.ErrNotFound
+		}
+
+		_, err = ms.deliveries().DeleteMany(ctx, bson.D{{Key: "webhookId", Value: ID}})
+		if err != nil {
+			return 0, wrapErr(err)
+		}
+		return int(result.DeletedCount), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deletedCount.(int), nil
+}
+
+func (ms *MongoStore) AddDelivery(delivery models.Delivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	if delivery.ID == "" {
+		delivery.ID = bson.NewObjectID().Hex()
+	}
+
+	_, err := ms.deliveries().InsertOne(ctx, delivery)
+	return wrapErr(err)
+}
+
+// GetDeliveries returns the latest deliveries of a webhook, newest first
+//
+// - returns errs.ErrNotFound if the webhook does not belong to the owner of ctx
+func (ms *MongoStore) GetDeliveries(ctx context.Context, webhookID string, limit int) ([]models.Delivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	err := ms.webhooks().FindOne(ctx, bson.D{{Key: "_id", Value: webhookID}, owned(ctx)}).Err()
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "deliveredAt", Value: -1}, {Key: "attempt", Value: -1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := ms.deliveries().Find(ctx, bson.D{{Key: "webhookId", Value: webhookID}}, opts)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	deliveries := []models.Delivery{}
+	err = cursor.All(ctx, &deliveries)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,175 @@
+package postgres_store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// visibleFormat matches the projects p that the owner of a context owns or that are shared with its user,
+// its placeholders are for auth.Owner and auth.UserID
+const visibleFormat = `(p.owner = $%d OR p.id IN (SELECT project_id FROM project_collaborators WHERE user_id = $%d))`
+
+// visible is visibleFormat with the placeholder numbers owner and user filled in
+func visible(owner, user int) string {
+	return fmt.Sprintf(visibleFormat, owner, user)
+}
+
+// GetProjAccess
+//
+// - returns errs.ErrNotFound if the project is neither owned by nor shared with the user of ctx
+func (pg *PostGresStore) GetProjAccess(ctx context.Context, projID string) (models.Access, error) {
+	ID, err := parseID(projID)
+	if err != nil {
+		return models.Access{}, err
+	}
+
+	stmt := `SELECT p.id, p.owner, c.role FROM projects p
+    LEFT JOIN project_collaborators c ON c.project_id = p.id AND c.user_id = $2
+    WHERE p.id = $1`
+
+	return scanAccess(pg.DB.QueryRow(stmt, ID, auth.UserID(ctx)), auth.UserID(ctx))
+}
+
+// GetTodoAccess is GetProjAccess for the project of the todo todoID
+func (pg *PostGresStore) GetTodoAccess(ctx context.Context, todoID string) (models.Access, error) {
+	ID, err := parseID(todoID)
+	if err != nil {
+		return models.Access{}, err
+	}
+
+	stmt := `SELECT p.id, p.owner, c.role FROM todos t
+    JOIN projects p ON p.owner = t.owner AND p.projname = t.projname
+    LEFT JOIN project_collaborators c ON c.project_id = p.id AND c.user_id = $2
+    WHERE t.id = $1`
+
+	return scanAccess(pg.DB.QueryRow(stmt, ID, auth.UserID(ctx)), auth.UserID(ctx))
+}
+
+// scanAccess scans the id, owner and collaborator role of a project into the access of the user userID
+func scanAccess(row scanner, userID string) (models.Access, error) {
+	access := models.Access{}
+	ID := 0
+	role := sql.NullString{}
+	err := row.Scan(&ID, &access.Owner, &role)
+	if err != nil {
+		return models.Access{}, wrapErr(err)
+	}
+	access.ProjID = strconv.Itoa(ID)
+
+	switch {
+	case access.Owner == userID:
+		access.Role = models.RoleOwner
+	case role.Valid:
+		access.Role = role.String
+	default:
+		return models.Access{}, errs.ErrNotFound
+	}
+	return access, nil
+}
+
+// GetCollaborators lists the collaborators of the project projID by user id
+func (pg *PostGresStore) GetCollaborators(ctx context.Context, projID string) ([]models.Collaborator, error) {
+	ID, err := parseID(projID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the project is joined first so that a project without collaborators still has a row
+	stmt := `SELECT c.user_id, c.role FROM projects p
+    LEFT JOIN project_collaborators c ON c.project_id = p.id
+    WHERE p.id = $1 AND p.owner = $2 AND p.deleted_at IS NULL
+    ORDER BY c.user_id`
+
+	rows, err := pg.DB.Query(stmt, ID, auth.Owner(ctx))
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	found := false
+	collaborators := []models.Collaborator{}
+	for rows.Next() {
+		found = true
+		userID, role := sql.NullString{}, sql.NullString{}
+		err := rows.Scan(&userID, &role)
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+		if userID.Valid {
+			collaborators = append(collaborators, models.Collaborator{UserID: userID.String, Role: role.String})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: project %q", errs.ErrNotFound, projID)
+	}
+	return collaborators, nil
+}
+
+// AddCollaborator
+//
+// - returns errs.ErrNotFound if the project does not exist or is in the trash
+// - returns errs.ErrConflict if the user already is a collaborator
+func (pg *PostGresStore) AddCollaborator(ctx context.Context, projID string, collaborator models.Collaborator) error {
+	ID, err := parseID(projID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO project_collaborators (project_id, user_id, role)
+    SELECT id, $3, $4 FROM projects WHERE id = $1 AND owner = $2 AND deleted_at IS NULL`
+
+	result, err := pg.DB.Exec(stmt, ID, auth.Owner(ctx), collaborator.UserID, collaborator.Role)
+	if err != nil {
+		return wrapErr(err)
+	}
+	_, err = checkRowsAffected(result)
+	return err
+}
+
+// UpdateCollaborator changes the role of a collaborator
+//
+// - returns errs.ErrNotFound if the user is not a collaborator of the project
+func (pg *PostGresStore) UpdateCollaborator(ctx context.Context, projID string, collaborator models.Collaborator) error {
+	ID, err := parseID(projID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `UPDATE project_collaborators c SET role = $4 FROM projects p
+    WHERE c.project_id = p.id AND p.id = $1 AND p.owner = $2 AND p.deleted_at IS NULL AND c.user_id = $3`
+
+	result, err := pg.DB.Exec(stmt, ID, auth.Owner(ctx), collaborator.UserID, collaborator.Role)
+	if err != nil {
+		return wrapErr(err)
+	}
+	_, err = checkRowsAffected(result)
+	return err
+}
+
+// RemoveCollaborator
+//
+// - returns errs.ErrNotFound if the user is not a collaborator of the project
+func (pg *PostGresStore) RemoveCollaborator(ctx context.Context, projID, userID string) (int, error) {
+	ID, err := parseID(projID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `DELETE FROM project_collaborators c USING projects p
+    WHERE c.project_id = p.id AND p.id = $1 AND p.owner = $2 AND c.user_id = $3`
+
+	result, err := pg.DB.Exec(stmt, ID, auth.Owner(ctx), userID)
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+	return checkRowsAffected(result)
+}
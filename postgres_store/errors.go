@@ -0,0 +1,103 @@
+package postgres_store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+)
+
+// postgres error codes we care about
+// see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgNotNullViolation    = "23502"
+	pgForeignKeyViolation = "23503"
+	pgUniqueViolation     = "23505"
+	pgCheckViolation      = "23514"
+)
+
+// wrapErr wraps errors returned by database/sql and pgx into the errs taxonomy
+//
+// the original error is kept in the chain so it can still be inspected with errors.Is/As
+func wrapErr(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %w", errs.ErrNotFound, err)
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgUniqueViolation:
+			return fmt.Errorf("%w: %w", errs.ErrConflict, err)
+		case pgForeignKeyViolation:
+			return fmt.Errorf("%w: %w", errs.ErrNotFound, err)
+		case pgNotNullViolation, pgCheckViolation:
+			return fmt.Errorf("%w: %w", errs.ErrValidation, err)
+		}
+		return err
+	}
+
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	if errors.As(err, &connectErr) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		pgconn.Timeout(err) {
+		return fmt.Errorf("%w: %w", errs.ErrUnavailable, err)
+	}
+
+	return err
+}
+
+// parseID converts the string ID used by the TodoStore interface into a postgres serial id
+func parseID(ID string) (int, error) {
+	intID, err := strconv.Atoi(ID)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", errs.ErrInvalidID, ID)
+	}
+	return intID, nil
+}
+
+// checkRowsAffected returns errs.ErrNotFound when an UPDATE/DELETE did not match any row
+func checkRowsAffected(result sql.Result) (int, error) {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+	if rowsAffected == 0 {
+		return 0, errs.ErrNotFound
+	}
+	return int(rowsAffected), nil
+}
+
+// checkVersionRowsAffected is checkRowsAffected for an UPDATE/DELETE of table that only matches the given version
+//
+// returns errs.ErrPreconditionFailed when the row of owner still exists at another version and is not in the trash
+func checkVersionRowsAffected(q querier, result sql.Result, table, owner string, ID, version int) (int, error) {
+	rowsAffected, err := checkRowsAffected(result)
+	if !errors.Is(err, errs.ErrNotFound) || version == 0 {
+		return rowsAffected, err
+	}
+
+	exists := false
+	err = q.QueryRow(`SELECT EXISTS (SELECT 1 FROM `+table+` WHERE id = $1 AND owner = $2 AND deleted_at IS NULL)`, ID, owner).Scan(&exists)
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+	if exists {
+		return 0, fmt.Errorf("%w: %s %d is no longer at version %d", errs.ErrPreconditionFailed, table, ID, version)
+	}
+	return 0, errs.ErrNotFound
+}
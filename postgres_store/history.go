@@ -0,0 +1,147 @@
+package postgres_store
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/history"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// GetHistory lists the history entries of the todo or project ID, oldest first
+func (pg *PostGresStore) GetHistory(ctx context.Context, kind, ID string) ([]models.HistoryEntry, error) {
+	intID, err := parseID(ID)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := `SELECT id, kind, entity_id, operation, actor, created_at, changes FROM history WHERE kind = $1 AND entity_id = $2 AND owner = $3 ORDER BY id`
+
+	rows, err := pg.DB.Query(stmt, kind, intID, auth.Owner(ctx))
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	entries := []models.HistoryEntry{}
+	for rows.Next() {
+		entry := models.HistoryEntry{}
+		entryID, entityID, changes := 0, 0, []byte{}
+
+		err := rows.Scan(&entryID, &entry.Kind, &entityID, &entry.Operation, &entry.Actor, &entry.Time, &changes)
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+		entry.ID = strconv.Itoa(entryID)
+		entry.EntityID = strconv.Itoa(entityID)
+
+		err = json.Unmarshal(changes, &entry.Changes)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, wrapErr(rows.Err())
+}
+
+// addHistory appends entry to the history table, unless it changed nothing
+func addHistory(q querier, entry models.HistoryEntry) error {
+	if len(entry.Changes) == 0 {
+		return nil
+	}
+	entityID, err := parseID(entry.EntityID)
+	if err != nil {
+		return err
+	}
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO history (kind, entity_id, operation, actor, created_at, changes, owner) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err = q.Exec(stmt, entry.Kind, entityID, entry.Operation, entry.Actor, entry.Time, changes, entry.Owner)
+	return wrapErr(err)
+}
+
+// recordTodo runs change and records what it did to the todo ID in the history
+//
+// - q has to be a transaction so that the entry is rolled back with the change
+// - nothing is recorded if change fails
+// - returns errs.ErrNotFound without running change if the todo is not owned by the owner of ctx
+func recordTodo(ctx context.Context, q querier, operation string, ID int, change func() error) error {
+	before, err := getTodo(ctx, q, ID)
+	if err != nil {
+		return err
+	}
+	err = change()
+	if err != nil {
+		return err
+	}
+	return addTodoHistory(ctx, q, operation, ID, before)
+}
+
+// addTodoHistory records the change of the todo ID from before to how it is now
+func addTodoHistory(ctx context.Context, q querier, operation string, ID int, before models.TODO) error {
+	after, err := getTodo(ctx, q, ID)
+	if err != nil {
+		return err
+	}
+	entry, err := history.TodoEntry(ctx, operation, strconv.Itoa(ID), before, after)
+	if err != nil {
+		return err
+	}
+	return addHistory(q, entry)
+}
+
+// recordProj is recordTodo for projects
+func recordProj(ctx context.Context, q querier, operation string, ID int, change func() error) error {
+	before, err := getProj(ctx, q, ID)
+	if err != nil {
+		return err
+	}
+	err = change()
+	if err != nil {
+		return err
+	}
+	return addProjHistory(ctx, q, operation, ID, before)
+}
+
+// addProjHistory is addTodoHistory for projects
+func addProjHistory(ctx context.Context, q querier, operation string, ID int, before models.PROJECT) error {
+	after, err := getProj(ctx, q, ID)
+	if err != nil {
+		return err
+	}
+	entry, err := history.ProjectEntry(ctx, operation, strconv.Itoa(ID), before, after)
+	if err != nil {
+		return err
+	}
+	return addHistory(q, entry)
+}
+
+// getTodo loads the todo ID of the owner of ctx whether or not it is in the trash
+func getTodo(ctx context.Context, q querier, ID int) (models.TODO, error) {
+	stmt := `SELECT ` + todoColumns + ` FROM todos t JOIN projects p ON p.owner = t.owner AND p.projname = t.projname WHERE t.id = $1 AND t.owner = $2`
+
+	todo, err := scanTodo(q.QueryRow(stmt, ID, auth.Owner(ctx)))
+	if err != nil {
+		return models.TODO{}, wrapErr(err)
+	}
+	return todo, nil
+}
+
+// getProj loads the project ID of the owner of ctx without its todos, whether or not it is in the trash
+func getProj(ctx context.Context, q querier, ID int) (models.PROJECT, error) {
+	project := models.PROJECT{}
+
+	stmt := `SELECT id, COALESCE(trashed_name, projname), version, deleted_at FROM projects WHERE id = $1 AND owner = $2`
+
+	err := q.QueryRow(stmt, ID, auth.Owner(ctx)).Scan(&project.Id, &project.ProjName, &project.Version, &project.DeletedAt)
+	if err != nil {
+		return models.PROJECT{}, wrapErr(err)
+	}
+	return project, nil
+}
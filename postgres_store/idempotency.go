@@ -0,0 +1,76 @@
+package postgres_store
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// CreateIdempotencyRecord reserves record.Key for a request that is about to run
+//
+// - an expired record with the same key is replaced, expired records are cleaned up on the way
+// - returns errs.ErrConflict if the key is still in use, the primary key takes care of concurrent requests
+func (pg *PostGresStore) CreateIdempotencyRecord(record models.IdempotencyRecord) error {
+	_, err := pg.DB.Exec(`DELETE FROM idempotency_keys WHERE expires_at <= $1`, record.CreatedAt)
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	stmt := `INSERT INTO idempotency_keys (key, fingerprint, created_at, expires_at) VALUES ($1, $2, $3, $4)`
+
+	_, err = pg.DB.Exec(stmt, record.Key, record.Fingerprint, record.CreatedAt, record.ExpiresAt)
+	return wrapErr(err)
+}
+
+// GetIdempotencyRecord
+//
+// - returns errs.ErrNotFound if there is no record for key or it has expired
+func (pg *PostGresStore) GetIdempotencyRecord(key string) (models.IdempotencyRecord, error) {
+	stmt := `SELECT key, fingerprint, status_code, header, body, created_at, expires_at FROM idempotency_keys WHERE key = $1 AND expires_at > $2`
+
+	record := models.IdempotencyRecord{}
+	header := []byte{}
+	err := pg.DB.QueryRow(stmt, key, time.Now()).Scan(&record.Key, &record.Fingerprint, &record.StatusCode, &header, &record.Body, &record.CreatedAt, &record.ExpiresAt)
+	if err != nil {
+		return models.IdempotencyRecord{}, wrapErr(err)
+	}
+	record.CreatedAt, record.ExpiresAt = record.CreatedAt.UTC(), record.ExpiresAt.UTC()
+
+	err = json.Unmarshal(header, &record.Header)
+	if err != nil {
+		return models.IdempotencyRecord{}, err
+	}
+	return record, nil
+}
+
+// SaveIdempotencyRecord stores the response of the request that reserved record.Key
+//
+// - returns errs.ErrNotFound if the key is not reserved
+func (pg *PostGresStore) SaveIdempotencyRecord(record models.IdempotencyRecord) error {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+
+	stmt := `UPDATE idempotency_keys SET status_code = $1, header = $2, body = $3 WHERE key = $4`
+
+	result, err := pg.DB.Exec(stmt, record.StatusCode, string(header), record.Body, record.Key)
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	_, err = checkRowsAffected(result)
+	return err
+}
+
+// DeleteIdempotencyRecord releases key so that the request can be retried
+func (pg *PostGresStore) DeleteIdempotencyRecord(key string) error {
+	result, err := pg.DB.Exec(`DELETE FROM idempotency_keys WHERE key = $1`, key)
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	_, err = checkRowsAffected(result)
+	return err
+}
@@ -0,0 +1,158 @@
+package postgres_store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+	"github.com/ganglinwu/todoapp-backend-v1/rank"
+)
+
+// AddItem
+//
+// - the item goes to the end of the checklist
+// - like every item change, it bumps the version of the todo
+// - returns errs.ErrNotFound if the todo does not exist or is in the trash
+func (pg *PostGresStore) AddItem(ctx context.Context, todoID string, item models.ChecklistItem) (string, error) {
+	intTodoID, err := parseID(todoID)
+	if err != nil {
+		return "", err
+	}
+
+	insertedID := 0
+	err = pg.withTx(func(tx *sql.Tx) error {
+		return recordTodo(ctx, tx, models.OpAddItem, intTodoID, func() error {
+			lastRank := ""
+			err := tx.QueryRow(`SELECT COALESCE(max(rank COLLATE "C"), '') FROM todo_items WHERE todo_id = $1`, intTodoID).Scan(&lastRank)
+			if err != nil {
+				return wrapErr(err)
+			}
+
+			stmt := `INSERT INTO todo_items (todo_id, name, completed, rank) VALUES ($1, $2, $3, $4) RETURNING id`
+
+			err = tx.QueryRow(stmt, intTodoID, item.Name, item.Completed, rank.After(lastRank)).Scan(&insertedID)
+			if err != nil {
+				return wrapErr(err)
+			}
+			return bumpVersion(tx, intTodoID)
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(insertedID), nil
+}
+
+// UpdateItem sets the name and completed state of an item
+//
+// - returns errs.ErrNotFound if the item is not on the todo's checklist
+func (pg *PostGresStore) UpdateItem(ctx context.Context, todoID, itemID string, item models.ChecklistItem) error {
+	stmt := `UPDATE todo_items SET name = $1, completed = $2 WHERE id = $3 AND todo_id = $4`
+
+	intTodoID, intItemID, err := parseItemIDs(todoID, itemID)
+	if err != nil {
+		return err
+	}
+
+	return pg.withTx(func(tx *sql.Tx) error {
+		return recordTodo(ctx, tx, models.OpUpdateItem, intTodoID, func() error {
+			result, err := tx.Exec(stmt, item.Name, item.Completed, intItemID, intTodoID)
+			if err != nil {
+				return wrapErr(err)
+			}
+
+			_, err = checkRowsAffected(result)
+			if err != nil {
+				return err
+			}
+			return bumpVersion(tx, intTodoID)
+		})
+	})
+}
+
+// ReorderItem
+//
+// works the same way as ReorderTodo, within the todo's checklist
+func (pg *PostGresStore) ReorderItem(ctx context.Context, todoID, itemID string, place models.Placement) error {
+	anchorID, after := place.Before, false
+	if place.After != "" {
+		anchorID, after = place.After, true
+	}
+
+	intTodoID, intItemID, err := parseItemIDs(todoID, itemID)
+	if err != nil {
+		return err
+	}
+	intAnchorID, err := parseID(anchorID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `SELECT id, rank FROM todo_items WHERE todo_id = $1 ORDER BY rank COLLATE "C", id FOR UPDATE`
+
+	return pg.withTx(func(tx *sql.Tx) error {
+		return recordTodo(ctx, tx, models.OpReorderItem, intTodoID, func() error {
+			IDs, keys, err := loadRanks(tx, stmt, intTodoID)
+			if err != nil {
+				return err
+			}
+
+			from := slices.Index(IDs, intItemID)
+			anchor := slices.Index(IDs, intAnchorID)
+			if from == -1 || anchor == -1 {
+				return fmt.Errorf("%w: item is not on the checklist of todo %q", errs.ErrNotFound, todoID)
+			}
+
+			err = saveRanks(tx, "todo_items", IDs, rank.Move(keys, from, anchor, after))
+			if err != nil {
+				return err
+			}
+			return bumpVersion(tx, intTodoID)
+		})
+	})
+}
+
+// DeleteItem
+//
+// - returns errs.ErrNotFound if the item is not on the todo's checklist
+func (pg *PostGresStore) DeleteItem(ctx context.Context, todoID, itemID string) (int, error) {
+	stmt := `DELETE FROM todo_items WHERE id = $1 AND todo_id = $2`
+
+	intTodoID, intItemID, err := parseItemIDs(todoID, itemID)
+	if err != nil {
+		return 0, err
+	}
+
+	deletedCount := 0
+	err = pg.withTx(func(tx *sql.Tx) error {
+		return recordTodo(ctx, tx, models.OpDeleteItem, intTodoID, func() error {
+			result, err := tx.Exec(stmt, intItemID, intTodoID)
+			if err != nil {
+				return wrapErr(err)
+			}
+
+			deletedCount, err = checkRowsAffected(result)
+			if err != nil {
+				return err
+			}
+			return bumpVersion(tx, intTodoID)
+		})
+	})
+	return deletedCount, err
+}
+
+func parseItemIDs(todoID, itemID string) (int, int, error) {
+	intTodoID, err := parseID(todoID)
+	if err != nil {
+		return 0, 0, err
+	}
+	intItemID, err := parseID(itemID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return intTodoID, intItemID, nil
+}
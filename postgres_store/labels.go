@@ -0,0 +1,148 @@
+package postgres_store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+func (pg *PostGresStore) GetAllLabels(ctx context.Context) ([]models.Label, error) {
+	rows, err := pg.DB.Query(`SELECT id, name, color FROM labels WHERE owner = $1 ORDER BY name, id`, auth.Owner(ctx))
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	labels := []models.Label{}
+	for rows.Next() {
+		label, err := scanLabel(rows)
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, label)
+	}
+	return labels, wrapErr(rows.Err())
+}
+
+func (pg *PostGresStore) GetLabelByID(ctx context.Context, ID string) (models.Label, error) {
+	intID, err := parseID(ID)
+	if err != nil {
+		return models.Label{}, err
+	}
+	return scanLabel(pg.DB.QueryRow(`SELECT id, name, color FROM labels WHERE id = $1 AND owner = $2`, intID, auth.Owner(ctx)))
+}
+
+// CreateLabel
+//
+// - returns errs.ErrConflict if the user already has a label with the same name
+func (pg *PostGresStore) CreateLabel(ctx context.Context, label models.Label) (string, error) {
+	insertedID := 0
+	err := pg.DB.QueryRow(`INSERT INTO labels (name, color, owner) VALUES ($1, $2, $3) RETURNING id`, label.Name, label.Color, auth.Owner(ctx)).Scan(&insertedID)
+	if err != nil {
+		return "", wrapErr(err)
+	}
+	return strconv.Itoa(insertedID), nil
+}
+
+// UpdateLabel
+//
+// - returns errs.ErrConflict if another label of the user has the same name
+func (pg *PostGresStore) UpdateLabel(ctx context.Context, ID string, label models.Label) error {
+	intID, err := parseID(ID)
+	if err != nil {
+		return err
+	}
+
+	result, err := pg.DB.Exec(`UPDATE labels SET name = $1, color = $2 WHERE id = $3 AND owner = $4`, label.Name, label.Color, intID, auth.Owner(ctx))
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	_, err = checkRowsAffected(result)
+	return err
+}
+
+// DeleteLabel
+//
+// todo_labels cascades, so the label is removed from every todo as well
+func (pg *PostGresStore) DeleteLabel(ctx context.Context, ID string) (int, error) {
+	intID, err := parseID(ID)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := pg.DB.Exec(`DELETE FROM labels WHERE id = $1 AND owner = $2`, intID, auth.Owner(ctx))
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+
+	return checkRowsAffected(result)
+}
+
+func scanLabel(row scanner) (models.Label, error) {
+	label := models.Label{}
+	ID := 0
+
+	err := row.Scan(&ID, &label.Name, &label.Color)
+	if err != nil {
+		return models.Label{}, wrapErr(err)
+	}
+	label.ID = strconv.Itoa(ID)
+	return label, nil
+}
+
+// setLabels replaces the labels of a todo
+//
+// - nil leaves the labels as they are
+// - returns errs.ErrValidation if any of the labels does not exist or is not owned by owner
+func setLabels(q querier, owner string, todoID int, labels []string) error {
+	if labels == nil {
+		return nil
+	}
+
+	labelIDs, err := parseIDs(labels)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(`DELETE FROM todo_labels WHERE todo_id = $1`, todoID)
+	if err != nil {
+		return wrapErr(err)
+	}
+	if len(labelIDs) == 0 {
+		return nil
+	}
+
+	// unknown labels are left out by the join, which shows up as fewer rows
+	result, err := q.Exec(`INSERT INTO todo_labels (todo_id, label_id) SELECT $1, id FROM labels WHERE id = ANY($2) AND owner = $3`, todoID, labelIDs, owner)
+	if err != nil {
+		return wrapErr(err)
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return wrapErr(err)
+	}
+	if int(inserted) != len(labelIDs) {
+		return fmt.Errorf("%w: unknown label in %v", errs.ErrValidation, labels)
+	}
+	return nil
+}
+
+// parseIDs parses a list of ids and drops duplicates
+func parseIDs(IDs []string) ([]int, error) {
+	intIDs := make([]int, 0, len(IDs))
+	for _, ID := range IDs {
+		intID, err := parseID(ID)
+		if err != nil {
+			return nil, err
+		}
+		intIDs = append(intIDs, intID)
+	}
+	slices.Sort(intIDs)
+	return slices.Compact(intIDs), nil
+}
@@ -1,14 +1,22 @@
 package postgres_store
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
 	"github.com/ganglinwu/todoapp-backend-v1/errs"
 	"github.com/ganglinwu/todoapp-backend-v1/models"
+	"github.com/ganglinwu/todoapp-backend-v1/rank"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
@@ -38,94 +46,410 @@ func NewConnection(connString string) (*sql.DB, error) {
 	return db, nil
 }
 
-func (pg *PostGresStore) GetAllProjs() ([]models.PROJECT, error) {
+// todoColumns is selected by every query that returns a models.TODO
+// the queries alias todos as t and join projects as p
+const todoColumns = `t.id, t.name, t.description, t.duedate, t.priority, t.completed, t.updated_at, COALESCE(p.trashed_name, t.projname), t.rank, t.recurrence, t.occurrence, t.version, t.deleted_at, p.id, ` + itemsColumn + `, ` + labelsColumn + `, ` + remindersColumn
+
+// itemsColumn aggregates the checklist of each todo into a json array
+// so that every query returning todos also returns their items
+const itemsColumn = `COALESCE((SELECT json_agg(json_build_object('id', i.id::text, 'name', i.name, 'completed', i.completed, 'rank', i.rank) ORDER BY i.rank COLLATE "C", i.id) FROM todo_items i WHERE i.todo_id = t.id), '[]')`
+
+// labelsColumn aggregates the label ids of each todo into a json array
+const labelsColumn = `COALESCE((SELECT json_agg(l.label_id::text ORDER BY l.label_id) FROM todo_labels l WHERE l.todo_id = t.id), '[]')`
+
+// liveTodo excludes todos in the trash
+//
+// trashing a project trashes its todos too, so a todo that is not in the trash
+// always belongs to a project that is not in the trash either
+const liveTodo = `t.deleted_at IS NULL`
+
+// rankOrder orders todos by their manual position, ranks compare bytewise
+const rankOrder = `t.rank COLLATE "C", t.id`
+
+// querier is satisfied by both *sql.DB and *sql.Tx so that the write
+// helpers can run on their own or as part of a batch transaction
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanTodo scans a row selected with todoColumns
+//
+// extra is scanned into from any columns selected after todoColumns
+func scanTodo(row scanner, extra ...any) (models.TODO, error) {
+	todo := models.TODO{}
+	projID := 0
+	items, labels, reminders := []byte{}, []byte{}, []byte{}
+
+	dest := []any{&todo.Id, &todo.Name, &todo.Description, &todo.DueDate, &todo.Priority, &todo.Completed, &todo.Updated_At, &todo.ProjName, &todo.Rank, &todo.Recurrence, &todo.Occurrence, &todo.Version, &todo.DeletedAt, &projID, &items, &labels, &reminders}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return models.TODO{}, err
+	}
+	todo.ProjID = strconv.Itoa(projID)
+
+	err = json.Unmarshal(items, &todo.Items)
+	if err != nil {
+		return models.TODO{}, err
+	}
+	err = json.Unmarshal(labels, &todo.Labels)
+	if err != nil {
+		return models.TODO{}, err
+	}
+	err = json.Unmarshal(reminders, &todo.Reminders)
+	if err != nil {
+		return models.TODO{}, err
+	}
+	return todo, nil
+}
+
+// escapeLike escapes the LIKE wildcards so user input is matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (pg *PostGresStore) GetAllProjs(ctx context.Context) ([]models.PROJECT, error) {
 	projects := &[]models.PROJECT{}
 
-	stmt := "select * from projects"
+	stmt := "select id, projname, version from projects p where " + visible(1, 2) + " and deleted_at is null order by id"
 
-	rows, err := pg.DB.Query(stmt)
+	rows, err := pg.DB.Query(stmt, auth.Owner(ctx), auth.UserID(ctx))
 	if err != nil {
-		return nil, err
+		return nil, wrapErr(err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		project := models.PROJECT{}
 
-		err := rows.Scan(&project.Id, &project.ProjName)
+		err := rows.Scan(&project.Id, &project.ProjName, &project.Version)
 		if err != nil {
-			return nil, err
+			return nil, wrapErr(err)
 		}
 		*projects = append(*projects, project)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
 
+	err = pg.attachTasks(*projects)
+	if err != nil {
+		return nil, err
+	}
 	return *projects, nil
 }
 
-func (pg *PostGresStore) GetAllTodos() ([]models.TODO, error) {
+// attachTasks loads the tasks of every project in projects, in rank order
+//
+// the projects have to be loaded with the owner's context already, their todos share their owner
+func (pg *PostGresStore) attachTasks(projects []models.PROJECT) error {
+	IDs := make([]int, len(projects))
+	index := map[int]int{}
+	for i := range projects {
+		projects[i].Tasks = []models.TODO{}
+		IDs[i] = projects[i].Id
+		index[projects[i].Id] = i
+	}
+
+	stmt := `SELECT ` + todoColumns + ` FROM todos t JOIN projects p ON p.owner = t.owner AND p.projname = t.projname WHERE p.id = ANY($1) AND ` + liveTodo + ` ORDER BY ` + rankOrder
+
+	rows, err := pg.DB.Query(stmt, IDs)
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return wrapErr(err)
+		}
+		projID, _ := strconv.Atoi(todo.ProjID)
+		i := index[projID]
+		projects[i].Tasks = append(projects[i].Tasks, todo)
+	}
+	return wrapErr(rows.Err())
+}
+
+func (pg *PostGresStore) GetAllTodos(ctx context.Context) ([]models.TODO, error) {
 	todos := []models.TODO{}
 
-	stmt := "select * from todos"
+	stmt := `SELECT ` + todoColumns + ` FROM todos t JOIN projects p ON p.owner = t.owner AND p.projname = t.projname WHERE ` + visible(1, 2) + ` AND ` + liveTodo + ` ORDER BY p.id, ` + rankOrder
 
-	rows, err := pg.DB.Query(stmt)
+	rows, err := pg.DB.Query(stmt, auth.Owner(ctx), auth.UserID(ctx))
 	if err != nil {
-		return nil, err
+		return nil, wrapErr(err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		todo := models.TODO{}
-		err := rows.Scan(&todo.Id, &todo.Name, &todo.Description, &todo.DueDate, &todo.Priority, &todo.Completed, &todo.Updated_At, &todo.ProjName)
+		todo, err := scanTodo(rows)
 		if err != nil {
-			return nil, err
+			return nil, wrapErr(err)
 		}
 		todos = append(todos, todo)
 	}
-	return todos, nil
+	return todos, wrapErr(rows.Err())
 }
 
-func (pg *PostGresStore) GetProjByID(ID string) (models.PROJECT, error) {
+func (pg *PostGresStore) GetProjByID(ctx context.Context, ID string) (models.PROJECT, error) {
 	project := models.PROJECT{}
 
-	IDint, err := strconv.Atoi(ID)
+	IDint, err := parseID(ID)
 	if err != nil {
 		return models.PROJECT{}, err
 	}
 
-	stmt := "select * from projects where id = $1"
+	stmt := "select id, projname, version from projects where id = $1 and owner = $2 and deleted_at is null"
+
+	row := pg.DB.QueryRow(stmt, IDint, auth.Owner(ctx))
 
-	row := pg.DB.QueryRow(stmt, IDint)
+	err = row.Scan(&project.Id, &project.ProjName, &project.Version)
+	if err != nil {
+		return models.PROJECT{}, wrapErr(err)
+	}
 
-	err = row.Scan(&project.Id, &project.ProjName)
+	projects := []models.PROJECT{project}
+	err = pg.attachTasks(projects)
 	if err != nil {
 		return models.PROJECT{}, err
 	}
-	return project, nil
+	return projects[0], nil
 }
 
-func (pg *PostGresStore) GetTodoByID(todoID string) (models.TODO, error) {
-	todo := models.TODO{}
-
-	intID, err := strconv.Atoi(todoID)
+func (pg *PostGresStore) GetTodoByID(ctx context.Context, todoID string) (models.TODO, error) {
+	intID, err := parseID(todoID)
 	if err != nil {
 		return models.TODO{}, err
 	}
 
-	stmt := `SELECT * FROM todos WHERE id=$1`
+	stmt := `SELECT ` + todoColumns + ` FROM todos t JOIN projects p ON p.owner = t.owner AND p.projname = t.projname WHERE t.id=$1 AND t.owner = $2 AND ` + liveTodo
 
-	err = pg.DB.QueryRow(stmt, intID).Scan(&todo.Id, &todo.Name, &todo.Description, &todo.DueDate, &todo.Priority, &todo.Completed, &todo.Updated_At, &todo.ProjName)
+	todo, err := scanTodo(pg.DB.QueryRow(stmt, intID, auth.Owner(ctx)))
 	if err != nil {
-		return models.TODO{}, err
+		return models.TODO{}, wrapErr(err)
 	}
 	return todo, nil
 }
 
-func (pg *PostGresStore) CreateProj(Name string, Tasks []models.TODO) (string, error) {
-	stmt := `insert into projects (projname) values ($1) returning id;`
+// priorityRankSQL mirrors models.PriorityRank
+const priorityRankSQL = `CASE lower(t.priority) WHEN 'high' THEN 3 WHEN 'hi' THEN 3 WHEN 'mid' THEN 2 WHEN 'medium' THEN 2 WHEN 'low' THEN 1 ELSE 0 END`
+
+// sortKeys maps models.TodoQuery.SortBy onto the expression to ORDER BY
+// and the type its cursor key has to be cast back into
+//
+// NULLs are coalesced so that the keyset comparison never sees a NULL
+var sortKeys = map[string]struct{ expr, cast string }{
+	models.SortByDueDate:   {`COALESCE(t.duedate, 'infinity')`, "timestamptz"},
+	models.SortByPriority:  {priorityRankSQL, "int"},
+	models.SortByUpdatedAt: {`COALESCE(t.updated_at, '-infinity')`, "timestamptz"},
+	models.SortByRank:      {`(t.rank COLLATE "C")`, "text"},
+}
+
+// QueryTodos
+//
+// - every filter in q is translated into the WHERE clause
+// - sorting is done by postgres, ties are broken by id
+// - pagination is keyset based: the cursor holds the sort key and id of the last row
+// so rows inserted between two requests never shift the next page
+//
+// returns the next cursor, or "" on the last page
+func (pg *PostGresStore) QueryTodos(ctx context.Context, q models.TodoQuery) ([]models.TODO, string, error) {
+	where := []string{liveTodo}
+	args := []any{}
+
+	// addFilter appends args and substitutes their placeholder numbers into cond
+	addFilter := func(cond string, filterArgs ...any) {
+		placeholders := []any{}
+		for _, arg := range filterArgs {
+			args = append(args, arg)
+			placeholders = append(placeholders, len(args))
+		}
+		where = append(where, fmt.Sprintf(cond, placeholders...))
+	}
+
+	addFilter(visibleFormat, auth.Owner(ctx), auth.UserID(ctx))
+
+	if q.ProjID != "" {
+		projID, err := parseID(q.ProjID)
+		if err != nil {
+			return nil, "", err
+		}
+		addFilter("p.id = $%d", projID)
+	}
+	if q.Completed != nil {
+		addFilter("t.completed = $%d", *q.Completed)
+	}
+	if len(q.Priority) > 0 {
+		addFilter("t.priority = ANY($%d)", q.Priority)
+	}
+	if q.DueBefore != nil {
+		addFilter("t.duedate < $%d", *q.DueBefore)
+	}
+	if q.DueAfter != nil {
+		addFilter("t.duedate > $%d", *q.DueAfter)
+	}
+	if q.Name != "" {
+		addFilter("t.name ILIKE '%%' || $%d || '%%'", escapeLike(q.Name))
+	}
+	if len(q.Labels) > 0 {
+		labelIDs, err := parseIDs(q.Labels)
+		if err != nil {
+			return nil, "", err
+		}
+		if q.AllLabels {
+			addFilter("(SELECT count(*) FROM todo_labels l WHERE l.todo_id = t.id AND l.label_id = ANY($%d)) = $%d", labelIDs, len(labelIDs))
+		} else {
+			addFilter("EXISTS (SELECT 1 FROM todo_labels l WHERE l.todo_id = t.id AND l.label_id = ANY($%d))", labelIDs)
+		}
+	}
+
+	cursor, err := q.DecodeCursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	comparison := ">"
+	direction := "ASC"
+	if q.Desc {
+		comparison = "<"
+		direction = "DESC"
+	}
+
+	// the sort key is selected as text so that it can be put in the cursor as is
+	sortKey, sorted := sortKeys[q.SortBy]
+	sortKeyColumn := "''"
+	if sorted {
+		sortKeyColumn = sortKey.expr + "::text"
+	}
+
+	if cursor != nil {
+		cursorID, err := parseID(cursor.ID)
+		if err != nil {
+			return nil, "", err
+		}
+		if sorted {
+			addFilter(fmt.Sprintf("(%s, t.id) %s ($%%d::%s, $%%d)", sortKey.expr, comparison, sortKey.cast), cursor.Key, cursorID)
+		} else {
+			addFilter("t.id "+comparison+" $%d", cursorID)
+		}
+	}
+
+	stmt := `SELECT ` + todoColumns + `, ` + sortKeyColumn + ` FROM todos t JOIN projects p ON p.owner = t.owner AND p.projname = t.projname WHERE ` + strings.Join(where, " AND ")
+
+	if sorted {
+		stmt += fmt.Sprintf(" ORDER BY %s %s, t.id %s", sortKey.expr, direction, direction)
+	} else {
+		stmt += " ORDER BY t.id " + direction
+	}
+
+	// fetch one extra row to find out if there is a next page
+	if q.Limit > 0 {
+		stmt += fmt.Sprintf(" LIMIT %d", q.Limit+1)
+	}
+
+	rows, err := pg.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, "", wrapErr(err)
+	}
+	defer rows.Close()
+
+	todos := []models.TODO{}
+	keys := []string{}
+	for rows.Next() {
+		key := ""
+		todo, err := scanTodo(rows, &key)
+		if err != nil {
+			return nil, "", wrapErr(err)
+		}
+		todos = append(todos, todo)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", wrapErr(err)
+	}
+
+	next := ""
+	if q.Limit > 0 && len(todos) > q.Limit {
+		todos = todos[:q.Limit]
+		last := todos[q.Limit-1]
+		next = q.NextCursor(keys[q.Limit-1], strconv.Itoa(last.Id))
+	}
+	return todos, next, nil
+}
+
+// QueryProjs
+//
+// - projects are returned in id order
+// - pagination is keyset based on id
+//
+// returns the next cursor, or "" on the last page
+func (pg *PostGresStore) QueryProjs(ctx context.Context, page models.Page) ([]models.PROJECT, string, error) {
+	afterID := 0
+	if page.Cursor != "" {
+		cursor, err := models.DecodeCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		afterID, err = parseID(cursor.ID)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	stmt := `SELECT id, projname, version FROM projects p WHERE id > $1 AND ` + visible(2, 3) + ` AND deleted_at IS NULL ORDER BY id`
+	if page.Limit > 0 {
+		stmt += fmt.Sprintf(" LIMIT %d", page.Limit+1)
+	}
+
+	rows, err := pg.DB.Query(stmt, afterID, auth.Owner(ctx), auth.UserID(ctx))
+	if err != nil {
+		return nil, "", wrapErr(err)
+	}
+	defer rows.Close()
+
+	projects := []models.PROJECT{}
+	for rows.Next() {
+		project := models.PROJECT{}
+		err := rows.Scan(&project.Id, &project.ProjName, &project.Version)
+		if err != nil {
+			return nil, "", wrapErr(err)
+		}
+		projects = append(projects, project)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", wrapErr(err)
+	}
+
+	next := ""
+	if page.Limit > 0 && len(projects) > page.Limit {
+		projects = projects[:page.Limit]
+		next = models.EncodeCursor(models.Cursor{ID: strconv.Itoa(projects[page.Limit-1].Id)})
+	}
+
+	err = pg.attachTasks(projects)
+	if err != nil {
+		return nil, "", err
+	}
+	return projects, next, nil
+}
+
+func (pg *PostGresStore) CreateProj(ctx context.Context, Name string, Tasks []models.TODO) (string, error) {
+	stmt := `insert into projects (projname, owner) values ($1, $2) returning id;`
 
 	var id int
 
-	err := pg.DB.QueryRow(stmt, Name).Scan(&id)
+	err := pg.withTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow(stmt, Name, auth.Owner(ctx)).Scan(&id)
+		if err != nil {
+			return wrapErr(err)
+		}
+		return addProjHistory(ctx, tx, models.OpCreate, id, models.PROJECT{})
+	})
 	if err != nil {
 		return "", err
 	}
@@ -135,30 +459,53 @@ func (pg *PostGresStore) CreateProj(Name string, Tasks []models.TODO) (string, e
 	return stringID, nil
 }
 
-func (pg *PostGresStore) CreateTodo(projID string, newTodoWithoutID models.TODO) (string, error) {
+// CreateTodo
+//
+// - returns errs.ErrValidation if any of the todo's labels does not exist
+func (pg *PostGresStore) CreateTodo(ctx context.Context, projID string, newTodoWithoutID models.TODO) (string, error) {
+	insertedID := ""
+	err := pg.withTx(func(tx *sql.Tx) (err error) {
+		insertedID, err = createTodo(ctx, tx, projID, newTodoWithoutID)
+		return err
+	})
+	return insertedID, err
+}
+
+func createTodo(ctx context.Context, q querier, projID string, newTodoWithoutID models.TODO) (string, error) {
 	// first run a query to get the projname from the projID
-	intProjID, err := strconv.Atoi(projID)
+	intProjID, err := parseID(projID)
 	if err != nil {
 		return "", err
 	}
 
-	projName := ""
-
-	row := pg.DB.QueryRow(`select projname from projects where id = $1`, intProjID)
-
-	err = row.Scan(&projName)
+	owner := auth.Owner(ctx)
+	projName, lastRank, err := projNameAndLastRank(q, owner, intProjID)
 	if err != nil {
 		return "", err
 	}
 
 	// server method handleCreateTodo needs to handle empty inputs!
-	stmt := `INSERT INTO todos (name, description, duedate, priority, completed, projname) VALUES($1, $2, $3, $4, $5, $6) RETURNING id;`
+	// new todos go to the end of the project
+	stmt := `INSERT INTO todos (name, description, duedate, priority, completed, projname, rank, recurrence, occurrence, owner) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id;`
 
-	row = pg.DB.QueryRow(stmt, newTodoWithoutID.Name, newTodoWithoutID.Description, newTodoWithoutID.DueDate, newTodoWithoutID.Priority, newTodoWithoutID.Completed, projName)
+	row := q.QueryRow(stmt, newTodoWithoutID.Name, newTodoWithoutID.Description, newTodoWithoutID.DueDate, newTodoWithoutID.Priority, newTodoWithoutID.Completed, projName, rank.After(lastRank), newTodoWithoutID.Recurrence, newTodoWithoutID.Occurrence, owner)
 
 	var insertedID int
 
 	err = row.Scan(&insertedID)
+	if err != nil {
+		return "", wrapErr(err)
+	}
+
+	err = setLabels(q, owner, insertedID, newTodoWithoutID.Labels)
+	if err != nil {
+		return "", err
+	}
+	err = setReminders(q, insertedID, newTodoWithoutID.Reminders)
+	if err != nil {
+		return "", err
+	}
+	err = addTodoHistory(ctx, q, models.OpCreate, insertedID, models.TODO{})
 	if err != nil {
 		return "", err
 	}
@@ -166,73 +513,480 @@ func (pg *PostGresStore) CreateTodo(projID string, newTodoWithoutID models.TODO)
 	return stringID, nil
 }
 
-func (pg *PostGresStore) UpdateProjNameByID(ID, newName string) error {
-	stmt := `UPDATE projects SET projname = $1 WHERE id = $2;`
+// projNameAndLastRank returns the name of the project and the highest rank of its todos
+//
+// - returns errs.ErrNotFound if the project is in the trash or not owned by owner
+func projNameAndLastRank(q querier, owner string, projID int) (string, string, error) {
+	stmt := `SELECT p.projname, COALESCE((SELECT max(t.rank COLLATE "C") FROM todos t WHERE t.owner = p.owner AND t.projname = p.projname), '') FROM projects p WHERE p.id = $1 AND p.owner = $2 AND p.deleted_at IS NULL`
 
-	intID, err := strconv.Atoi(ID)
+	projName, lastRank := "", ""
+	err := q.QueryRow(stmt, projID, owner).Scan(&projName, &lastRank)
 	if err != nil {
-		return err
+		return "", "", wrapErr(err)
 	}
+	return projName, lastRank, nil
+}
 
-	_, err = pg.DB.Exec(stmt, newName, intID)
+// UpdateProjNameByID
+//
+// - only renames the project while it is at version, 0 matches any version
+// - returns errs.ErrPreconditionFailed if the project is at another version
+func (pg *PostGresStore) UpdateProjNameByID(ctx context.Context, ID, newName string, version int) error {
+	stmt := `UPDATE projects SET projname = $1, version = version + 1 WHERE id = $2 AND ($3 = 0 OR version = $3) AND owner = $4 AND deleted_at IS NULL;`
+
+	intID, err := parseID(ID)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	owner := auth.Owner(ctx)
+	return pg.withTx(func(tx *sql.Tx) error {
+		return recordProj(ctx, tx, models.OpUpdate, intID, func() error {
+			result, err := tx.Exec(stmt, newName, intID, version, owner)
+			if err != nil {
+				return wrapErr(err)
+			}
+
+			_, err = checkVersionRowsAffected(tx, result, "projects", owner, intID, version)
+			return err
+		})
+	})
+}
+
+// UpdateTodoByID
+//
+// - projname is only changed when newTodoWithoutID.ProjName is not empty
+// - labels are only changed when newTodoWithoutID.Labels is not nil
+// - reminders are always replaced
+// - only updates the todo while it is at newTodoWithoutID.Version, 0 matches any version
+// - returns errs.ErrPreconditionFailed if the todo is at another version
+func (pg *PostGresStore) UpdateTodoByID(ctx context.Context, todoID string, newTodoWithoutID models.TODO) error {
+	return pg.withTx(func(tx *sql.Tx) error {
+		return updateTodo(ctx, tx, todoID, newTodoWithoutID)
+	})
 }
 
-func (pg *PostGresStore) UpdateTodoByID(todoID string, newTodoWithoutID models.TODO) error {
-	stmt := `UPDATE todos SET name = $1, description = $2, duedate = $3, priority = $4, completed = $5, projname = $6 WHERE id = $7`
+func updateTodo(ctx context.Context, q querier, todoID string, newTodoWithoutID models.TODO) error {
+	stmt := `UPDATE todos SET name = $1, description = $2, duedate = $3, priority = $4, completed = $5, projname = COALESCE(NULLIF($6, ''), projname), recurrence = $7, occurrence = $8, version = version + 1 WHERE id = $9 AND ($10 = 0 OR version = $10) AND owner = $11 AND deleted_at IS NULL`
 
-	_, err := pg.DB.Exec(stmt, newTodoWithoutID.Name, newTodoWithoutID.Description, newTodoWithoutID.DueDate, newTodoWithoutID.Priority, newTodoWithoutID.Completed, newTodoWithoutID.ProjName, todoID)
+	intID, err := parseID(todoID)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	owner := auth.Owner(ctx)
+	return recordTodo(ctx, q, models.OpUpdate, intID, func() error {
+		result, err := q.Exec(stmt, newTodoWithoutID.Name, newTodoWithoutID.Description, newTodoWithoutID.DueDate, newTodoWithoutID.Priority, newTodoWithoutID.Completed, newTodoWithoutID.ProjName, newTodoWithoutID.Recurrence, newTodoWithoutID.Occurrence, intID, newTodoWithoutID.Version, owner)
+		if err != nil {
+			return wrapErr(err)
+		}
+
+		_, err = checkVersionRowsAffected(q, result, "todos", owner, intID, newTodoWithoutID.Version)
+		if err != nil {
+			return err
+		}
+		err = setLabels(q, owner, intID, newTodoWithoutID.Labels)
+		if err != nil {
+			return err
+		}
+		return setReminders(q, intID, newTodoWithoutID.Reminders)
+	})
 }
 
-func (pg *PostGresStore) DeleteProjByID(projID string) (int, error) {
-	stmt := `DELETE FROM projects WHERE id = $1`
+// PatchTodo
+//
+// - only the columns of the fields are written, labels and reminders are only replaced when they are in fields
+// - updated_at is set to the time of the patch
+// - only patches the todo while it is at todo.Version, 0 matches any version
+// - returns errs.ErrPreconditionFailed if the todo is at another version
+// - returns errs.ErrValidation for a field that cannot be patched, see the models.Field constants
+func (pg *PostGresStore) PatchTodo(ctx context.Context, todoID string, todo models.TODO, fields []string) error {
+	intID, err := parseID(todoID)
+	if err != nil {
+		return err
+	}
 
-	intProjID, err := strconv.Atoi(projID)
+	sets := []string{"updated_at = now()", "version = version + 1"}
+	args := []any{}
+	for _, field := range fields {
+		if field == models.FieldLabels || field == models.FieldReminders {
+			continue
+		}
+		column, value, err := patchColumn(todo, field)
+		if err != nil {
+			return err
+		}
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	owner := auth.Owner(ctx)
+	args = append(args, intID, todo.Version, owner)
+	n := len(args)
+	stmt := fmt.Sprintf(`UPDATE todos SET %s WHERE id = $%d AND ($%d = 0 OR version = $%d) AND owner = $%d AND deleted_at IS NULL`,
+		strings.Join(sets, ", "), n-2, n-1, n-1, n)
+
+	return pg.withTx(func(tx *sql.Tx) error {
+		return recordTodo(ctx, tx, models.OpUpdate, intID, func() error {
+			result, err := tx.Exec(stmt, args...)
+			if err != nil {
+				return wrapErr(err)
+			}
+
+			_, err = checkVersionRowsAffected(tx, result, "todos", owner, intID, todo.Version)
+			if err != nil {
+				return err
+			}
+			if slices.Contains(fields, models.FieldLabels) {
+				// an empty list clears the labels, nil would leave them as they are
+				labels := todo.Labels
+				if labels == nil {
+					labels = []string{}
+				}
+				err = setLabels(tx, owner, intID, labels)
+				if err != nil {
+					return err
+				}
+			}
+			if slices.Contains(fields, models.FieldReminders) {
+				return setReminders(tx, intID, todo.Reminders)
+			}
+			return nil
+		})
+	})
+}
+
+// patchColumn is the column of the todos table PatchTodo writes for field and its value
+func patchColumn(todo models.TODO, field string) (string, any, error) {
+	switch field {
+	case models.FieldName:
+		return "name", todo.Name, nil
+	case models.FieldDescription:
+		return "description", todo.Description, nil
+	case models.FieldDueDate:
+		return "duedate", todo.DueDate, nil
+	case models.FieldPriority:
+		return "priority", todo.Priority, nil
+	case models.FieldCompleted:
+		return "completed", todo.Completed, nil
+	case models.FieldRecurrence:
+		return "recurrence", todo.Recurrence, nil
+	case models.FieldOccurrence:
+		return "occurrence", todo.Occurrence, nil
+	}
+	return "", nil, fmt.Errorf("%w: %q cannot be patched", errs.ErrValidation, field)
+}
+
+// DeleteProjByID
+//
+// - moves the project and its todos to the trash, they share the deleted_at
+// so that RestoreProj knows which todos to bring back
+// - the project gives up its name while it is in the trash, see trashedProjName
+// - only deletes the project while it is at version, 0 matches any version
+// - returns errs.ErrPreconditionFailed if the project is at another version
+func (pg *PostGresStore) DeleteProjByID(ctx context.Context, projID string, version int) (int, error) {
+	stmt := `UPDATE projects SET deleted_at = now(), version = version + 1, trashed_name = projname, projname = ` + trashedProjName + `
+    WHERE id = $1 AND ($2 = 0 OR version = $2) AND owner = $3 AND deleted_at IS NULL`
+
+	intProjID, err := parseID(projID)
 	if err != nil {
 		return 0, err
 	}
 
-	result, err := pg.DB.Exec(stmt, intProjID)
+	owner := auth.Owner(ctx)
+	deletedCount := 0
+	err = pg.withTx(func(tx *sql.Tx) error {
+		return recordProj(ctx, tx, models.OpDelete, intProjID, func() error {
+			result, err := tx.Exec(stmt, intProjID, version, owner)
+			if err != nil {
+				return wrapErr(err)
+			}
+			deletedCount, err = checkVersionRowsAffected(tx, result, "projects", owner, intProjID, version)
+			if err != nil {
+				return err
+			}
+
+			// now() is the start of the transaction, so the todos get the project's deleted_at
+			_, err = tx.Exec(`UPDATE todos t SET deleted_at = p.deleted_at, version = t.version + 1 FROM projects p
+            WHERE p.id = $1 AND t.owner = p.owner AND t.projname = p.projname AND t.deleted_at IS NULL`, intProjID)
+			return wrapErr(err)
+		})
+	})
+	return deletedCount, err
+}
+
+// trashedProjName is the name a project gives up its own for while it is in the trash,
+// it starts with a control character that a project name is not expected to have
+// and is unique as it holds the id. the todos follow it through ON UPDATE CASCADE
+const trashedProjName = `chr(31) || 'trashed-' || id`
+
+// DeleteTodoByID
+//
+// - moves the todo to the trash
+// - only deletes the todo while it is at version, 0 matches any version
+// - returns errs.ErrPreconditionFailed if the todo is at another version
+func (pg *PostGresStore) DeleteTodoByID(ctx context.Context, todoID string, version int) (int, error) {
+	deletedCount := 0
+	err := pg.withTx(func(tx *sql.Tx) (err error) {
+		deletedCount, err = deleteTodo(ctx, tx, todoID, version)
+		return err
+	})
+	return deletedCount, err
+}
+
+func deleteTodo(ctx context.Context, q querier, todoID string, version int) (int, error) {
+	stmt := `UPDATE todos SET deleted_at = now(), version = version + 1 WHERE id = $1 AND ($2 = 0 OR version = $2) AND owner = $3 AND deleted_at IS NULL`
+
+	intTodoID, err := parseID(todoID)
 	if err != nil {
 		return 0, err
 	}
 
-	deletedCount, err := result.RowsAffected()
+	owner := auth.Owner(ctx)
+	deletedCount := 0
+	err = recordTodo(ctx, q, models.OpDelete, intTodoID, func() error {
+		result, err := q.Exec(stmt, intTodoID, version, owner)
+		if err != nil {
+			return wrapErr(err)
+		}
+
+		deletedCount, err = checkVersionRowsAffected(q, result, "todos", owner, intTodoID, version)
+		return err
+	})
+	return deletedCount, err
+}
+
+// MoveTodo
+//
+// - todos belong to a project through their projname, so that and the rank are the only columns that change
+// - the todo goes to the end of the project
+// - returns errs.ErrNotFound if either the todo or the project does not exist or is in the trash
+func (pg *PostGresStore) MoveTodo(ctx context.Context, todoID, projID string) error {
+	stmt := `UPDATE todos t SET projname = p.projname, rank = $3, version = t.version + 1 FROM projects p
+    WHERE p.id = $1 AND t.id = $2 AND p.owner = $4 AND t.owner = p.owner AND p.deleted_at IS NULL AND ` + liveTodo
+
+	intTodoID, err := parseID(todoID)
 	if err != nil {
-		return 0, err
+		return err
+	}
+	intProjID, err := parseID(projID)
+	if err != nil {
+		return err
 	}
 
-	return int(deletedCount), nil
+	owner := auth.Owner(ctx)
+	return pg.withTx(func(tx *sql.Tx) error {
+		return recordTodo(ctx, tx, models.OpMove, intTodoID, func() error {
+			_, lastRank, err := projNameAndLastRank(tx, owner, intProjID)
+			if err != nil {
+				return err
+			}
+
+			result, err := tx.Exec(stmt, intProjID, intTodoID, rank.After(lastRank), owner)
+			if err != nil {
+				return wrapErr(err)
+			}
+
+			_, err = checkRowsAffected(result)
+			return err
+		})
+	})
 }
 
-func (pg *PostGresStore) DeleteTodoByID(todoID string) (int, error) {
-	stmt := `DELETE FROM todos WHERE id = $1`
+// ReorderTodo
+//
+// - the ranks of the project are loaded, locked, and handed to rank.Move
+// - normally only the moved todo is updated, every todo of the project is
+// updated when the ranks have to be spread out again
+// - only the moved todo gets a history entry
+// - returns errs.ErrValidation if the todos are in different projects
+func (pg *PostGresStore) ReorderTodo(ctx context.Context, todoID string, place models.Placement) error {
+	anchorID, after := place.Before, false
+	if place.After != "" {
+		anchorID, after = place.After, true
+	}
 
-	intTodoID, err := strconv.Atoi(todoID)
+	intTodoID, err := parseID(todoID)
 	if err != nil {
-		return 0, err
+		return err
+	}
+	intAnchorID, err := parseID(anchorID)
+	if err != nil {
+		return err
+	}
+
+	return pg.withTx(func(tx *sql.Tx) error {
+		return recordTodo(ctx, tx, models.OpReorder, intTodoID, func() error {
+			return reorderTodo(tx, auth.Owner(ctx), intTodoID, intAnchorID, after)
+		})
+	})
+}
+
+// reorderTodo moves the todo todoID next to the todo anchorID, see ReorderTodo
+func reorderTodo(tx *sql.Tx, owner string, todoID, anchorID int, after bool) error {
+	stmt := `SELECT t.id, t.rank FROM todos t WHERE t.owner = $2 AND t.projname = (SELECT projname FROM todos WHERE id = $1 AND owner = $2) AND ` + liveTodo + ` ORDER BY ` + rankOrder + ` FOR UPDATE`
+
+	IDs, keys, err := loadRanks(tx, stmt, todoID, owner)
+	if err != nil {
+		return err
+	}
+
+	from := slices.Index(IDs, todoID)
+	if from == -1 {
+		return fmt.Errorf("%w: todo %d", errs.ErrNotFound, todoID)
+	}
+	anchor := slices.Index(IDs, anchorID)
+	if anchor == -1 {
+		exists := false
+		err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM todos WHERE id = $1 AND owner = $2 AND deleted_at IS NULL)`, anchorID, owner).Scan(&exists)
+		if err != nil {
+			return wrapErr(err)
+		}
+		if !exists {
+			return fmt.Errorf("%w: todo %d", errs.ErrNotFound, anchorID)
+		}
+		return fmt.Errorf("%w: todo %d is in another project", errs.ErrValidation, anchorID)
+	}
+
+	changed := rank.Move(keys, from, anchor, after)
+	err = saveRanks(tx, "todos", IDs, changed)
+	if err != nil {
+		return err
+	}
+
+	moved := []int{}
+	for i := range changed {
+		moved = append(moved, IDs[i])
+	}
+	return bumpVersion(tx, moved...)
+}
+
+// loadRanks runs stmt, which selects the id and rank of each row in order
+func loadRanks(tx *sql.Tx, stmt string, args ...any) ([]int, []string, error) {
+	rows, err := tx.Query(stmt, args...)
+	if err != nil {
+		return nil, nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	IDs := []int{}
+	keys := []string{}
+	for rows.Next() {
+		ID, key := 0, ""
+		err := rows.Scan(&ID, &key)
+		if err != nil {
+			return nil, nil, wrapErr(err)
+		}
+		IDs = append(IDs, ID)
+		keys = append(keys, key)
+	}
+	return IDs, keys, wrapErr(rows.Err())
+}
+
+// withTx runs f inside a transaction that is committed if f returns nil
+func (pg *PostGresStore) withTx(f func(tx *sql.Tx) error) error {
+	tx, err := pg.DB.Begin()
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer tx.Rollback()
+
+	err = f(tx)
+	if err != nil {
+		return err
+	}
+	return wrapErr(tx.Commit())
+}
+
+// bumpVersion increments the version of the todos in IDs, for writes that
+// change a todo through one of its other tables or only change its rank
+//
+// - returns errs.ErrNotFound if any of the todos is missing or in the trash,
+// so that the caller's transaction is rolled back
+func bumpVersion(q querier, IDs ...int) error {
+	result, err := q.Exec(`UPDATE todos SET version = version + 1 WHERE id = ANY($1) AND deleted_at IS NULL`, IDs)
+	if err != nil {
+		return wrapErr(err)
+	}
+	rowsAffected, err := checkRowsAffected(result)
+	if err != nil {
+		return err
+	}
+	if rowsAffected != len(IDs) {
+		return errs.ErrNotFound
+	}
+	return nil
+}
+
+// saveRanks writes the ranks returned by rank.Move back to table
+func saveRanks(tx *sql.Tx, table string, IDs []int, changed map[int]string) error {
+	for i, key := range changed {
+		_, err := tx.Exec(`UPDATE `+table+` SET rank = $1 WHERE id = $2`, key, IDs[i])
+		if err != nil {
+			return wrapErr(err)
+		}
 	}
+	return nil
+}
 
-	result, err := pg.DB.Exec(stmt, intTodoID)
+// BatchTodos
+//
+// - every operation runs inside a single transaction
+// - atomic: the first failure rolls the transaction back, the other
+// operations are reported as errs.ErrAborted
+// - otherwise each operation runs inside its own savepoint so a failure
+// only rolls back that operation
+func (pg *PostGresStore) BatchTodos(ctx context.Context, ops []models.BatchOp, atomic bool) ([]models.BatchResult, error) {
+	tx, err := pg.DB.Begin()
 	if err != nil {
-		return 0, nil
+		return nil, wrapErr(err)
 	}
+	defer tx.Rollback()
+
+	results := make([]models.BatchResult, len(ops))
+
+	for i, op := range ops {
+		if !atomic {
+			_, err = tx.Exec(`SAVEPOINT batch_op`)
+			if err != nil {
+				return nil, wrapErr(err)
+			}
+		}
 
-	deleteCount, err := result.RowsAffected()
+		results[i] = applyBatchOp(ctx, tx, op)
+
+		switch {
+		case results[i].Err != nil && atomic:
+			return models.AbortBatch(ops, results, i), nil
+		case results[i].Err != nil:
+			_, err = tx.Exec(`ROLLBACK TO SAVEPOINT batch_op`)
+		case !atomic:
+			_, err = tx.Exec(`RELEASE SAVEPOINT batch_op`)
+		}
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+	}
+
+	err = tx.Commit()
 	if err != nil {
-		return 0, nil
+		return nil, wrapErr(err)
 	}
+	return results, nil
+}
 
-	return int(deleteCount), nil
+func applyBatchOp(ctx context.Context, q querier, op models.BatchOp) models.BatchResult {
+	switch op.Op {
+	case models.BatchCreate:
+		ID, err := createTodo(ctx, q, op.ProjID, op.Todo)
+		return models.BatchResult{ID: ID, Err: err}
+	case models.BatchUpdate:
+		return models.BatchResult{ID: op.ID, Err: updateTodo(ctx, q, op.ID, op.Todo)}
+	case models.BatchDelete:
+		_, err := deleteTodo(ctx, q, op.ID, op.Todo.Version)
+		return models.BatchResult{ID: op.ID, Err: err}
+	}
+	return models.BatchResult{Err: fmt.Errorf("%w: unknown op %q", errs.ErrValidation, op.Op)}
 }
 
 /*
@@ -251,3 +1005,75 @@ func (pg *PostGresStore) DeleteTodoByID(todoID string) (int, error) {
 		GetTodoByID(todoID string) (models.TODO, error)
 	}
 */
+
+// Search
+//
+// - todos and projects are matched against their generated tsvector columns
+// with websearch_to_tsquery, so quoted phrases and -exclusions work
+// - hits from both tables are merged and ordered by ts_rank
+func (pg *PostGresStore) Search(ctx context.Context, query string, limit int) ([]models.SearchHit, error) {
+	hits := []models.SearchHit{}
+	owner, userID := auth.Owner(ctx), auth.UserID(ctx)
+
+	todoStmt := `SELECT ` + todoColumns + `, ts_rank(t.search, query) AS score
+    FROM todos t JOIN projects p ON p.owner = t.owner AND p.projname = t.projname, websearch_to_tsquery('english', $1) query
+    WHERE t.search @@ query AND ` + visible(3, 4) + ` AND ` + liveTodo + `
+    ORDER BY score DESC, t.id
+    LIMIT $2`
+
+	rows, err := pg.DB.Query(todoStmt, query, limit, owner, userID)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		score := 0.0
+		todo, err := scanTodo(rows, &score)
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+		projID, _ := strconv.Atoi(todo.ProjID)
+		hits = append(hits, models.SearchHit{
+			Kind:    models.SearchHitTodo,
+			Score:   score,
+			Todo:    &todo,
+			Project: models.PROJECT{Id: projID, ProjName: todo.ProjName},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+
+	projStmt := `SELECT p.id, p.projname, p.version, ts_rank(p.search, query) AS score
+    FROM projects p, websearch_to_tsquery('english', $1) query
+    WHERE p.search @@ query AND ` + visible(3, 4) + ` AND p.deleted_at IS NULL
+    ORDER BY score DESC, p.id
+    LIMIT $2`
+
+	projRows, err := pg.DB.Query(projStmt, query, limit, owner, userID)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer projRows.Close()
+
+	for projRows.Next() {
+		hit := models.SearchHit{Kind: models.SearchHitProject}
+		err := projRows.Scan(&hit.Project.Id, &hit.Project.ProjName, &hit.Project.Version, &hit.Score)
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+		hits = append(hits, hit)
+	}
+	if err := projRows.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+
+	slices.SortStableFunc(hits, func(a, b models.SearchHit) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
@@ -1,6 +1,8 @@
 package postgres_store
 
 import (
+	"context"
+	"encoding/json"
 	"log"
 	"os"
 	"strconv"
@@ -10,6 +12,9 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/suite"
 
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/history"
 	"github.com/ganglinwu/todoapp-backend-v1/models"
 )
 
@@ -109,42 +114,24 @@ func (ts *TestSuite) SetupSuite() {
 // This runs before EVERY test
 func (ts *TestSuite) SetupTest() {
 	// clear DB
-	_, err := ts.store.DB.Exec(`truncate table todos;`)
+	_, err := ts.store.DB.Exec(`drop table if exists todo_items, todo_labels, labels, todo_reminders, webhook_deliveries, webhooks, idempotency_keys, history, refresh_tokens, personal_tokens, project_collaborators, users;`)
 	if err != nil {
-		log.Fatal("exec 1:", err.Error())
+		log.Fatal("exec 0:", err.Error())
 	}
 
-	_, err = ts.store.DB.Exec(`drop table todos;`)
+	_, err = ts.store.DB.Exec(`drop table if exists todos cascade;`)
 	if err != nil {
-		log.Fatal("exec 2:", err.Error())
+		log.Fatal("exec 1:", err.Error())
 	}
 
-	_, err = ts.store.DB.Exec(`drop table projects;`)
+	_, err = ts.store.DB.Exec(`drop table if exists projects cascade;`)
 	if err != nil {
 		log.Fatal("exec 2:", err.Error())
 	}
 
-	_, err = ts.store.DB.Exec(`create table if not exists projects (
-    id SERIAL PRIMARY KEY,
-    projname VARCHAR(255) NOT NULL UNIQUE
-    );`)
-	if err != nil {
-		log.Fatal("exec 3:", err.Error())
-	}
-
-	_, err = ts.store.DB.Exec(`create table if not exists todos (
-    id SERIAL PRIMARY KEY, 
-    name VARCHAR(255) NOT NULL,
-    description VARCHAR(255) NOT NULL,
-    duedate TIMESTAMPTZ NOT NULL,
-    priority VARCHAR(10) NOT NULL,
-    completed BOOLEAN NOT NULL,
-    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
-    projname VARCHAR(255) NOT NULL,
-    FOREIGN KEY (projname) REFERENCES projects(projname) ON UPDATE CASCADE ON DELETE CASCADE
-    );`)
+	err = ts.store.Migrate()
 	if err != nil {
-		log.Fatal("exec 4:", err.Error())
+		log.Fatal("migrate:", err.Error())
 	}
 
 	ts.store.DB.Exec(`INSERT INTO projects (projname) VALUES ($1)`, proj1.ProjName)
@@ -179,7 +166,7 @@ func (ts *TestSuite) SetupTest() {
 }
 
 func (ts *TestSuite) TestGetAllProjs() {
-	got, err := ts.store.GetAllProjs()
+	got, err := ts.store.GetAllProjs(context.Background())
 	if err != nil {
 		ts.FailNowf("err on GetAllProjs: ", err.Error())
 	}
@@ -192,7 +179,7 @@ func (ts *TestSuite) TestGetAllProjs() {
 }
 
 func (ts *TestSuite) TestGetAllTodos() {
-	got, err := ts.store.GetAllTodos()
+	got, err := ts.store.GetAllTodos(context.Background())
 	if err != nil {
 		ts.FailNowf("err on GetAllTodos: ", err.Error())
 	}
@@ -204,7 +191,7 @@ func (ts *TestSuite) TestGetAllTodos() {
 }
 
 func (ts *TestSuite) TestGetProjByID() {
-	got, err := ts.store.GetProjByID("1")
+	got, err := ts.store.GetProjByID(context.Background(), "1")
 	if err != nil {
 		ts.FailNowf("err on GetProjByID: ", err.Error())
 	}
@@ -214,7 +201,7 @@ func (ts *TestSuite) TestGetProjByID() {
 }
 
 func (ts *TestSuite) TestGetTodoByID() {
-	got, err := ts.store.GetTodoByID("1")
+	got, err := ts.store.GetTodoByID(context.Background(), "1")
 	if err != nil {
 		ts.FailNowf("err on GetTodoByID: ", err.Error())
 	}
@@ -227,12 +214,12 @@ func (ts *TestSuite) TestCreateProj() {
 	// flush and reset table
 	ts.SetupTest()
 
-	insertedProjID, err := ts.store.CreateProj("proj3", []models.TODO{})
+	insertedProjID, err := ts.store.CreateProj(context.Background(), "proj3", []models.TODO{})
 	if err != nil {
 		ts.FailNowf("err on CreateProj: ", err.Error())
 	}
 
-	got, err := ts.store.GetProjByID(insertedProjID)
+	got, err := ts.store.GetProjByID(context.Background(), insertedProjID)
 	if err != nil {
 		ts.FailNowf("err on GetProjByID: ", err.Error())
 	}
@@ -261,13 +248,13 @@ func (ts *TestSuite) TestCreateTodo() {
 	}
 
 	// CreateTodo returns int, err
-	stringID, err := ts.store.CreateTodo("2", newTodo)
+	stringID, err := ts.store.CreateTodo(context.Background(), "2", newTodo)
 	if err != nil {
 		ts.FailNowf("err on CreateTodo ", err.Error())
 	}
 
 	// TODO: fetch specific todo using GetTodoByID
-	got, err := ts.store.GetAllTodos()
+	got, err := ts.store.GetAllTodos(context.Background())
 	if err != nil {
 		ts.FailNowf("err on GetAllTodos ", err.Error())
 	}
@@ -286,12 +273,12 @@ func (ts *TestSuite) TestCreateTodo() {
 }
 
 func (ts *TestSuite) TestUpdateProjNameByID() {
-	err := ts.store.UpdateProjNameByID("1", "New proj1")
+	err := ts.store.UpdateProjNameByID(context.Background(), "1", "New proj1", 0)
 	if err != nil {
 		ts.FailNowf("err on UpdateProjNameByID ", err.Error())
 	}
 
-	got, err := ts.store.GetProjByID("1")
+	got, err := ts.store.GetProjByID(context.Background(), "1")
 	if err != nil {
 		ts.FailNowf("err on GetProjByID ", err.Error())
 	}
@@ -316,14 +303,14 @@ func (ts *TestSuite) TestUpdateTodoByID() {
 		ProjName:    "proj2",
 	}
 
-	err := ts.store.UpdateTodoByID("1", todoToUpdate)
+	err := ts.store.UpdateTodoByID(context.Background(), "1", todoToUpdate)
 	if err != nil {
 		ts.FailNowf("err on UpdateTodoByID ", err.Error())
 	}
 
 	// TODO: compare specific todo instead of all
 	// especially postgres does sequential writes, thus the "order" of todos will not be the same as the index(id) number
-	got, err := ts.store.GetAllTodos()
+	got, err := ts.store.GetAllTodos(context.Background())
 	if err != nil {
 		ts.FailNowf("err on GetAllTodos ", err.Error())
 	}
@@ -337,15 +324,59 @@ func (ts *TestSuite) TestUpdateTodoByID() {
 	}
 }
 
+func (ts *TestSuite) TestPatchTodo() {
+	ctx := context.Background()
+	todoID := "1"
+
+	current, err := ts.store.GetTodoByID(ctx, todoID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+
+	// only the fields named are written, an empty one is cleared
+	patch := models.TODO{Name: "not written", Completed: true, Version: current.Version}
+	err = ts.store.PatchTodo(ctx, todoID, patch, []string{models.FieldDescription, models.FieldCompleted})
+	if err != nil {
+		ts.FailNowf("err on PatchTodo: ", err.Error())
+	}
+
+	got, err := ts.store.GetTodoByID(ctx, todoID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Equal(current.Name, got.Name)
+	ts.Equal("", got.Description)
+	ts.True(got.Completed)
+	ts.NotNil(got.DueDate)
+	ts.Equal(current.Version+1, got.Version)
+
+	err = ts.store.PatchTodo(ctx, todoID, patch, []string{models.FieldCompleted})
+	ts.ErrorIs(err, errs.ErrPreconditionFailed)
+	err = ts.store.PatchTodo(ctx, todoID, models.TODO{}, []string{"rank"})
+	ts.ErrorIs(err, errs.ErrValidation)
+
+	// a due date that is nil is cleared
+	err = ts.store.PatchTodo(ctx, todoID, models.TODO{Version: got.Version}, []string{models.FieldDueDate})
+	if err != nil {
+		ts.FailNowf("err on PatchTodo: ", err.Error())
+	}
+	got, err = ts.store.GetTodoByID(ctx, todoID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Nil(got.DueDate)
+	ts.Equal(current.Name, got.Name)
+}
+
 func (ts *TestSuite) TestDeleteProjByID() {
-	deleteCount, err := ts.store.DeleteProjByID("1")
+	deleteCount, err := ts.store.DeleteProjByID(context.Background(), "1", 0)
 	if err != nil {
 		ts.FailNowf("err on DeleteProjByID ", err.Error())
 	}
 
 	ts.Equal(1, deleteCount, "want 1 got %d", deleteCount)
 
-	got, err := ts.store.GetAllProjs()
+	got, err := ts.store.GetAllProjs(context.Background())
 	if err != nil {
 		ts.FailNowf("err on GetAllProjs ", err.Error())
 	}
@@ -360,14 +391,14 @@ func (ts *TestSuite) TestDeleteProjByID() {
 }
 
 func (ts *TestSuite) TestDeleteTodoByID() {
-	deleteCount, err := ts.store.DeleteTodoByID("1")
+	deleteCount, err := ts.store.DeleteTodoByID(context.Background(), "1", 0)
 	if err != nil {
 		ts.FailNowf("err on DeleteTodoByID ", err.Error())
 	}
 
 	ts.Equal(1, deleteCount, "want 1 got %d", deleteCount)
 
-	got, err := ts.store.GetAllTodos()
+	got, err := ts.store.GetAllTodos(context.Background())
 	if err != nil {
 		ts.FailNowf("err on GetAllTodos ", err.Error())
 	}
@@ -379,6 +410,1149 @@ func (ts *TestSuite) TestDeleteTodoByID() {
 	}
 }
 
+func (ts *TestSuite) TestQueryTodos() {
+	completed := false
+	inAMonth := time.Now().AddDate(0, 1, 0)
+
+	queryTests := []struct {
+		testname string
+		query    models.TodoQuery
+		want     []models.TODO
+	}{
+		{"no filters", models.TodoQuery{}, []models.TODO{todo1, todo2, todo3}},
+		{"by project", models.TodoQuery{ProjID: "1"}, []models.TODO{todo1, todo2}},
+		{"by priority", models.TodoQuery{Priority: []string{"mid", "hi"}}, []models.TODO{todo2, todo3}},
+		{"not completed", models.TodoQuery{Completed: &completed}, []models.TODO{todo1, todo2, todo3}},
+		{"due before", models.TodoQuery{DueBefore: &inAMonth}, []models.TODO{todo2, todo3}},
+		{"due after", models.TodoQuery{DueAfter: &inAMonth}, []models.TODO{todo1}},
+		{"name substring", models.TodoQuery{Name: "SOCK"}, []models.TODO{todo2}},
+		{"sort by priority desc", models.TodoQuery{SortBy: models.SortByPriority, Desc: true}, []models.TODO{todo3, todo2, todo1}},
+		{"sort by due date", models.TodoQuery{SortBy: models.SortByDueDate}, []models.TODO{todo2, todo3, todo1}},
+	}
+
+	for _, test := range queryTests {
+		ts.Run(test.testname, func() {
+			got, _, err := ts.store.QueryTodos(context.Background(), test.query)
+			if err != nil {
+				ts.FailNowf("err on QueryTodos: ", err.Error())
+			}
+
+			ts.Require().Len(got, len(test.want))
+			for i := range test.want {
+				ts.compareTodoStructFields(test.want[i], got[i])
+			}
+		})
+	}
+}
+
+func (ts *TestSuite) TestQueryTodosPagination() {
+	for _, q := range []models.TodoQuery{
+		{},
+		{SortBy: models.SortByDueDate},
+		{SortBy: models.SortByPriority, Desc: true},
+		{SortBy: models.SortByUpdatedAt},
+	} {
+		all, _, err := ts.store.QueryTodos(context.Background(), q)
+		if err != nil {
+			ts.FailNowf("err on QueryTodos: ", err.Error())
+		}
+
+		got := []models.TODO{}
+		q.Limit = 2
+		for {
+			page, next, err := ts.store.QueryTodos(context.Background(), q)
+			if err != nil {
+				ts.FailNowf("err on QueryTodos: ", err.Error())
+			}
+			got = append(got, page...)
+			if next == "" {
+				break
+			}
+			q.Cursor = next
+
+			// rows inserted before the cursor must not shift the next page
+			_, err = ts.store.CreateTodo(context.Background(), "1", models.TODO{Name: "inserted while paging", DueDate: &dueDate1, Priority: "low"})
+			if err != nil {
+				ts.FailNowf("err on CreateTodo: ", err.Error())
+			}
+		}
+
+		ts.Require().GreaterOrEqual(len(got), len(all))
+		for i := range all {
+			ts.compareTodoStructFields(all[i], got[i])
+		}
+
+		ts.SetupTest()
+	}
+}
+
+func (ts *TestSuite) TestQueryProjsPagination() {
+	got, next, err := ts.store.QueryProjs(context.Background(), models.Page{Limit: 1})
+	if err != nil {
+		ts.FailNowf("err on QueryProjs: ", err.Error())
+	}
+	ts.Require().Len(got, 1)
+	ts.compareProjStructFields(proj1, got[0])
+	ts.NotEmpty(next)
+
+	got, next, err = ts.store.QueryProjs(context.Background(), models.Page{Limit: 1, Cursor: next})
+	if err != nil {
+		ts.FailNowf("err on QueryProjs: ", err.Error())
+	}
+	ts.Require().Len(got, 1)
+	ts.compareProjStructFields(proj2, got[0])
+	ts.Empty(next)
+}
+
+func (ts *TestSuite) TestSearch() {
+	got, err := ts.store.Search(context.Background(), "socks", 10)
+	if err != nil {
+		ts.FailNowf("err on Search: ", err.Error())
+	}
+	ts.Require().Len(got, 1)
+	ts.Equal(models.SearchHitTodo, got[0].Kind)
+	ts.compareTodoStructFields(todo2, *got[0].Todo)
+	ts.compareProjStructFields(proj1, got[0].Project)
+
+	got, err = ts.store.Search(context.Background(), "proj2", 10)
+	if err != nil {
+		ts.FailNowf("err on Search: ", err.Error())
+	}
+	ts.Require().NotEmpty(got)
+	ts.Equal(models.SearchHitProject, got[0].Kind)
+	ts.compareProjStructFields(proj2, got[0].Project)
+
+	got, err = ts.store.Search(context.Background(), "nothing matches this", 10)
+	if err != nil {
+		ts.FailNowf("err on Search: ", err.Error())
+	}
+	ts.Empty(got)
+}
+
+func (ts *TestSuite) taskIDs(projID string) []int {
+	proj, err := ts.store.GetProjByID(context.Background(), projID)
+	if err != nil {
+		ts.FailNowf("err on GetProjByID: ", err.Error())
+	}
+	IDs := []int{}
+	for _, task := range proj.Tasks {
+		IDs = append(IDs, task.Id)
+	}
+	return IDs
+}
+
+func (ts *TestSuite) TestReorderTodo() {
+	// seeded todos have no rank yet and come back in id order
+	ts.Equal([]int{1, 2}, ts.taskIDs("1"))
+
+	_, err := ts.store.CreateTodo(context.Background(), "1", models.TODO{Name: "last", DueDate: &dueDate1})
+	if err != nil {
+		ts.FailNowf("err on CreateTodo: ", err.Error())
+	}
+	ts.Equal([]int{1, 2, 4}, ts.taskIDs("1"))
+
+	err = ts.store.ReorderTodo(context.Background(), "4", models.Placement{Before: "1"})
+	if err != nil {
+		ts.FailNowf("err on ReorderTodo: ", err.Error())
+	}
+	ts.Equal([]int{4, 1, 2}, ts.taskIDs("1"))
+
+	err = ts.store.ReorderTodo(context.Background(), "4", models.Placement{After: "1"})
+	if err != nil {
+		ts.FailNowf("err on ReorderTodo: ", err.Error())
+	}
+	ts.Equal([]int{1, 4, 2}, ts.taskIDs("1"))
+
+	todos, _, err := ts.store.QueryTodos(context.Background(), models.TodoQuery{ProjID: "1", SortBy: models.SortByRank, Page: models.Page{Limit: 2}})
+	if err != nil {
+		ts.FailNowf("err on QueryTodos: ", err.Error())
+	}
+	ts.Require().Len(todos, 2)
+	ts.Equal(1, todos[0].Id)
+	ts.Equal(4, todos[1].Id)
+
+	err = ts.store.ReorderTodo(context.Background(), "1", models.Placement{Before: "3"})
+	ts.ErrorIs(err, errs.ErrValidation)
+
+	err = ts.store.ReorderTodo(context.Background(), "1", models.Placement{Before: "999"})
+	ts.ErrorIs(err, errs.ErrNotFound)
+}
+
+func (ts *TestSuite) TestChecklistItems() {
+	itemIDs := []string{}
+	for _, name := range []string{"aloe vera", "fern"} {
+		itemID, err := ts.store.AddItem(context.Background(), "1", models.ChecklistItem{Name: name})
+		if err != nil {
+			ts.FailNowf("err on AddItem: ", err.Error())
+		}
+		itemIDs = append(itemIDs, itemID)
+	}
+
+	err := ts.store.UpdateItem(context.Background(), "1", itemIDs[0], models.ChecklistItem{Name: "aloe", Completed: true})
+	if err != nil {
+		ts.FailNowf("err on UpdateItem: ", err.Error())
+	}
+
+	err = ts.store.ReorderItem(context.Background(), "1", itemIDs[1], models.Placement{Before: itemIDs[0]})
+	if err != nil {
+		ts.FailNowf("err on ReorderItem: ", err.Error())
+	}
+
+	todo, err := ts.store.GetTodoByID(context.Background(), "1")
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Require().Len(todo.Items, 2)
+	ts.Equal("fern", todo.Items[0].Name)
+	ts.Equal("aloe", todo.Items[1].Name)
+	ts.True(todo.Items[1].Completed)
+	ts.Equal(50, *todo.Progress())
+
+	// items belong to their todo
+	err = ts.store.UpdateItem(context.Background(), "2", itemIDs[0], models.ChecklistItem{Name: "aloe"})
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	_, err = ts.store.AddItem(context.Background(), "999", models.ChecklistItem{Name: "nope"})
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	deletedCount, err := ts.store.DeleteItem(context.Background(), "1", itemIDs[1])
+	if err != nil {
+		ts.FailNowf("err on DeleteItem: ", err.Error())
+	}
+	ts.Equal(1, deletedCount)
+
+	// deleting the todo deletes its checklist
+	_, err = ts.store.DeleteTodoByID(context.Background(), "1", 0)
+	if err != nil {
+		ts.FailNowf("err on DeleteTodoByID: ", err.Error())
+	}
+	_, err = ts.store.DeleteItem(context.Background(), "1", itemIDs[0])
+	ts.ErrorIs(err, errs.ErrNotFound)
+}
+
+func (ts *TestSuite) TestLabels() {
+	urgent, err := ts.store.CreateLabel(context.Background(), models.Label{Name: "urgent", Color: "#ff0000"})
+	if err != nil {
+		ts.FailNowf("err on CreateLabel: ", err.Error())
+	}
+	home, err := ts.store.CreateLabel(context.Background(), models.Label{Name: "home"})
+	if err != nil {
+		ts.FailNowf("err on CreateLabel: ", err.Error())
+	}
+
+	_, err = ts.store.CreateLabel(context.Background(), models.Label{Name: "home"})
+	ts.ErrorIs(err, errs.ErrConflict)
+
+	err = ts.store.UpdateLabel(context.Background(), home, models.Label{Name: "house", Color: "#00ff00"})
+	if err != nil {
+		ts.FailNowf("err on UpdateLabel: ", err.Error())
+	}
+
+	labels, err := ts.store.GetAllLabels(context.Background())
+	if err != nil {
+		ts.FailNowf("err on GetAllLabels: ", err.Error())
+	}
+	ts.Equal([]models.Label{{ID: home, Name: "house", Color: "#00ff00"}, {ID: urgent, Name: "urgent", Color: "#ff0000"}}, labels)
+
+	todo, err := ts.store.GetTodoByID(context.Background(), "1")
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	todo.Labels = []string{urgent, home}
+	err = ts.store.UpdateTodoByID(context.Background(), "1", todo)
+	if err != nil {
+		ts.FailNowf("err on UpdateTodoByID: ", err.Error())
+	}
+
+	_, err = ts.store.CreateTodo(context.Background(), "1", models.TODO{Name: "fix sink", DueDate: &dueDate1, Labels: []string{home}})
+	if err != nil {
+		ts.FailNowf("err on CreateTodo: ", err.Error())
+	}
+
+	_, err = ts.store.CreateTodo(context.Background(), "1", models.TODO{Name: "nope", DueDate: &dueDate1, Labels: []string{"999"}})
+	ts.ErrorIs(err, errs.ErrValidation)
+
+	todos, _, err := ts.store.QueryTodos(context.Background(), models.TodoQuery{Labels: []string{urgent, home}})
+	if err != nil {
+		ts.FailNowf("err on QueryTodos: ", err.Error())
+	}
+	ts.Len(todos, 2)
+
+	todos, _, err = ts.store.QueryTodos(context.Background(), models.TodoQuery{Labels: []string{urgent, home}, AllLabels: true})
+	if err != nil {
+		ts.FailNowf("err on QueryTodos: ", err.Error())
+	}
+	ts.Require().Len(todos, 1)
+	ts.Equal(1, todos[0].Id)
+	ts.ElementsMatch([]string{urgent, home}, todos[0].Labels)
+
+	_, err = ts.store.DeleteLabel(context.Background(), urgent)
+	if err != nil {
+		ts.FailNowf("err on DeleteLabel: ", err.Error())
+	}
+	todo, err = ts.store.GetTodoByID(context.Background(), "1")
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Equal([]string{home}, todo.Labels)
+}
+
+func (ts *TestSuite) TestRecurrence() {
+	todoID, err := ts.store.CreateTodo(context.Background(), "1", models.TODO{Name: "pay rent", DueDate: &dueDate1, Recurrence: "FREQ=MONTHLY", Occurrence: 1})
+	if err != nil {
+		ts.FailNowf("err on CreateTodo: ", err.Error())
+	}
+
+	todo, err := ts.store.GetTodoByID(context.Background(), todoID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Equal("FREQ=MONTHLY", todo.Recurrence)
+	ts.Equal(1, todo.Occurrence)
+
+	todo.Recurrence, todo.Occurrence = "FREQ=MONTHLY;COUNT=12", 2
+	err = ts.store.UpdateTodoByID(context.Background(), todoID, todo)
+	if err != nil {
+		ts.FailNowf("err on UpdateTodoByID: ", err.Error())
+	}
+
+	todo, err = ts.store.GetTodoByID(context.Background(), todoID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Equal("FREQ=MONTHLY;COUNT=12", todo.Recurrence)
+	ts.Equal(2, todo.Occurrence)
+}
+
+func (ts *TestSuite) TestReminders() {
+	early, late := dueDate1.Add(-24*time.Hour), dueDate1.Add(-30*time.Minute)
+	todoID, err := ts.store.CreateTodo(context.Background(), "1", models.TODO{Name: "dentist", DueDate: &dueDate1, Reminders: []models.Reminder{
+		{Before: "24h0m0s", FireAt: &early},
+		{Before: "30m0s", FireAt: &late},
+	}})
+	if err != nil {
+		ts.FailNowf("err on CreateTodo: ", err.Error())
+	}
+
+	due, err := ts.store.DueReminders(early)
+	if err != nil {
+		ts.FailNowf("err on DueReminders: ", err.Error())
+	}
+	ts.Require().Len(due, 1)
+	ts.Equal(todoID, due[0].TodoID)
+	ts.Equal("dentist", due[0].Todo.Name)
+	ts.Equal("24h0m0s", due[0].Reminder.Before)
+
+	err = ts.store.MarkReminderSent(todoID, "24h0m0s", early)
+	if err != nil {
+		ts.FailNowf("err on MarkReminderSent: ", err.Error())
+	}
+	ts.ErrorIs(ts.store.MarkReminderSent(todoID, "24h0m0s", early), errs.ErrNotFound)
+
+	due, err = ts.store.DueReminders(late)
+	if err != nil {
+		ts.FailNowf("err on DueReminders: ", err.Error())
+	}
+	ts.Require().Len(due, 1)
+	ts.Equal("30m0s", due[0].Reminder.Before)
+
+	todo, err := ts.store.GetTodoByID(context.Background(), todoID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Require().Len(todo.Reminders, 2)
+	ts.NotNil(todo.Reminders[0].SentAt)
+	ts.Nil(todo.Reminders[1].SentAt)
+
+	// completed todos have no due reminders
+	todo.Completed = true
+	err = ts.store.UpdateTodoByID(context.Background(), todoID, todo)
+	if err != nil {
+		ts.FailNowf("err on UpdateTodoByID: ", err.Error())
+	}
+	due, err = ts.store.DueReminders(late)
+	if err != nil {
+		ts.FailNowf("err on DueReminders: ", err.Error())
+	}
+	ts.Empty(due)
+}
+
+func (ts *TestSuite) TestWebhooks() {
+	store := ts.store
+	created := time.Now().UTC().Truncate(time.Millisecond)
+
+	hookID, err := store.CreateWebhook(context.Background(), models.Webhook{URL: "https://example.com/hook", Secret: "s3cret", Events: []string{models.EventTodoCreated}, Active: true, CreatedAt: created})
+	if err != nil {
+		ts.FailNowf("err on CreateWebhook: ", err.Error())
+	}
+
+	hook, err := store.GetWebhookByID(context.Background(), hookID)
+	if err != nil {
+		ts.FailNowf("err on GetWebhookByID: ", err.Error())
+	}
+	ts.Equal(models.Webhook{ID: hookID, URL: "https://example.com/hook", Secret: "s3cret", Events: []string{models.EventTodoCreated}, Active: true, CreatedAt: created}, hook)
+
+	hook.Events, hook.Active, hook.Failures = []string{models.EventTodoDeleted, models.EventTodoUpdated}, false, 3
+	err = store.UpdateWebhook(context.Background(), hookID, hook)
+	if err != nil {
+		ts.FailNowf("err on UpdateWebhook: ", err.Error())
+	}
+
+	hooks, err := store.GetAllWebhooks(context.Background())
+	if err != nil {
+		ts.FailNowf("err on GetAllWebhooks: ", err.Error())
+	}
+	ts.Equal([]models.Webhook{hook}, hooks)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		err = store.AddDelivery(models.Delivery{ID: "e1-" + strconv.Itoa(attempt), WebhookID: hookID, EventID: "e1", Event: models.EventTodoDeleted, Attempt: attempt, StatusCode: 500, DeliveredAt: created.Add(time.Duration(attempt) * time.Second)})
+		if err != nil {
+			ts.FailNowf("err on AddDelivery: ", err.Error())
+		}
+	}
+
+	deliveries, err := store.GetDeliveries(context.Background(), hookID, 2)
+	if err != nil {
+		ts.FailNowf("err on GetDeliveries: ", err.Error())
+	}
+	ts.Require().Len(deliveries, 2)
+	ts.Equal(3, deliveries[0].Attempt)
+	ts.Equal(2, deliveries[1].Attempt)
+
+	deletedCount, err := store.DeleteWebhook(context.Background(), hookID)
+	if err != nil {
+		ts.FailNowf("err on DeleteWebhook: ", err.Error())
+	}
+	ts.Equal(1, deletedCount)
+
+	deliveries, err = store.GetDeliveries(context.Background(), hookID, 10)
+	if err != nil {
+		ts.FailNowf("err on GetDeliveries: ", err.Error())
+	}
+	ts.Empty(deliveries)
+
+	_, err = store.GetWebhookByID(context.Background(), hookID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+}
+
+func (ts *TestSuite) TestWebhookFailures() {
+	store := ts.store
+
+	hookID, err := store.CreateWebhook(context.Background(), models.Webhook{URL: "https://example.com/hook", Secret: "s3cret", Events: []string{models.EventTodoCreated}, Active: true, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		ts.FailNowf("err on CreateWebhook: ", err.Error())
+	}
+
+	hook, err := store.AddWebhookFailure(context.Background(), hookID, 2)
+	if err != nil {
+		ts.FailNowf("err on AddWebhookFailure: ", err.Error())
+	}
+	ts.True(hook.Active)
+	ts.Equal(1, hook.Failures)
+
+	hook, err = store.AddWebhookFailure(context.Background(), hookID, 2)
+	if err != nil {
+		ts.FailNowf("err on AddWebhookFailure: ", err.Error())
+	}
+	ts.False(hook.Active)
+	ts.Equal(2, hook.Failures)
+
+	err = store.ResetWebhookFailures(context.Background(), hookID)
+	if err != nil {
+		ts.FailNowf("err on ResetWebhookFailures: ", err.Error())
+	}
+	hook, err = store.GetWebhookByID(context.Background(), hookID)
+	if err != nil {
+		ts.FailNowf("err on GetWebhookByID: ", err.Error())
+	}
+	ts.False(hook.Active)
+	ts.Equal(0, hook.Failures)
+
+	_, err = store.AddWebhookFailure(auth.WithUser(context.Background(), "bob"), hookID, 2)
+	ts.ErrorIs(err, errs.ErrNotFound)
+}
+
+func (ts *TestSuite) TestMoveTodo() {
+	before, err := ts.store.GetTodoByID(context.Background(), "1")
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+
+	err = ts.store.MoveTodo(context.Background(), "1", "2")
+	if err != nil {
+		ts.FailNowf("err on MoveTodo: ", err.Error())
+	}
+
+	got, err := ts.store.GetTodoByID(context.Background(), "1")
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Equal("proj2", got.ProjName)
+	ts.Equal("2", got.ProjID)
+	ts.Equal(before.Updated_At, got.Updated_At)
+
+	err = ts.store.MoveTodo(context.Background(), "1", "999")
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	err = ts.store.MoveTodo(context.Background(), "999", "1")
+	ts.ErrorIs(err, errs.ErrNotFound)
+}
+
+func (ts *TestSuite) TestBatchTodos() {
+	ops := []models.BatchOp{
+		{Op: models.BatchUpdate, ID: "1", Todo: models.TODO{Name: todo1.Name, DueDate: todo1.DueDate, Priority: todo1.Priority, Completed: true}},
+		{Op: models.BatchDelete, ID: "2"},
+		{Op: models.BatchCreate, ProjID: "2", Todo: models.TODO{Name: "batched", DueDate: &dueDate3}},
+		{Op: models.BatchDelete, ID: "999"},
+		{Op: models.BatchCreate, ProjID: "not-a-number", Todo: models.TODO{Name: "orphan", DueDate: &dueDate3}},
+	}
+
+	got, err := ts.store.BatchTodos(context.Background(), ops, false)
+	if err != nil {
+		ts.FailNowf("err on BatchTodos: ", err.Error())
+	}
+	ts.Require().Len(got, 5)
+	ts.NoError(got[0].Err)
+	ts.NoError(got[1].Err)
+	ts.NoError(got[2].Err)
+	ts.ErrorIs(got[3].Err, errs.ErrNotFound)
+	ts.ErrorIs(got[4].Err, errs.ErrInvalidID)
+
+	updated, err := ts.store.GetTodoByID(context.Background(), "1")
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.True(updated.Completed)
+
+	_, err = ts.store.GetTodoByID(context.Background(), "2")
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	created, err := ts.store.GetTodoByID(context.Background(), got[2].ID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Equal("proj2", created.ProjName)
+}
+
+func (ts *TestSuite) TestBatchTodosAtomic() {
+	ops := []models.BatchOp{
+		{Op: models.BatchDelete, ID: "1"},
+		{Op: models.BatchDelete, ID: "999"},
+		{Op: models.BatchDelete, ID: "2"},
+	}
+
+	got, err := ts.store.BatchTodos(context.Background(), ops, true)
+	if err != nil {
+		ts.FailNowf("err on BatchTodos: ", err.Error())
+	}
+	ts.ErrorIs(got[0].Err, errs.ErrAborted)
+	ts.ErrorIs(got[1].Err, errs.ErrNotFound)
+	ts.ErrorIs(got[2].Err, errs.ErrAborted)
+
+	// the delete of todo 1 was rolled back
+	_, err = ts.store.GetTodoByID(context.Background(), "1")
+	ts.NoError(err)
+}
+
+func (ts *TestSuite) TestVersions() {
+	todoID, err := ts.store.CreateTodo(context.Background(), "1", models.TODO{Name: "versioned"})
+	if err != nil {
+		ts.FailNowf("err on CreateTodo: ", err.Error())
+	}
+
+	todo, err := ts.store.GetTodoByID(context.Background(), todoID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Equal(1, todo.Version)
+
+	todo.Name = "renamed"
+	err = ts.store.UpdateTodoByID(context.Background(), todoID, todo)
+	if err != nil {
+		ts.FailNowf("err on UpdateTodoByID: ", err.Error())
+	}
+
+	// the todo is at version 2 now, an update or delete expecting version 1 is stale
+	todo.Name = "clobbered"
+	err = ts.store.UpdateTodoByID(context.Background(), todoID, todo)
+	ts.ErrorIs(err, errs.ErrPreconditionFailed)
+	_, err = ts.store.DeleteTodoByID(context.Background(), todoID, 1)
+	ts.ErrorIs(err, errs.ErrPreconditionFailed)
+
+	// item changes bump the version too
+	_, err = ts.store.AddItem(context.Background(), todoID, models.ChecklistItem{Name: "step"})
+	if err != nil {
+		ts.FailNowf("err on AddItem: ", err.Error())
+	}
+
+	todo, err = ts.store.GetTodoByID(context.Background(), todoID)
+	if err != nil {
+		ts.FailNowf("err on GetTodoByID: ", err.Error())
+	}
+	ts.Equal("renamed", todo.Name)
+	ts.Equal(3, todo.Version)
+
+	_, err = ts.store.DeleteTodoByID(context.Background(), todoID, 3)
+	if err != nil {
+		ts.FailNowf("err on DeleteTodoByID: ", err.Error())
+	}
+	_, err = ts.store.DeleteTodoByID(context.Background(), todoID, 3)
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	projID, err := ts.store.CreateProj(context.Background(), "versioned", []models.TODO{})
+	if err != nil {
+		ts.FailNowf("err on CreateProj: ", err.Error())
+	}
+
+	err = ts.store.UpdateProjNameByID(context.Background(), projID, "renamed", 1)
+	if err != nil {
+		ts.FailNowf("err on UpdateProjNameByID: ", err.Error())
+	}
+	err = ts.store.UpdateProjNameByID(context.Background(), projID, "clobbered", 1)
+	ts.ErrorIs(err, errs.ErrPreconditionFailed)
+
+	proj, err := ts.store.GetProjByID(context.Background(), projID)
+	if err != nil {
+		ts.FailNowf("err on GetProjByID: ", err.Error())
+	}
+	ts.Equal("renamed", proj.ProjName)
+	ts.Equal(2, proj.Version)
+
+	_, err = ts.store.DeleteProjByID(context.Background(), projID, 1)
+	ts.ErrorIs(err, errs.ErrPreconditionFailed)
+	_, err = ts.store.DeleteProjByID(context.Background(), projID, 2)
+	if err != nil {
+		ts.FailNowf("err on DeleteProjByID: ", err.Error())
+	}
+}
+
+func (ts *TestSuite) TestIdempotencyRecords() {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	record := models.IdempotencyRecord{Key: "key-1", Fingerprint: "abc", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+
+	err := ts.store.CreateIdempotencyRecord(record)
+	if err != nil {
+		ts.FailNowf("err on CreateIdempotencyRecord: ", err.Error())
+	}
+	err = ts.store.CreateIdempotencyRecord(record)
+	ts.ErrorIs(err, errs.ErrConflict)
+
+	got, err := ts.store.GetIdempotencyRecord("key-1")
+	if err != nil {
+		ts.FailNowf("err on GetIdempotencyRecord: ", err.Error())
+	}
+	ts.Equal("abc", got.Fingerprint)
+	ts.Equal(0, got.StatusCode)
+
+	record.StatusCode = 201
+	record.Header = map[string]string{"Location": "/proj/1"}
+	record.Body = []byte(`{"id":1}`)
+	err = ts.store.SaveIdempotencyRecord(record)
+	if err != nil {
+		ts.FailNowf("err on SaveIdempotencyRecord: ", err.Error())
+	}
+
+	got, err = ts.store.GetIdempotencyRecord("key-1")
+	if err != nil {
+		ts.FailNowf("err on GetIdempotencyRecord: ", err.Error())
+	}
+	ts.Equal(record, got)
+
+	err = ts.store.DeleteIdempotencyRecord("key-1")
+	if err != nil {
+		ts.FailNowf("err on DeleteIdempotencyRecord: ", err.Error())
+	}
+	_, err = ts.store.GetIdempotencyRecord("key-1")
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	// an expired record is not returned and its key can be reserved again
+	expired := models.IdempotencyRecord{Key: "key-2", Fingerprint: "abc", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)}
+	err = ts.store.CreateIdempotencyRecord(expired)
+	if err != nil {
+		ts.FailNowf("err on CreateIdempotencyRecord: ", err.Error())
+	}
+	_, err = ts.store.GetIdempotencyRecord("key-2")
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	err = ts.store.CreateIdempotencyRecord(models.IdempotencyRecord{Key: "key-2", Fingerprint: "def", CreatedAt: now, ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		ts.FailNowf("err on CreateIdempotencyRecord: ", err.Error())
+	}
+}
+
+func (ts *TestSuite) TestUsers() {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	user := models.User{Email: "ada@example.com", PasswordHash: "hash", CreatedAt: now}
+
+	userID, err := ts.store.CreateUser(user)
+	if err != nil {
+		ts.FailNowf("err on CreateUser: ", err.Error())
+	}
+	_, err = ts.store.CreateUser(user)
+	ts.ErrorIs(err, errs.ErrConflict)
+
+	got, err := ts.store.GetUserByEmail("ada@example.com")
+	if err != nil {
+		ts.FailNowf("err on GetUserByEmail: ", err.Error())
+	}
+	user.ID = userID
+	ts.Equal(user, got)
+	_, err = ts.store.GetUserByEmail("bob@example.com")
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	got, err = ts.store.GetUserByID(userID)
+	if err != nil {
+		ts.FailNowf("err on GetUserByID: ", err.Error())
+	}
+	ts.Equal(user, got)
+}
+
+func (ts *TestSuite) TestRefreshTokens() {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	userID, err := ts.store.CreateUser(models.User{Email: "ada@example.com", PasswordHash: "hash", CreatedAt: now})
+	if err != nil {
+		ts.FailNowf("err on CreateUser: ", err.Error())
+	}
+
+	token := models.RefreshToken{Hash: "hash-1", UserID: userID, Family: "family-1", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	for _, token := range []models.RefreshToken{token, {Hash: "hash-2", UserID: userID, Family: "family-1", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}} {
+		err = ts.store.CreateRefreshToken(token)
+		if err != nil {
+			ts.FailNowf("err on CreateRefreshToken: ", err.Error())
+		}
+	}
+
+	got, err := ts.store.UseRefreshToken("hash-1", now)
+	if err != nil {
+		ts.FailNowf("err on UseRefreshToken: ", err.Error())
+	}
+	token.UsedAt = &now
+	ts.Equal(token, got)
+
+	// a token can only be used once
+	got, err = ts.store.UseRefreshToken("hash-1", now)
+	ts.ErrorIs(err, errs.ErrConflict)
+	ts.Equal("family-1", got.Family)
+	_, err = ts.store.UseRefreshToken("hash-3", now)
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	deletedCount, err := ts.store.DeleteRefreshTokens("family-1")
+	if err != nil {
+		ts.FailNowf("err on DeleteRefreshTokens: ", err.Error())
+	}
+	ts.Equal(2, deletedCount)
+	_, err = ts.store.UseRefreshToken("hash-2", now)
+	ts.ErrorIs(err, errs.ErrNotFound)
+}
+
+func (ts *TestSuite) TestTrash() {
+	_, err := ts.store.DeleteTodoByID(context.Background(), "1", 0)
+	if err != nil {
+		ts.FailNowf("err on DeleteTodoByID: ", err.Error())
+	}
+	_, err = ts.store.DeleteProjByID(context.Background(), "2", 0)
+	if err != nil {
+		ts.FailNowf("err on DeleteProjByID: ", err.Error())
+	}
+
+	// trashed todos and projects are left out of every read and write
+	_, err = ts.store.GetTodoByID(context.Background(), "1")
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = ts.store.GetTodoByID(context.Background(), "3")
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = ts.store.GetProjByID(context.Background(), "2")
+	ts.ErrorIs(err, errs.ErrNotFound)
+	err = ts.store.UpdateTodoByID(context.Background(), "1", todo1)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = ts.store.AddItem(context.Background(), "1", models.ChecklistItem{Name: "step"})
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = ts.store.CreateTodo(context.Background(), "2", models.TODO{Name: "orphan"})
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	todos, err := ts.store.GetAllTodos(context.Background())
+	if err != nil {
+		ts.FailNowf("err on GetAllTodos: ", err.Error())
+	}
+	ts.Len(todos, 1)
+
+	trash, err := ts.store.GetTrash(context.Background())
+	if err != nil {
+		ts.FailNowf("err on GetTrash: ", err.Error())
+	}
+	ts.Require().Len(trash, 2)
+	ts.Equal(models.TrashProject, trash[0].Kind)
+	ts.Equal("2", trash[0].ID)
+	ts.Require().Len(trash[0].Project.Tasks, 1)
+	ts.Equal("Test task 3", trash[0].Project.Tasks[0].Name)
+	ts.Equal("proj2", trash[0].Project.ProjName)
+	ts.Equal("proj2", trash[0].Project.Tasks[0].ProjName)
+	ts.Equal(models.TrashTodo, trash[1].Kind)
+	ts.Equal("1", trash[1].ID)
+
+	// the name of a trashed project is free to take, the project cannot be restored while it is taken
+	takenID, err := ts.store.CreateProj(context.Background(), "proj2", nil)
+	if err != nil {
+		ts.FailNowf("err on CreateProj: ", err.Error())
+	}
+	err = ts.store.RestoreProj(context.Background(), "2")
+	ts.ErrorIs(err, errs.ErrConflict)
+	_, err = ts.store.DeleteProjByID(context.Background(), takenID, 0)
+	if err != nil {
+		ts.FailNowf("err on DeleteProjByID: ", err.Error())
+	}
+
+	err = ts.store.RestoreProj(context.Background(), "2")
+	if err != nil {
+		ts.FailNowf("err on RestoreProj: ", err.Error())
+	}
+	proj, err := ts.store.GetProjByID(context.Background(), "2")
+	if err != nil {
+		ts.FailNowf("err on GetProjByID: ", err.Error())
+	}
+	ts.Len(proj.Tasks, 1)
+	ts.Equal("proj2", proj.ProjName)
+	ts.Equal("proj2", proj.Tasks[0].ProjName)
+
+	err = ts.store.RestoreTodo(context.Background(), "1")
+	if err != nil {
+		ts.FailNowf("err on RestoreTodo: ", err.Error())
+	}
+	err = ts.store.RestoreTodo(context.Background(), "1")
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = ts.store.GetTodoByID(context.Background(), "1")
+	ts.NoError(err)
+
+	// only items trashed before the cutoff are purged
+	_, err = ts.store.DeleteProjByID(context.Background(), "1", 0)
+	if err != nil {
+		ts.FailNowf("err on DeleteProjByID: ", err.Error())
+	}
+	purged, err := ts.store.PurgeTrash(time.Now().Add(-time.Hour))
+	if err != nil {
+		ts.FailNowf("err on PurgeTrash: ", err.Error())
+	}
+	ts.Equal(0, purged)
+
+	purged, err = ts.store.PurgeTrash(time.Now().Add(time.Hour))
+	if err != nil {
+		ts.FailNowf("err on PurgeTrash: ", err.Error())
+	}
+	// proj1 with its todos and the project that took the name of proj2
+	ts.Equal(4, purged)
+	err = ts.store.RestoreProj(context.Background(), "1")
+	ts.ErrorIs(err, errs.ErrNotFound)
+}
+
+func (ts *TestSuite) TestPersonalTokens() {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	userID, err := ts.store.CreateUser(models.User{Email: "ada@example.com", PasswordHash: "hash", CreatedAt: now})
+	if err != nil {
+		ts.FailNowf("err on CreateUser: ", err.Error())
+	}
+	otherID, err := ts.store.CreateUser(models.User{Email: "bob@example.com", PasswordHash: "hash", CreatedAt: now})
+	if err != nil {
+		ts.FailNowf("err on CreateUser: ", err.Error())
+	}
+
+	token := models.PersonalToken{UserID: userID, Name: "deploy", Scope: models.ScopeRead, ProjectID: "1", Prefix: "tdpat_abcd", Hash: "hash-1", CreatedAt: now}
+	token.ID, err = ts.store.CreatePersonalToken(token)
+	if err != nil {
+		ts.FailNowf("err on CreatePersonalToken: ", err.Error())
+	}
+	_, err = ts.store.CreatePersonalToken(models.PersonalToken{UserID: userID, Name: "again", Scope: models.ScopeRead, Prefix: "tdpat_abcd", Hash: "hash-1", CreatedAt: now})
+	ts.ErrorIs(err, errs.ErrConflict)
+
+	tokens, err := ts.store.GetPersonalTokens(userID)
+	if err != nil {
+		ts.FailNowf("err on GetPersonalTokens: ", err.Error())
+	}
+	ts.Equal([]models.PersonalToken{token}, tokens)
+	tokens, err = ts.store.GetPersonalTokens(otherID)
+	if err != nil {
+		ts.FailNowf("err on GetPersonalTokens: ", err.Error())
+	}
+	ts.Empty(tokens)
+
+	got, err := ts.store.GetPersonalToken("hash-1")
+	if err != nil {
+		ts.FailNowf("err on GetPersonalToken: ", err.Error())
+	}
+	ts.Equal(token, got)
+	_, err = ts.store.GetPersonalToken("hash-2")
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	usedAt := now.Add(time.Minute)
+	got, err = ts.store.UsePersonalToken("hash-1", usedAt)
+	if err != nil {
+		ts.FailNowf("err on UsePersonalToken: ", err.Error())
+	}
+	token.LastUsedAt = &usedAt
+	ts.Equal(token, got)
+	_, err = ts.store.UsePersonalToken("hash-2", usedAt)
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	// only the user who created a token can revoke it
+	_, err = ts.store.DeletePersonalToken(otherID, token.ID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	deletedCount, err := ts.store.DeletePersonalToken(userID, token.ID)
+	if err != nil {
+		ts.FailNowf("err on DeletePersonalToken: ", err.Error())
+	}
+	ts.Equal(1, deletedCount)
+	_, err = ts.store.UsePersonalToken("hash-1", usedAt)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = ts.store.DeletePersonalToken(userID, "999")
+	ts.ErrorIs(err, errs.ErrNotFound)
+}
+
+func (ts *TestSuite) TestHistory() {
+	ctx := history.WithActor(context.Background(), "ada")
+
+	todoID, err := ts.store.CreateTodo(ctx, "1", todo1)
+	if err != nil {
+		ts.FailNowf("err on CreateTodo: ", err.Error())
+	}
+	updated := todo1
+	updated.Name = "Water all plants"
+	err = ts.store.UpdateTodoByID(ctx, todoID, updated)
+	if err != nil {
+		ts.FailNowf("err on UpdateTodoByID: ", err.Error())
+	}
+	_, err = ts.store.DeleteTodoByID(ctx, todoID, 0)
+	if err != nil {
+		ts.FailNowf("err on DeleteTodoByID: ", err.Error())
+	}
+
+	// a change that fails is rolled back together with its entry
+	err = ts.store.UpdateTodoByID(ctx, todoID, updated)
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	entries, err := ts.store.GetHistory(context.Background(), models.HistoryTodo, todoID)
+	if err != nil {
+		ts.FailNowf("err on GetHistory: ", err.Error())
+	}
+	ts.Require().Len(entries, 3)
+	ts.Equal([]string{models.OpCreate, models.OpUpdate, models.OpDelete}, []string{entries[0].Operation, entries[1].Operation, entries[2].Operation})
+	ts.Equal("ada", entries[0].Actor)
+	ts.Equal(todoID, entries[1].EntityID)
+	ts.Equal([]models.FieldChange{{Field: "name", Before: json.RawMessage(`"Water Plants"`), After: json.RawMessage(`"Water all plants"`)}}, entries[1].Changes)
+
+	err = ts.store.UpdateProjNameByID(ctx, "2", "renamed", 0)
+	if err != nil {
+		ts.FailNowf("err on UpdateProjNameByID: ", err.Error())
+	}
+	entries, err = ts.store.GetHistory(context.Background(), models.HistoryProject, "2")
+	if err != nil {
+		ts.FailNowf("err on GetHistory: ", err.Error())
+	}
+	ts.Require().Len(entries, 1)
+	ts.Equal([]models.FieldChange{{Field: "projname", Before: json.RawMessage(`"proj2"`), After: json.RawMessage(`"renamed"`)}}, entries[0].Changes)
+}
+
+func (ts *TestSuite) TestOwners() {
+	anonymous := context.Background()
+	bob := auth.WithUser(context.Background(), "bob")
+
+	// the seeded projects and todos belong to the anonymous owner
+	projs, err := ts.store.GetAllProjs(bob)
+	if err != nil {
+		ts.FailNowf("err on GetAllProjs: ", err.Error())
+	}
+	ts.Empty(projs)
+	todos, err := ts.store.GetAllTodos(bob)
+	if err != nil {
+		ts.FailNowf("err on GetAllTodos: ", err.Error())
+	}
+	ts.Empty(todos)
+	todos, _, err = ts.store.QueryTodos(bob, models.TodoQuery{})
+	if err != nil {
+		ts.FailNowf("err on QueryTodos: ", err.Error())
+	}
+	ts.Empty(todos)
+	hits, err := ts.store.Search(bob, "water", 10)
+	if err != nil {
+		ts.FailNowf("err on Search: ", err.Error())
+	}
+	ts.Empty(hits)
+
+	_, err = ts.store.GetProjByID(bob, "1")
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = ts.store.GetTodoByID(bob, "1")
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = ts.store.CreateTodo(bob, "1", models.TODO{Name: "intruder"})
+	ts.ErrorIs(err, errs.ErrNotFound)
+	err = ts.store.UpdateTodoByID(bob, "1", todo1)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	err = ts.store.UpdateProjNameByID(bob, "1", "mine now", 0)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	err = ts.store.MoveTodo(bob, "1", "2")
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = ts.store.DeleteTodoByID(bob, "1", 0)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = ts.store.DeleteProjByID(bob, "1", 0)
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	// project and label names only have to be unique per owner
+	bobsProjID, err := ts.store.CreateProj(bob, "proj1", []models.TODO{})
+	if err != nil {
+		ts.FailNowf("err on CreateProj: ", err.Error())
+	}
+	_, err = ts.store.GetProjByID(anonymous, bobsProjID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	err = ts.store.MoveTodo(bob, "1", bobsProjID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	err = ts.store.MoveTodo(anonymous, "1", bobsProjID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	labelID, err := ts.store.CreateLabel(anonymous, models.Label{Name: "urgent", Color: "#ff0000"})
+	if err != nil {
+		ts.FailNowf("err on CreateLabel: ", err.Error())
+	}
+	_, err = ts.store.CreateLabel(bob, models.Label{Name: "urgent", Color: "#ff0000"})
+	ts.NoError(err)
+	_, err = ts.store.GetLabelByID(bob, labelID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = ts.store.CreateTodo(bob, bobsProjID, models.TODO{Name: "labelled", Labels: []string{labelID}})
+	ts.ErrorIs(err, errs.ErrValidation)
+	_, err = ts.store.DeleteLabel(bob, labelID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	hookID, err := ts.store.CreateWebhook(anonymous, models.Webhook{URL: "https://example.com/hook", Events: []string{models.EventTodoCreated}, Active: true, CreatedAt: time.Now()})
+	if err != nil {
+		ts.FailNowf("err on CreateWebhook: ", err.Error())
+	}
+	hooks, err := ts.store.GetAllWebhooks(bob)
+	if err != nil {
+		ts.FailNowf("err on GetAllWebhooks: ", err.Error())
+	}
+	ts.Empty(hooks)
+	_, err = ts.store.GetWebhookByID(bob, hookID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = ts.store.GetDeliveries(bob, hookID, 10)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = ts.store.DeleteWebhook(bob, hookID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	_, err = ts.store.DeleteTodoByID(anonymous, "1", 0)
+	if err != nil {
+		ts.FailNowf("err on DeleteTodoByID: ", err.Error())
+	}
+	trash, err := ts.store.GetTrash(bob)
+	if err != nil {
+		ts.FailNowf("err on GetTrash: ", err.Error())
+	}
+	ts.Empty(trash)
+	err = ts.store.RestoreTodo(bob, "1")
+	ts.ErrorIs(err, errs.ErrNotFound)
+	entries, err := ts.store.GetHistory(bob, models.HistoryTodo, "1")
+	if err != nil {
+		ts.FailNowf("err on GetHistory: ", err.Error())
+	}
+	ts.Empty(entries)
+
+	// nothing bob did touched the anonymous owner's data
+	projs, err = ts.store.GetAllProjs(anonymous)
+	if err != nil {
+		ts.FailNowf("err on GetAllProjs: ", err.Error())
+	}
+	ts.Len(projs, 2)
+	entries, err = ts.store.GetHistory(anonymous, models.HistoryTodo, "1")
+	if err != nil {
+		ts.FailNowf("err on GetHistory: ", err.Error())
+	}
+	ts.Len(entries, 1)
+}
+
+func (ts *TestSuite) TestCollaborators() {
+	ada := auth.WithUser(context.Background(), "ada")
+	bob := auth.WithUser(context.Background(), "bob")
+	store := ts.store
+
+	projID, err := store.CreateProj(ada, "shared", []models.TODO{})
+	if err != nil {
+		ts.FailNowf("err on CreateProj: ", err.Error())
+	}
+	todoID, err := store.CreateTodo(ada, projID, models.TODO{Name: "shared task"})
+	if err != nil {
+		ts.FailNowf("err on CreateTodo: ", err.Error())
+	}
+
+	_, err = store.GetProjAccess(bob, projID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	access, err := store.GetProjAccess(ada, projID)
+	ts.NoError(err)
+	ts.Equal(models.Access{ProjID: projID, Owner: "ada", Role: models.RoleOwner}, access)
+
+	err = store.AddCollaborator(ada, projID, models.Collaborator{UserID: "bob", Role: models.RoleViewer})
+	ts.NoError(err)
+	err = store.AddCollaborator(ada, projID, models.Collaborator{UserID: "bob", Role: models.RoleEditor})
+	ts.ErrorIs(err, errs.ErrConflict)
+	err = store.AddCollaborator(bob, projID, models.Collaborator{UserID: "carol", Role: models.RoleViewer})
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	access, err = store.GetProjAccess(bob, projID)
+	ts.NoError(err)
+	ts.Equal(models.Access{ProjID: projID, Owner: "ada", Role: models.RoleViewer}, access)
+	access, err = store.GetTodoAccess(bob, todoID)
+	ts.NoError(err)
+	ts.Equal(models.Access{ProjID: projID, Owner: "ada", Role: models.RoleViewer}, access)
+
+	// shared projects are listed next to the user's own
+	projs, err := store.GetAllProjs(bob)
+	if err != nil {
+		ts.FailNowf("err on GetAllProjs: ", err.Error())
+	}
+	ts.Require().Len(projs, 1)
+	ts.Equal("shared", projs[0].ProjName)
+	todos, err := store.GetAllTodos(bob)
+	if err != nil {
+		ts.FailNowf("err on GetAllTodos: ", err.Error())
+	}
+	ts.Require().Len(todos, 1)
+	ts.Equal("shared task", todos[0].Name)
+
+	err = store.UpdateCollaborator(ada, projID, models.Collaborator{UserID: "bob", Role: models.RoleEditor})
+	ts.NoError(err)
+	err = store.UpdateCollaborator(ada, projID, models.Collaborator{UserID: "carol", Role: models.RoleEditor})
+	ts.ErrorIs(err, errs.ErrNotFound)
+	collaborators, err := store.GetCollaborators(ada, projID)
+	ts.NoError(err)
+	ts.Equal([]models.Collaborator{{UserID: "bob", Role: models.RoleEditor}}, collaborators)
+
+	removedCount, err := store.RemoveCollaborator(ada, projID, "bob")
+	ts.NoError(err)
+	ts.Equal(1, removedCount)
+	_, err = store.RemoveCollaborator(ada, projID, "bob")
+	ts.ErrorIs(err, errs.ErrNotFound)
+	_, err = store.GetProjAccess(bob, projID)
+	ts.ErrorIs(err, errs.ErrNotFound)
+	projs, err = store.GetAllProjs(bob)
+	if err != nil {
+		ts.FailNowf("err on GetAllProjs: ", err.Error())
+	}
+	ts.Empty(projs)
+}
+
+func (ts *TestSuite) TestErrorsAreWrapped() {
+	_, err := ts.store.GetProjByID(context.Background(), "not-a-number")
+	ts.ErrorIs(err, errs.ErrInvalidID)
+
+	_, err = ts.store.GetProjByID(context.Background(), "999")
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	_, err = ts.store.GetTodoByID(context.Background(), "999")
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	_, err = ts.store.CreateTodo(context.Background(), "999", models.TODO{Name: "orphan"})
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	_, err = ts.store.CreateProj(context.Background(), "proj1", []models.TODO{})
+	ts.ErrorIs(err, errs.ErrConflict)
+
+	_, err = ts.store.DeleteTodoByID(context.Background(), "999", 0)
+	ts.ErrorIs(err, errs.ErrNotFound)
+}
+
 /*
 * methods to implement
 type TodoStore interface {
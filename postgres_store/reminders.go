@@ -0,0 +1,89 @@
+package postgres_store
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// remindersColumn aggregates the reminders of each todo into a json array, earliest first
+const remindersColumn = `COALESCE((SELECT json_agg(json_build_object('before', r.remind_before, 'fireAt', r.fire_at, 'sentAt', r.sent_at) ORDER BY r.fire_at) FROM todo_reminders r WHERE r.todo_id = t.id), '[]')`
+
+// setReminders replaces the reminders of a todo
+//
+// unlike labels the reminders are always replaced, nil removes them all,
+// because the server reschedules them on every update
+func setReminders(q querier, todoID int, reminders []models.Reminder) error {
+	_, err := q.Exec(`DELETE FROM todo_reminders WHERE todo_id = $1`, todoID)
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	for _, reminder := range reminders {
+		_, err := q.Exec(`INSERT INTO todo_reminders (todo_id, remind_before, fire_at, sent_at) VALUES ($1, $2, $3, $4)`, todoID, reminder.Before, reminder.FireAt, reminder.SentAt)
+		if err != nil {
+			return wrapErr(err)
+		}
+	}
+	return nil
+}
+
+// DueReminders returns the pending reminders of open todos outside the trash that fire at or before now, earliest first
+//
+// it is a background job, the reminders of every user are returned along with their owner
+func (pg *PostGresStore) DueReminders(now time.Time) ([]models.DueReminder, error) {
+	stmt := `SELECT ` + todoColumns + `, due.remind_before, due.fire_at, t.owner FROM todo_reminders due
+	JOIN todos t ON t.id = due.todo_id
+	JOIN projects p ON p.owner = t.owner AND p.projname = t.projname
+	WHERE due.sent_at IS NULL AND due.fire_at <= $1 AND NOT t.completed AND ` + liveTodo + `
+	ORDER BY due.fire_at, t.id`
+
+	rows, err := pg.DB.Query(stmt, now)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	due := []models.DueReminder{}
+	for rows.Next() {
+		reminder := models.Reminder{FireAt: &time.Time{}}
+		owner := ""
+
+		todo, err := scanTodo(rows, &reminder.Before, reminder.FireAt, &owner)
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+		due = append(due, models.DueReminder{TodoID: strconv.Itoa(todo.Id), Todo: todo, Reminder: reminder, Owner: owner})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	return due, nil
+}
+
+// MarkReminderSent
+//
+// - bumps the version of the todo
+// - returns errs.ErrNotFound if the reminder is gone or was already sent
+func (pg *PostGresStore) MarkReminderSent(todoID, before string, sentAt time.Time) error {
+	intID, err := parseID(todoID)
+	if err != nil {
+		return err
+	}
+
+	return pg.withTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`UPDATE todo_reminders SET sent_at = $1 WHERE todo_id = $2 AND remind_before = $3 AND sent_at IS NULL`, sentAt, intID, before)
+		if err != nil {
+			return wrapErr(err)
+		}
+
+		_, err = checkRowsAffected(result)
+		if err != nil {
+			return err
+		}
+		// sentAt is part of the todo, an update merged before it was sent must not clear it
+		return bumpVersion(tx, intID)
+	})
+}
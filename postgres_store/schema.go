@@ -0,0 +1,213 @@
+package postgres_store
+
+import "fmt"
+
+// schema is applied in order by Migrate
+//
+// every statement has to be safe to run more than once,
+// new columns and tables are appended at the end
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS projects (
+    id SERIAL PRIMARY KEY,
+    projname VARCHAR(255) NOT NULL UNIQUE
+    )`,
+	`CREATE TABLE IF NOT EXISTS todos (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    description VARCHAR(255) NOT NULL,
+    duedate TIMESTAMPTZ NOT NULL,
+    priority VARCHAR(10) NOT NULL,
+    completed BOOLEAN NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    projname VARCHAR(255) NOT NULL,
+    FOREIGN KEY (projname) REFERENCES projects(projname) ON UPDATE CASCADE ON DELETE CASCADE
+    )`,
+
+	// full-text search
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS search tsvector
+    GENERATED ALWAYS AS (to_tsvector('english', coalesce(name, '') || ' ' || coalesce(description, ''))) STORED`,
+	`CREATE INDEX IF NOT EXISTS todos_search_idx ON todos USING GIN (search)`,
+	`ALTER TABLE projects ADD COLUMN IF NOT EXISTS search tsvector
+    GENERATED ALWAYS AS (to_tsvector('english', coalesce(projname, ''))) STORED`,
+	`CREATE INDEX IF NOT EXISTS projects_search_idx ON projects USING GIN (search)`,
+
+	// manual ordering, todos created before this have an empty rank and sort first by id
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS rank TEXT NOT NULL DEFAULT ''`,
+	`CREATE INDEX IF NOT EXISTS todos_rank_idx ON todos (projname, rank COLLATE "C", id)`,
+
+	// checklists
+	`CREATE TABLE IF NOT EXISTS todo_items (
+    id SERIAL PRIMARY KEY,
+    todo_id INT NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    completed BOOLEAN NOT NULL DEFAULT false,
+    rank TEXT NOT NULL DEFAULT ''
+    )`,
+	`CREATE INDEX IF NOT EXISTS todo_items_rank_idx ON todo_items (todo_id, rank COLLATE "C", id)`,
+
+	// labels
+	`CREATE TABLE IF NOT EXISTS labels (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    color VARCHAR(7) NOT NULL DEFAULT ''
+    )`,
+	`CREATE TABLE IF NOT EXISTS todo_labels (
+    todo_id INT NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+    label_id INT NOT NULL REFERENCES labels(id) ON DELETE CASCADE,
+    PRIMARY KEY (todo_id, label_id)
+    )`,
+	`CREATE INDEX IF NOT EXISTS todo_labels_label_idx ON todo_labels (label_id, todo_id)`,
+
+	// recurrence
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS recurrence TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS occurrence INT NOT NULL DEFAULT 0`,
+
+	// reminders, a reminder is pending until sent_at is set
+	`CREATE TABLE IF NOT EXISTS todo_reminders (
+    todo_id INT NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+    remind_before TEXT NOT NULL,
+    fire_at TIMESTAMPTZ NOT NULL,
+    sent_at TIMESTAMPTZ,
+    PRIMARY KEY (todo_id, remind_before)
+    )`,
+	`CREATE INDEX IF NOT EXISTS todo_reminders_pending_idx ON todo_reminders (fire_at) WHERE sent_at IS NULL`,
+
+	// webhooks
+	`CREATE TABLE IF NOT EXISTS webhooks (
+    id SERIAL PRIMARY KEY,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT[] NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT true,
+    failures INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+    )`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id TEXT PRIMARY KEY,
+    webhook_id INT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event_id TEXT NOT NULL,
+    event TEXT NOT NULL,
+    attempt INT NOT NULL,
+    status_code INT NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    success BOOLEAN NOT NULL,
+    delivered_at TIMESTAMPTZ NOT NULL
+    )`,
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, delivered_at DESC)`,
+
+	// versions, see the ETag of todos and projects
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1`,
+	`ALTER TABLE projects ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1`,
+
+	// idempotency keys, status_code is 0 while the request is in progress
+	`CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key TEXT PRIMARY KEY,
+    fingerprint TEXT NOT NULL,
+    status_code INT NOT NULL DEFAULT 0,
+    header JSONB NOT NULL DEFAULT '{}',
+    body BYTEA NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+    )`,
+	`CREATE INDEX IF NOT EXISTS idempotency_keys_expires_idx ON idempotency_keys (expires_at)`,
+
+	// trash, rows with deleted_at set are only seen by the trash and purged after a while
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ`,
+	`ALTER TABLE projects ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ`,
+	`CREATE INDEX IF NOT EXISTS todos_deleted_idx ON todos (deleted_at) WHERE deleted_at IS NOT NULL`,
+	`CREATE INDEX IF NOT EXISTS projects_deleted_idx ON projects (deleted_at) WHERE deleted_at IS NOT NULL`,
+	// a trashed project gives up its name so that a new project can take it, see trashedProjName,
+	// it keeps the name in trashed_name until it is restored
+	`ALTER TABLE projects ADD COLUMN IF NOT EXISTS trashed_name VARCHAR(255)`,
+
+	// history, entries are only ever inserted and stay after their todo or project is purged
+	`CREATE TABLE IF NOT EXISTS history (
+    id SERIAL PRIMARY KEY,
+    kind TEXT NOT NULL,
+    entity_id INT NOT NULL,
+    operation TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    changes JSONB NOT NULL
+    )`,
+	`CREATE INDEX IF NOT EXISTS history_entity_idx ON history (kind, entity_id, id)`,
+
+	// users, only the hash of a refresh token is stored
+	`CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    email TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+    )`,
+	`CREATE TABLE IF NOT EXISTS refresh_tokens (
+    hash TEXT PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+    )`,
+	`CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family)`,
+	`CREATE INDEX IF NOT EXISTS refresh_tokens_expires_idx ON refresh_tokens (expires_at)`,
+
+	// owners, rows created before this belong to the anonymous owner ''.
+	// project and label names only have to be unique per owner, todos point at their
+	// project through (owner, projname) so that they always share its owner
+	`ALTER TABLE projects ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE labels ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE history ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE todos DROP CONSTRAINT IF EXISTS todos_projname_fkey`,
+	`ALTER TABLE projects DROP CONSTRAINT IF EXISTS projects_projname_key`,
+	`ALTER TABLE labels DROP CONSTRAINT IF EXISTS labels_name_key`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS projects_owner_projname_key ON projects (owner, projname)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS labels_owner_name_key ON labels (owner, name)`,
+	`DO $$ BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'todos_owner_projname_fkey') THEN
+        ALTER TABLE todos ADD CONSTRAINT todos_owner_projname_fkey FOREIGN KEY (owner, projname)
+        REFERENCES projects (owner, projname) ON UPDATE CASCADE ON DELETE CASCADE;
+    END IF;
+    END $$`,
+	`DROP INDEX IF EXISTS todos_rank_idx`,
+	`CREATE INDEX IF NOT EXISTS todos_owner_rank_idx ON todos (owner, projname, rank COLLATE "C", id)`,
+	`CREATE INDEX IF NOT EXISTS webhooks_owner_idx ON webhooks (owner, id)`,
+
+	// personal access tokens, only their hash is stored.
+	// project_id is '' for a token that is not limited to a project
+	`CREATE TABLE IF NOT EXISTS personal_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    project_id TEXT NOT NULL DEFAULT '',
+    prefix TEXT NOT NULL,
+    hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ
+    )`,
+	`CREATE INDEX IF NOT EXISTS personal_tokens_user_idx ON personal_tokens (user_id, id)`,
+
+	// collaborators of shared projects, the owner of a project is not one of them
+	`CREATE TABLE IF NOT EXISTS project_collaborators (
+    project_id INT NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL,
+    role TEXT NOT NULL,
+    PRIMARY KEY (project_id, user_id)
+    )`,
+	`CREATE INDEX IF NOT EXISTS project_collaborators_user_idx ON project_collaborators (user_id, project_id)`,
+
+	// todos without a due date, a merge patch clears the due date with null
+	`ALTER TABLE todos ALTER COLUMN duedate DROP NOT NULL`,
+}
+
+// Migrate creates the tables and indexes the store needs
+func (pg *PostGresStore) Migrate() error {
+	for i, stmt := range schema {
+		_, err := pg.DB.Exec(stmt)
+		if err != nil {
+			return fmt.Errorf("migration %d failed: %w", i, wrapErr(err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package postgres_store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// GetTrash
+//
+// - lists trashed projects with the todos trashed together with them,
+// and trashed todos of projects that are not in the trash
+// - most recently deleted first
+func (pg *PostGresStore) GetTrash(ctx context.Context) ([]models.TrashItem, error) {
+	owner := auth.Owner(ctx)
+	rows, err := pg.DB.Query(`SELECT id, COALESCE(trashed_name, projname), version, deleted_at FROM projects WHERE owner = $1 AND deleted_at IS NOT NULL ORDER BY id`, owner)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	trash := []models.TrashItem{}
+	index := map[int]int{}
+	for rows.Next() {
+		project := models.PROJECT{Tasks: []models.TODO{}, DeletedAt: &time.Time{}}
+		err := rows.Scan(&project.Id, &project.ProjName, &project.Version, project.DeletedAt)
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+		index[project.Id] = len(trash)
+		trash = append(trash, models.TrashItem{Kind: models.TrashProject, ID: strconv.Itoa(project.Id), DeletedAt: *project.DeletedAt, Project: &project})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+
+	// the todos of a trashed project that were deleted before it stay hidden until it is restored
+	stmt := `SELECT ` + todoColumns + `, p.deleted_at IS NOT NULL FROM todos t JOIN projects p ON p.owner = t.owner AND p.projname = t.projname
+    WHERE t.owner = $1 AND t.deleted_at IS NOT NULL AND (p.deleted_at IS NULL OR t.deleted_at = p.deleted_at)
+    ORDER BY ` + rankOrder
+
+	todoRows, err := pg.DB.Query(stmt, owner)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer todoRows.Close()
+
+	for todoRows.Next() {
+		inTrashedProj := false
+		todo, err := scanTodo(todoRows, &inTrashedProj)
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+
+		if inTrashedProj {
+			projID, _ := strconv.Atoi(todo.ProjID)
+			project := trash[index[projID]].Project
+			project.Tasks = append(project.Tasks, todo)
+			continue
+		}
+		trash = append(trash, models.TrashItem{Kind: models.TrashTodo, ID: strconv.Itoa(todo.Id), DeletedAt: *todo.DeletedAt, Todo: &todo})
+	}
+	if err := todoRows.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+
+	slices.SortStableFunc(trash, func(a, b models.TrashItem) int {
+		return b.DeletedAt.Compare(a.DeletedAt)
+	})
+	return trash, nil
+}
+
+// RestoreTodo takes a todo out of the trash
+//
+// - returns errs.ErrNotFound if the todo is not in the trash or its project is
+func (pg *PostGresStore) RestoreTodo(ctx context.Context, todoID string) error {
+	stmt := `UPDATE todos t SET deleted_at = NULL, version = t.version + 1 FROM projects p
+    WHERE p.owner = t.owner AND p.projname = t.projname AND t.id = $1 AND t.owner = $2 AND t.deleted_at IS NOT NULL AND p.deleted_at IS NULL`
+
+	intTodoID, err := parseID(todoID)
+	if err != nil {
+		return err
+	}
+
+	return pg.withTx(func(tx *sql.Tx) error {
+		return recordTodo(ctx, tx, models.OpRestore, intTodoID, func() error {
+			result, err := tx.Exec(stmt, intTodoID, auth.Owner(ctx))
+			if err != nil {
+				return wrapErr(err)
+			}
+
+			_, err = checkRowsAffected(result)
+			return err
+		})
+	})
+}
+
+// RestoreProj takes a project and the todos trashed together with it out of the trash
+//
+// - the project gets its name back, see trashedProjName
+// - returns errs.ErrNotFound if the project is not in the trash
+// - returns errs.ErrConflict if another project took its name in the meantime
+func (pg *PostGresStore) RestoreProj(ctx context.Context, ID string) error {
+	intID, err := parseID(ID)
+	if err != nil {
+		return err
+	}
+
+	owner := auth.Owner(ctx)
+	return pg.withTx(func(tx *sql.Tx) error {
+		return recordProj(ctx, tx, models.OpRestore, intID, func() error {
+			// the todos go first, they are matched on the deleted_at of the project
+			_, err := tx.Exec(`UPDATE todos t SET deleted_at = NULL, version = t.version + 1 FROM projects p
+            WHERE p.id = $1 AND p.owner = $2 AND t.owner = p.owner AND t.projname = p.projname AND t.deleted_at = p.deleted_at`, intID, owner)
+			if err != nil {
+				return wrapErr(err)
+			}
+
+			result, err := tx.Exec(`UPDATE projects SET deleted_at = NULL, version = version + 1, projname = COALESCE(trashed_name, projname), trashed_name = NULL
+            WHERE id = $1 AND owner = $2 AND deleted_at IS NOT NULL`, intID, owner)
+			if err != nil {
+				err = wrapErr(err)
+				if errors.Is(err, errs.ErrConflict) {
+					return fmt.Errorf("%w: another project took the name of the project, rename that one first", errs.ErrConflict)
+				}
+				return err
+			}
+			_, err = checkRowsAffected(result)
+			return err
+		})
+	})
+}
+
+// PurgeTrash permanently deletes the todos and projects trashed before before
+//
+// - it is a background job, it purges the trash of every user
+// - the checklists, labels and reminders of the todos go with them
+// - returns the number of deleted todos and projects
+func (pg *PostGresStore) PurgeTrash(before time.Time) (int, error) {
+	purged := 0
+	err := pg.withTx(func(tx *sql.Tx) error {
+		// a project is trashed no earlier than its todos, so none of its todos are left when it is deleted
+		for _, table := range []string{"todos", "projects"} {
+			result, err := tx.Exec(`DELETE FROM `+table+` WHERE deleted_at < $1`, before)
+			if err != nil {
+				return wrapErr(err)
+			}
+			rowsAffected, err := result.RowsAffected()
+			if err != nil {
+				return wrapErr(err)
+			}
+			purged += int(rowsAffected)
+		}
+		return nil
+	})
+	return purged, err
+}
@@ -0,0 +1,230 @@
+package postgres_store
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// CreateUser
+//
+// - returns errs.ErrConflict if the email is already taken, the unique constraint takes care of concurrent requests
+func (pg *PostGresStore) CreateUser(user models.User) (string, error) {
+	stmt := `INSERT INTO users (email, password_hash, created_at) VALUES ($1, $2, $3) RETURNING id`
+
+	insertedID := 0
+	err := pg.DB.QueryRow(stmt, user.Email, user.PasswordHash, user.CreatedAt).Scan(&insertedID)
+	if err != nil {
+		return "", wrapErr(err)
+	}
+	return strconv.Itoa(insertedID), nil
+}
+
+func (pg *PostGresStore) GetUserByEmail(email string) (models.User, error) {
+	stmt := `SELECT id, email, password_hash, created_at FROM users WHERE email = $1`
+
+	user := models.User{}
+	ID := 0
+	err := pg.DB.QueryRow(stmt, email).Scan(&ID, &user.Email, &user.PasswordHash, &user.CreatedAt)
+	if err != nil {
+		return models.User{}, wrapErr(err)
+	}
+	user.ID = strconv.Itoa(ID)
+	user.CreatedAt = user.CreatedAt.UTC()
+	return user, nil
+}
+
+// GetUserByID
+//
+// - returns errs.ErrNotFound if there is no user ID
+func (pg *PostGresStore) GetUserByID(ID string) (models.User, error) {
+	intID, err := parseID(ID)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	stmt := `SELECT id, email, password_hash, created_at FROM users WHERE id = $1`
+
+	user := models.User{}
+	err = pg.DB.QueryRow(stmt, intID).Scan(&intID, &user.Email, &user.PasswordHash, &user.CreatedAt)
+	if err != nil {
+		return models.User{}, wrapErr(err)
+	}
+	user.ID = strconv.Itoa(intID)
+	user.CreatedAt = user.CreatedAt.UTC()
+	return user, nil
+}
+
+// CreateRefreshToken
+//
+// expired tokens are cleaned up on the way
+func (pg *PostGresStore) CreateRefreshToken(token models.RefreshToken) error {
+	userID, err := parseID(token.UserID)
+	if err != nil {
+		return err
+	}
+
+	_, err = pg.DB.Exec(`DELETE FROM refresh_tokens WHERE expires_at <= $1`, token.CreatedAt)
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	stmt := `INSERT INTO refresh_tokens (hash, user_id, family, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)`
+
+	_, err = pg.DB.Exec(stmt, token.Hash, userID, token.Family, token.CreatedAt, token.ExpiresAt)
+	return wrapErr(err)
+}
+
+// refreshTokenColumns is selected by every query that returns a models.RefreshToken
+const refreshTokenColumns = `hash, user_id, family, created_at, expires_at, used_at`
+
+// UseRefreshToken marks the refresh token stored under hash as used and returns it
+//
+// - returns errs.ErrNotFound if there is no token under hash
+// - returns the token and errs.ErrConflict if it was already used
+func (pg *PostGresStore) UseRefreshToken(hash string, usedAt time.Time) (models.RefreshToken, error) {
+	stmt := `UPDATE refresh_tokens SET used_at = $2 WHERE hash = $1 AND used_at IS NULL RETURNING ` + refreshTokenColumns
+
+	token, err := scanRefreshToken(pg.DB.QueryRow(stmt, hash, usedAt))
+	if !errors.Is(err, errs.ErrNotFound) {
+		return token, err
+	}
+
+	token, err = scanRefreshToken(pg.DB.QueryRow(`SELECT `+refreshTokenColumns+` FROM refresh_tokens WHERE hash = $1`, hash))
+	if err != nil {
+		return models.RefreshToken{}, err
+	}
+	return token, errs.ErrConflict
+}
+
+// DeleteRefreshTokens deletes every refresh token of family
+func (pg *PostGresStore) DeleteRefreshTokens(family string) (int, error) {
+	result, err := pg.DB.Exec(`DELETE FROM refresh_tokens WHERE family = $1`, family)
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+	return int(rowsAffected), nil
+}
+
+func scanRefreshToken(row scanner) (models.RefreshToken, error) {
+	token := models.RefreshToken{}
+	userID := 0
+	usedAt := sql.NullTime{}
+	err := row.Scan(&token.Hash, &userID, &token.Family, &token.CreatedAt, &token.ExpiresAt, &usedAt)
+	if err != nil {
+		return models.RefreshToken{}, wrapErr(err)
+	}
+	token.UserID = strconv.Itoa(userID)
+	token.CreatedAt, token.ExpiresAt = token.CreatedAt.UTC(), token.ExpiresAt.UTC()
+	if usedAt.Valid {
+		used := usedAt.Time.UTC()
+		token.UsedAt = &used
+	}
+	return token, nil
+}
+
+func (pg *PostGresStore) CreatePersonalToken(token models.PersonalToken) (string, error) {
+	userID, err := parseID(token.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO personal_tokens (user_id, name, scope, project_id, prefix, hash, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+
+	insertedID := 0
+	err = pg.DB.QueryRow(stmt, userID, token.Name, token.Scope, token.ProjectID, token.Prefix, token.Hash, token.CreatedAt).Scan(&insertedID)
+	if err != nil {
+		return "", wrapErr(err)
+	}
+	return strconv.Itoa(insertedID), nil
+}
+
+// personalTokenColumns is selected by every query that returns a models.PersonalToken
+const personalTokenColumns = `id, user_id, name, scope, project_id, prefix, hash, created_at, last_used_at`
+
+// GetPersonalTokens lists the personal access tokens of the user userID, oldest first
+func (pg *PostGresStore) GetPersonalTokens(userID string) ([]models.PersonalToken, error) {
+	ID, err := parseID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := pg.DB.Query(`SELECT `+personalTokenColumns+` FROM personal_tokens WHERE user_id = $1 ORDER BY id`, ID)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	tokens := []models.PersonalToken{}
+	for rows.Next() {
+		token, err := scanPersonalToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, wrapErr(rows.Err())
+}
+
+// GetPersonalToken returns the personal access token stored under hash
+//
+// - returns errs.ErrNotFound if there is no token under hash
+func (pg *PostGresStore) GetPersonalToken(hash string) (models.PersonalToken, error) {
+	return scanPersonalToken(pg.DB.QueryRow(`SELECT `+personalTokenColumns+` FROM personal_tokens WHERE hash = $1`, hash))
+}
+
+// UsePersonalToken sets the last use of the personal access token stored under hash and returns it
+//
+// - returns errs.ErrNotFound if there is no token under hash
+func (pg *PostGresStore) UsePersonalToken(hash string, usedAt time.Time) (models.PersonalToken, error) {
+	stmt := `UPDATE personal_tokens SET last_used_at = $2 WHERE hash = $1 RETURNING ` + personalTokenColumns
+
+	return scanPersonalToken(pg.DB.QueryRow(stmt, hash, usedAt))
+}
+
+// DeletePersonalToken revokes the personal access token ID of the user userID
+//
+// - returns errs.ErrNotFound if the user has no such token
+func (pg *PostGresStore) DeletePersonalToken(userID, ID string) (int, error) {
+	user, err := parseID(userID)
+	if err != nil {
+		return 0, err
+	}
+	tokenID, err := parseID(ID)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := pg.DB.Exec(`DELETE FROM personal_tokens WHERE id = $1 AND user_id = $2`, tokenID, user)
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+	return checkRowsAffected(result)
+}
+
+func scanPersonalToken(row scanner) (models.PersonalToken, error) {
+	token := models.PersonalToken{}
+	ID, userID := 0, 0
+	lastUsedAt := sql.NullTime{}
+	err := row.Scan(&ID, &userID, &token.Name, &token.Scope, &token.ProjectID, &token.Prefix, &token.Hash, &token.CreatedAt, &lastUsedAt)
+	if err != nil {
+		return models.PersonalToken{}, wrapErr(err)
+	}
+	token.ID, token.UserID = strconv.Itoa(ID), strconv.Itoa(userID)
+	token.CreatedAt = token.CreatedAt.UTC()
+	if lastUsedAt.Valid {
+		used := lastUsedAt.Time.UTC()
+		token.LastUsedAt = &used
+	}
+	return token, nil
+}
@@ -0,0 +1,187 @@
+package postgres_store
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// webhookColumns is selected by every query that returns a models.Webhook,
+// events is selected as json because database/sql cannot scan a TEXT[]
+const webhookColumns = `id, url, secret, array_to_json(events), active, failures, created_at, owner`
+
+func (pg *PostGresStore) GetAllWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	rows, err := pg.DB.Query(`SELECT `+webhookColumns+` FROM webhooks WHERE owner = $1 ORDER BY id`, auth.Owner(ctx))
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	hooks := []models.Webhook{}
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, wrapErr(rows.Err())
+}
+
+func (pg *PostGresStore) GetWebhookByID(ctx context.Context, ID string) (models.Webhook, error) {
+	intID, err := parseID(ID)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	return scanWebhook(pg.DB.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = $1 AND owner = $2`, intID, auth.Owner(ctx)))
+}
+
+func (pg *PostGresStore) CreateWebhook(ctx context.Context, hook models.Webhook) (string, error) {
+	stmt := `INSERT INTO webhooks (url, secret, events, active, failures, created_at, owner) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+
+	insertedID := 0
+	err := pg.DB.QueryRow(stmt, hook.URL, hook.Secret, hook.Events, hook.Active, hook.Failures, hook.CreatedAt, auth.Owner(ctx)).Scan(&insertedID)
+	if err != nil {
+		return "", wrapErr(err)
+	}
+	return strconv.Itoa(insertedID), nil
+}
+
+// UpdateWebhook replaces every field of a webhook except its id, creation time and owner
+func (pg *PostGresStore) UpdateWebhook(ctx context.Context, ID string, hook models.Webhook) error {
+	intID, err := parseID(ID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `UPDATE webhooks SET url = $1, secret = $2, events = $3, active = $4, failures = $5 WHERE id = $6 AND owner = $7`
+
+	result, err := pg.DB.Exec(stmt, hook.URL, hook.Secret, hook.Events, hook.Active, hook.Failures, intID, auth.Owner(ctx))
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	_, err = checkRowsAffected(result)
+	return err
+}
+
+// AddWebhookFailure counts a failed delivery of a webhook and disables it once
+// its failures in a row reach disableAfter, in one statement so that concurrent
+// deliveries and updates through the API are not lost
+//
+// - returns the webhook after the update
+func (pg *PostGresStore) AddWebhookFailure(ctx context.Context, ID string, disableAfter int) (models.Webhook, error) {
+	intID, err := parseID(ID)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+
+	stmt := `UPDATE webhooks SET failures = failures + 1, active = active AND failures + 1 < $2 WHERE id = $1 AND owner = $3 RETURNING ` + webhookColumns
+
+	return scanWebhook(pg.DB.QueryRow(stmt, intID, disableAfter, auth.Owner(ctx)))
+}
+
+// ResetWebhookFailures clears the failures in a row of a webhook after a successful delivery
+func (pg *PostGresStore) ResetWebhookFailures(ctx context.Context, ID string) error {
+	intID, err := parseID(ID)
+	if err != nil {
+		return err
+	}
+
+	result, err := pg.DB.Exec(`UPDATE webhooks SET failures = 0 WHERE id = $1 AND owner = $2`, intID, auth.Owner(ctx))
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	_, err = checkRowsAffected(result)
+	return err
+}
+
+// DeleteWebhook
+//
+// webhook_deliveries cascades, so the delivery log is deleted as well
+func (pg *PostGresStore) DeleteWebhook(ctx context.Context, ID string) (int, error) {
+	intID, err := parseID(ID)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := pg.DB.Exec(`DELETE FROM webhooks WHERE id = $1 AND owner = $2`, intID, auth.Owner(ctx))
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+
+	return checkRowsAffected(result)
+}
+
+// AddDelivery
+//
+// - returns errs.ErrNotFound if the webhook was deleted in the meantime
+func (pg *PostGresStore) AddDelivery(delivery models.Delivery) error {
+	webhookID, err := parseID(delivery.WebhookID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO webhook_deliveries (id, webhook_id, event_id, event, attempt, status_code, error, success, delivered_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+	_, err = pg.DB.Exec(stmt, delivery.ID, webhookID, delivery.EventID, delivery.Event, delivery.Attempt, delivery.StatusCode, delivery.Error, delivery.Success, delivery.DeliveredAt)
+	return wrapErr(err)
+}
+
+// GetDeliveries returns the latest deliveries of a webhook, newest first
+//
+// a webhook of another user has no deliveries
+func (pg *PostGresStore) GetDeliveries(ctx context.Context, webhookID string, limit int) ([]models.Delivery, error) {
+	intID, err := parseID(webhookID)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := `SELECT d.id, d.webhook_id, d.event_id, d.event, d.attempt, d.status_code, d.error, d.success, d.delivered_at FROM webhook_deliveries d
+	JOIN webhooks w ON w.id = d.webhook_id
+	WHERE d.webhook_id = $1 AND w.owner = $3 ORDER BY d.delivered_at DESC, d.attempt DESC LIMIT $2`
+
+	rows, err := pg.DB.Query(stmt, intID, limit, auth.Owner(ctx))
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	deliveries := []models.Delivery{}
+	for rows.Next() {
+		delivery := models.Delivery{}
+		hookID := 0
+
+		err := rows.Scan(&delivery.ID, &hookID, &delivery.EventID, &delivery.Event, &delivery.Attempt, &delivery.StatusCode, &delivery.Error, &delivery.Success, &delivery.DeliveredAt)
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+		delivery.WebhookID = strconv.Itoa(hookID)
+		delivery.DeliveredAt = delivery.DeliveredAt.UTC()
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, wrapErr(rows.Err())
+}
+
+func scanWebhook(row scanner) (models.Webhook, error) {
+	hook := models.Webhook{}
+	ID := 0
+	events := []byte{}
+
+	err := row.Scan(&ID, &hook.URL, &hook.Secret, &events, &hook.Active, &hook.Failures, &hook.CreatedAt, &hook.Owner)
+	if err != nil {
+		return models.Webhook{}, wrapErr(err)
+	}
+	hook.ID = strconv.Itoa(ID)
+	hook.CreatedAt = hook.CreatedAt.UTC()
+
+	err = json.Unmarshal(events, &hook.Events)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	return hook, nil
+}
@@ -0,0 +1,169 @@
+// Package rank generates lexicographic sort keys for manually ordered lists
+//
+// a key can always be generated between two other keys, so moving an item
+// only changes that item's key and none of its neighbours
+//
+// keys only use 0-9 and a-z and are compared bytewise, the same way
+// Go compares strings and postgres compares text COLLATE "C"
+package rank
+
+import (
+	"errors"
+	"strings"
+)
+
+const digits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+const base = len(digits)
+
+// ErrNoRoom is returned by Between when lo is not strictly before hi
+var ErrNoRoom = errors.New("rank: no key fits between the given keys")
+
+// Between returns a key that sorts strictly after lo and strictly before hi
+//
+// - lo "" means the start of the list, hi "" means the end of the list
+// - generated keys never end in '0' so that there is always room before them
+func Between(lo, hi string) (string, error) {
+	if hi != "" && lo >= hi {
+		return "", ErrNoRoom
+	}
+
+	key := strings.Builder{}
+	bounded := hi != ""
+
+	for i := 0; ; i++ {
+		l := 0
+		if i < len(lo) {
+			l = strings.IndexByte(digits, lo[i])
+		}
+		h := base
+		if bounded {
+			if i >= len(hi) {
+				// the key so far equals hi, nothing longer can sort before it
+				return "", ErrNoRoom
+			}
+			h = strings.IndexByte(digits, hi[i])
+		}
+
+		switch {
+		case l == h:
+			key.WriteByte(digits[l])
+		case h-l > 1:
+			key.WriteByte(digits[(l+h)/2])
+			return key.String(), nil
+		default:
+			// no digit fits between l and h, keep l and only stay after lo from here on
+			key.WriteByte(digits[l])
+			bounded = false
+		}
+	}
+}
+
+// After returns a key that sorts after lo, used to append to the end of a list
+func After(lo string) string {
+	key, _ := Between(lo, "")
+	return key
+}
+
+// Spread returns n evenly spaced keys in ascending order
+//
+// used to give every item of a list a fresh key when the existing keys
+// are missing or collide
+func Spread(n int) []string {
+	width := 1
+	for capacity := base; capacity <= n; capacity *= base {
+		width++
+	}
+
+	space := 1
+	for range width {
+		space *= base
+	}
+
+	keys := make([]string, n)
+	for i := range n {
+		v := (i + 1) * space / (n + 1)
+
+		key := make([]byte, width, width+1)
+		for j := width - 1; j >= 0; j-- {
+			key[j] = digits[v%base]
+			v /= base
+		}
+		// a trailing middle digit keeps room on both sides of every key
+		keys[i] = string(append(key, digits[base/2]))
+	}
+	return keys
+}
+
+// Move works out the keys that change when the item at index from is moved
+// next to the item at index anchor
+//
+// - keys must be the keys of the list in their current sort order
+// - after places the item right after the anchor, otherwise right before it
+// - only the moved item changes, unless keys are missing or not strictly
+// ascending, then every item gets a key from Spread
+//
+// returns the new key of every item that changed, by index into keys
+func Move(keys []string, from, anchor int, after bool) map[int]string {
+	changed := map[int]string{}
+
+	if !ascending(keys) {
+		keys = Spread(len(keys))
+		for i, key := range keys {
+			changed[i] = key
+		}
+	}
+
+	// neighbours of the new position, skipping the item being moved
+	lo, hi := "", ""
+	if after {
+		lo = keys[anchor]
+		if next := neighbour(keys, anchor, from, 1); next != -1 {
+			hi = keys[next]
+		}
+	} else {
+		hi = keys[anchor]
+		if prev := neighbour(keys, anchor, from, -1); prev != -1 {
+			lo = keys[prev]
+		}
+	}
+
+	key, err := Between(lo, hi)
+	if err != nil {
+		// only possible with keys that were not generated by this package
+		keys = Spread(len(keys))
+		for i, key := range keys {
+			changed[i] = key
+		}
+		return mergeMove(changed, Move(keys, from, anchor, after))
+	}
+	changed[from] = key
+	return changed
+}
+
+func mergeMove(changed, moved map[int]string) map[int]string {
+	for i, key := range moved {
+		changed[i] = key
+	}
+	return changed
+}
+
+// neighbour returns the index next to i in the given direction, skipping skip
+func neighbour(keys []string, i, skip, direction int) int {
+	for j := i + direction; j >= 0 && j < len(keys); j += direction {
+		if j != skip {
+			return j
+		}
+	}
+	return -1
+}
+
+// ascending reports whether every key is set and strictly after the one before it
+func ascending(keys []string) bool {
+	for i, key := range keys {
+		if key == "" || (i > 0 && keys[i-1] >= key) {
+			return false
+		}
+	}
+	return true
+}
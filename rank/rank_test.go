@@ -0,0 +1,114 @@
+package rank
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBetween(t *testing.T) {
+	cases := []struct {
+		lo, hi string
+	}{
+		{"", ""},
+		{"", "i"},
+		{"i", ""},
+		{"a", "b"},
+		{"a", "a1"},
+		{"az", "b"},
+		{"zz", ""},
+		{"", "01"},
+		{"0000000010", "0000000011"},
+	}
+	for _, c := range cases {
+		got, err := Between(c.lo, c.hi)
+		if err != nil {
+			t.Fatalf("Between(%q, %q) returned %v", c.lo, c.hi, err)
+		}
+		if got <= c.lo || (c.hi != "" && got >= c.hi) {
+			t.Errorf("Between(%q, %q) = %q, not between", c.lo, c.hi, got)
+		}
+		if strings.HasSuffix(got, "0") {
+			t.Errorf("Between(%q, %q) = %q, ends in 0", c.lo, c.hi, got)
+		}
+	}
+}
+
+func TestBetweenNoRoom(t *testing.T) {
+	for _, c := range [][2]string{{"b", "a"}, {"a", "a"}, {"a", "a0"}} {
+		_, err := Between(c[0], c[1])
+		if err != ErrNoRoom {
+			t.Errorf("Between(%q, %q) returned %v, want ErrNoRoom", c[0], c[1], err)
+		}
+	}
+}
+
+func TestBetweenRepeatedly(t *testing.T) {
+	// keep inserting at the front and in the middle, keys must stay ordered
+	lo, hi := "", After("")
+	for range 200 {
+		key, err := Between(lo, hi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key <= lo || key >= hi {
+			t.Fatalf("Between(%q, %q) = %q, not between", lo, hi, key)
+		}
+		hi = key
+	}
+}
+
+func TestSpread(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 35, 36, 1000} {
+		keys := Spread(n)
+		if len(keys) != n {
+			t.Fatalf("Spread(%d) returned %d keys", n, len(keys))
+		}
+		if !ascending(keys) {
+			t.Errorf("Spread(%d) is not strictly ascending", n)
+		}
+	}
+}
+
+func TestMove(t *testing.T) {
+	keys := Spread(4)
+
+	// move the last item before the first
+	changed := Move(keys, 3, 0, false)
+	if len(changed) != 1 || changed[3] >= keys[0] {
+		t.Fatalf("Move before first changed %v", changed)
+	}
+
+	// move the first item after the second
+	changed = Move(keys, 0, 1, true)
+	if len(changed) != 1 || changed[0] <= keys[1] || changed[0] >= keys[2] {
+		t.Fatalf("Move after second changed %v", changed)
+	}
+
+	// move the first item after the last
+	changed = Move(keys, 0, 3, true)
+	if len(changed) != 1 || changed[0] <= keys[3] {
+		t.Fatalf("Move after last changed %v", changed)
+	}
+}
+
+func TestMoveRebalances(t *testing.T) {
+	// items created before ranks existed have no key
+	keys := []string{"", "", "", "i"}
+
+	changed := Move(keys, 0, 2, true)
+	if len(changed) != len(keys) {
+		t.Fatalf("expected every key to change, got %v", changed)
+	}
+
+	got := make([]string, len(keys))
+	for i, key := range changed {
+		got[i] = key
+	}
+
+	order := []int{0, 1, 2, 3}
+	slices.SortFunc(order, func(a, b int) int { return strings.Compare(got[a], got[b]) })
+	if !slices.Equal(order, []int{1, 2, 0, 3}) {
+		t.Errorf("order after move is %v", order)
+	}
+}
@@ -0,0 +1,122 @@
+package reminder
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// Notifier delivers a reminder
+type Notifier interface {
+	Notify(ctx context.Context, reminder models.DueReminder) error
+}
+
+// LogNotifier writes reminders to the log, it is the default notifier
+type LogNotifier struct {
+	Logger *log.Logger // defaults to the standard logger
+}
+
+func (n LogNotifier) Notify(ctx context.Context, reminder models.DueReminder) error {
+	logger := n.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Println("reminder:", message(reminder))
+	return nil
+}
+
+// Publisher queues events for the webhooks of their owner, see webhook.Dispatcher
+type Publisher interface {
+	Publish(event models.Event)
+}
+
+// EventNotifier publishes reminders as reminder.due events, so every reminder reaches
+// the webhooks its owner subscribed to reminder.due
+//
+// the publisher retries failed deliveries itself, a reminder counts as sent once it is published
+type EventNotifier struct {
+	Publisher Publisher
+}
+
+func (n EventNotifier) Notify(ctx context.Context, reminder models.DueReminder) error {
+	n.Publisher.Publish(models.Event{
+		ID:     bson.NewObjectID().Hex(),
+		Type:   models.EventReminderDue,
+		Time:   time.Now().UTC(),
+		ProjID: reminder.Todo.ProjID,
+		Data:   reminder,
+		Owner:  reminder.Owner,
+	})
+	return nil
+}
+
+// Users looks up the owners of reminders
+type Users interface {
+	// GetUserByID returns errs.ErrNotFound if there is no user ID
+	GetUserByID(ID string) (models.User, error)
+}
+
+// SMTPNotifier emails reminders through the SMTP server at Addr
+//
+// - each reminder goes to the email of the owner of its todo, see Users
+// - the reminders of todos without an owner, created while sign in was off, go to To,
+// they are an error when To is empty
+type SMTPNotifier struct {
+	Addr  string    // host:port
+	Auth  smtp.Auth // nil for servers without authentication
+	From  string
+	Users Users
+	To    []string
+}
+
+func (n SMTPNotifier) Notify(ctx context.Context, reminder models.DueReminder) error {
+	to, err := n.recipients(reminder)
+	if err != nil {
+		return err
+	}
+
+	msg := strings.Join([]string{
+		"From: " + n.From,
+		"To: " + strings.Join(to, ", "),
+		// the name is user input, a line break would start a new header
+		"Subject: Reminder: " + strings.NewReplacer("\r", " ", "\n", " ").Replace(reminder.Todo.Name),
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		message(reminder),
+		"",
+	}, "\r\n")
+
+	return smtp.SendMail(n.Addr, n.Auth, n.From, to, []byte(msg))
+}
+
+// recipients returns the addresses reminder is emailed to
+func (n SMTPNotifier) recipients(reminder models.DueReminder) ([]string, error) {
+	if reminder.Owner == "" {
+		if len(n.To) == 0 {
+			return nil, fmt.Errorf("todo %s has no owner and there are no recipients for todos without one", reminder.TodoID)
+		}
+		return n.To, nil
+	}
+
+	user, err := n.Users.GetUserByID(reminder.Owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the owner of todo %s: %w", reminder.TodoID, err)
+	}
+	return []string{user.Email}, nil
+}
+
+// message describes a reminder in one line
+func message(reminder models.DueReminder) string {
+	msg := fmt.Sprintf("%q (todo %s)", reminder.Todo.Name, reminder.TodoID)
+	if reminder.Todo.DueDate != nil {
+		msg += " is due at " + reminder.Todo.DueDate.Format(time.RFC3339)
+	}
+	return msg
+}
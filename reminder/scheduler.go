@@ -0,0 +1,91 @@
+// Package reminder sends the reminders of todos when they are due
+//
+// reminders are stored with their todo, see models.Reminder, so pending reminders
+// survive restarts and are rescheduled or cancelled together with the todo.
+// the Scheduler polls the store for reminders whose time has come and hands them to a Notifier
+package reminder
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// Store is the part of the todo store the Scheduler needs
+type Store interface {
+	// DueReminders returns the pending reminders of open todos that fire at or before now
+	DueReminders(now time.Time) ([]models.DueReminder, error)
+	// MarkReminderSent records that a reminder went out,
+	// returns errs.ErrNotFound if the reminder is gone or was already sent
+	MarkReminderSent(todoID, before string, sentAt time.Time) error
+}
+
+type Scheduler struct {
+	Store    Store
+	Notifier Notifier
+	Interval time.Duration    // how often the store is polled
+	Now      func() time.Time // defaults to time.Now
+}
+
+// NewScheduler returns a Scheduler that polls the store every interval
+func NewScheduler(store Store, notifier Notifier, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		Store:    store,
+		Notifier: notifier,
+		Interval: interval,
+		Now:      time.Now,
+	}
+}
+
+// Run sends due reminders every Interval until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.Send(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Send notifies every reminder that is due and marks it sent
+//
+// - a reminder whose notification fails stays pending and is retried on the next poll
+// - returns the number of reminders sent
+func (s *Scheduler) Send(ctx context.Context) int {
+	now := s.Now()
+	due, err := s.Store.DueReminders(now)
+	if err != nil {
+		log.Println("failed to fetch due reminders: ", err.Error())
+		return 0
+	}
+
+	sent := 0
+	for _, reminder := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		todoID := reminder.TodoID
+
+		err := s.Notifier.Notify(ctx, reminder)
+		if err != nil {
+			log.Printf("failed to send reminder %s for todo %s: %s\n", reminder.Reminder.Before, todoID, err.Error())
+			continue
+		}
+
+		err = s.Store.MarkReminderSent(todoID, reminder.Reminder.Before, now)
+		if err != nil {
+			log.Printf("failed to mark reminder %s for todo %s sent: %s\n", reminder.Reminder.Before, todoID, err.Error())
+			continue
+		}
+		sent++
+	}
+	return sent
+}
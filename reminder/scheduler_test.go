@@ -0,0 +1,199 @@
+package reminder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+var now = time.Date(2025, 1, 6, 8, 30, 0, 0, time.UTC)
+
+type stubStore struct {
+	todos map[string]models.TODO
+}
+
+func (s *stubStore) DueReminders(now time.Time) ([]models.DueReminder, error) {
+	due := []models.DueReminder{}
+	for todoID, todo := range s.todos {
+		if todo.Completed {
+			continue
+		}
+		for _, reminder := range todo.Reminders {
+			if reminder.SentAt == nil && !reminder.FireAt.After(now) {
+				due = append(due, models.DueReminder{TodoID: todoID, Todo: todo, Reminder: reminder})
+			}
+		}
+	}
+	return due, nil
+}
+
+func (s *stubStore) MarkReminderSent(todoID, before string, sentAt time.Time) error {
+	for i, reminder := range s.todos[todoID].Reminders {
+		if reminder.Before == before && reminder.SentAt == nil {
+			s.todos[todoID].Reminders[i].SentAt = &sentAt
+			return nil
+		}
+	}
+	return errs.ErrNotFound
+}
+
+type stubNotifier struct {
+	sent []string
+	err  error
+}
+
+func (n *stubNotifier) Notify(ctx context.Context, reminder models.DueReminder) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.sent = append(n.sent, reminder.TodoID+" "+reminder.Reminder.Before)
+	return nil
+}
+
+func todo(completed bool, dueDate time.Time, befores ...time.Duration) models.TODO {
+	todo := models.TODO{Name: "Dentist", DueDate: &dueDate, Completed: completed}
+	for _, before := range befores {
+		fireAt := dueDate.Add(-before)
+		todo.Reminders = append(todo.Reminders, models.Reminder{Before: before.String(), FireAt: &fireAt})
+	}
+	return todo
+}
+
+func newScheduler(store Store, notifier Notifier) *Scheduler {
+	scheduler := NewScheduler(store, notifier, time.Minute)
+	scheduler.Now = func() time.Time { return now }
+	return scheduler
+}
+
+func TestSend(t *testing.T) {
+	store := &stubStore{todos: map[string]models.TODO{
+		"1": todo(false, now.Add(30*time.Minute), 30*time.Minute, 10*time.Minute),
+		"2": todo(true, now.Add(time.Hour), time.Hour),
+		"3": todo(false, now.Add(2*time.Hour), 24*time.Hour),
+	}}
+	notifier := &stubNotifier{}
+	scheduler := newScheduler(store, notifier)
+
+	if sent := scheduler.Send(context.Background()); sent != 2 {
+		t.Fatalf("sent %d reminders, want 2: %v", sent, notifier.sent)
+	}
+	if sentAt := store.todos["1"].Reminders[0].SentAt; sentAt == nil || !sentAt.Equal(now) {
+		t.Errorf("reminder was not marked sent: %v", sentAt)
+	}
+	if store.todos["1"].Reminders[1].SentAt != nil {
+		t.Error("reminder that is not due yet was marked sent")
+	}
+
+	// sent reminders are not sent again
+	if sent := scheduler.Send(context.Background()); sent != 0 {
+		t.Errorf("sent %d reminders again", sent)
+	}
+}
+
+func TestSendRetriesFailedNotifications(t *testing.T) {
+	store := &stubStore{todos: map[string]models.TODO{
+		"1": todo(false, now.Add(time.Minute), 5*time.Minute),
+	}}
+	notifier := &stubNotifier{err: errors.New("unreachable")}
+	scheduler := newScheduler(store, notifier)
+
+	if sent := scheduler.Send(context.Background()); sent != 0 {
+		t.Fatalf("sent %d reminders with a failing notifier", sent)
+	}
+	if store.todos["1"].Reminders[0].SentAt != nil {
+		t.Fatal("failed reminder was marked sent")
+	}
+
+	notifier.err = nil
+	if sent := scheduler.Send(context.Background()); sent != 1 {
+		t.Errorf("sent %d reminders on retry, want 1", sent)
+	}
+}
+
+type stubPublisher struct {
+	events []models.Event
+}
+
+func (p *stubPublisher) Publish(event models.Event) {
+	p.events = append(p.events, event)
+}
+
+func TestEventNotifier(t *testing.T) {
+	publisher := &stubPublisher{}
+	notifier := EventNotifier{Publisher: publisher}
+	reminder := models.DueReminder{TodoID: "1", Todo: todo(false, now, time.Hour), Reminder: models.Reminder{Before: "1h0m0s"}, Owner: "ada"}
+	reminder.Todo.ProjID = "7"
+
+	err := notifier.Notify(context.Background(), reminder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(publisher.events) != 1 {
+		t.Fatalf("published %d events, want 1", len(publisher.events))
+	}
+	event := publisher.events[0]
+	if event.Type != models.EventReminderDue || event.Owner != "ada" || event.ProjID != "7" || event.ID == "" {
+		t.Errorf("published %+v", event)
+	}
+
+	// the owner is routed by the publisher and is not part of the payload
+	body, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := struct{ Data models.DueReminder }{}
+	err = json.Unmarshal(body, &received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Data.TodoID != "1" || received.Data.Todo.Name != "Dentist" || received.Data.Owner != "" {
+		t.Errorf("webhook would receive %+v", received.Data)
+	}
+}
+
+type stubUsers map[string]models.User
+
+func (u stubUsers) GetUserByID(ID string) (models.User, error) {
+	user, ok := u[ID]
+	if !ok {
+		return models.User{}, errs.ErrNotFound
+	}
+	return user, nil
+}
+
+func TestSMTPNotifierRecipients(t *testing.T) {
+	notifier := SMTPNotifier{Users: stubUsers{"ada": {ID: "ada", Email: "ada@example.com"}}}
+
+	to, err := notifier.recipients(models.DueReminder{TodoID: "1", Owner: "ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(to, []string{"ada@example.com"}) {
+		t.Errorf("emailed the reminder of ada to %v", to)
+	}
+
+	_, err = notifier.recipients(models.DueReminder{TodoID: "1", Owner: "bob"})
+	if !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("reminder of an unknown owner returned %v", err)
+	}
+
+	_, err = notifier.recipients(models.DueReminder{TodoID: "1"})
+	if err == nil {
+		t.Error("reminder without an owner or recipients did not return an error")
+	}
+
+	notifier.To = []string{"admin@example.com"}
+	to, err = notifier.recipients(models.DueReminder{TodoID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(to, notifier.To) {
+		t.Errorf("emailed the reminder without an owner to %v", to)
+	}
+}
@@ -0,0 +1,301 @@
+// Package rrule parses and evaluates a subset of RFC 5545 recurrence rules
+//
+// supported parts:
+//
+//	FREQ=DAILY|WEEKLY|MONTHLY|YEARLY (required)
+//	INTERVAL=<n>
+//	BYDAY=MO,WE,FR (MONTHLY also takes an ordinal, 1MO is the first monday, -1FR the last friday)
+//	COUNT=<n>
+//	UNTIL=<yyyymmdd> or <yyyymmddThhmmssZ>
+//
+// there is no DTSTART, the due date of the current occurrence is used in its place
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidRule is returned by Parse for rules outside the supported subset
+var ErrInvalidRule = errors.New("rrule: invalid recurrence rule")
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+var weekdays = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// Day is a BYDAY entry
+//
+// N is the ordinal within the month, 0 means every such weekday
+type Day struct {
+	Weekday time.Weekday
+	N       int
+}
+
+type Rule struct {
+	Freq     Frequency
+	Interval int
+	ByDay    []Day
+	Count    int        // 0 means no limit
+	Until    *time.Time // nil means no limit
+}
+
+// Parse parses a rule such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH"
+//
+// an "RRULE:" prefix is allowed
+func Parse(s string) (Rule, error) {
+	rule := Rule{Interval: 1}
+
+	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "RRULE:")
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return Rule{}, fmt.Errorf("%w: %q", ErrInvalidRule, part)
+		}
+
+		var err error
+		switch key {
+		case "FREQ":
+			rule.Freq = Frequency(value)
+			if !slices.Contains([]Frequency{Daily, Weekly, Monthly, Yearly}, rule.Freq) {
+				err = fmt.Errorf("unsupported FREQ %q", value)
+			}
+		case "INTERVAL":
+			rule.Interval, err = positive(value)
+		case "COUNT":
+			rule.Count, err = positive(value)
+		case "UNTIL":
+			rule.Until, err = parseUntil(value)
+		case "BYDAY":
+			rule.ByDay, err = parseByDay(value)
+		default:
+			err = fmt.Errorf("unsupported part %q", key)
+		}
+		if err != nil {
+			return Rule{}, fmt.Errorf("%w: %w", ErrInvalidRule, err)
+		}
+	}
+
+	if rule.Freq == "" {
+		return Rule{}, fmt.Errorf("%w: FREQ is required", ErrInvalidRule)
+	}
+	if rule.Count > 0 && rule.Until != nil {
+		return Rule{}, fmt.Errorf("%w: COUNT and UNTIL cannot both be set", ErrInvalidRule)
+	}
+	for _, day := range rule.ByDay {
+		if day.N != 0 && rule.Freq != Monthly {
+			return Rule{}, fmt.Errorf("%w: BYDAY ordinals are only supported with FREQ=MONTHLY", ErrInvalidRule)
+		}
+	}
+	if len(rule.ByDay) > 0 && rule.Freq == Yearly {
+		return Rule{}, fmt.Errorf("%w: BYDAY is not supported with FREQ=YEARLY", ErrInvalidRule)
+	}
+	return rule, nil
+}
+
+// String formats the rule in a canonical form that Parse accepts
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := []string{}
+		for _, day := range r.ByDay {
+			prefix := ""
+			if day.N != 0 {
+				prefix = strconv.Itoa(day.N)
+			}
+			days = append(days, prefix+weekdays[day.Weekday])
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Next returns the occurrence that follows the occurrence at t
+//
+// - n is the 1-based number of the occurrence at t, it is checked against COUNT
+// - the time of day of t is kept
+// - returns false when the rule has no more occurrences
+func (r Rule) Next(t time.Time, n int) (time.Time, bool) {
+	if r.Count > 0 && n >= r.Count {
+		return time.Time{}, false
+	}
+
+	var next time.Time
+	switch r.Freq {
+	case Daily:
+		next = r.nextDaily(t)
+	case Weekly:
+		next = r.nextWeekly(t)
+	case Monthly:
+		next = r.nextMonthly(t)
+	case Yearly:
+		next = r.nextYearly(t)
+	}
+
+	if next.IsZero() || (r.Until != nil && next.After(*r.Until)) {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// maxSteps bounds the search for rules that rarely or never match,
+// such as the 31st of every second month starting in february
+const maxSteps = 1000
+
+func (r Rule) nextDaily(t time.Time) time.Time {
+	for range maxSteps {
+		t = t.AddDate(0, 0, r.Interval)
+		if r.matchesDay(t) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (r Rule) nextWeekly(t time.Time) time.Time {
+	if len(r.ByDay) == 0 {
+		return t.AddDate(0, 0, 7*r.Interval)
+	}
+
+	// later days in the same week come first, weeks start on monday
+	weekStart := t.AddDate(0, 0, -mondayIndex(t.Weekday()))
+	for i := mondayIndex(t.Weekday()) + 1; i < 7; i++ {
+		if day := weekStart.AddDate(0, 0, i); r.matchesDay(day) {
+			return day
+		}
+	}
+
+	weekStart = weekStart.AddDate(0, 0, 7*r.Interval)
+	for i := range 7 {
+		if day := weekStart.AddDate(0, 0, i); r.matchesDay(day) {
+			return day
+		}
+	}
+	return time.Time{}
+}
+
+func (r Rule) nextMonthly(t time.Time) time.Time {
+	year, month, day := t.Date()
+
+	for step := range maxSteps {
+		// the first month is only searched for days after t
+		first := time.Date(year, month+time.Month(step*r.Interval), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+
+		if len(r.ByDay) == 0 {
+			// months without that day are skipped, as RFC 5545 does
+			candidate := first.AddDate(0, 0, day-1)
+			if step > 0 && candidate.Month() == first.Month() {
+				return candidate
+			}
+			continue
+		}
+
+		for candidate := first; candidate.Month() == first.Month(); candidate = candidate.AddDate(0, 0, 1) {
+			if candidate.After(t) && r.matchesMonthDay(candidate) {
+				return candidate
+			}
+		}
+	}
+	return time.Time{}
+}
+
+func (r Rule) nextYearly(t time.Time) time.Time {
+	for step := 1; step <= maxSteps; step++ {
+		// february 29th only exists in leap years, the other years are skipped
+		candidate := time.Date(t.Year()+step*r.Interval, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+		if candidate.Day() == t.Day() {
+			return candidate
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay reports whether t falls on one of the BYDAY weekdays, or true without BYDAY
+func (r Rule) matchesDay(t time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(r.ByDay, func(day Day) bool { return day.Weekday == t.Weekday() })
+}
+
+// matchesMonthDay is matchesDay that also checks the ordinal of the weekday within the month
+func (r Rule) matchesMonthDay(t time.Time) bool {
+	fromStart := (t.Day()-1)/7 + 1
+	daysInMonth := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	fromEnd := -((daysInMonth-t.Day())/7 + 1)
+
+	return slices.ContainsFunc(r.ByDay, func(day Day) bool {
+		return day.Weekday == t.Weekday() && (day.N == 0 || day.N == fromStart || day.N == fromEnd)
+	})
+}
+
+// mondayIndex numbers the weekdays from monday 0 to sunday 6
+func mondayIndex(day time.Weekday) int {
+	return (int(day) + 6) % 7
+}
+
+func positive(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%q is not a positive number", value)
+	}
+	return n, nil
+}
+
+func parseUntil(value string) (*time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("20060102", value)
+	if err != nil {
+		return nil, fmt.Errorf("UNTIL %q is not a date", value)
+	}
+	// a date means up to the end of that day
+	t = t.Add(24*time.Hour - time.Second)
+	return &t, nil
+}
+
+func parseByDay(value string) ([]Day, error) {
+	days := []Day{}
+	for _, entry := range strings.Split(value, ",") {
+		if len(entry) < 2 {
+			return nil, fmt.Errorf("BYDAY %q is not a weekday", entry)
+		}
+		code, ordinal := entry[len(entry)-2:], entry[:len(entry)-2]
+
+		weekday := slices.Index(weekdays, code)
+		if weekday == -1 {
+			return nil, fmt.Errorf("BYDAY %q is not a weekday", entry)
+		}
+
+		n := 0
+		if ordinal != "" {
+			var err error
+			n, err = strconv.Atoi(ordinal)
+			if err != nil || n == 0 || n < -5 || n > 5 {
+				return nil, fmt.Errorf("BYDAY %q has an invalid ordinal", entry)
+			}
+		}
+		days = append(days, Day{Weekday: time.Weekday(weekday), N: n})
+	}
+	return days, nil
+}
@@ -0,0 +1,106 @@
+package rrule
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"FREQ=DAILY", "FREQ=DAILY"},
+		{"rrule:freq=weekly;interval=2;byday=mo,th", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH"},
+		{"FREQ=MONTHLY;BYDAY=-1FR;COUNT=12", "FREQ=MONTHLY;BYDAY=-1FR;COUNT=12"},
+		{"FREQ=YEARLY;INTERVAL=1;UNTIL=20300101", "FREQ=YEARLY;UNTIL=20300101T235959Z"},
+	}
+	for _, c := range cases {
+		rule, err := Parse(c.in)
+		if err != nil {
+			t.Fatalf("Parse(%q) returned %v", c.in, err)
+		}
+		if got := rule.String(); got != c.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=2;UNTIL=20300101",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=YEARLY;BYDAY=MO",
+		"FREQ=DAILY;BYMONTH=1",
+		"FREQ=DAILY;UNTIL=soon",
+	} {
+		_, err := Parse(in)
+		if !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("Parse(%q) returned %v, want ErrInvalidRule", in, err)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	cases := []struct {
+		rule  string
+		from  time.Time
+		wants []time.Time
+	}{
+		{"FREQ=DAILY;INTERVAL=3", date(2025, 1, 30), []time.Time{date(2025, 2, 2), date(2025, 2, 5)}},
+		// 2025-01-06 is a monday
+		{"FREQ=DAILY;BYDAY=MO,FR", date(2025, 1, 6), []time.Time{date(2025, 1, 10), date(2025, 1, 13)}},
+		{"FREQ=WEEKLY", date(2025, 1, 6), []time.Time{date(2025, 1, 13)}},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE", date(2025, 1, 6), []time.Time{date(2025, 1, 8), date(2025, 1, 20), date(2025, 1, 22)}},
+		// sunday is the last day of the week
+		{"FREQ=WEEKLY;BYDAY=MO,SU", date(2025, 1, 6), []time.Time{date(2025, 1, 12), date(2025, 1, 13)}},
+		// months without a 31st are skipped
+		{"FREQ=MONTHLY", date(2025, 1, 31), []time.Time{date(2025, 3, 31), date(2025, 5, 31)}},
+		{"FREQ=MONTHLY;INTERVAL=3", date(2025, 11, 15), []time.Time{date(2026, 2, 15)}},
+		{"FREQ=MONTHLY;BYDAY=1MO", date(2025, 1, 6), []time.Time{date(2025, 2, 3), date(2025, 3, 3)}},
+		{"FREQ=MONTHLY;BYDAY=-1FR", date(2025, 1, 31), []time.Time{date(2025, 2, 28), date(2025, 3, 28)}},
+		{"FREQ=YEARLY", date(2024, 2, 29), []time.Time{date(2028, 2, 29)}},
+		{"FREQ=YEARLY;INTERVAL=2", date(2025, 6, 1), []time.Time{date(2027, 6, 1)}},
+	}
+	for _, c := range cases {
+		rule, err := Parse(c.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		current := c.from
+		for i, want := range c.wants {
+			next, ok := rule.Next(current, i+1)
+			if !ok || !next.Equal(want) {
+				t.Fatalf("%s: occurrence after %s = %s, %v, want %s", c.rule, current, next, ok, want)
+			}
+			current = next
+		}
+	}
+}
+
+func TestNextEnds(t *testing.T) {
+	rule, _ := Parse("FREQ=DAILY;COUNT=3")
+	if _, ok := rule.Next(date(2025, 1, 1), 2); !ok {
+		t.Error("second of three occurrences has no next")
+	}
+	if _, ok := rule.Next(date(2025, 1, 2), 3); ok {
+		t.Error("third of three occurrences has a next")
+	}
+
+	rule, _ = Parse("FREQ=WEEKLY;UNTIL=20250110")
+	if _, ok := rule.Next(date(2025, 1, 3), 1); !ok {
+		t.Error("occurrence on the UNTIL date is missing")
+	}
+	if _, ok := rule.Next(date(2025, 1, 10), 2); ok {
+		t.Error("occurrence after UNTIL was returned")
+	}
+}
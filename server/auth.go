@@ -0,0 +1,283 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/history"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// WithAuth requires every request but the ones to /auth/ to be signed in
+// with an access token signed by issuer, see authenticate
+func WithAuth(issuer *auth.Issuer) Option {
+	return func(ts *TodoServer) {
+		ts.issuer = issuer
+	}
+}
+
+// refreshRequest is the body of a refresh or logout request
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// authenticate rejects requests without a valid "Authorization: Bearer <token>" header
+//
+// - the token is an access token or a personal access token, see usePersonalToken
+// - the requests are run as the user of the token, their changes are recorded in the history as made by that user
+// - the /auth/ endpoints are let through, they are how a client gets a token.
+// preflight requests never get here, see corsHandler
+// - without WithAuth every request is let through
+func (ts TodoServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ts.issuer == nil || strings.HasPrefix(r.URL.Path, "/auth/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			unauthorized(w, r, fmt.Errorf("%w: an Authorization: Bearer header is required", errs.ErrUnauthorized))
+			return
+		}
+
+		if auth.IsPersonalToken(token) {
+			ctx, err := ts.usePersonalToken(r, token)
+			if errors.Is(err, errs.ErrForbidden) {
+				writeErr(w, r, err)
+				return
+			}
+			if err != nil {
+				unauthorized(w, r, err)
+				return
+			}
+			ctx = history.WithActor(ctx, auth.UserID(ctx))
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		claims, err := ts.issuer.Verify(token)
+		if err != nil {
+			unauthorized(w, r, err)
+			return
+		}
+
+		ctx := auth.WithUser(r.Context(), claims.Subject)
+		ctx = history.WithActor(ctx, claims.Subject)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// unauthorized answers a request that is not signed in with a 401
+func unauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="todoapp"`)
+	writeErr(w, r, err)
+}
+
+// handleRegister
+//
+// endpoint: "POST /auth/register"
+//
+// - takes {"email": "...", "password": "..."}
+// - the email is lowercased, it has to be unused, otherwise 409
+// - the password has to be auth.MinPasswordLength to auth.MaxPasswordLength bytes long
+// - responds with the created user, the client then logs in with handleLogin
+func (ts TodoServer) handleRegister(w http.ResponseWriter, r *http.Request) {
+	credentials := models.Credentials{}
+	err := json.NewDecoder(r.Body).Decode(&credentials)
+	if err != nil {
+		log.Println("failed to unmarshal json to Credentials struct: ", err.Error())
+		writeErr(w, r, fmt.Errorf("%w: %w", errs.ErrValidation, err))
+		return
+	}
+
+	email := normalizeEmail(credentials.Email)
+	_, err = mail.ParseAddress(email)
+	if err != nil {
+		writeErr(w, r, fmt.Errorf("%w: email is not a valid email address", errs.ErrValidation))
+		return
+	}
+
+	hash, err := auth.HashPassword(credentials.Password)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	user := models.User{Email: email, PasswordHash: hash, CreatedAt: time.Now().UTC()}
+	user.ID, err = ts.TodoStore.CreateUser(user)
+	if err != nil {
+		if errors.Is(err, errs.ErrConflict) {
+			writeErr(w, r, fmt.Errorf("%w: %s is already registered", errs.ErrConflict, email))
+			return
+		}
+		log.Println("failed to create user on data store: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, user)
+}
+
+// handleLogin
+//
+// endpoint: "POST /auth/login"
+//
+// - takes {"email": "...", "password": "..."}
+// - a wrong email or password is a 401, which of the two is not given away
+// - responds with an access token and a refresh token, see issueTokens
+func (ts TodoServer) handleLogin(w http.ResponseWriter, r *http.Request) {
+	credentials := models.Credentials{}
+	err := json.NewDecoder(r.Body).Decode(&credentials)
+	if err != nil {
+		log.Println("failed to unmarshal json to Credentials struct: ", err.Error())
+		writeErr(w, r, fmt.Errorf("%w: %w", errs.ErrValidation, err))
+		return
+	}
+
+	user, err := ts.TodoStore.GetUserByEmail(normalizeEmail(credentials.Email))
+	if err != nil && !errors.Is(err, errs.ErrNotFound) {
+		writeErr(w, r, err)
+		return
+	}
+	// an unknown user has no hash, the check still takes as long
+	err = auth.CheckPassword(user.PasswordHash, credentials.Password)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	tokens, err := ts.issueTokens(user.ID, bson.NewObjectID().Hex())
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// handleRefresh
+//
+// endpoint: "POST /auth/refresh"
+//
+// - takes {"refreshToken": "..."}
+// - the refresh token is spent, the response has a new access token and refresh token
+// - an unknown or expired refresh token is a 401
+// - a refresh token that was already spent is a 401 and signs the login out,
+// every refresh token issued since the login stops working
+func (ts TodoServer) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	token, err := ts.useRefreshToken(r)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	tokens, err := ts.issueTokens(token.UserID, token.Family)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// handleLogout
+//
+// endpoint: "POST /auth/logout"
+//
+// - takes {"refreshToken": "..."}
+// - every refresh token of the login stops working, access tokens already issued last until they expire
+// - responds with 204 No Content
+func (ts TodoServer) handleLogout(w http.ResponseWriter, r *http.Request) {
+	token, err := ts.useRefreshToken(r)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	_, err = ts.TodoStore.DeleteRefreshTokens(token.Family)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// useRefreshToken spends the refresh token in the body of r
+//
+// - returns errs.ErrUnauthorized if the token is unknown, expired or already spent.
+// spending a token twice deletes its family
+func (ts TodoServer) useRefreshToken(r *http.Request) (models.RefreshToken, error) {
+	body := refreshRequest{}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return models.RefreshToken{}, fmt.Errorf("%w: %w", errs.ErrValidation, err)
+	}
+	if body.RefreshToken == "" {
+		return models.RefreshToken{}, fmt.Errorf("%w: refreshToken is required", errs.ErrValidation)
+	}
+
+	now := ts.issuer.Now()
+	token, err := ts.TodoStore.UseRefreshToken(auth.HashToken(body.RefreshToken), now)
+	switch {
+	case errors.Is(err, errs.ErrNotFound):
+		return models.RefreshToken{}, fmt.Errorf("%w: invalid refresh token", errs.ErrUnauthorized)
+	case errors.Is(err, errs.ErrConflict):
+		log.Printf("refresh token of family %s was reused, signing the login out", token.Family)
+		_, err = ts.TodoStore.DeleteRefreshTokens(token.Family)
+		if err != nil {
+			return models.RefreshToken{}, err
+		}
+		return models.RefreshToken{}, fmt.Errorf("%w: the refresh token was already used, please log in again", errs.ErrUnauthorized)
+	case err != nil:
+		return models.RefreshToken{}, err
+	}
+
+	if !now.Before(token.ExpiresAt) {
+		return models.RefreshToken{}, fmt.Errorf("%w: the refresh token has expired, please log in again", errs.ErrUnauthorized)
+	}
+	return token, nil
+}
+
+// issueTokens signs an access token for the user userID and stores a new refresh token of family
+func (ts TodoServer) issueTokens(userID, family string) (models.TokenPair, error) {
+	accessToken, expiresAt, err := ts.issuer.Sign(userID)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	refreshToken, hash, err := auth.NewRefreshToken()
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+	now := ts.issuer.Now().UTC()
+	err = ts.TodoStore.CreateRefreshToken(models.RefreshToken{
+		Hash:      hash,
+		UserID:    userID,
+		Family:    family,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ts.issuer.RefreshTTL),
+	})
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	return models.TokenPair{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresAt:    expiresAt.UTC(),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
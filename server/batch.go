@@ -0,0 +1,267 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+	"github.com/ganglinwu/todoapp-backend-v1/validate"
+)
+
+// maxBatchOps caps the number of operations in a single batch request
+const maxBatchOps = 100
+
+// batchRequest is the body of POST /todo/batch
+//
+//	{"atomic": true, "ops": [{"op": "update", "id": "...", "todo": {"completed": true}}]}
+type batchRequest struct {
+	Atomic bool             `json:"atomic"`
+	Ops    []models.BatchOp `json:"ops"`
+}
+
+// batchBody is how a batch request is decoded, every op is decoded on its own
+// so that an op that is not valid only fails itself, see decodeBatchOp
+type batchBody struct {
+	Atomic bool              `json:"atomic"`
+	Ops    []json.RawMessage `json:"ops"`
+}
+
+// batchOpBody is how an op of a batch request is decoded, the todo is decoded on its own to check its fields
+type batchOpBody struct {
+	Op     string          `json:"op"`
+	ID     string          `json:"id"`
+	ProjID string          `json:"projId"`
+	Todo   json.RawMessage `json:"todo"`
+}
+
+// batchResponse holds one result per operation, in the same order as the request
+//
+// Error is only set when an atomic batch failed
+type batchResponse struct {
+	Error   *errorBody    `json:"error,omitempty"`
+	Results []batchResult `json:"results"`
+}
+
+type batchResult struct {
+	Op     string     `json:"op"`
+	ID     string     `json:"id,omitempty"`
+	Status int        `json:"status"`
+	Error  *errorBody `json:"error,omitempty"`
+}
+
+// handleBatchTodos
+//
+// endpoint: "POST /todo/batch"
+//
+// - runs a list of create, update and delete operations on todos in one request
+// - create takes the projId to add the todo to, update and delete take the todo id
+// - update ops are merged with the current todo the same way as PATCH /todo/{ID}
+// - only reaches the projects and todos of the signed in user, not the ones shared with them
+// - responds 200 with a status code per operation, an operation with unknown fields, fields of the wrong type
+// or a todo that breaks the rules of models.TODO gets a 422 that names them, see decodeBatchOp
+// - atomic: either every operation is applied or none is, a failure responds
+// with the status code of the failing operation and the others are reported as aborted
+func (ts TodoServer) handleBatchTodos(w http.ResponseWriter, r *http.Request) {
+	body := batchBody{}
+	err := validate.Decode(r.Body, &body)
+	if err != nil {
+		log.Println("failed to unmarshal json to batch request: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+	if len(body.Ops) == 0 || len(body.Ops) > maxBatchOps {
+		writeErr(w, r, fmt.Errorf("%w: a batch needs between 1 and %d ops", errs.ErrValidation, maxBatchOps))
+		return
+	}
+
+	// every op is decoded first, the response reports the results of all of them
+	batch := batchRequest{Atomic: body.Atomic, Ops: make([]models.BatchOp, len(body.Ops))}
+	decodeErrs := make([]error, len(body.Ops))
+	for i, raw := range body.Ops {
+		batch.Ops[i], decodeErrs[i] = decodeBatchOp(raw)
+	}
+
+	results := make([]models.BatchResult, len(batch.Ops))
+
+	// ops that are ready to be sent to the store, index maps them back to the request
+	ops := []models.BatchOp{}
+	index := []int{}
+	completes := []bool{}
+
+	for i, op := range batch.Ops {
+		var completed bool
+		err = decodeErrs[i]
+		if err == nil {
+			op, completed, err = ts.prepareBatchOp(r.Context(), op)
+		}
+		if err != nil {
+			results[i] = models.BatchResult{ID: op.ID, Err: err}
+			if batch.Atomic {
+				writeBatch(w, r, batch, models.AbortBatch(batch.Ops, results, i))
+				return
+			}
+			continue
+		}
+		ops = append(ops, op)
+		index = append(index, i)
+		completes = append(completes, completed)
+	}
+
+	if len(ops) > 0 {
+		stored, err := ts.TodoStore.BatchTodos(r.Context(), ops, batch.Atomic)
+		if err != nil {
+			log.Println("failed to run batch on data store: ", err.Error())
+			writeErr(w, r, err)
+			return
+		}
+		for j, result := range stored {
+			results[index[j]] = result
+			if result.Err == nil {
+				ts.publishBatchOp(r.Context(), ops[j], result.ID, completes[j])
+			}
+		}
+	}
+
+	writeBatch(w, r, batch, results)
+}
+
+// decodeBatchOp decodes an op of a batch request
+//
+// - returns validate.Errors for unknown fields and fields of the wrong type,
+// the fields of the todo are named "todo.<field>", see todoFields
+// - the op is returned with whatever was decoded, so that an op that failed is still reported by its op and id
+func decodeBatchOp(raw json.RawMessage) (models.BatchOp, error) {
+	body := batchOpBody{}
+	err := validate.Decode(bytes.NewReader(raw), &body)
+	op := models.BatchOp{Op: body.Op, ID: body.ID, ProjID: body.ProjID}
+	if err != nil {
+		return op, err
+	}
+
+	if len(body.Todo) > 0 && string(body.Todo) != "null" {
+		err = validate.Decode(bytes.NewReader(body.Todo), &op.Todo)
+		if errors.Is(err, errs.ErrValidation) {
+			return op, validate.Invalid("todo", "has to be an object")
+		}
+		if err != nil {
+			return op, todoFields(err)
+		}
+	}
+	return op, nil
+}
+
+// todoFields names the fields in the validate.Errors of the todo of a batch op "todo.<field>",
+// other errors are returned as they are
+func todoFields(err error) error {
+	invalid := validate.Errors{}
+	if !errors.As(err, &invalid) {
+		return err
+	}
+
+	named := make(validate.Errors, len(invalid))
+	for i, field := range invalid {
+		named[i] = validate.FieldError{Field: "todo." + field.Field, Message: field.Message}
+	}
+	return named
+}
+
+// prepareBatchOp checks op and turns the todo in it into the todo to store
+//
+// - a delete gets the ProjID of the todo it deletes
+// - the version of the todo in an update or delete is the version it expects, 0 matches any,
+// see checkVersion
+// - returns validate.Errors if the todo breaks the rules of models.TODO, its fields are named "todo.<field>"
+// - also reports whether op is an update that completes an open todo
+func (ts TodoServer) prepareBatchOp(ctx context.Context, op models.BatchOp) (models.BatchOp, bool, error) {
+	var err error
+	completes := false
+
+	switch op.Op {
+	case models.BatchCreate:
+		if op.ProjID == "" {
+			return op, false, fmt.Errorf("%w: create needs a projId", errs.ErrValidation)
+		}
+		op.Todo, err = ts.newTodo(op.Todo)
+	case models.BatchUpdate:
+		if op.ID == "" {
+			return op, false, fmt.Errorf("%w: update needs an id", errs.ErrValidation)
+		}
+		current := models.TODO{}
+		current, err = ts.TodoStore.GetTodoByID(ctx, op.ID)
+		if err != nil {
+			return op, false, err
+		}
+		err = checkVersion(op.Todo.Version, current.Version)
+		if err != nil {
+			return op, false, err
+		}
+		op.Todo, err = ts.mergeTodo(current, op.Todo)
+		completes = op.Todo.Completed && !current.Completed
+	case models.BatchDelete:
+		if op.ID == "" {
+			return op, false, fmt.Errorf("%w: delete needs an id", errs.ErrValidation)
+		}
+		// the delete event names the project the todo was in
+		current := models.TODO{}
+		current, err = ts.TodoStore.GetTodoByID(ctx, op.ID)
+		if err != nil {
+			return op, false, err
+		}
+		err = checkVersion(op.Todo.Version, current.Version)
+		op.ProjID = current.ProjID
+	default:
+		return op, false, fmt.Errorf("%w: unknown op %q", errs.ErrValidation, op.Op)
+	}
+	return op, completes, todoFields(err)
+}
+
+// publishBatchOp sends the events of a batch operation that succeeded
+func (ts TodoServer) publishBatchOp(ctx context.Context, op models.BatchOp, ID string, completes bool) {
+	switch op.Op {
+	case models.BatchCreate:
+		ts.publishTodo(ctx, models.EventTodoCreated, ID, false)
+	case models.BatchUpdate:
+		ts.publishTodo(ctx, models.EventTodoUpdated, ID, completes)
+	case models.BatchDelete:
+		ts.publish(ctx, models.EventTodoDeleted, op.ProjID, models.DeletedData{ID: ID})
+	}
+}
+
+// writeBatch maps the result of every operation onto a status code
+//
+// a failed atomic batch responds with the status code of the operation that failed
+func writeBatch(w http.ResponseWriter, r *http.Request, batch batchRequest, results []models.BatchResult) {
+	response := batchResponse{Results: make([]batchResult, len(results))}
+	status := http.StatusOK
+
+	for i, result := range results {
+		op := batch.Ops[i]
+		response.Results[i] = batchResult{Op: op.Op, ID: result.ID, Status: http.StatusOK}
+		if op.Op == models.BatchCreate {
+			response.Results[i].Status = http.StatusCreated
+		}
+		if result.Err == nil {
+			continue
+		}
+
+		opStatus, body := mapErr(r, result.Err)
+		response.Results[i].Status = opStatus
+		response.Results[i].Error = &body
+
+		if batch.Atomic && !errors.Is(result.Err, errs.ErrAborted) {
+			status = opStatus
+			response.Error = &errorBody{
+				Code:      body.Code,
+				Message:   fmt.Sprintf("op %d failed: %s", i, body.Message),
+				RequestID: body.RequestID,
+			}
+		}
+	}
+	writeJSON(w, status, response)
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig is the cross-origin resource sharing policy of the server, see WithCORS
+//
+// - AllowedOrigins are origins like "https://app.example.com", an origin with a
+// wildcard subdomain like "https://*.example.com" allows every subdomain of example.com
+// but not example.com itself, "*" allows every origin
+// - AllowedMethods and AllowedHeaders are what a preflight request may ask for,
+// AllowedHeaders "*" allows every header
+// - AllowCredentials lets browsers send cookies and HTTP authentication along
+// - MaxAge is how long browsers may cache a preflight response, 0 leaves it to the browser
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// DefaultCORSConfig allows the frontend dev server and the methods and headers the endpoints use
+var DefaultCORSConfig = CORSConfig{
+	AllowedOrigins: []string{"http://localhost:5173"},
+	AllowedMethods: []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPatch, http.MethodDelete},
+	AllowedHeaders: []string{"Content-Type", "Authorization", "X-Requested-With", "If-Match", idempotencyKeyHeader, requestIDHeader},
+	MaxAge:         10 * time.Minute,
+}
+
+// exposedHeaders are the response headers browsers let the frontend read
+var exposedHeaders = []string{"Location", "ETag", "Link", requestIDHeader, idempotencyReplayedHeader}
+
+// WithCORS replaces DefaultCORSConfig with config
+func WithCORS(config CORSConfig) Option {
+	return func(ts *TodoServer) {
+		ts.cors = config
+	}
+}
+
+// corsHandler applies the CORS policy of the server to every request
+//
+// - every response varies by Origin, caches must not hand one origin the response of another
+// - requests from an allowed origin get Access-Control-Allow-Origin and the exposed headers,
+// requests from other origins get no CORS headers and the browser keeps the response from them
+// - preflight requests are answered here and never reach the endpoints,
+// a preflight asking for a method or header that is not allowed gets no CORS headers either
+func (ts TodoServer) corsHandler(next http.Handler) http.Handler {
+	config := ts.cors
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Add("Vary", "Origin")
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && config.allowsOrigin(origin)
+		if preflight {
+			if allowed && config.allowsPreflight(r) {
+				config.setAllowOrigin(header, origin)
+				header.Set("Access-Control-Allow-Methods", strings.Join(config.AllowedMethods, ", "))
+				header.Set("Access-Control-Allow-Headers", config.allowHeaders(r))
+				if config.MaxAge > 0 {
+					header.Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge.Seconds())))
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed {
+			config.setAllowOrigin(header, origin)
+			header.Set("Access-Control-Expose-Headers", strings.Join(exposedHeaders, ", "))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowsOrigin reports whether origin matches one of the allowed origins, see CORSConfig
+func (config CORSConfig) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range config.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+
+		scheme, domain, ok := strings.Cut(allowed, "://*.")
+		if !ok {
+			continue
+		}
+		subdomain, ok := strings.CutPrefix(origin, scheme+"://")
+		if !ok {
+			continue
+		}
+		subdomain, ok = strings.CutSuffix(subdomain, "."+domain)
+		if ok && subdomain != "" && !strings.ContainsAny(subdomain, "/:@") {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsPreflight reports whether the method and headers a preflight request asks for are allowed
+func (config CORSConfig) allowsPreflight(r *http.Request) bool {
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !slices.Contains(config.AllowedMethods, method) {
+		return false
+	}
+	if slices.Contains(config.AllowedHeaders, "*") {
+		return true
+	}
+	for _, requested := range requestedHeaders(r) {
+		if !slices.ContainsFunc(config.AllowedHeaders, func(allowed string) bool { return strings.EqualFold(allowed, requested) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// allowHeaders is the Access-Control-Allow-Headers of a preflight response,
+// with AllowedHeaders "*" it is the headers the preflight asks for
+func (config CORSConfig) allowHeaders(r *http.Request) string {
+	if slices.Contains(config.AllowedHeaders, "*") {
+		return strings.Join(requestedHeaders(r), ", ")
+	}
+	return strings.Join(config.AllowedHeaders, ", ")
+}
+
+// setAllowOrigin allows origin, "*" is never sent back because it does not work with credentials
+func (config CORSConfig) setAllowOrigin(header http.Header, origin string) {
+	header.Set("Access-Control-Allow-Origin", origin)
+	if config.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// requestedHeaders lists the headers in the Access-Control-Request-Headers of a preflight request
+func requestedHeaders(r *http.Request) []string {
+	headers := []string{}
+	for _, value := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				headers = append(headers, name)
+			}
+		}
+	}
+	return headers
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+)
+
+// setETag sets the ETag of the todo or project in the response, "<version>"
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(version)))
+}
+
+// ifMatch reads the If-Match header of a request into the version it expects
+//
+// - returns 0 when there is no If-Match or it is "*", the change is then unconditional
+// - only a single strong ETag as set by setETag is understood, a weak one never matches
+// - returns errs.ErrPreconditionFailed for any other value, it cannot match an ETag we handed out
+func ifMatch(r *http.Request) (int, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	unquoted, err := strconv.Unquote(header)
+	if err != nil {
+		return 0, fmt.Errorf("%w: If-Match %s does not match", errs.ErrPreconditionFailed, header)
+	}
+	version, err := strconv.Atoi(unquoted)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("%w: If-Match %s does not match", errs.ErrPreconditionFailed, header)
+	}
+	return version, nil
+}
+
+// checkVersion returns errs.ErrPreconditionFailed if the request expects another version than current
+//
+// expected 0 matches any version
+func checkVersion(expected, current int) error {
+	if expected != 0 && expected != current {
+		return fmt.Errorf("%w: expected version %d, the current version is %d", errs.ErrPreconditionFailed, expected, current)
+	}
+	return nil
+}
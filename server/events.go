@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// EventPublisher receives the change events emitted by the handlers, see models.Event
+//
+// Publish is called while the request is being handled, so it must not block
+type EventPublisher interface {
+	Publish(event models.Event)
+}
+
+// Option configures a TodoServer, see NewTodoServer
+type Option func(*TodoServer)
+
+// WithEvents publishes the change events of the server to publishers
+func WithEvents(publishers ...EventPublisher) Option {
+	return func(ts *TodoServer) {
+		ts.events = append(ts.events, publishers...)
+	}
+}
+
+// publish sends an event about a change in project projID to every publisher
+//
+// the event belongs to the owner of ctx, see models.Event.Owner
+func (ts TodoServer) publish(ctx context.Context, eventType, projID string, data any) {
+	if len(ts.events) == 0 {
+		return
+	}
+
+	event := models.Event{
+		ID:     newRequestID(),
+		Type:   eventType,
+		Time:   time.Now().UTC(),
+		ProjID: projID,
+		Data:   data,
+		Owner:  auth.Owner(ctx),
+	}
+	for _, publisher := range ts.events {
+		publisher.Publish(event)
+	}
+}
+
+// publishTodo sends a todo event with the stored todo as its data
+//
+// - completed also sends todo.completed, it is set when an update completes an open todo
+func (ts TodoServer) publishTodo(ctx context.Context, eventType, todoID string, completed bool) {
+	if len(ts.events) == 0 {
+		return
+	}
+
+	todo, err := ts.TodoStore.GetTodoByID(ctx, todoID)
+	if err != nil {
+		log.Println("failed to fetch todo for event: ", err.Error())
+		return
+	}
+	ts.publish(ctx, eventType, todo.ProjID, todo)
+	if completed {
+		ts.publish(ctx, models.EventTodoCompleted, todo.ProjID, todo)
+	}
+}
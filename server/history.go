@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// handleGetTodoHistory
+//
+// endpoint: "GET /todo/{ID}/history"
+//
+// - lists the changes made to the todo, oldest first
+// - the history stays readable after the todo is deleted
+// - viewers of the project of the todo can read it
+func (ts TodoServer) handleGetTodoHistory(w http.ResponseWriter, r *http.Request) {
+	todoID := r.PathValue("ID")
+	ctx, err := historyAccess(r.Context(), todoID, models.RoleViewer, ts.todoAccess)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	r = r.WithContext(ctx)
+	ts.writeHistory(w, r, models.HistoryTodo, todoID, func() error {
+		_, err := ts.TodoStore.GetTodoByID(r.Context(), todoID)
+		return err
+	})
+}
+
+// handleGetProjHistory
+//
+// endpoint: "GET /proj/{ID}/history"
+//
+// - lists the changes made to the project itself, oldest first,
+// the changes to its todos are in their own history
+// - the history stays readable after the project is deleted
+// - viewers of the project can read it
+func (ts TodoServer) handleGetProjHistory(w http.ResponseWriter, r *http.Request) {
+	projID := r.PathValue("ID")
+	ctx, err := historyAccess(r.Context(), projID, models.RoleViewer, ts.projAccess)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	r = r.WithContext(ctx)
+	ts.writeHistory(w, r, models.HistoryProject, projID, func() error {
+		_, err := ts.TodoStore.GetProjByID(r.Context(), projID)
+		return err
+	})
+}
+
+// historyAccess checks the role of the user of ctx with access, see projAccess
+//
+// a purged todo or project has no access to check, its history is looked up in the user's own data
+func historyAccess(ctx context.Context, ID, least string, access func(context.Context, string, string) (context.Context, error)) (context.Context, error) {
+	accessCtx, err := access(ctx, ID, least)
+	if errors.Is(err, errs.ErrNotFound) {
+		return ctx, nil
+	}
+	return accessCtx, err
+}
+
+// writeHistory responds with the history of the todo or project ID
+//
+// an empty history is only returned for todos and projects that exist,
+// they may have been created before their changes were recorded
+func (ts TodoServer) writeHistory(w http.ResponseWriter, r *http.Request, kind, ID string, exists func() error) {
+	entries, err := ts.TodoStore.GetHistory(r.Context(), kind, ID)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	if len(entries) == 0 {
+		err = exists()
+		if err != nil {
+			writeErr(w, r, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
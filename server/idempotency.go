@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotencyReplayedHeader is set on a response that was replayed from an earlier request
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	// idempotencyTTL is how long a response is replayed for
+	idempotencyTTL = 24 * time.Hour
+	// maxIdempotencyKeyLength is the longest Idempotency-Key accepted, a UUID is 36
+	maxIdempotencyKeyLength = 255
+)
+
+// replayedHeaders are the response headers that are stored with the response and replayed
+var replayedHeaders = []string{"Content-Type", "Location", "ETag", "Link"}
+
+// idempotent makes a POST endpoint safe to retry with an Idempotency-Key header
+//
+// - requests without the header run as usual
+// - the first request with a key reserves it, runs and stores its response
+// - a retry with the same key gets the stored response, with an Idempotent-Replayed header,
+// until it expires after idempotencyTTL. the handler does not run again
+// - a retry while the first request is still running is a 409
+// - reusing a key for a different method, path or body is a 422
+// - a response with a 5xx status is not stored, the key is released so that the request can be retried,
+// the same goes for a handler that writes nothing or panics, e.g. with http.ErrAbortHandler
+// - keys are per user, see ownKey
+func (ts TodoServer) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeErr(w, r, fmt.Errorf("%w: %s is longer than %d characters", errs.ErrValidation, idempotencyKeyHeader, maxIdempotencyKeyLength))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeErr(w, r, fmt.Errorf("%w: %w", errs.ErrValidation, err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := time.Now().UTC()
+		record := models.IdempotencyRecord{
+			Key:         ownKey(r.Context(), key),
+			Fingerprint: fingerprint(r, body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(idempotencyTTL),
+		}
+
+		err = ts.TodoStore.CreateIdempotencyRecord(record)
+		if errors.Is(err, errs.ErrConflict) {
+			ts.replay(w, r, record)
+			return
+		}
+		if err != nil {
+			writeErr(w, r, err)
+			return
+		}
+
+		// runs while a panic of next unwinds, before it reaches net/http
+		stored := false
+		defer func() {
+			if stored {
+				return
+			}
+			err := ts.TodoStore.DeleteIdempotencyRecord(record.Key)
+			if err != nil {
+				log.Printf("request %s failed to release its idempotency key: %s", requestIDFrom(r), err.Error())
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next(recorder, r)
+
+		if recorder.status == 0 || recorder.status >= http.StatusInternalServerError {
+			return
+		}
+		record.StatusCode = recorder.status
+		record.Header = map[string]string{}
+		for _, name := range replayedHeaders {
+			if value := recorder.Header().Get(name); value != "" {
+				record.Header[name] = value
+			}
+		}
+		record.Body = recorder.body.Bytes()
+		err = ts.TodoStore.SaveIdempotencyRecord(record)
+		if err != nil {
+			log.Printf("request %s failed to store the response for its idempotency key: %s", requestIDFrom(r), err.Error())
+			return
+		}
+		stored = true
+	}
+}
+
+// replay answers a request whose Idempotency-Key is already in use with the stored response
+func (ts TodoServer) replay(w http.ResponseWriter, r *http.Request, request models.IdempotencyRecord) {
+	stored, err := ts.TodoStore.GetIdempotencyRecord(request.Key)
+	if errors.Is(err, errs.ErrNotFound) {
+		// it expired or was released in the meantime
+		err = fmt.Errorf("%w: the request with this %s did not finish, please retry", errs.ErrConflict, idempotencyKeyHeader)
+	}
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	switch {
+	case stored.Fingerprint != request.Fingerprint:
+		writeErr(w, r, fmt.Errorf("%w: %s %q", errs.ErrIdempotencyReused, idempotencyKeyHeader, r.Header.Get(idempotencyKeyHeader)))
+	case stored.StatusCode == 0:
+		writeErr(w, r, fmt.Errorf("%w: the request with this %s is still in progress", errs.ErrConflict, idempotencyKeyHeader))
+	default:
+		for name, value := range stored.Header {
+			w.Header().Set(name, value)
+		}
+		w.Header().Set(idempotencyReplayedHeader, "true")
+		w.WriteHeader(stored.StatusCode)
+		w.Write(stored.Body)
+	}
+}
+
+// ownKey namespaces key by the user of ctx, two users sending the same key never see each other's responses
+func ownKey(ctx context.Context, key string) string {
+	owner := auth.UserID(ctx)
+	if owner == "" {
+		return key
+	}
+	return owner + ":" + key
+}
+
+// fingerprint hashes what makes two requests the same request
+func fingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder passes a response through while keeping a copy of its status and body
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
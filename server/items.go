@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// itemPatch is the body of "PATCH /todo/{ID}/items/{itemID}"
+//
+// pointers tell a missing field apart from false or ""
+type itemPatch struct {
+	Name      *string `json:"name"`
+	Completed *bool   `json:"completed"`
+}
+
+// handleAddItem
+//
+// endpoint: "POST /todo/{ID}/items"
+//
+// - takes {"name": "...", "completed": false}, name is required
+// - the item is added to the end of the checklist
+// - responds with the created item and its URL in the Location header
+func (ts TodoServer) handleAddItem(w http.ResponseWriter, r *http.Request) {
+	item := models.ChecklistItem{}
+	err := json.NewDecoder(r.Body).Decode(&item)
+	if err != nil {
+		log.Println("failed to unmarshal json to ChecklistItem struct: ", err.Error())
+		writeErr(w, r, fmt.Errorf("%w: %w", errs.ErrValidation, err))
+		return
+	}
+	if item.Name == "" {
+		writeErr(w, r, fmt.Errorf("%w: name is required", errs.ErrValidation))
+		return
+	}
+
+	todoID := r.PathValue("ID")
+
+	itemID, err := ts.TodoStore.AddItem(r.Context(), todoID, models.ChecklistItem{Name: item.Name, Completed: item.Completed})
+	if err != nil {
+		log.Println("failed to add item on data store: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+
+	created, err := ts.findItem(r.Context(), todoID, itemID)
+	if err != nil {
+		log.Println("failed to fetch created item from data store: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/todo/"+todoID+"/items/"+itemID)
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// handleUpdateItem
+//
+// endpoint: "PATCH /todo/{ID}/items/{itemID}"
+//
+// - takes {"name": "..."} and/or {"completed": true}, missing fields are left as they are
+// - responds with the updated item
+func (ts TodoServer) handleUpdateItem(w http.ResponseWriter, r *http.Request) {
+	patch := itemPatch{}
+	err := json.NewDecoder(r.Body).Decode(&patch)
+	if err != nil {
+		log.Println("failed to unmarshal json to itemPatch struct: ", err.Error())
+		writeErr(w, r, fmt.Errorf("%w: %w", errs.ErrValidation, err))
+		return
+	}
+	if patch.Name != nil && *patch.Name == "" {
+		writeErr(w, r, fmt.Errorf("%w: name cannot be empty", errs.ErrValidation))
+		return
+	}
+
+	todoID := r.PathValue("ID")
+	itemID := r.PathValue("itemID")
+
+	item, err := ts.findItem(r.Context(), todoID, itemID)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	if patch.Name != nil {
+		item.Name = *patch.Name
+	}
+	if patch.Completed != nil {
+		item.Completed = *patch.Completed
+	}
+
+	err = ts.TodoStore.UpdateItem(r.Context(), todoID, itemID, item)
+	if err != nil {
+		log.Println("failed to update item on data store: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, item)
+}
+
+// handleReorderItem
+//
+// endpoint: "POST /todo/{ID}/items/{itemID}/reorder"
+//
+// - takes {"before": "<item ID>"} or {"after": "<item ID>"}
+// - the other item has to be on the same checklist
+// - responds with the todo, its items in their new order
+func (ts TodoServer) handleReorderItem(w http.ResponseWriter, r *http.Request) {
+	place := models.Placement{}
+	err := json.NewDecoder(r.Body).Decode(&place)
+	if err != nil {
+		log.Println("failed to unmarshal json to Placement struct: ", err.Error())
+		writeErr(w, r, fmt.Errorf("%w: %w", errs.ErrValidation, err))
+		return
+	}
+
+	todoID := r.PathValue("ID")
+	itemID := r.PathValue("itemID")
+
+	if (place.Before == "") == (place.After == "") {
+		writeErr(w, r, fmt.Errorf("%w: exactly one of before and after is required", errs.ErrValidation))
+		return
+	}
+	if place.Before == itemID || place.After == itemID {
+		writeErr(w, r, fmt.Errorf("%w: an item cannot be placed next to itself", errs.ErrValidation))
+		return
+	}
+
+	err = ts.TodoStore.ReorderItem(r.Context(), todoID, itemID, place)
+	if err != nil {
+		log.Println("failed to reorder item on data store: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+
+	todo, err := ts.TodoStore.GetTodoByID(r.Context(), todoID)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, todo)
+}
+
+// handleDeleteItem
+//
+// endpoint: "DELETE /todo/{ID}/items/{itemID}"
+func (ts TodoServer) handleDeleteItem(w http.ResponseWriter, r *http.Request) {
+	deletedCount, err := ts.TodoStore.DeleteItem(r.Context(), r.PathValue("ID"), r.PathValue("itemID"))
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deleteResponse{DeletedCount: deletedCount})
+}
+
+// findItem looks up a single item on a todo's checklist
+//
+// - returns errs.ErrNotFound if the todo or the item does not exist
+func (ts TodoServer) findItem(ctx context.Context, todoID, itemID string) (models.ChecklistItem, error) {
+	todo, err := ts.TodoStore.GetTodoByID(ctx, todoID)
+	if err != nil {
+		return models.ChecklistItem{}, err
+	}
+	for _, item := range todo.Items {
+		if item.ID == itemID {
+			return item, nil
+		}
+	}
+	return models.ChecklistItem{}, fmt.Errorf("%w: item %q is not on todo %q", errs.ErrNotFound, itemID, todoID)
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// labelColor is the only color format accepted, "#rrggbb"
+var labelColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// labelPatch is the body of "PATCH /label/{ID}"
+//
+// pointers tell a missing field apart from ""
+type labelPatch struct {
+	Name  *string `json:"name"`
+	Color *string `json:"color"`
+}
+
+// handleGetAllLabels
+//
+// endpoint: "GET /label"
+func (ts TodoServer) handleGetAllLabels(w http.ResponseWriter, r *http.Request) {
+	labels, err := ts.TodoStore.GetAllLabels(r.Context())
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, labels)
+}
+
+// handleCreateLabel
+//
+// endpoint: "POST /label"
+//
+// - takes {"name": "...", "color": "#rrggbb"}, name is required and unique
+// - responds with the created label and its URL in the Location header
+func (ts TodoServer) handleCreateLabel(w http.ResponseWriter, r *http.Request) {
+	label := models.Label{}
+	err := json.NewDecoder(r.Body).Decode(&label)
+	if err != nil {
+		log.Println("failed to unmarshal json to Label struct: ", err.Error())
+		writeErr(w, r, fmt.Errorf("%w: %w", errs.ErrValidation, err))
+		return
+	}
+
+	label, err = validateLabel(models.Label{Name: label.Name, Color: label.Color})
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	insertedID, err := ts.TodoStore.CreateLabel(r.Context(), label)
+	if err != nil {
+		log.Println("failed to create label on data store: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+	label.ID = insertedID
+
+	w.Header().Set("Location", "/label/"+insertedID)
+	writeJSON(w, http.StatusCreated, label)
+}
+
+// handleUpdateLabel
+//
+// endpoint: "PATCH /label/{ID}"
+//
+// - takes {"name": "..."} and/or {"color": "..."}, missing fields are left as they are
+// - responds with the updated label
+func (ts TodoServer) handleUpdateLabel(w http.ResponseWriter, r *http.Request) {
+	patch := labelPatch{}
+	err := json.NewDecoder(r.Body).Decode(&patch)
+	if err != nil {
+		log.Println("failed to unmarshal json to labelPatch struct: ", err.Error())
+		writeErr(w, r, fmt.Errorf("%w: %w", errs.ErrValidation, err))
+		return
+	}
+
+	ID := r.PathValue("ID")
+
+	label, err := ts.TodoStore.GetLabelByID(r.Context(), ID)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	if patch.Name != nil {
+		label.Name = *patch.Name
+	}
+	if patch.Color != nil {
+		label.Color = *patch.Color
+	}
+
+	label, err = validateLabel(label)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	err = ts.TodoStore.UpdateLabel(r.Context(), ID, label)
+	if err != nil {
+		log.Println("failed to update label on data store: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, label)
+}
+
+// handleDeleteLabel
+//
+// endpoint: "DELETE /label/{ID}"
+//
+// - the label is also removed from every todo that has it
+func (ts TodoServer) handleDeleteLabel(w http.ResponseWriter, r *http.Request) {
+	deletedCount, err := ts.TodoStore.DeleteLabel(r.Context(), r.PathValue("ID"))
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deleteResponse{DeletedCount: deletedCount})
+}
+
+// validateLabel checks the name and color of a label and lower cases the color
+//
+// - returns errs.ErrValidation if the name is empty or the color is not "#rrggbb"
+func validateLabel(label models.Label) (models.Label, error) {
+	label.Name = strings.TrimSpace(label.Name)
+	if label.Name == "" {
+		return models.Label{}, fmt.Errorf("%w: name is required", errs.ErrValidation)
+	}
+	if label.Color != "" && !labelColor.MatchString(label.Color) {
+		return models.Label{}, fmt.Errorf("%w: color must be in the form #rrggbb", errs.ErrValidation)
+	}
+	label.Color = strings.ToLower(label.Color)
+	return label, nil
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+	"github.com/ganglinwu/todoapp-backend-v1/validate"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// decodePatch decodes the JSON merge patch in the body of a PATCH request into patch, see RFC 7396
+//
+// - the body is application/merge-patch+json, application/json and a body without a
+// Content-Type are taken as a merge patch too
+// - returns errs.ErrUnsupportedMedia for any other media type, the Accept-Patch header
+// of the response names the one that is accepted
+// - returns validate.Errors for unknown fields and fields of the wrong type
+func decodePatch(w http.ResponseWriter, r *http.Request, patch any) error {
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != models.MergePatchType && mediaType != "application/json") {
+			w.Header().Set("Accept-Patch", models.MergePatchType)
+			return fmt.Errorf("%w: %q is not %s", errs.ErrUnsupportedMedia, contentType, models.MergePatchType)
+		}
+	}
+
+	err := validate.Decode(r.Body, patch)
+	if err != nil {
+		log.Println("failed to unmarshal json merge patch: ", err.Error())
+		return err
+	}
+	return nil
+}
+
+// patchTodo applies a merge patch to the current todo
+//
+// - returns the patched todo and the fields that changed, see TodoStore.PatchTodo
+// - a field left out of the patch keeps its value, a field that is null is cleared,
+// so a null completed is false and a null name is invalid
+// - Version is kept, the store only writes the patch if the todo is still at that version
+// - the recurrence rule is checked again when it or the due date changes, and the reminders
+// are rescheduled when they or the due date change, see checkRecurrence and scheduleReminders
+// - returns validate.Errors if the fields the patch sets break the rules of models.TODO, the due date is not RFC3339
+// or it is before the update, see checkDueDate
+// - returns errs.ErrValidation if the recurrence rule is invalid or a reminder is invalid
+func (ts TodoServer) patchTodo(current models.TODO, patch models.TodoPatch) (models.TODO, []string, error) {
+	todo := current
+	fields := []string{}
+	if apply(&todo.Name, patch.Name) {
+		fields = append(fields, models.FieldName)
+	}
+	if apply(&todo.Description, patch.Description) {
+		fields = append(fields, models.FieldDescription)
+	}
+	if apply(&todo.Priority, patch.Priority) {
+		fields = append(fields, models.FieldPriority)
+	}
+	if apply(&todo.Completed, patch.Completed) {
+		fields = append(fields, models.FieldCompleted)
+	}
+	if apply(&todo.Labels, patch.Labels) {
+		todo.Labels = labelSet(todo.Labels)
+		fields = append(fields, models.FieldLabels)
+	}
+	if apply(&todo.Recurrence, patch.Recurrence) {
+		fields = append(fields, models.FieldRecurrence)
+	}
+	if apply(&todo.Reminders, patch.Reminders) {
+		fields = append(fields, models.FieldReminders)
+	}
+
+	dueDate, dueDateField := patch.DueDate, models.FieldDueDate
+	if patch.DueDateString.Set {
+		if patch.DueDate.Set {
+			return models.TODO{}, nil, validate.Invalid("dueDateString", "cannot be set along with dueDate")
+		}
+		dueDate, dueDateField = patch.DueDateString, "dueDateString"
+	}
+	if dueDate.Set {
+		todo.DueDate = nil
+		if !dueDate.Null {
+			var err error
+			todo.DueDate, err = ts.checkDueDate(dueDateField, dueDate.Value)
+			if err != nil {
+				return models.TODO{}, nil, err
+			}
+		}
+		fields = append(fields, models.FieldDueDate)
+	}
+
+	err := validate.Fields(todo, fields...)
+	if err != nil {
+		return models.TODO{}, nil, err
+	}
+
+	if slices.Contains(fields, models.FieldRecurrence) || dueDate.Set {
+		todo, err = checkRecurrence(todo)
+		if err != nil {
+			return models.TODO{}, nil, err
+		}
+		if todo.Occurrence != current.Occurrence {
+			fields = append(fields, models.FieldOccurrence)
+		}
+	}
+	if slices.Contains(fields, models.FieldReminders) || dueDate.Set {
+		todo, err = scheduleReminders(todo, current.Reminders)
+		if err != nil {
+			return models.TODO{}, nil, err
+		}
+		if !slices.Contains(fields, models.FieldReminders) {
+			fields = append(fields, models.FieldReminders)
+		}
+	}
+
+	todo.Updated_at = &bson.Timestamp{T: uint32(time.Now().Unix())}
+	return todo, fields, nil
+}
+
+// apply sets field to the value of the patch if the field is in it, null sets the zero value
+//
+// - returns whether the field is in the patch
+func apply[T any](field *T, patch models.Patch[T]) bool {
+	if patch.Set {
+		*field = patch.Value
+	}
+	return patch.Set
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// parseTodoQuery reads the filter and sort query parameters used by
+// "GET /todo" and "GET /proj/{ID}"
+//
+//	completed=true|false
+//	priority=high,mid
+//	project=<project ID>
+//	dueBefore=<RFC3339>, dueAfter=<RFC3339>
+//	name=<substring>
+//	label=<label ID>,<label ID>
+//	labelMatch=any|all (defaults to any)
+//	sort=dueDate|priority|updated_at|rank
+//	order=asc|desc
+//
+// and the pagination parameters described in parsePage, a page holds defaultPageLimit todos unless limit is given
+func parseTodoQuery(values url.Values) (models.TodoQuery, error) {
+	q := models.TodoQuery{
+		ProjID: values.Get("project"),
+		Name:   values.Get("name"),
+	}
+
+	if completed := values.Get("completed"); completed != "" {
+		b, err := strconv.ParseBool(completed)
+		if err != nil {
+			return models.TodoQuery{}, fmt.Errorf("%w: completed must be true or false", errs.ErrValidation)
+		}
+		q.Completed = &b
+	}
+
+	q.Priority = splitList(values.Get("priority"))
+	q.Labels = splitList(values.Get("label"))
+
+	switch labelMatch := strings.ToLower(values.Get("labelMatch")); labelMatch {
+	case "", "any":
+	case "all":
+		q.AllLabels = true
+	default:
+		return models.TodoQuery{}, fmt.Errorf("%w: labelMatch must be any or all", errs.ErrValidation)
+	}
+
+	for param, dest := range map[string]**time.Time{"dueBefore": &q.DueBefore, "dueAfter": &q.DueAfter} {
+		value := values.Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return models.TodoQuery{}, fmt.Errorf("%w: %s must be an RFC3339 date", errs.ErrValidation, param)
+		}
+		*dest = &t
+	}
+
+	page, err := parsePage(values, defaultPageLimit)
+	if err != nil {
+		return models.TodoQuery{}, err
+	}
+	q.Page = page
+
+	switch sortBy := values.Get("sort"); sortBy {
+	case "", models.SortByDueDate, models.SortByPriority, models.SortByUpdatedAt, models.SortByRank:
+		q.SortBy = sortBy
+	default:
+		return models.TodoQuery{}, fmt.Errorf("%w: sort must be one of %s, %s, %s, %s", errs.ErrValidation, models.SortByDueDate, models.SortByPriority, models.SortByUpdatedAt, models.SortByRank)
+	}
+
+	switch order := strings.ToLower(values.Get("order")); order {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return models.TodoQuery{}, fmt.Errorf("%w: order must be asc or desc", errs.ErrValidation)
+	}
+
+	return q, nil
+}
+
+// splitList splits a comma separated query parameter, dropping empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// maxPageLimit caps the page size a client can ask for,
+// defaultPageLimit is the page size of the lists when the client does not ask for one
+const (
+	maxPageLimit     = 500
+	defaultPageLimit = 100
+)
+
+// parsePage reads the pagination query parameters
+//
+//	limit=<1 to maxPageLimit>
+//	cursor=<next cursor from the previous page>
+//
+// without a limit a page holds defaultLimit items, the rest is on the next pages
+func parsePage(values url.Values, defaultLimit int) (models.Page, error) {
+	page := models.Page{Cursor: values.Get("cursor"), Limit: defaultLimit}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return models.Page{}, fmt.Errorf("%w: limit must be between 1 and %d", errs.ErrValidation, maxPageLimit)
+		}
+		page.Limit = n
+	}
+
+	return page, nil
+}
+
+// setNextLink adds a Link header pointing at the next page
+//
+// the request's own query parameters are kept, only the cursor is replaced
+func setNextLink(w http.ResponseWriter, r *http.Request, next string) {
+	if next == "" {
+		return
+	}
+	values := r.URL.Query()
+	values.Set("cursor", next)
+
+	nextURL := url.URL{Path: r.URL.Path, RawQuery: values.Encode()}
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL.String()))
+}
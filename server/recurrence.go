@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+	"github.com/ganglinwu/todoapp-backend-v1/rrule"
+)
+
+// checkRecurrence validates the recurrence rule of a todo about to be stored
+// and puts it in canonical form
+//
+// - a recurring todo needs a due date, the next due date is worked out from it
+// - the first occurrence of a recurring todo is number 1
+// - returns errs.ErrValidation if the rule is not supported
+func checkRecurrence(todo models.TODO) (models.TODO, error) {
+	if todo.Recurrence == "" {
+		todo.Occurrence = 0
+		return todo, nil
+	}
+
+	rule, err := rrule.Parse(todo.Recurrence)
+	if err != nil {
+		return models.TODO{}, fmt.Errorf("%w: %w", errs.ErrValidation, err)
+	}
+	if todo.DueDate == nil {
+		return models.TODO{}, fmt.Errorf("%w: a recurring todo needs a due date", errs.ErrValidation)
+	}
+
+	todo.Recurrence = rule.String()
+	todo.Occurrence = max(todo.Occurrence, 1)
+	return todo, nil
+}
+
+// createNextOccurrence creates the todo that follows a completed occurrence of a recurring todo
+//
+// - the next todo is a copy with the next due date, it is not completed,
+// its checklist items are unchecked and its reminders are pending
+// - returns "" if the rule has no more occurrences
+func (ts TodoServer) createNextOccurrence(ctx context.Context, projID string, todo models.TODO) (string, error) {
+	rule, err := rrule.Parse(todo.Recurrence)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errs.ErrValidation, err)
+	}
+
+	dueDate, ok := rule.Next(*todo.DueDate, todo.Occurrence)
+	if !ok {
+		return "", nil
+	}
+
+	next, err := scheduleReminders(models.TODO{
+		Name:        todo.Name,
+		Description: todo.Description,
+		DueDate:     &dueDate,
+		Priority:    todo.Priority,
+		Updated_at:  &bson.Timestamp{T: uint32(time.Now().Unix())},
+		Labels:      todo.Labels,
+		Recurrence:  todo.Recurrence,
+		Occurrence:  todo.Occurrence + 1,
+		Reminders:   reminderBefores(todo.Reminders),
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	nextID, err := ts.TodoStore.CreateTodo(ctx, projID, next)
+	if err != nil {
+		return "", err
+	}
+
+	models.SortItems(todo.Items)
+	for _, item := range todo.Items {
+		_, err := ts.TodoStore.AddItem(ctx, nextID, models.ChecklistItem{Name: item.Name})
+		if err != nil {
+			log.Println("failed to copy checklist item to next occurrence: ", err.Error())
+		}
+	}
+	return nextID, nil
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// scheduleReminders validates the reminders of a todo about to be stored
+// and works out when each of them fires
+//
+// - Before is a duration such as "30m" or "24h", it is put in canonical form
+// and reminders with the same duration are dropped
+// - reminders need a due date, they fire Before the due date
+// - a reminder that was already sent stays sent unless its fire time changed,
+// so moving the due date reschedules it
+// - returns errs.ErrValidation if a duration is invalid or not positive
+func scheduleReminders(todo models.TODO, current []models.Reminder) (models.TODO, error) {
+	if len(todo.Reminders) == 0 {
+		todo.Reminders = nil
+		return todo, nil
+	}
+	if todo.DueDate == nil {
+		return models.TODO{}, fmt.Errorf("%w: reminders need a due date", errs.ErrValidation)
+	}
+
+	befores := []time.Duration{}
+	for _, reminder := range todo.Reminders {
+		before, err := time.ParseDuration(strings.TrimSpace(reminder.Before))
+		if err != nil {
+			return models.TODO{}, fmt.Errorf("%w: reminder %w", errs.ErrValidation, err)
+		}
+		if before <= 0 {
+			return models.TODO{}, fmt.Errorf("%w: reminder %q is not before the due date", errs.ErrValidation, reminder.Before)
+		}
+		befores = append(befores, before)
+	}
+	// earliest reminder first
+	slices.SortFunc(befores, func(a, b time.Duration) int { return int(b - a) })
+	befores = slices.Compact(befores)
+
+	reminders := []models.Reminder{}
+	for _, before := range befores {
+		fireAt := todo.DueDate.Add(-before)
+		reminder := models.Reminder{Before: before.String(), FireAt: &fireAt}
+
+		i := slices.IndexFunc(current, func(r models.Reminder) bool { return r.Before == reminder.Before })
+		if i != -1 && current[i].FireAt != nil && current[i].FireAt.Equal(fireAt) {
+			reminder.SentAt = current[i].SentAt
+		}
+		reminders = append(reminders, reminder)
+	}
+	todo.Reminders = reminders
+	return todo, nil
+}
+
+// reminderBefores strips the schedule from reminders so they can be scheduled for another todo
+func reminderBefores(reminders []models.Reminder) []models.Reminder {
+	befores := []models.Reminder{}
+	for _, reminder := range reminders {
+		befores = append(befores, models.Reminder{Before: reminder.Before})
+	}
+	return befores
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/validate"
+)
+
+// stable error codes returned in the "code" field of an error response
+const (
+	codeInvalidID          = "invalid_id"
+	codeValidationFailed   = "validation_failed"
+	codeNotFound           = "not_found"
+	codeConflict           = "conflict"
+	codePreconditionFailed = "precondition_failed"
+	codeUnavailable        = "unavailable"
+	codeAborted            = "aborted"
+	codeIdempotencyReused  = "idempotency_key_reused"
+	codeUnauthorized       = "unauthorized"
+	codeForbidden          = "forbidden"
+	codeInvalidFields      = "invalid_fields"
+	codeUnsupportedMedia   = "unsupported_media_type"
+	codeInternal           = "internal_error"
+)
+
+// errorMapping is the single place where the errs taxonomy is turned into HTTP status codes
+//
+// order matters: the first match wins
+var errorMapping = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{errs.ErrNotFound, http.StatusNotFound, codeNotFound},
+	{errs.ErrInvalidID, http.StatusBadRequest, codeInvalidID},
+	{errs.ErrValidation, http.StatusBadRequest, codeValidationFailed},
+	{errs.ErrConflict, http.StatusConflict, codeConflict},
+	{errs.ErrIdAlreadyInUse, http.StatusConflict, codeConflict},
+	{errs.ErrPreconditionFailed, http.StatusPreconditionFailed, codePreconditionFailed},
+	{errs.ErrUnavailable, http.StatusServiceUnavailable, codeUnavailable},
+	{errs.ErrAborted, http.StatusFailedDependency, codeAborted},
+	{errs.ErrIdempotencyReused, http.StatusUnprocessableEntity, codeIdempotencyReused},
+	{errs.ErrUnauthorized, http.StatusUnauthorized, codeUnauthorized},
+	{errs.ErrForbidden, http.StatusForbidden, codeForbidden},
+	{errs.ErrInvalidFields, http.StatusUnprocessableEntity, codeInvalidFields},
+	{errs.ErrUnsupportedMedia, http.StatusUnsupportedMediaType, codeUnsupportedMedia},
+}
+
+type ctxKey int
+
+const requestIDKey ctxKey = iota
+
+const requestIDHeader = "X-Request-ID"
+
+// errorResponse is the envelope every failed request is answered with
+//
+//	{"error": {"code": "not_found", "message": "...", "requestId": "..."}}
+//
+// an invalid_fields error also has the invalid fields in "details", see validate.Errors
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code      string                `json:"code"`
+	Message   string                `json:"message"`
+	RequestID string                `json:"requestId,omitempty"`
+	Details   []validate.FieldError `json:"details,omitempty"`
+}
+
+// deleteResponse is returned by the DELETE endpoints
+type deleteResponse struct {
+	DeletedCount int `json:"deletedCount"`
+}
+
+// withRequestID tags every request with an ID so that error responses
+// and log lines can be correlated.
+//
+// - an incoming X-Request-ID header is reused
+// - otherwise a random ID is generated
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		ctx := context.WithValue(r.Context(), requestIDKey, requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+func requestIDFrom(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDKey).(string)
+	return requestID
+}
+
+// writeJSON encodes v as the response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Println("failed to encode response into json:", err.Error())
+	}
+}
+
+// writeError writes the error envelope with the given status code
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeJSON(w, status, errorResponse{Error: errorBody{
+		Code:      code,
+		Message:   message,
+		RequestID: requestIDFrom(r),
+	}})
+}
+
+// writeErr maps err onto a status code and error code using errorMapping
+//
+// - errors that are not part of the errs taxonomy become a 500
+// - the details of a 500 are logged but not sent to the client
+func writeErr(w http.ResponseWriter, r *http.Request, err error) {
+	status, body := mapErr(r, err)
+	writeJSON(w, status, errorResponse{Error: body})
+}
+
+// mapErr looks err up in errorMapping and returns its status code and error body
+func mapErr(r *http.Request, err error) (int, errorBody) {
+	for _, mapping := range errorMapping {
+		if errors.Is(err, mapping.err) {
+			body := errorBody{Code: mapping.code, Message: err.Error(), RequestID: requestIDFrom(r)}
+			invalid := validate.Errors{}
+			if errors.As(err, &invalid) {
+				body.Details = invalid
+			}
+			return mapping.status, body
+		}
+	}
+	log.Printf("request %s failed: %s", requestIDFrom(r), err.Error())
+	return http.StatusInternalServerError, errorBody{Code: codeInternal, Message: "internal server error", RequestID: requestIDFrom(r)}
+}
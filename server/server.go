@@ -1,152 +1,326 @@
 package server
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
 	"github.com/ganglinwu/todoapp-backend-v1/errs"
 	"github.com/ganglinwu/todoapp-backend-v1/models"
+	"github.com/ganglinwu/todoapp-backend-v1/validate"
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
 // TODO: CreateProj returns string while CreateTodo returns interface{}/int
 // probably better to standardise what we want to return for both Create methods
+//
+// the methods that take a context only see what the owner of the context owns, see auth.Owner,
+// anything else is errs.ErrNotFound as if it did not exist. so are the todos and projects in the trash,
+// outside of the trash methods
 type TodoStore interface {
-	GetAllProjs() ([]models.PROJECT, error)
-	GetAllTodos() ([]models.TODO, error)
-	GetProjByID(ID string) (models.PROJECT, error)
-	CreateProj(Name string, Tasks []models.TODO) (string, error)
-	CreateTodo(projID string, newTodoWithoutID models.TODO) (string, error)
-	UpdateProjNameByID(ID, newName string) error
-	UpdateTodoByID(todoID string, newTodoWithoutID models.TODO) error
-	DeleteProjByID(ID string) (int, error)
-	DeleteTodoByID(todoID string) (int, error)
-	GetTodoByID(todoID string) (models.TODO, error)
+	// GetAllProjs and GetAllTodos also list the projects shared with the user of ctx and their todos
+	GetAllProjs(ctx context.Context) ([]models.PROJECT, error)
+	GetAllTodos(ctx context.Context) ([]models.TODO, error)
+	GetProjByID(ctx context.Context, ID string) (models.PROJECT, error)
+	CreateProj(ctx context.Context, Name string, Tasks []models.TODO) (string, error)
+	CreateTodo(ctx context.Context, projID string, newTodoWithoutID models.TODO) (string, error)
+	// UpdateProjNameByID only writes while the project is at version and bumps it,
+	// otherwise it returns errs.ErrPreconditionFailed. version 0 matches any version,
+	// the same goes for the other methods that take a version
+	UpdateProjNameByID(ctx context.Context, ID, newName string, version int) error
+	// UpdateTodoByID rewrites the whole todo, it expects the version the todo holds
+	UpdateTodoByID(ctx context.Context, todoID string, newTodoWithoutID models.TODO) error
+	// PatchTodo only writes the fields of todo named in fields, see the models.Field constants,
+	// a field that is empty is cleared. it expects the version todo holds
+	PatchTodo(ctx context.Context, todoID string, todo models.TODO, fields []string) error
+	// DeleteProjByID moves the project to the trash, it takes its todos with it
+	DeleteProjByID(ctx context.Context, ID string, version int) (int, error)
+	DeleteTodoByID(ctx context.Context, todoID string, version int) (int, error)
+	GetTodoByID(ctx context.Context, todoID string) (models.TODO, error)
+	// QueryTodos, QueryProjs and Search also list the projects shared with the user of ctx and their todos
+	QueryTodos(ctx context.Context, q models.TodoQuery) (todos []models.TODO, nextCursor string, err error)
+	QueryProjs(ctx context.Context, page models.Page) (projs []models.PROJECT, nextCursor string, err error)
+	Search(ctx context.Context, query string, limit int) ([]models.SearchHit, error)
+	BatchTodos(ctx context.Context, ops []models.BatchOp, atomic bool) ([]models.BatchResult, error)
+	MoveTodo(ctx context.Context, todoID, projID string) error
+	ReorderTodo(ctx context.Context, todoID string, place models.Placement) error
+	AddItem(ctx context.Context, todoID string, item models.ChecklistItem) (string, error)
+	UpdateItem(ctx context.Context, todoID, itemID string, item models.ChecklistItem) error
+	ReorderItem(ctx context.Context, todoID, itemID string, place models.Placement) error
+	DeleteItem(ctx context.Context, todoID, itemID string) (int, error)
+	GetAllLabels(ctx context.Context) ([]models.Label, error)
+	GetLabelByID(ctx context.Context, ID string) (models.Label, error)
+	CreateLabel(ctx context.Context, label models.Label) (string, error)
+	UpdateLabel(ctx context.Context, ID string, label models.Label) error
+	DeleteLabel(ctx context.Context, ID string) (int, error)
+	GetAllWebhooks(ctx context.Context) ([]models.Webhook, error)
+	GetWebhookByID(ctx context.Context, ID string) (models.Webhook, error)
+	CreateWebhook(ctx context.Context, hook models.Webhook) (string, error)
+	UpdateWebhook(ctx context.Context, ID string, hook models.Webhook) error
+	DeleteWebhook(ctx context.Context, ID string) (int, error)
+	// AddDelivery adds to the delivery log of a webhook, the log belongs to the owner of the webhook
+	AddDelivery(delivery models.Delivery) error
+	GetDeliveries(ctx context.Context, webhookID string, limit int) ([]models.Delivery, error)
+	CreateIdempotencyRecord(record models.IdempotencyRecord) error
+	GetIdempotencyRecord(key string) (models.IdempotencyRecord, error)
+	SaveIdempotencyRecord(record models.IdempotencyRecord) error
+	DeleteIdempotencyRecord(key string) error
+	GetTrash(ctx context.Context) ([]models.TrashItem, error)
+	RestoreTodo(ctx context.Context, todoID string) error
+	// RestoreProj gives the project back the todos it took to the trash
+	RestoreProj(ctx context.Context, ID string) error
+	PurgeTrash(before time.Time) (int, error)
+	// GetHistory lists the entries recorded for a todo or project, the methods that take a context
+	// record one for every change with the actor of the context, see package history,
+	// in the same transaction as the change where the store supports it
+	GetHistory(ctx context.Context, kind, ID string) ([]models.HistoryEntry, error)
+	CreateUser(user models.User) (string, error)
+	GetUserByEmail(email string) (models.User, error)
+	// CreateRefreshToken only stores the hash of the token
+	CreateRefreshToken(token models.RefreshToken) error
+	// UseRefreshToken spends the token stored under hash once,
+	// it returns errs.ErrConflict with the token when it was already spent
+	UseRefreshToken(hash string, usedAt time.Time) (models.RefreshToken, error)
+	DeleteRefreshTokens(family string) (int, error)
+	// CreatePersonalToken only stores the hash of the token
+	CreatePersonalToken(token models.PersonalToken) (string, error)
+	GetPersonalTokens(userID string) ([]models.PersonalToken, error)
+	GetPersonalToken(hash string) (models.PersonalToken, error)
+	// UsePersonalToken sets the LastUsedAt of the token stored under hash
+	UsePersonalToken(hash string, usedAt time.Time) (models.PersonalToken, error)
+	// DeletePersonalToken only deletes the tokens of userID
+	DeletePersonalToken(userID, ID string) (int, error)
+	// GetProjAccess and GetTodoAccess tell the role of the user of ctx in a project whoever owns it,
+	// trashed or not. the server checks that role and reaches the project with auth.WithOwner
+	GetProjAccess(ctx context.Context, projID string) (models.Access, error)
+	GetTodoAccess(ctx context.Context, todoID string) (models.Access, error)
+	GetCollaborators(ctx context.Context, projID string) ([]models.Collaborator, error)
+	AddCollaborator(ctx context.Context, projID string, collaborator models.Collaborator) error
+	UpdateCollaborator(ctx context.Context, projID string, collaborator models.Collaborator) error
+	RemoveCollaborator(ctx context.Context, projID, userID string) (int, error)
 }
 
 type TodoServer struct {
 	TodoStore TodoStore
 	http.Handler
-}
-
-const whitelist = "http://localhost:5173"
-
-func enableCors(w *http.ResponseWriter) {
-	(*w).Header().Set("Access-Control-Allow-Origin", whitelist)
-	(*w).Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
-	(*w).Header().Set("Access-Control-Allow-Headers", "Access-Control-Allow-Headers, Content-Type, Authorization, X-Requested-With")
-}
 
-// function to handle pre flight request
-func handlePreFlight(w http.ResponseWriter, r *http.Request) {
-	// we already know http.Request.Method == "OPTIONS"
-	if r.Header.Get("Access-Control-Request-Method") != "" {
-		w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Access-Control-Allow-Headers, Content-Type, Authorization, X-Requested-With")
-		return
-	}
+	events []EventPublisher
+	stream *eventStream
+	issuer *auth.Issuer
+	cors   CORSConfig
+	// checkDueDates rejects due dates in the past, see WithDueDateCheck
+	checkDueDates bool
 }
 
-func NewTodoServer(store TodoStore) *TodoServer {
+// NewTodoServer
+//
+// options have to be passed in here, the handlers are bound to a copy of the server
+//
+// every POST endpoint can be retried safely with an Idempotency-Key header, see idempotent,
+// except the /auth/ ones, "POST /token" and "POST /webhook", their responses hold tokens
+// or secrets that must not be stored for replays
+//
+// every response follows DefaultCORSConfig unless WithCORS is passed, see corsHandler
+//
+// with WithAuth the /auth/ and /token endpoints are added and every other request has to be signed in, see authenticate,
+// projects can then be shared with other users
+//
+// requests to a project or a todo need a role on the project, see projRole and todoRole
+func NewTodoServer(store TodoStore, options ...Option) *TodoServer {
 	r := http.NewServeMux()
 	ts := &TodoServer{}
-	ts.Handler = r
 	ts.TodoStore = store
+	ts.stream = newEventStream()
+	ts.events = []EventPublisher{ts.stream}
+	ts.cors = DefaultCORSConfig
+	for _, option := range options {
+		option(ts)
+	}
+	ts.Handler = withRequestID(ts.corsHandler(ts.authenticate(r)))
+
+	if ts.issuer != nil {
+		r.HandleFunc("POST /auth/register", ts.handleRegister)
+		r.HandleFunc("POST /auth/login", ts.handleLogin)
+		r.HandleFunc("POST /auth/refresh", ts.handleRefresh)
+		r.HandleFunc("POST /auth/logout", ts.handleLogout)
+		r.HandleFunc("GET /token", ts.handleGetPersonalTokens)
+		r.HandleFunc("POST /token", ts.handleCreatePersonalToken)
+		r.HandleFunc("DELETE /token/{ID}", ts.handleDeletePersonalToken)
+		r.HandleFunc("GET /proj/{ID}/collaborators", ts.projRole(models.RoleViewer, ts.handleGetCollaborators))
+		r.HandleFunc("POST /proj/{ID}/collaborators", ts.idempotent(ts.projRole(models.RoleOwner, ts.handleAddCollaborator)))
+		r.HandleFunc("PATCH /proj/{ID}/collaborators/{userID}", ts.projRole(models.RoleOwner, ts.handleUpdateCollaborator))
+		r.HandleFunc("DELETE /proj/{ID}/collaborators/{userID}", ts.handleRemoveCollaborator)
+	}
 
 	r.HandleFunc("GET /proj", ts.handleGetAllProjs)
 	r.HandleFunc("GET /todo", ts.handleGetAllTodos)
-	r.HandleFunc("GET /proj/{ID}", ts.handleGetProjByID)
-	r.HandleFunc("OPTIONS /proj/", handlePreFlight)
-	r.HandleFunc("POST /proj/", ts.handleCreateProj)
-	r.HandleFunc("OPTIONS /proj/{ID}", handlePreFlight)
-	r.HandleFunc("OPTIONS /todo/{ID}", handlePreFlight)
-	r.HandleFunc("POST /proj/{ID}", ts.handleCreateTodo)
-	r.HandleFunc("PATCH /proj/{ID}", ts.handleUpdateProjNameByID)
-	r.HandleFunc("PATCH /todo/{ID}", ts.handleUpdateTodoByID)
-	r.HandleFunc("DELETE /proj/{ID}", ts.handleDeleteProjByID)
-	r.HandleFunc("DELETE /todo/{ID}", ts.handleDeleteTodoByID)
+	r.HandleFunc("GET /proj/{ID}", ts.projRole(models.RoleViewer, ts.handleGetProjByID))
+	r.HandleFunc("GET /proj/{ID}/history", ts.handleGetProjHistory)
+	r.HandleFunc("GET /search", ts.handleSearch)
+	r.HandleFunc("POST /proj/", ts.idempotent(ts.handleCreateProj))
+	r.HandleFunc("GET /todo/{ID}", ts.todoRole(models.RoleViewer, ts.handleGetTodoByID))
+	r.HandleFunc("GET /todo/{ID}/history", ts.handleGetTodoHistory)
+	r.HandleFunc("POST /proj/{ID}", ts.idempotent(ts.projRole(models.RoleEditor, ts.handleCreateTodo)))
+	r.HandleFunc("PATCH /proj/{ID}", ts.projRole(models.RoleEditor, ts.handleUpdateProjNameByID))
+	r.HandleFunc("PATCH /todo/{ID}", ts.todoRole(models.RoleEditor, ts.handleUpdateTodoByID))
+	r.HandleFunc("DELETE /proj/{ID}", ts.projRole(models.RoleOwner, ts.handleDeleteProjByID))
+	r.HandleFunc("DELETE /todo/{ID}", ts.todoRole(models.RoleEditor, ts.handleDeleteTodoByID))
+	r.HandleFunc("POST /todo/{ID}/move", ts.idempotent(ts.todoRole(models.RoleEditor, ts.handleMoveTodo)))
+	r.HandleFunc("POST /todo/{ID}/reorder", ts.idempotent(ts.todoRole(models.RoleEditor, ts.handleReorderTodo)))
+	r.HandleFunc("POST /todo/{ID}/items", ts.idempotent(ts.todoRole(models.RoleEditor, ts.handleAddItem)))
+	r.HandleFunc("PATCH /todo/{ID}/items/{itemID}", ts.todoRole(models.RoleEditor, ts.handleUpdateItem))
+	r.HandleFunc("DELETE /todo/{ID}/items/{itemID}", ts.todoRole(models.RoleEditor, ts.handleDeleteItem))
+	r.HandleFunc("POST /todo/{ID}/items/{itemID}/reorder", ts.idempotent(ts.todoRole(models.RoleEditor, ts.handleReorderItem)))
+	r.HandleFunc("GET /label", ts.handleGetAllLabels)
+	r.HandleFunc("POST /label", ts.idempotent(ts.handleCreateLabel))
+	r.HandleFunc("PATCH /label/{ID}", ts.handleUpdateLabel)
+	r.HandleFunc("DELETE /label/{ID}", ts.handleDeleteLabel)
+	r.HandleFunc("POST /todo/batch", ts.idempotent(ts.handleBatchTodos))
+	r.HandleFunc("GET /webhook", ts.handleGetAllWebhooks)
+	r.HandleFunc("POST /webhook", ts.handleCreateWebhook)
+	r.HandleFunc("GET /webhook/{ID}", ts.handleGetWebhookByID)
+	r.HandleFunc("PATCH /webhook/{ID}", ts.handleUpdateWebhook)
+	r.HandleFunc("DELETE /webhook/{ID}", ts.handleDeleteWebhook)
+	r.HandleFunc("GET /webhook/{ID}/deliveries", ts.handleGetDeliveries)
+	r.HandleFunc("GET /events", ts.handleEvents)
+	r.HandleFunc("GET /trash", ts.handleGetTrash)
+	r.HandleFunc("POST /trash/{ID}/restore", ts.idempotent(ts.handleRestore))
 	return ts
 }
 
 // handleGetAllProjs
 //
 // endpoint: "GET /proj"
+//
+// - accepts the pagination query parameters described in parsePage, a page holds defaultPageLimit projects by default
+// - the next page is linked to in the Link header
 func (ts TodoServer) handleGetAllProjs(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
-	projs, err := ts.TodoStore.GetAllProjs()
-
-	switch err {
-	case errs.ErrNotFound:
-		w.WriteHeader(http.StatusNotFound)
-	case nil:
-		w.Header().Set("Content-Type", "application/json")
-		err := json.NewEncoder(w).Encode(projs)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "%s", err.Error())
-			return
-		}
+	page, err := parsePage(r.URL.Query(), defaultPageLimit)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
 
-	default:
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "err: %s", err.Error())
+	projs, next, err := ts.TodoStore.QueryProjs(r.Context(), page)
+	if err != nil {
+		writeErr(w, r, err)
+		return
 	}
+	setNextLink(w, r, next)
+	writeJSON(w, http.StatusOK, projs)
 }
 
 // handleGetAllTodos
 //
 // endpoint: "GET /todo"
+//
+// - accepts the filter, sort and pagination query parameters described in parseTodoQuery
+// - the next page is linked to in the Link header
 func (ts TodoServer) handleGetAllTodos(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
-	todos, err := ts.TodoStore.GetAllTodos()
-
-	switch err {
-	case errs.ErrNotFound:
-		w.WriteHeader(http.StatusNotFound)
-	case nil:
-		w.Header().Set("Content-Type", "application/json")
-		err := json.NewEncoder(w).Encode(todos)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "%s", err.Error())
-			return
-		}
+	q, err := parseTodoQuery(r.URL.Query())
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
 
-		w.WriteHeader(http.StatusOK)
-	default:
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "err: %s", err.Error())
+	todos, next, err := ts.TodoStore.QueryTodos(r.Context(), q)
+	if err != nil {
+		writeErr(w, r, err)
+		return
 	}
+	setNextLink(w, r, next)
+	writeJSON(w, http.StatusOK, todos)
 }
 
 // handleGetProjByID
 //
 // endpoint: "GET /proj/{ID}"
+//
+// - tasks are in their manual order, see handleReorderTodo
+// - when filter, sort or pagination query parameters are given, the project's
+// tasks are replaced with the matching tasks (see parseTodoQuery)
+// - the ETag is the version of the project, see handleUpdateProjNameByID
 func (ts TodoServer) handleGetProjByID(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
 	ID := r.PathValue("ID")
-	proj, err := ts.TodoStore.GetProjByID(ID)
-
-	switch err {
-	case errs.ErrNotFound:
-		w.WriteHeader(http.StatusNotFound)
-	case nil:
-		w.Header().Set("Content-Type", "application/json")
-		err := json.NewEncoder(w).Encode(proj)
+	proj, err := ts.TodoStore.GetProjByID(r.Context(), ID)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	if len(r.URL.Query()) > 0 {
+		q, err := parseTodoQuery(r.URL.Query())
 		if err != nil {
-			log.Println("handleGetProjByID failed to encode into json:", err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "%s", err.Error())
+			writeErr(w, r, err)
 			return
 		}
-	default:
-		log.Println("handleGetProjByID failed with error", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		q.ProjID = ID
+		if q.SortBy == "" {
+			q.SortBy = models.SortByRank
+		}
+
+		var next string
+		proj.Tasks, next, err = ts.TodoStore.QueryTodos(r.Context(), q)
+		if err != nil {
+			writeErr(w, r, err)
+			return
+		}
+		setNextLink(w, r, next)
+	}
+	setETag(w, proj.Version)
+	writeJSON(w, http.StatusOK, proj)
+}
+
+// handleGetTodoByID
+//
+// endpoint: "GET /todo/{ID}"
+//
+// - the ETag is the version of the todo, see handleUpdateTodoByID
+func (ts TodoServer) handleGetTodoByID(w http.ResponseWriter, r *http.Request) {
+	todo, err := ts.TodoStore.GetTodoByID(r.Context(), r.PathValue("ID"))
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	setETag(w, todo.Version)
+	writeJSON(w, http.StatusOK, todo)
+}
+
+// defaultSearchLimit is the number of hits returned when no limit is given
+const defaultSearchLimit = 20
+
+// handleSearch
+//
+// endpoint: "GET /search?q=..."
+//
+// - searches todo names and descriptions, and project names
+// - hits are ranked by relevance, each todo hit includes its owning project
+// - limit=<1 to maxPageLimit> caps the number of hits (default 20)
+func (ts TodoServer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeErr(w, r, fmt.Errorf("%w: q is required", errs.ErrValidation))
+		return
+	}
+
+	page, err := parsePage(r.URL.Query(), defaultSearchLimit)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	hits, err := ts.TodoStore.Search(r.Context(), query, page.Limit)
+	if err != nil {
+		writeErr(w, r, err)
+		return
 	}
+	writeJSON(w, http.StatusOK, hits)
 }
 
 // handleCreateProj
@@ -158,146 +332,308 @@ func (ts TodoServer) handleGetProjByID(w http.ResponseWriter, r *http.Request) {
 // - CreateProj requires ONLY the project name from the frontend
 // - The ID will be auto-generated by mongodb/postgres
 // - Project will be created with empty array/slice of TODOs
+// - responds with the created project and its URL in the Location header
 func (ts TodoServer) handleCreateProj(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
-
 	project := models.PROJECT{}
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&project)
+	err := decodeProj(r, &project)
 	if err != nil {
-		log.Println("failed to unmarshal json to TODO struct: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "%s", err.Error())
+		writeErr(w, r, err)
 		return
 	}
 
 	tasks := []models.TODO{}
 
-	insertedID, err := ts.TodoStore.CreateProj(project.ProjName, tasks)
+	insertedID, err := ts.TodoStore.CreateProj(r.Context(), project.ProjName, tasks)
 	if err != nil {
 		log.Println("failed to create proj on data store: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "%s", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+
+	created, err := ts.TodoStore.GetProjByID(r.Context(), insertedID)
+	if err != nil {
+		log.Println("failed to fetch created proj from data store: ", err.Error())
+		writeErr(w, r, err)
 		return
 	}
-	log.Printf("Sucessfully created proj \n ID: %s \n ProjName: %s \n Tasks: %#v \n", insertedID, project.ProjName, tasks)
-	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintf(w, "%s \n Sucessfully created proj \n ID: %s \n ProjName: %s \n Tasks: %#v \n", insertedID, insertedID, project.ProjName, tasks)
+
+	ts.publish(r.Context(), models.EventProjectCreated, insertedID, created)
+
+	w.Header().Set("Location", "/proj/"+insertedID)
+	setETag(w, created.Version)
+	writeJSON(w, http.StatusCreated, created)
 }
 
 // handleCreateTodo
 //
 // endpoint: "POST /proj/{ID}"
+//
+// - responds with the created todo and its URL in the Location header
 func (ts TodoServer) handleCreateTodo(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
 	todo := models.TODO{}
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&todo)
+	err := validate.Decode(r.Body, &todo)
 	if err != nil {
 		log.Println("failed to unmarshal json to TODO struct: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "%s", err.Error())
+		writeErr(w, r, err)
 		return
 	}
 
-	newTodoWithoutID := models.TODO{}
-
 	projID := r.PathValue("ID")
-	if todo.DueDateString != "" {
-		dueDate, err := time.Parse(time.RFC3339, todo.DueDateString)
-		if err != nil {
-			log.Println("failed to parse date string to date: ", err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "%s", err.Error())
-			return
-		}
-		newTodoWithoutID.DueDate = &dueDate
-	}
 
-	newTodoWithoutID = models.TODO{
-		Name:        todo.Name,
-		Description: todo.Description,
-		Priority:    todo.Priority,
-		Completed:   todo.Completed,
-		Updated_at:  &bson.Timestamp{T: uint32(time.Now().Unix())},
+	newTodoWithoutID, err := ts.newTodo(todo)
+	if err != nil {
+		log.Println("failed to parse date string to date: ", err.Error())
+		writeErr(w, r, err)
+		return
 	}
-	upsertedID, err := ts.TodoStore.CreateTodo(projID, newTodoWithoutID)
+
+	upsertedID, err := ts.TodoStore.CreateTodo(r.Context(), projID, newTodoWithoutID)
 	if err != nil {
 		log.Println("failed to create todo on data store: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "%s", err.Error())
+		writeErr(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintf(w, "%s\n Successfully created todo \n ID: %s \n Name: %s \n Description: %s \n DueDate: %s \n Priority: %s \n Completed: %t \n", upsertedID, upsertedID, todo.Name, todo.Description, todo.DueDate, todo.Priority, todo.Completed)
+	created, err := ts.TodoStore.GetTodoByID(r.Context(), upsertedID)
+	if err != nil {
+		log.Println("failed to fetch created todo from data store: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+
+	ts.publish(r.Context(), models.EventTodoCreated, created.ProjID, created)
+
+	w.Header().Set("Location", "/todo/"+upsertedID)
+	setETag(w, created.Version)
+	writeJSON(w, http.StatusCreated, created)
 }
 
 // handleUpdateProjNameByID
 //
 // endpoint: "PATCH /proj/{ID}"
+//
+// - takes a JSON merge patch of the project, see models.ProjPatch and decodePatch
+// - the name is the only field a patch can change, it cannot be cleared
+// - a patch without the name changes nothing and responds with the project as it is
+// - with If-Match, the project is only renamed if its ETag still matches, otherwise 412
+// - responds with the updated project
 func (ts TodoServer) handleUpdateProjNameByID(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
-	updatedProj := models.PROJECT{}
+	version, err := ifMatch(r)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
 
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&updatedProj)
+	patch := models.ProjPatch{}
+	err = decodePatch(w, r, &patch)
 	if err != nil {
-		log.Println("failed to unmarshal json to PROJECT struct: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "%s", err.Error())
+		writeErr(w, r, err)
 		return
 	}
 
 	ID := r.PathValue("ID")
-	newProjName := updatedProj.ProjName
 
-	err = ts.TodoStore.UpdateProjNameByID(ID, newProjName)
+	if !patch.ProjName.Set {
+		proj, err := ts.TodoStore.GetProjByID(r.Context(), ID)
+		if err != nil {
+			writeErr(w, r, err)
+			return
+		}
+		err = checkVersion(version, proj.Version)
+		if err != nil {
+			writeErr(w, r, err)
+			return
+		}
+		setETag(w, proj.Version)
+		writeJSON(w, http.StatusOK, proj)
+		return
+	}
+
+	newProjName := patch.ProjName.Value
+	err = validate.Fields(models.PROJECT{ProjName: newProjName}, "projname")
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	err = ts.TodoStore.UpdateProjNameByID(r.Context(), ID, newProjName, version)
 	if err != nil {
 		log.Println("failed to update proj name on data store: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "%s", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+
+	proj, err := ts.TodoStore.GetProjByID(r.Context(), ID)
+	if err != nil {
+		writeErr(w, r, err)
 		return
 	}
-	log.Printf("Sucessfully updated proj name \n ID: %s \n ProjName: %s \n", ID, newProjName)
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "\n Sucessfully updated proj name \n ID: %s \n ProjName: %s \n", ID, newProjName)
+	ts.publish(r.Context(), models.EventProjectUpdated, ID, proj)
+	setETag(w, proj.Version)
+	writeJSON(w, http.StatusOK, proj)
 }
 
 // handleUpdateTodoByID
 //
 // endpoint: "PATCH /todo/{ID}"
 //
-// - handleUpdateTodoByID will take in the updatedTodo through json
+// - takes a JSON merge patch of the todo, see models.TodoPatch and decodePatch
 // - then it will search data store for existing todo under the ID
-// - compares the fields
-// - if the updatedTodo has blank fields, the existing field will be used
-// - else it supercedes existing field
+// - and apply the patch to it, see patchTodo. the store only writes the fields that changed
+// - a patch that sets no field changes nothing and responds with the todo as it is
+// - with If-Match, the todo is only updated if its ETag still matches, otherwise 412
+// - without If-Match, the patch is applied again when the todo changes in between,
+// up to maxUpdateAttempts times
+// - completing a recurring todo creates its next occurrence in the same project,
+// its URL is in the Link header with rel="next"
+// - responds with the updated todo
 func (ts TodoServer) handleUpdateTodoByID(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
-
-	updatedTodo := models.TODO{}
+	version, err := ifMatch(r)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
 
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&updatedTodo)
+	patch := models.TodoPatch{}
+	err = decodePatch(w, r, &patch)
 	if err != nil {
-		log.Println("failed to unmarshal json to TODO struct: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "%s", err.Error())
+		writeErr(w, r, err)
 		return
 	}
 
 	todoID := r.PathValue("ID")
 
-	currentTodo, err := ts.TodoStore.GetTodoByID(todoID)
+	var currentTodo, patchedTodo models.TODO
+	var fields []string
+	for attempt := 1; ; attempt++ {
+		currentTodo, err = ts.TodoStore.GetTodoByID(r.Context(), todoID)
+		if err != nil {
+			log.Println("failed to GetTodoByID: ", err.Error())
+			writeErr(w, r, err)
+			return
+		}
+		err = checkVersion(version, currentTodo.Version)
+		if err != nil {
+			writeErr(w, r, err)
+			return
+		}
+
+		patchedTodo, fields, err = ts.patchTodo(currentTodo, patch)
+		if err != nil {
+			log.Println("failed to apply patch: ", err.Error())
+			writeErr(w, r, err)
+			return
+		}
+		if len(fields) == 0 {
+			setETag(w, currentTodo.Version)
+			writeJSON(w, http.StatusOK, currentTodo)
+			return
+		}
+
+		// the store only writes if the todo is still at currentTodo.Version
+		err = ts.TodoStore.PatchTodo(r.Context(), todoID, patchedTodo, fields)
+		if errors.Is(err, errs.ErrPreconditionFailed) && version == 0 && attempt < maxUpdateAttempts {
+			continue
+		}
+		if err != nil {
+			log.Println("failed to update todo by id: ", err.Error())
+			writeErr(w, r, err)
+			return
+		}
+		break
+	}
+
+	completes := patchedTodo.Completed && !currentTodo.Completed
+
+	if patchedTodo.Recurrence != "" && completes {
+		nextID, err := ts.createNextOccurrence(r.Context(), currentTodo.ProjID, patchedTodo)
+		if err != nil {
+			log.Println("failed to create next occurrence on data store: ", err.Error())
+			writeErr(w, r, err)
+			return
+		}
+		if nextID != "" {
+			ts.publishTodo(r.Context(), models.EventTodoCreated, nextID, false)
+			w.Header().Set("Link", fmt.Sprintf(`</todo/%s>; rel="next"`, nextID))
+		}
+	}
+
+	todo, err := ts.TodoStore.GetTodoByID(r.Context(), todoID)
 	if err != nil {
-		log.Println("failed to GetTodoByID: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "%s", err.Error())
+		writeErr(w, r, err)
 		return
 	}
+	ts.publish(r.Context(), models.EventTodoUpdated, todo.ProjID, todo)
+	if completes {
+		ts.publish(r.Context(), models.EventTodoCompleted, todo.ProjID, todo)
+	}
+	setETag(w, todo.Version)
+	writeJSON(w, http.StatusOK, todo)
+}
 
-	// check and update
+// maxUpdateAttempts is how often handleUpdateTodoByID applies a patch
+// without If-Match before it gives up on a todo that keeps changing
+const maxUpdateAttempts = 3
+
+// newTodo builds the todo to store from the todo in a create request
+//
+// - returns validate.Errors if the todo breaks the rules of models.TODO, the due date is not RFC3339
+// or it is before the todo is created, see checkDueDate
+// - returns errs.ErrValidation if the recurrence rule is invalid or a reminder is invalid,
+// see checkRecurrence and scheduleReminders
+func (ts TodoServer) newTodo(todo models.TODO) (models.TODO, error) {
+	err := validate.Struct(todo)
+	if err != nil {
+		return models.TODO{}, err
+	}
+
+	newTodoWithoutID := models.TODO{
+		Name:        todo.Name,
+		Description: todo.Description,
+		Priority:    todo.Priority,
+		Completed:   todo.Completed,
+		Updated_at:  &bson.Timestamp{T: uint32(time.Now().Unix())},
+		Labels:      labelSet(todo.Labels),
+		Recurrence:  todo.Recurrence,
+		Reminders:   todo.Reminders,
+	}
+
+	if todo.DueDateString != "" {
+		newTodoWithoutID.DueDate, err = ts.checkDueDate("dueDateString", todo.DueDateString)
+		if err != nil {
+			return models.TODO{}, err
+		}
+	}
+
+	newTodoWithoutID, err = checkRecurrence(newTodoWithoutID)
+	if err != nil {
+		return models.TODO{}, err
+	}
+	return scheduleReminders(newTodoWithoutID, nil)
+}
+
+// mergeTodo compares the fields of a batch update with the current todo, "PATCH /todo/{ID}"
+// applies a merge patch instead, see patchTodo
+//
+// - if the updatedTodo has blank fields, the existing field will be used
+// - else it supercedes existing field
+// - Completed is always taken from updatedTodo
+// - Rank is always kept, it only changes through handleReorderTodo
+// - Items are always kept, they only change through the /todo/{ID}/items endpoints
+// - Version is always kept, the store only writes the merge if the todo is still at that version
+// - Labels replace the existing labels when given, an empty list removes them all
+// - Recurrence replaces the existing rule when given, Occurrence is always kept
+// - Reminders replace the existing reminders when given, an empty list removes them all,
+// either way they are rescheduled against the merged due date
+// - returns validate.Errors if the fields given break the rules of models.TODO, the due date is not RFC3339
+// or it is before the update, see checkDueDate
+// - returns errs.ErrValidation if the recurrence rule is invalid or a reminder is invalid,
+// see checkRecurrence and scheduleReminders
+func (ts TodoServer) mergeTodo(currentTodo, updatedTodo models.TODO) (models.TODO, error) {
+	err := validate.Partial(updatedTodo)
+	if err != nil {
+		return models.TODO{}, err
+	}
 
 	// Name should never be empty
 	todoName := ""
@@ -316,14 +652,10 @@ func (ts TodoServer) handleUpdateTodoByID(w http.ResponseWriter, r *http.Request
 
 	todoDueDate := currentTodo.DueDate
 	if updatedTodo.DueDateString != "" {
-		newDueDate, err := time.Parse(time.RFC3339, updatedTodo.DueDateString)
+		todoDueDate, err = ts.checkDueDate("dueDateString", updatedTodo.DueDateString)
 		if err != nil {
-			log.Println("failed to parse date string: ", err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "%s", err.Error())
-			return
+			return models.TODO{}, err
 		}
-		todoDueDate = &newDueDate
 	}
 
 	todoPriority := ""
@@ -333,64 +665,206 @@ func (ts TodoServer) handleUpdateTodoByID(w http.ResponseWriter, r *http.Request
 		todoPriority = updatedTodo.Priority
 	}
 
-	todoCompleted := updatedTodo.Completed
+	todoRecurrence := currentTodo.Recurrence
+	if updatedTodo.Recurrence != "" {
+		todoRecurrence = updatedTodo.Recurrence
+	}
 
-	updatedTodoWithoutID := models.TODO{
+	todoLabels := currentTodo.Labels
+	if updatedTodo.Labels != nil {
+		todoLabels = labelSet(updatedTodo.Labels)
+	}
+
+	todoReminders := currentTodo.Reminders
+	if updatedTodo.Reminders != nil {
+		todoReminders = updatedTodo.Reminders
+	}
+
+	mergedTodo, err := checkRecurrence(models.TODO{
 		Name:        todoName,
 		Description: todoDescription,
 		DueDate:     todoDueDate,
 		Priority:    todoPriority,
-		Completed:   todoCompleted,
+		Completed:   updatedTodo.Completed,
 		Updated_at:  &bson.Timestamp{T: uint32(time.Now().Unix())},
+		Rank:        currentTodo.Rank,
+		Items:       currentTodo.Items,
+		Labels:      todoLabels,
+		Recurrence:  todoRecurrence,
+		Occurrence:  currentTodo.Occurrence,
+		Reminders:   todoReminders,
+		Version:     currentTodo.Version,
+	})
+	if err != nil {
+		return models.TODO{}, err
+	}
+	return scheduleReminders(mergedTodo, currentTodo.Reminders)
+}
+
+// labelSet sorts the label ids of a request and drops duplicates
+func labelSet(labels []string) []string {
+	if labels == nil {
+		return nil
+	}
+	labels = slices.Clone(labels)
+	slices.Sort(labels)
+	return slices.Compact(labels)
+}
+
+// moveRequest is the body of "POST /todo/{ID}/move"
+type moveRequest struct {
+	ProjID string `json:"projId"`
+}
+
+// handleMoveTodo
+//
+// endpoint: "POST /todo/{ID}/move"
+//
+// - takes the target project as {"projId": "..."}, see moveRequest
+// - the user has to be an editor of both projects, and both have to have the same owner
+// - the todo keeps its ID and timestamps
+// - responds with the moved todo
+func (ts TodoServer) handleMoveTodo(w http.ResponseWriter, r *http.Request) {
+	target := moveRequest{}
+	err := validate.Decode(r.Body, &target)
+	if err != nil {
+		log.Println("failed to unmarshal json to moveRequest struct: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+	if target.ProjID == "" {
+		writeErr(w, r, fmt.Errorf("%w: projId is required", errs.ErrValidation))
+		return
 	}
 
-	err = ts.TodoStore.UpdateTodoByID(todoID, updatedTodoWithoutID)
+	todoID := r.PathValue("ID")
+
+	// the todo stays with the owner of its project, so the target project has to be theirs too
+	ctx, err := ts.projAccess(r.Context(), target.ProjID, models.RoleEditor)
 	if err != nil {
-		log.Println("failed to update todo by id: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "%s", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+	if auth.Owner(ctx) != auth.Owner(r.Context()) {
+		writeErr(w, r, fmt.Errorf("%w: a todo can only be moved between projects of the same owner", errs.ErrValidation))
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	log.Printf("Sucessfully updated todo \n ID: %s \n Name: %s \n Description: %s \n DueDate: %s \n Priority: %s \n Completed: %t \n", todoID, updatedTodoWithoutID.Name, updatedTodoWithoutID.Description, r.FormValue("DueDate"), updatedTodoWithoutID.Priority, updatedTodoWithoutID.Completed)
-	fmt.Fprintf(w, "\n Sucessfully updated todo \n ID: %s \n Name: %s \n Description: %s \n DueDate: %s \n Priority: %s \n Completed %t \n", todoID, updatedTodoWithoutID.Name, updatedTodoWithoutID.Description, r.FormValue("DueDate"), updatedTodoWithoutID.Priority, updatedTodoWithoutID.Completed)
+
+	err = ts.TodoStore.MoveTodo(r.Context(), todoID, target.ProjID)
+	if err != nil {
+		log.Println("failed to move todo on data store: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+
+	todo, err := ts.TodoStore.GetTodoByID(r.Context(), todoID)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, todo)
+}
+
+// handleReorderTodo
+//
+// endpoint: "POST /todo/{ID}/reorder"
+//
+// - takes {"before": "<todo ID>"} or {"after": "<todo ID>"}
+// - the other todo has to be in the same project
+// - responds with the reordered todo
+func (ts TodoServer) handleReorderTodo(w http.ResponseWriter, r *http.Request) {
+	place := models.Placement{}
+	err := validate.Decode(r.Body, &place)
+	if err != nil {
+		log.Println("failed to unmarshal json to Placement struct: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+
+	todoID := r.PathValue("ID")
+
+	if (place.Before == "") == (place.After == "") {
+		writeErr(w, r, fmt.Errorf("%w: exactly one of before and after is required", errs.ErrValidation))
+		return
+	}
+	if place.Before == todoID || place.After == todoID {
+		writeErr(w, r, fmt.Errorf("%w: a todo cannot be placed next to itself", errs.ErrValidation))
+		return
+	}
+
+	err = ts.TodoStore.ReorderTodo(r.Context(), todoID, place)
+	if err != nil {
+		log.Println("failed to reorder todo on data store: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+
+	todo, err := ts.TodoStore.GetTodoByID(r.Context(), todoID)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, todo)
 }
 
 // handleDeleteProjByID
 //
 // endpoint: "DELETE /proj/{ID}"
+//
+// - the project and its todos are moved to the trash, see handleRestore
+// - with If-Match, the project is only deleted if its ETag still matches, otherwise 412
 func (ts TodoServer) handleDeleteProjByID(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
+	version, err := ifMatch(r)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
 	ID := r.PathValue("ID")
 
-	deletedCount, err := ts.TodoStore.DeleteProjByID(ID)
+	deletedCount, err := ts.TodoStore.DeleteProjByID(r.Context(), ID, version)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "%s", err.Error())
+		writeErr(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "Number of projects deleted: %d", deletedCount)
+	ts.publish(r.Context(), models.EventProjectDeleted, ID, models.DeletedData{ID: ID})
+	writeJSON(w, http.StatusOK, deleteResponse{DeletedCount: deletedCount})
 }
 
 // handleDeleteTodoByID
 //
 // endpoint: "DELETE /todo/{ID}"
+//
+// - the todo is moved to the trash, see handleRestore
+// - the todo is looked up first, the delete event names the project it was in
+// - with If-Match, the todo is only deleted if its ETag still matches, otherwise 412
 func (ts TodoServer) handleDeleteTodoByID(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
+	version, err := ifMatch(r)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
 	todoID := r.PathValue("ID")
 
-	deletedCount, err := ts.TodoStore.DeleteTodoByID(todoID)
+	todo, err := ts.TodoStore.GetTodoByID(r.Context(), todoID)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	err = checkVersion(version, todo.Version)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	deletedCount, err := ts.TodoStore.DeleteTodoByID(r.Context(), todoID, version)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "%s", err.Error())
+		writeErr(w, r, err)
 		return
 	}
 	if deletedCount != 1 {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "we could not delete the todo. something went wrong on our end.")
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "we could not delete the todo. something went wrong on our end.")
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "Number of todos deleted: %d", deletedCount)
+	ts.publish(r.Context(), models.EventTodoDeleted, todo.ProjID, models.DeletedData{ID: todoID})
+	writeJSON(w, http.StatusOK, deleteResponse{DeletedCount: deletedCount})
 }
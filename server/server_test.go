@@ -1,19 +1,30 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"slices"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
 	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/history"
 	"github.com/ganglinwu/todoapp-backend-v1/models"
+	"github.com/ganglinwu/todoapp-backend-v1/rank"
+	"github.com/ganglinwu/todoapp-backend-v1/textsearch"
+	"github.com/ganglinwu/todoapp-backend-v1/validate"
 	"github.com/stretchr/testify/suite"
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
@@ -22,10 +33,35 @@ import (
 type TestSuite struct {
 	suite.Suite
 	server *TodoServer
+	events *eventRecorder
 }
 
 type StubTodoStore struct {
-	store []models.PROJECT
+	store      []models.PROJECT
+	labels     []models.Label
+	webhooks   []models.Webhook
+	deliveries []models.Delivery
+	// idempotency is created on first use
+	idempotency map[string]models.IdempotencyRecord
+	// deleted todos and projects are moved here, most recently deleted last
+	trash []models.TrashItem
+	// only the creates, updates and deletes of todos and projects are recorded
+	history []models.HistoryEntry
+	users   []models.User
+	// refreshTokens is created on first use, keyed by hash
+	refreshTokens  map[string]models.RefreshToken
+	personalTokens []models.PersonalToken
+}
+
+// eventRecorder is an EventPublisher that keeps the types of the events it receives
+type eventRecorder struct {
+	types []string
+	data  []any
+}
+
+func (e *eventRecorder) Publish(event models.Event) {
+	e.types = append(e.types, event.Type)
+	e.data = append(e.data, event.Data)
 }
 
 var (
@@ -80,17 +116,18 @@ func (ts *TestSuite) SetupTest() {
 	proj2 := models.PROJECT{ID: &objID5, ProjName: "proj2", Tasks: todos2}
 
 	store := []models.PROJECT{proj1, proj2}
-	ts.server = NewTodoServer(&StubTodoStore{store})
+	ts.events = &eventRecorder{}
+	ts.server = NewTodoServer(&StubTodoStore{store: store}, WithEvents(ts.events))
 }
 
-func (s *StubTodoStore) GetAllProjs() ([]models.PROJECT, error) {
+func (s *StubTodoStore) GetAllProjs(ctx context.Context) ([]models.PROJECT, error) {
 	if len(s.store) == 0 {
 		return []models.PROJECT{}, errs.ErrNotFound
 	}
 	return s.store, nil
 }
 
-func (s *StubTodoStore) GetAllTodos() ([]models.TODO, error) {
+func (s *StubTodoStore) GetAllTodos(ctx context.Context) ([]models.TODO, error) {
 	if len(s.store) == 0 {
 		return []models.TODO{}, errs.ErrNotFound
 	}
@@ -104,26 +141,41 @@ func (s *StubTodoStore) GetAllTodos() ([]models.TODO, error) {
 	return todos, nil
 }
 
-func (s *StubTodoStore) GetProjByID(ID string) (models.PROJECT, error) {
+func (s *StubTodoStore) GetProjByID(ctx context.Context, ID string) (models.PROJECT, error) {
 	for _, proj := range s.store {
 		if proj.ID.Hex() == ID {
+			proj.Tasks = slices.Clone(proj.Tasks)
+			models.SortTasks(proj.Tasks)
 			return proj, nil
 		}
 	}
 	return models.PROJECT{}, errs.ErrNotFound
 }
 
-func (s *StubTodoStore) CreateProj(Name string, Tasks []models.TODO) (string, error) {
+// lastRank returns the highest rank in the project at projIndex
+func (s *StubTodoStore) lastRank(projIndex int) string {
+	last := ""
+	for _, task := range s.store[projIndex].Tasks {
+		last = max(last, task.Rank)
+	}
+	return last
+}
+
+func (s *StubTodoStore) CreateProj(ctx context.Context, Name string, Tasks []models.TODO) (string, error) {
 	randomObjID := bson.NewObjectID()
 	IDstr := randomObjID.Hex()
-	s.store = append(s.store, models.PROJECT{ID: &randomObjID, ProjName: Name, Tasks: Tasks})
+	s.store = append(s.store, models.PROJECT{ID: &randomObjID, ProjName: Name, Tasks: Tasks, Version: 1, Owner: auth.Owner(ctx)})
+	s.recordProj(ctx, models.OpCreate, IDstr, models.PROJECT{}, s.store[len(s.store)-1])
 	return IDstr, nil
 }
 
-func (s *StubTodoStore) CreateTodo(projID string, newTodoWithoutID models.TODO) (string, error) {
+func (s *StubTodoStore) CreateTodo(ctx context.Context, projID string, newTodoWithoutID models.TODO) (string, error) {
 	if len(s.store) == 0 {
 		return "", errs.ErrNotFound
 	}
+	if err := s.checkLabels(ctx, newTodoWithoutID.Labels); err != nil {
+		return "", err
+	}
 	for projIndex, proj := range s.store {
 		if proj.ID.Hex() == projID {
 			taskID := bson.NewObjectID()
@@ -134,32 +186,60 @@ func (s *StubTodoStore) CreateTodo(projID string, newTodoWithoutID models.TODO)
 				Description: newTodoWithoutID.Description,
 				DueDate:     newTodoWithoutID.DueDate,
 				Priority:    newTodoWithoutID.Priority,
+				Rank:        rank.After(s.lastRank(projIndex)),
+				Labels:      newTodoWithoutID.Labels,
+				Recurrence:  newTodoWithoutID.Recurrence,
+				Occurrence:  newTodoWithoutID.Occurrence,
+				Reminders:   newTodoWithoutID.Reminders,
+				Version:     1,
 			})
+			s.recordTodo(ctx, models.OpCreate, upsertedID, models.TODO{}, s.store[projIndex].Tasks[len(proj.Tasks)])
 			return upsertedID, nil
 		}
 	}
 	return "", errs.ErrNotFound
 }
 
-func (s *StubTodoStore) UpdateProjNameByID(ID, NewName string) error {
+// stubVersion mirrors the version check of the stores, 0 matches any version
+func stubVersion(expected, current int) error {
+	if expected != 0 && expected != current {
+		return errs.ErrPreconditionFailed
+	}
+	return nil
+}
+
+func (s *StubTodoStore) UpdateProjNameByID(ctx context.Context, ID, NewName string, version int) error {
 	if len(s.store) == 0 {
 		return errs.ErrNotFound
 	}
 	for index, proj := range s.store {
 		IDStr := proj.ID.Hex()
 		if IDStr == ID {
+			if err := stubVersion(version, proj.Version); err != nil {
+				return err
+			}
 			s.store[index].ProjName = NewName
+			s.store[index].Version++
+			s.recordProj(ctx, models.OpUpdate, ID, proj, s.store[index])
 		}
 	}
 	return nil
 }
 
-func (s *StubTodoStore) DeleteProjByID(ID string) (int, error) {
+func (s *StubTodoStore) DeleteProjByID(ctx context.Context, ID string, version int) (int, error) {
 	if len(s.store) == 0 {
 		return 0, errs.ErrNotFound
 	}
 	for i, proj := range s.store {
 		if proj.ID.Hex() == ID {
+			if err := stubVersion(version, proj.Version); err != nil {
+				return 0, err
+			}
+			now := time.Now()
+			proj.Version++
+			proj.DeletedAt = &now
+			s.trash = append(s.trash, models.TrashItem{Kind: models.TrashProject, ID: ID, DeletedAt: now, Project: &proj})
+			s.recordProj(ctx, models.OpDelete, ID, s.store[i], proj)
 			s.store = slices.Delete(s.store, i, i+1)
 			return 1, nil
 		}
@@ -167,13 +247,23 @@ func (s *StubTodoStore) DeleteProjByID(ID string) (int, error) {
 	return 0, errs.ErrNotFound
 }
 
-func (s *StubTodoStore) DeleteTodoByID(todoID string) (int, error) {
+func (s *StubTodoStore) DeleteTodoByID(ctx context.Context, todoID string, version int) (int, error) {
 	if len(s.store) == 0 {
 		return 0, errs.ErrNotFound
 	}
 	for projIndex, proj := range s.store {
 		for taskIndex, task := range proj.Tasks {
 			if task.ID.Hex() == todoID {
+				if err := stubVersion(version, task.Version); err != nil {
+					return 0, err
+				}
+				now := time.Now()
+				before := task
+				task.Version++
+				task.DeletedAt = &now
+				task.ProjID = proj.ID.Hex()
+				s.recordTodo(ctx, models.OpDelete, todoID, before, task)
+				s.trash = append(s.trash, models.TrashItem{Kind: models.TrashTodo, ID: todoID, DeletedAt: now, Todo: &task})
 				s.store[projIndex].Tasks = slices.Delete(s.store[projIndex].Tasks, taskIndex, taskIndex+1)
 				return 1, nil
 			}
@@ -182,13 +272,14 @@ func (s *StubTodoStore) DeleteTodoByID(todoID string) (int, error) {
 	return 0, errs.ErrNotFound
 }
 
-func (s *StubTodoStore) GetTodoByID(todoID string) (models.TODO, error) {
+func (s *StubTodoStore) GetTodoByID(ctx context.Context, todoID string) (models.TODO, error) {
 	if len(s.store) == 0 {
 		return models.TODO{}, errs.ErrNotFound
 	}
 	for _, proj := range s.store {
 		for _, task := range proj.Tasks {
 			if task.ID.Hex() == todoID {
+				task.ProjID = proj.ID.Hex()
 				return task, nil
 			}
 		}
@@ -196,337 +287,3302 @@ func (s *StubTodoStore) GetTodoByID(todoID string) (models.TODO, error) {
 	return models.TODO{}, errs.ErrNotFound
 }
 
-func (s *StubTodoStore) UpdateTodoByID(ID string, newTodoWithoutID models.TODO) error {
+func (s *StubTodoStore) UpdateTodoByID(ctx context.Context, ID string, newTodoWithoutID models.TODO) error {
+	if err := s.checkLabels(ctx, newTodoWithoutID.Labels); err != nil {
+		return err
+	}
 	for projIndex, proj := range s.store {
 		for taskIndex, task := range proj.Tasks {
 			if task.ID.Hex() == ID {
+				if err := stubVersion(newTodoWithoutID.Version, task.Version); err != nil {
+					return err
+				}
 				taskID, err := bson.ObjectIDFromHex(ID)
 				if err != nil {
 					return err
 				}
+				before := task
 				s.store[projIndex].Tasks[taskIndex].ID = &taskID
 				s.store[projIndex].Tasks[taskIndex].Name = newTodoWithoutID.Name
 				s.store[projIndex].Tasks[taskIndex].Description = newTodoWithoutID.Description
 				s.store[projIndex].Tasks[taskIndex].DueDate = newTodoWithoutID.DueDate
 				s.store[projIndex].Tasks[taskIndex].Priority = newTodoWithoutID.Priority
+				s.store[projIndex].Tasks[taskIndex].Completed = newTodoWithoutID.Completed
+				s.store[projIndex].Tasks[taskIndex].Labels = newTodoWithoutID.Labels
+				s.store[projIndex].Tasks[taskIndex].Recurrence = newTodoWithoutID.Recurrence
+				s.store[projIndex].Tasks[taskIndex].Occurrence = newTodoWithoutID.Occurrence
+				s.store[projIndex].Tasks[taskIndex].Reminders = newTodoWithoutID.Reminders
+				s.store[projIndex].Tasks[taskIndex].Version++
+				s.recordTodo(ctx, models.OpUpdate, ID, before, s.store[projIndex].Tasks[taskIndex])
+				return nil
 			}
 		}
 	}
-	return nil
+	return errs.ErrNotFound
 }
 
-func (ts *TestSuite) TestGetAllProjs() {
-	request, _ := http.NewRequest(http.MethodGet, "/proj", nil)
-	responseRecorder := httptest.NewRecorder()
-
-	ts.server.ServeHTTP(responseRecorder, request)
-
-	response := responseRecorder.Result()
-
-	got := []models.PROJECT{}
-
-	err := json.NewDecoder(response.Body).Decode(&got)
-	defer response.Body.Close()
-
-	if err != nil {
-		ts.FailNow(err.Error())
+func (s *StubTodoStore) PatchTodo(ctx context.Context, ID string, todo models.TODO, fields []string) error {
+	if slices.Contains(fields, models.FieldLabels) {
+		if err := s.checkLabels(ctx, todo.Labels); err != nil {
+			return err
+		}
 	}
-
-	for index, proj := range got {
-		ts.compareProjStructFields(store[index], proj)
+	for projIndex, proj := range s.store {
+		for taskIndex, task := range proj.Tasks {
+			if task.ID.Hex() != ID || task.DeletedAt != nil {
+				continue
+			}
+			if err := stubVersion(todo.Version, task.Version); err != nil {
+				return err
+			}
+			patched := &s.store[projIndex].Tasks[taskIndex]
+			for _, field := range fields {
+				switch field {
+				case models.FieldName:
+					patched.Name = todo.Name
+				case models.FieldDescription:
+					patched.Description = todo.Description
+				case models.FieldDueDate:
+					patched.DueDate = todo.DueDate
+				case models.FieldPriority:
+					patched.Priority = todo.Priority
+				case models.FieldCompleted:
+					patched.Completed = todo.Completed
+				case models.FieldLabels:
+					patched.Labels = todo.Labels
+				case models.FieldRecurrence:
+					patched.Recurrence = todo.Recurrence
+				case models.FieldOccurrence:
+					patched.Occurrence = todo.Occurrence
+				case models.FieldReminders:
+					patched.Reminders = todo.Reminders
+				default:
+					return errs.ErrValidation
+				}
+			}
+			patched.Updated_at = todo.Updated_at
+			patched.Version++
+			s.recordTodo(ctx, models.OpUpdate, ID, task, *patched)
+			return nil
+		}
 	}
-
-	ts.assertStatusCode(200, responseRecorder.Code)
+	return errs.ErrNotFound
 }
 
-func (ts *TestSuite) TestGetAllTodos() {
-	request, _ := http.NewRequest(http.MethodGet, "/todo", nil)
-	responseRecorder := httptest.NewRecorder()
+// paginateStub pages through items that are already in their final order
+//
+// the stub does not need keyset pagination, the cursor just holds the id of the last item
+func paginateStub[T any](items []T, page models.Page, idOf func(T) string) ([]T, string, error) {
+	if page.Cursor != "" {
+		cursor, err := models.DecodeCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		index := slices.IndexFunc(items, func(item T) bool { return idOf(item) == cursor.ID })
+		items = items[index+1:]
+	}
+	if page.Limit > 0 && len(items) > page.Limit {
+		items = items[:page.Limit]
+		return items, models.EncodeCursor(models.Cursor{ID: idOf(items[page.Limit-1])}), nil
+	}
+	return items, "", nil
+}
 
-	ts.server.ServeHTTP(responseRecorder, request)
+func (s *StubTodoStore) QueryProjs(ctx context.Context, page models.Page) ([]models.PROJECT, string, error) {
+	return paginateStub(s.store, page, func(proj models.PROJECT) string { return proj.ID.Hex() })
+}
 
-	response := responseRecorder.Result()
+func (s *StubTodoStore) QueryTodos(ctx context.Context, q models.TodoQuery) ([]models.TODO, string, error) {
+	todos := []models.TODO{}
 
-	got := []models.TODO{}
+	for _, proj := range s.store {
+		if q.ProjID != "" && proj.ID.Hex() != q.ProjID {
+			continue
+		}
+		for _, todo := range proj.Tasks {
+			if q.Match(todo) {
+				todo.ProjID = proj.ID.Hex()
+				todos = append(todos, todo)
+			}
+		}
+	}
+	q.Sort(todos)
+	return paginateStub(todos, q.Page, func(todo models.TODO) string { return todo.ID.Hex() })
+}
 
-	err := json.NewDecoder(response.Body).Decode(&got)
-	defer response.Body.Close()
+func (s *StubTodoStore) Search(ctx context.Context, query string, limit int) ([]models.SearchHit, error) {
+	terms := textsearch.Tokenize(query)
+	hits := []models.SearchHit{}
 
-	if err != nil {
-		ts.FailNow(err.Error())
+	for _, proj := range s.store {
+		project := models.PROJECT{ID: proj.ID, ProjName: proj.ProjName}
+		if score := textsearch.Score(terms, proj.ProjName); score > 0 {
+			hits = append(hits, models.SearchHit{Kind: models.SearchHitProject, Score: score, Project: project})
+		}
+		for _, todo := range proj.Tasks {
+			if score := textsearch.Score(terms, todo.Name, todo.Description); score > 0 {
+				hits = append(hits, models.SearchHit{Kind: models.SearchHitTodo, Score: score, Todo: &todo, Project: project})
+			}
+		}
 	}
-	objID1, _ := bson.ObjectIDFromHex("67bc5c4f1e8db0c9a17efca0")
-	objID2, _ := bson.ObjectIDFromHex("67e0c98b2c3e82a398cdbb16")
-	objID4, _ := bson.ObjectIDFromHex("682996bc78d219298228c10a")
-	dueDate1 := time.Now().AddDate(0, 3, 0)
-	dueDate2 := time.Now().AddDate(0, 0, 3)
-	dueDate4 := time.Now().AddDate(0, 0, 3)
+	slices.SortStableFunc(hits, func(a, b models.SearchHit) int { return cmp.Compare(b.Score, a.Score) })
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
 
-	want := []models.TODO{
-		{ID: &objID1, Name: "Water Plants", Description: "Not too much water for aloe vera", DueDate: &dueDate1},
-		{ID: &objID2, Name: "Buy socks", Description: "No show socks", DueDate: &dueDate2},
-		{ID: &objID4, Name: "Test task 3", Description: "test description", DueDate: &dueDate4},
+func (s *StubTodoStore) BatchTodos(ctx context.Context, ops []models.BatchOp, atomic bool) ([]models.BatchResult, error) {
+	// keep a copy of every project's tasks to roll back to
+	snapshot := make([]models.PROJECT, len(s.store))
+	for i, proj := range s.store {
+		snapshot[i] = proj
+		snapshot[i].Tasks = slices.Clone(proj.Tasks)
 	}
-	for i, todo := range want {
-		ts.compareTodoStructFields(todo, got[i])
+	trash := slices.Clone(s.trash)
+
+	results := make([]models.BatchResult, len(ops))
+	for i, op := range ops {
+		switch op.Op {
+		case models.BatchCreate:
+			results[i].ID, results[i].Err = s.CreateTodo(ctx, op.ProjID, op.Todo)
+		case models.BatchUpdate:
+			results[i] = models.BatchResult{ID: op.ID, Err: s.UpdateTodoByID(ctx, op.ID, op.Todo)}
+		case models.BatchDelete:
+			_, err := s.DeleteTodoByID(ctx, op.ID, op.Todo.Version)
+			results[i] = models.BatchResult{ID: op.ID, Err: err}
+		}
+		if results[i].Err != nil && atomic {
+			s.store = snapshot
+			s.trash = trash
+			return models.AbortBatch(ops, results, i), nil
+		}
 	}
+	return results, nil
 }
 
-func (ts *TestSuite) TestGetProjByID() {
-	getTests := []struct {
-		testname   string
-		testpath   string
-		want       models.PROJECT
-		statusCode int
-	}{
-		{"get proj 1", "/proj/682571d1dafbee2eecbf4913", proj1, http.StatusOK},
-		{"get proj 2", "/proj/68299585e7b6718ddf79b567", proj2, http.StatusOK},
-		{"get non-existent proj 3", "/proj/3", models.PROJECT{}, http.StatusNotFound},
+func (s *StubTodoStore) MoveTodo(ctx context.Context, todoID, projID string) error {
+	target := slices.IndexFunc(s.store, func(proj models.PROJECT) bool { return proj.ID.Hex() == projID })
+	if target == -1 {
+		return errs.ErrNotFound
 	}
-
-	for _, test := range getTests {
-		request, _ := http.NewRequest(http.MethodGet, test.testpath, nil)
-		responseRecorder := httptest.NewRecorder()
-
-		ts.server.ServeHTTP(responseRecorder, request)
-
-		response := responseRecorder.Result()
-
-		got := models.PROJECT{}
-
-		err := json.NewDecoder(response.Body).Decode(&got)
-		defer response.Body.Close()
-
-		if err != nil {
-			if !errors.Is(err, io.EOF) {
-				ts.FailNow(err.Error())
+	for projIndex, proj := range s.store {
+		for taskIndex, task := range proj.Tasks {
+			if task.ID.Hex() == todoID {
+				s.store[projIndex].Tasks = slices.Delete(s.store[projIndex].Tasks, taskIndex, taskIndex+1)
+				task.Rank = rank.After(s.lastRank(target))
+				s.store[target].Tasks = append(s.store[target].Tasks, task)
+				return nil
 			}
 		}
-
-		ts.compareProjStructFields(test.want, got)
-
-		ts.assertStatusCode(test.statusCode, responseRecorder.Code)
 	}
+	return errs.ErrNotFound
 }
 
-func (ts *TestSuite) TestCreateNewProj() {
-	project := models.PROJECT{
-		ProjName: "Test Project Name",
-		Tasks:    []models.TODO{},
+func (s *StubTodoStore) ReorderTodo(ctx context.Context, todoID string, place models.Placement) error {
+	anchorID, after := place.Before, false
+	if place.After != "" {
+		anchorID, after = place.After, true
 	}
 
-	jsonData, err := json.Marshal(project)
+	todo, err := s.GetTodoByID(ctx, todoID)
 	if err != nil {
-		ts.FailNow(err.Error())
+		return err
 	}
-
-	request, _ := http.NewRequest(http.MethodPost, "/proj/", bytes.NewBuffer(jsonData))
-	response := httptest.NewRecorder()
-
-	request.Header.Set("Content-Type", "application/json")
-
-	ts.server.ServeHTTP(response, request)
-
-	byteGot, _ := io.ReadAll(response.Result().Body)
-	insertedIDString := string(byteGot)[:24]
-
-	got, err := ts.server.TodoStore.GetProjByID(insertedIDString)
+	anchorTodo, err := s.GetTodoByID(ctx, anchorID)
 	if err != nil {
-		ts.FailNow(err.Error())
+		return err
+	}
+	if todo.ProjID != anchorTodo.ProjID {
+		return errs.ErrValidation
 	}
 
-	insertedObjID, err := bson.ObjectIDFromHex(insertedIDString)
-	if err != nil {
-		ts.FailNow(err.Error())
+	proj, _ := s.GetProjByID(ctx, todo.ProjID)
+
+	keys := []string{}
+	from, anchor := -1, -1
+	for i, task := range proj.Tasks {
+		keys = append(keys, task.Rank)
+		switch task.ID.Hex() {
+		case todoID:
+			from = i
+		case anchorID:
+			anchor = i
+		}
 	}
-	want := models.PROJECT{ID: &insertedObjID, ProjName: "Test Project Name", Tasks: []models.TODO{}}
 
-	ts.compareProjStructFields(want, got)
+	for i, key := range rank.Move(keys, from, anchor, after) {
+		for projIndex := range s.store {
+			for taskIndex, task := range s.store[projIndex].Tasks {
+				if task.ID == proj.Tasks[i].ID {
+					s.store[projIndex].Tasks[taskIndex].Rank = key
+				}
+			}
+		}
+	}
+	return nil
 }
 
-func (ts *TestSuite) TestCreateTodo() {
-	// reset seeded data
-	ts.SetupTest()
-
-	timestamp := bson.Timestamp{T: uint32(time.Now().Unix())}
-
-	todoToCreate := models.TODO{
-		Name:          "Newly Created Task",
-		Description:   "Newly Created Description",
-		DueDateString: "2020-03-20T02:00:00+08:00",
-		Priority:      "high",
-		Completed:     false,
-		Updated_at:    &timestamp,
+// task returns a pointer to the stored todo so that its items can be changed in place
+func (s *StubTodoStore) task(todoID string) (*models.TODO, error) {
+	for projIndex := range s.store {
+		for taskIndex, task := range s.store[projIndex].Tasks {
+			if task.ID.Hex() == todoID {
+				return &s.store[projIndex].Tasks[taskIndex], nil
+			}
+		}
 	}
+	return nil, errs.ErrNotFound
+}
 
-	jsonData, err := json.Marshal(todoToCreate)
+func (s *StubTodoStore) AddItem(ctx context.Context, todoID string, item models.ChecklistItem) (string, error) {
+	todo, err := s.task(todoID)
 	if err != nil {
-		ts.FailNow(err.Error())
+		return "", err
 	}
+	lastRank := ""
+	for _, existing := range todo.Items {
+		lastRank = max(lastRank, existing.Rank)
+	}
+	item.ID = bson.NewObjectID().Hex()
+	item.Rank = rank.After(lastRank)
+	todo.Items = append(todo.Items, item)
+	return item.ID, nil
+}
 
-	request, _ := http.NewRequest(http.MethodPost, "/proj/68299585e7b6718ddf79b567", bytes.NewBuffer(jsonData))
-	responseRecorder := httptest.NewRecorder()
-
-	request.Header.Set("Content-Type", "application/json")
-
-	ts.server.ServeHTTP(responseRecorder, request)
-
-	response := responseRecorder.Result()
-
-	byteGot, err := io.ReadAll(response.Body)
+func (s *StubTodoStore) UpdateItem(ctx context.Context, todoID, itemID string, item models.ChecklistItem) error {
+	todo, err := s.task(todoID)
 	if err != nil {
-		if err != io.EOF {
-			ts.FailNow(err.Error())
+		return err
+	}
+	for i := range todo.Items {
+		if todo.Items[i].ID == itemID {
+			todo.Items[i].Name = item.Name
+			todo.Items[i].Completed = item.Completed
+			return nil
 		}
 	}
-	defer response.Body.Close()
-
-	ts.assertStatusCode(201, responseRecorder.Code)
+	return errs.ErrNotFound
+}
 
-	insertedIDString := string(byteGot)[:24]
-	log.Println("debug bytegot", string(byteGot))
+func (s *StubTodoStore) ReorderItem(ctx context.Context, todoID, itemID string, place models.Placement) error {
+	anchorID, after := place.Before, false
+	if place.After != "" {
+		anchorID, after = place.After, true
+	}
 
-	insertedObjID, err := bson.ObjectIDFromHex(insertedIDString)
+	todo, err := s.task(todoID)
 	if err != nil {
-		ts.FailNow(err.Error())
+		return err
 	}
-	dueDate, err := time.Parse(time.RFC3339, "2020-03-20T02:00:00+08:00")
-	if err != nil {
-		ts.FailNow(err.Error())
+	models.SortItems(todo.Items)
+
+	keys := []string{}
+	from, anchor := -1, -1
+	for i, item := range todo.Items {
+		keys = append(keys, item.Rank)
+		switch item.ID {
+		case itemID:
+			from = i
+		case anchorID:
+			anchor = i
+		}
 	}
+	if from == -1 || anchor == -1 {
+		return errs.ErrNotFound
+	}
+
+	for i, key := range rank.Move(keys, from, anchor, after) {
+		todo.Items[i].Rank = key
+	}
+	return nil
+}
 
-	got, err := ts.server.TodoStore.GetProjByID("68299585e7b6718ddf79b567")
+func (s *StubTodoStore) DeleteItem(ctx context.Context, todoID, itemID string) (int, error) {
+	todo, err := s.task(todoID)
 	if err != nil {
-		ts.FailNow(err.Error())
+		return 0, err
+	}
+	index := slices.IndexFunc(todo.Items, func(item models.ChecklistItem) bool { return item.ID == itemID })
+	if index == -1 {
+		return 0, errs.ErrNotFound
 	}
+	todo.Items = slices.Delete(todo.Items, index, index+1)
+	return 1, nil
+}
 
-	want := models.PROJECT{ID: &objID5, ProjName: "proj2", Tasks: todos2}
-	want.Tasks = append(want.Tasks, models.TODO{
-		ID:          &insertedObjID,
-		Name:        "Newly Created Task",
-		Description: "Newly Created Description",
-		DueDate:     &dueDate,
-		Priority:    "high",
-		Completed:   false,
-		Updated_at:  &timestamp,
-	})
+func (s *StubTodoStore) GetAllLabels(ctx context.Context) ([]models.Label, error) {
+	return slices.Clone(s.labels), nil
+}
 
-	ts.compareProjStructFields(want, got)
+func (s *StubTodoStore) GetLabelByID(ctx context.Context, ID string) (models.Label, error) {
+	index := slices.IndexFunc(s.labels, func(label models.Label) bool { return label.ID == ID })
+	if index == -1 {
+		return models.Label{}, errs.ErrNotFound
+	}
+	return s.labels[index], nil
 }
 
-func (ts *TestSuite) TestUpdateProjNameByID() {
-	updatedProj := models.PROJECT{
-		ProjName: "Updated Proj Name",
+func (s *StubTodoStore) CreateLabel(ctx context.Context, label models.Label) (string, error) {
+	if slices.ContainsFunc(s.labels, func(existing models.Label) bool { return existing.Name == label.Name }) {
+		return "", errs.ErrConflict
 	}
+	label.ID = bson.NewObjectID().Hex()
+	s.labels = append(s.labels, label)
+	return label.ID, nil
+}
 
-	jsonData, err := json.Marshal(updatedProj)
-	if err != nil {
-		ts.FailNow(err.Error())
+func (s *StubTodoStore) UpdateLabel(ctx context.Context, ID string, label models.Label) error {
+	index := slices.IndexFunc(s.labels, func(label models.Label) bool { return label.ID == ID })
+	if index == -1 {
+		return errs.ErrNotFound
 	}
+	label.ID = ID
+	s.labels[index] = label
+	return nil
+}
 
-	request, _ := http.NewRequest(http.MethodPatch, "/proj/68299585e7b6718ddf79b567", bytes.NewBuffer(jsonData))
-	responseRecorder := httptest.NewRecorder()
+func (s *StubTodoStore) DeleteLabel(ctx context.Context, ID string) (int, error) {
+	index := slices.IndexFunc(s.labels, func(label models.Label) bool { return label.ID == ID })
+	if index == -1 {
+		return 0, errs.ErrNotFound
+	}
+	s.labels = slices.Delete(s.labels, index, index+1)
 
-	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for projIndex := range s.store {
+		for taskIndex, task := range s.store[projIndex].Tasks {
+			s.store[projIndex].Tasks[taskIndex].Labels = slices.DeleteFunc(slices.Clone(task.Labels), func(label string) bool { return label == ID })
+		}
+	}
+	return 1, nil
+}
 
-	ts.server.ServeHTTP(responseRecorder, request)
+func (s *StubTodoStore) GetAllWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	return slices.Clone(s.webhooks), nil
+}
 
-	got, err := ts.server.TodoStore.GetProjByID("68299585e7b6718ddf79b567")
-	if err != nil {
-		ts.FailNow(err.Error())
+func (s *StubTodoStore) GetWebhookByID(ctx context.Context, ID string) (models.Webhook, error) {
+	index := slices.IndexFunc(s.webhooks, func(hook models.Webhook) bool { return hook.ID == ID })
+	if index == -1 {
+		return models.Webhook{}, errs.ErrNotFound
 	}
+	return s.webhooks[index], nil
+}
 
-	want := models.PROJECT{ID: &objID5, ProjName: "Updated Proj Name", Tasks: todos2}
+func (s *StubTodoStore) CreateWebhook(ctx context.Context, hook models.Webhook) (string, error) {
+	hook.ID = bson.NewObjectID().Hex()
+	s.webhooks = append(s.webhooks, hook)
+	return hook.ID, nil
+}
 
-	ts.compareProjStructFields(want, got)
+func (s *StubTodoStore) UpdateWebhook(ctx context.Context, ID string, hook models.Webhook) error {
+	index := slices.IndexFunc(s.webhooks, func(hook models.Webhook) bool { return hook.ID == ID })
+	if index == -1 {
+		return errs.ErrNotFound
+	}
+	hook.ID = ID
+	s.webhooks[index] = hook
+	return nil
 }
 
-func (ts *TestSuite) TestUpdateTodoByID() {
-	// reset seeded data
-	ts.SetupTest()
+func (s *StubTodoStore) DeleteWebhook(ctx context.Context, ID string) (int, error) {
+	index := slices.IndexFunc(s.webhooks, func(hook models.Webhook) bool { return hook.ID == ID })
+	if index == -1 {
+		return 0, errs.ErrNotFound
+	}
+	s.webhooks = slices.Delete(s.webhooks, index, index+1)
+	s.deliveries = slices.DeleteFunc(s.deliveries, func(delivery models.Delivery) bool { return delivery.WebhookID == ID })
+	return 1, nil
+}
 
-	timestamp := bson.Timestamp{T: uint32(time.Now().Unix())}
+func (s *StubTodoStore) AddDelivery(delivery models.Delivery) error {
+	s.deliveries = append(s.deliveries, delivery)
+	return nil
+}
 
-	todoToUpdate := models.TODO{
-		Name:          "Updated Task",
-		Description:   "Updated Description",
-		DueDateString: "2025-03-20T02:00:00+08:00",
-		Priority:      "low",
-		Completed:     false,
-		Updated_at:    &timestamp,
+func (s *StubTodoStore) GetDeliveries(ctx context.Context, webhookID string, limit int) ([]models.Delivery, error) {
+	deliveries := []models.Delivery{}
+	for i := len(s.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if s.deliveries[i].WebhookID == webhookID {
+			deliveries = append(deliveries, s.deliveries[i])
+		}
+	}
+	return deliveries, nil
+}
+
+func (s *StubTodoStore) CreateIdempotencyRecord(record models.IdempotencyRecord) error {
+	if s.idempotency == nil {
+		s.idempotency = map[string]models.IdempotencyRecord{}
+	}
+	if existing, ok := s.idempotency[record.Key]; ok && existing.ExpiresAt.After(record.CreatedAt) {
+		return errs.ErrConflict
+	}
+	s.idempotency[record.Key] = record
+	return nil
+}
+
+func (s *StubTodoStore) GetIdempotencyRecord(key string) (models.IdempotencyRecord, error) {
+	record, ok := s.idempotency[key]
+	if !ok || !record.ExpiresAt.After(time.Now()) {
+		return models.IdempotencyRecord{}, errs.ErrNotFound
+	}
+	return record, nil
+}
+
+func (s *StubTodoStore) SaveIdempotencyRecord(record models.IdempotencyRecord) error {
+	if _, ok := s.idempotency[record.Key]; !ok {
+		return errs.ErrNotFound
+	}
+	s.idempotency[record.Key] = record
+	return nil
+}
+
+func (s *StubTodoStore) DeleteIdempotencyRecord(key string) error {
+	if _, ok := s.idempotency[key]; !ok {
+		return errs.ErrNotFound
+	}
+	delete(s.idempotency, key)
+	return nil
+}
+
+func (s *StubTodoStore) GetTrash(ctx context.Context) ([]models.TrashItem, error) {
+	trash := slices.Clone(s.trash)
+	slices.Reverse(trash)
+	return trash, nil
+}
+
+// takeFromTrash removes the item of kind with ID from the trash and returns it
+func (s *StubTodoStore) takeFromTrash(kind, ID string) (models.TrashItem, error) {
+	for i, item := range s.trash {
+		if item.Kind == kind && item.ID == ID {
+			s.trash = slices.Delete(s.trash, i, i+1)
+			return item, nil
+		}
+	}
+	return models.TrashItem{}, errs.ErrNotFound
+}
+
+func (s *StubTodoStore) RestoreTodo(ctx context.Context, todoID string) error {
+	for _, item := range s.trash {
+		if item.Kind != models.TrashTodo || item.ID != todoID {
+			continue
+		}
+		for projIndex, proj := range s.store {
+			if proj.ID.Hex() == item.Todo.ProjID {
+				s.takeFromTrash(models.TrashTodo, todoID)
+				todo := *item.Todo
+				todo.DeletedAt = nil
+				todo.Version++
+				s.store[projIndex].Tasks = append(s.store[projIndex].Tasks, todo)
+				return nil
+			}
+		}
+	}
+	return errs.ErrNotFound
+}
+
+func (s *StubTodoStore) RestoreProj(ctx context.Context, ID string) error {
+	item, err := s.takeFromTrash(models.TrashProject, ID)
+	if err != nil {
+		return err
+	}
+	proj := *item.Project
+	proj.DeletedAt = nil
+	proj.Version++
+	s.store = append(s.store, proj)
+	return nil
+}
+
+func (s *StubTodoStore) PurgeTrash(before time.Time) (int, error) {
+	kept := []models.TrashItem{}
+	for _, item := range s.trash {
+		if !item.DeletedAt.Before(before) {
+			kept = append(kept, item)
+		}
+	}
+	purged := len(s.trash) - len(kept)
+	s.trash = kept
+	return purged, nil
+}
+
+// recordTodo and recordProj add history entries the way the stores do
+func (s *StubTodoStore) recordTodo(ctx context.Context, operation, ID string, before, after models.TODO) {
+	entry, _ := history.TodoEntry(ctx, operation, ID, before, after)
+	entry.ID = strconv.Itoa(len(s.history) + 1)
+	s.history = append(s.history, entry)
+}
+
+func (s *StubTodoStore) recordProj(ctx context.Context, operation, ID string, before, after models.PROJECT) {
+	entry, _ := history.ProjectEntry(ctx, operation, ID, before, after)
+	entry.ID = strconv.Itoa(len(s.history) + 1)
+	s.history = append(s.history, entry)
+}
+
+func (s *StubTodoStore) GetHistory(ctx context.Context, kind, ID string) ([]models.HistoryEntry, error) {
+	entries := []models.HistoryEntry{}
+	for _, entry := range s.history {
+		if entry.Kind == kind && entry.EntityID == ID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (s *StubTodoStore) CreateUser(user models.User) (string, error) {
+	for _, existing := range s.users {
+		if existing.Email == user.Email {
+			return "", errs.ErrConflict
+		}
+	}
+	user.ID = bson.NewObjectID().Hex()
+	s.users = append(s.users, user)
+	return user.ID, nil
+}
+
+func (s *StubTodoStore) GetUserByEmail(email string) (models.User, error) {
+	for _, user := range s.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return models.User{}, errs.ErrNotFound
+}
+
+func (s *StubTodoStore) CreateRefreshToken(token models.RefreshToken) error {
+	if s.refreshTokens == nil {
+		s.refreshTokens = map[string]models.RefreshToken{}
+	}
+	s.refreshTokens[token.Hash] = token
+	return nil
+}
+
+func (s *StubTodoStore) UseRefreshToken(hash string, usedAt time.Time) (models.RefreshToken, error) {
+	token, ok := s.refreshTokens[hash]
+	if !ok {
+		return models.RefreshToken{}, errs.ErrNotFound
+	}
+	if token.UsedAt != nil {
+		return token, errs.ErrConflict
+	}
+	token.UsedAt = &usedAt
+	s.refreshTokens[hash] = token
+	return token, nil
+}
+
+func (s *StubTodoStore) DeleteRefreshTokens(family string) (int, error) {
+	deleted := 0
+	for hash, token := range s.refreshTokens {
+		if token.Family == family {
+			delete(s.refreshTokens, hash)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *StubTodoStore) CreatePersonalToken(token models.PersonalToken) (string, error) {
+	token.ID = bson.NewObjectID().Hex()
+	s.personalTokens = append(s.personalTokens, token)
+	return token.ID, nil
+}
+
+func (s *StubTodoStore) GetPersonalTokens(userID string) ([]models.PersonalToken, error) {
+	tokens := []models.PersonalToken{}
+	for _, token := range s.personalTokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+func (s *StubTodoStore) GetPersonalToken(hash string) (models.PersonalToken, error) {
+	for _, token := range s.personalTokens {
+		if token.Hash == hash {
+			return token, nil
+		}
+	}
+	return models.PersonalToken{}, errs.ErrNotFound
+}
+
+func (s *StubTodoStore) UsePersonalToken(hash string, usedAt time.Time) (models.PersonalToken, error) {
+	for i, token := range s.personalTokens {
+		if token.Hash == hash {
+			s.personalTokens[i].LastUsedAt = &usedAt
+			return s.personalTokens[i], nil
+		}
+	}
+	return models.PersonalToken{}, errs.ErrNotFound
+}
+
+func (s *StubTodoStore) DeletePersonalToken(userID, ID string) (int, error) {
+	for i, token := range s.personalTokens {
+		if token.ID == ID && token.UserID == userID {
+			s.personalTokens = slices.Delete(s.personalTokens, i, i+1)
+			return 1, nil
+		}
+	}
+	return 0, errs.ErrNotFound
+}
+
+// GetProjAccess makes the user of ctx the owner of the projects without an owner, like the seed data
+func (s *StubTodoStore) GetProjAccess(ctx context.Context, projID string) (models.Access, error) {
+	for _, proj := range s.store {
+		if proj.ID.Hex() == projID {
+			return stubAccess(ctx, proj)
+		}
+	}
+	return models.Access{}, errs.ErrNotFound
+}
+
+func (s *StubTodoStore) GetTodoAccess(ctx context.Context, todoID string) (models.Access, error) {
+	for _, proj := range s.store {
+		for _, task := range proj.Tasks {
+			if task.ID.Hex() == todoID {
+				return stubAccess(ctx, proj)
+			}
+		}
+	}
+	return models.Access{}, errs.ErrNotFound
+}
+
+func stubAccess(ctx context.Context, proj models.PROJECT) (models.Access, error) {
+	access := models.Access{ProjID: proj.ID.Hex(), Owner: proj.Owner, Role: models.RoleOwner}
+	userID := auth.UserID(ctx)
+	if proj.Owner == "" || proj.Owner == userID {
+		return access, nil
+	}
+	for _, collaborator := range proj.Collaborators {
+		if collaborator.UserID == userID {
+			access.Role = collaborator.Role
+			return access, nil
+		}
+	}
+	return models.Access{}, errs.ErrNotFound
+}
+
+func (s *StubTodoStore) GetCollaborators(ctx context.Context, projID string) ([]models.Collaborator, error) {
+	proj, err := s.GetProjByID(ctx, projID)
+	if err != nil {
+		return nil, err
+	}
+	collaborators := slices.Clone(proj.Collaborators)
+	slices.SortFunc(collaborators, func(a, b models.Collaborator) int { return cmp.Compare(a.UserID, b.UserID) })
+	return collaborators, nil
+}
+
+// collaboratorIndex returns the index of the project projID and of its collaborator userID, -1 if it has none
+func (s *StubTodoStore) collaboratorIndex(projID, userID string) (int, int, error) {
+	for i, proj := range s.store {
+		if proj.ID.Hex() == projID {
+			return i, slices.IndexFunc(proj.Collaborators, func(c models.Collaborator) bool { return c.UserID == userID }), nil
+		}
+	}
+	return 0, 0, errs.ErrNotFound
+}
+
+func (s *StubTodoStore) AddCollaborator(ctx context.Context, projID string, collaborator models.Collaborator) error {
+	i, j, err := s.collaboratorIndex(projID, collaborator.UserID)
+	if err != nil {
+		return err
+	}
+	if j != -1 {
+		return errs.ErrConflict
+	}
+	s.store[i].Collaborators = append(s.store[i].Collaborators, collaborator)
+	return nil
+}
+
+func (s *StubTodoStore) UpdateCollaborator(ctx context.Context, projID string, collaborator models.Collaborator) error {
+	i, j, err := s.collaboratorIndex(projID, collaborator.UserID)
+	if err != nil {
+		return err
+	}
+	if j == -1 {
+		return errs.ErrNotFound
+	}
+	s.store[i].Collaborators[j] = collaborator
+	return nil
+}
+
+func (s *StubTodoStore) RemoveCollaborator(ctx context.Context, projID, userID string) (int, error) {
+	i, j, err := s.collaboratorIndex(projID, userID)
+	if err != nil {
+		return 0, err
+	}
+	if j == -1 {
+		return 0, errs.ErrNotFound
+	}
+	s.store[i].Collaborators = slices.Delete(s.store[i].Collaborators, j, j+1)
+	return 1, nil
+}
+
+func (s *StubTodoStore) checkLabels(ctx context.Context, labels []string) error {
+	for _, label := range labels {
+		if _, err := s.GetLabelByID(ctx, label); err != nil {
+			return errs.ErrValidation
+		}
+	}
+	return nil
+}
+
+func (ts *TestSuite) TestGetAllProjs() {
+	request, _ := http.NewRequest(http.MethodGet, "/proj", nil)
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+
+	response := responseRecorder.Result()
+
+	got := []models.PROJECT{}
+
+	err := json.NewDecoder(response.Body).Decode(&got)
+	defer response.Body.Close()
+
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	for index, proj := range got {
+		ts.compareProjStructFields(store[index], proj)
+	}
+
+	ts.assertStatusCode(200, responseRecorder.Code)
+}
+
+func (ts *TestSuite) TestGetAllTodos() {
+	request, _ := http.NewRequest(http.MethodGet, "/todo", nil)
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+
+	response := responseRecorder.Result()
+
+	got := []models.TODO{}
+
+	err := json.NewDecoder(response.Body).Decode(&got)
+	defer response.Body.Close()
+
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	objID1, _ := bson.ObjectIDFromHex("67bc5c4f1e8db0c9a17efca0")
+	objID2, _ := bson.ObjectIDFromHex("67e0c98b2c3e82a398cdbb16")
+	objID4, _ := bson.ObjectIDFromHex("682996bc78d219298228c10a")
+	dueDate1 := time.Now().AddDate(0, 3, 0)
+	dueDate2 := time.Now().AddDate(0, 0, 3)
+	dueDate4 := time.Now().AddDate(0, 0, 3)
+
+	want := []models.TODO{
+		{ID: &objID1, Name: "Water Plants", Description: "Not too much water for aloe vera", DueDate: &dueDate1},
+		{ID: &objID2, Name: "Buy socks", Description: "No show socks", DueDate: &dueDate2},
+		{ID: &objID4, Name: "Test task 3", Description: "test description", DueDate: &dueDate4},
+	}
+	for i, todo := range want {
+		ts.compareTodoStructFields(todo, got[i])
+	}
+}
+
+func (ts *TestSuite) TestQueryTodos() {
+	inAMonth := url.QueryEscape(time.Now().AddDate(0, 1, 0).UTC().Format(time.RFC3339))
+
+	queryTests := []struct {
+		testname   string
+		testpath   string
+		wantIDs    []bson.ObjectID
+		statusCode int
+	}{
+		{"no filters", "/todo", []bson.ObjectID{objID1, objID2, objID4}, http.StatusOK},
+		{"name substring", "/todo?name=SOCKS", []bson.ObjectID{objID2}, http.StatusOK},
+		{"by project", "/todo?project=682571d1dafbee2eecbf4913", []bson.ObjectID{objID1, objID2}, http.StatusOK},
+		{"due before", "/todo?dueBefore=" + inAMonth, []bson.ObjectID{objID2, objID4}, http.StatusOK},
+		{"due after", "/todo?dueAfter=" + inAMonth, []bson.ObjectID{objID1}, http.StatusOK},
+		{"completed", "/todo?completed=true", []bson.ObjectID{}, http.StatusOK},
+		{"sort by due date desc", "/todo?sort=dueDate&order=desc", []bson.ObjectID{objID1, objID2, objID4}, http.StatusOK},
+		{"sort by due date asc", "/todo?sort=dueDate&project=682571d1dafbee2eecbf4913", []bson.ObjectID{objID2, objID1}, http.StatusOK},
+		{"invalid sort", "/todo?sort=bogus", nil, http.StatusBadRequest},
+		{"invalid order", "/todo?sort=dueDate&order=sideways", nil, http.StatusBadRequest},
+		{"invalid completed", "/todo?completed=maybe", nil, http.StatusBadRequest},
+		{"invalid due date", "/todo?dueBefore=tomorrow", nil, http.StatusBadRequest},
+	}
+
+	for _, test := range queryTests {
+		ts.Run(test.testname, func() {
+			request, _ := http.NewRequest(http.MethodGet, test.testpath, nil)
+			responseRecorder := httptest.NewRecorder()
+
+			ts.server.ServeHTTP(responseRecorder, request)
+
+			ts.assertStatusCode(test.statusCode, responseRecorder.Code)
+			if test.statusCode != http.StatusOK {
+				return
+			}
+
+			got := []models.TODO{}
+			err := json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+			if err != nil {
+				ts.FailNow(err.Error())
+			}
+
+			gotIDs := []bson.ObjectID{}
+			for _, todo := range got {
+				gotIDs = append(gotIDs, *todo.ID)
+			}
+			ts.Equal(test.wantIDs, gotIDs)
+		})
+	}
+}
+
+func (ts *TestSuite) TestPaginateTodos() {
+	gotIDs := []bson.ObjectID{}
+	path := "/todo?limit=2&sort=dueDate"
+	pages := 0
+
+	for path != "" {
+		request, _ := http.NewRequest(http.MethodGet, path, nil)
+		responseRecorder := httptest.NewRecorder()
+
+		ts.server.ServeHTTP(responseRecorder, request)
+		ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+
+		got := []models.TODO{}
+		err := json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+		if err != nil {
+			ts.FailNow(err.Error())
+		}
+		for _, todo := range got {
+			gotIDs = append(gotIDs, *todo.ID)
+		}
+
+		path = ""
+		if link := responseRecorder.Header().Get("Link"); link != "" {
+			ts.Contains(link, `rel="next"`)
+			ts.Contains(link, "sort=dueDate")
+			path = link[1:strings.Index(link, ">")]
+		}
+		pages++
+	}
+
+	ts.Equal(2, pages)
+	ts.Equal([]bson.ObjectID{objID2, objID4, objID1}, gotIDs)
+}
+
+func (ts *TestSuite) TestPaginateProjs() {
+	request, _ := http.NewRequest(http.MethodGet, "/proj?limit=1", nil)
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+
+	got := []models.PROJECT{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Require().Len(got, 1)
+	ts.Equal(objID3, *got[0].ID)
+	ts.NotEmpty(responseRecorder.Header().Get("Link"))
+}
+
+func (ts *TestSuite) TestDefaultPage() {
+	// reset seeded data
+	ts.SetupTest()
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, path, strings.NewReader(body))
+		responseRecorder := httptest.NewRecorder()
+		ts.server.ServeHTTP(responseRecorder, request)
+		return responseRecorder
+	}
+	for i := range defaultPageLimit {
+		ts.assertStatusCode(http.StatusCreated, send(http.MethodPost, "/proj/"+objID3.Hex(), `{"name":"todo `+strconv.Itoa(i)+`"}`).Code)
+	}
+
+	// without a limit the first page holds defaultPageLimit todos and links to the rest
+	responseRecorder := send(http.MethodGet, "/todo", "")
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+
+	got := []models.TODO{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Len(got, defaultPageLimit)
+	ts.Contains(responseRecorder.Header().Get("Link"), `rel="next"`)
+
+	// a limit above the default is still capped by maxPageLimit only
+	responseRecorder = send(http.MethodGet, "/todo?limit="+strconv.Itoa(maxPageLimit), "")
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	got = []models.TODO{}
+	err = json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Greater(len(got), defaultPageLimit)
+	ts.Empty(responseRecorder.Header().Get("Link"))
+}
+
+func (ts *TestSuite) TestInvalidPage() {
+	for _, path := range []string{"/todo?limit=0", "/todo?limit=100000", "/proj?limit=abc", "/proj?cursor=!!!"} {
+		request, _ := http.NewRequest(http.MethodGet, path, nil)
+		responseRecorder := httptest.NewRecorder()
+
+		ts.server.ServeHTTP(responseRecorder, request)
+
+		ts.assertStatusCode(http.StatusBadRequest, responseRecorder.Code)
+	}
+}
+
+func (ts *TestSuite) TestQueryTodosSortByPriority() {
+	stub := ts.server.TodoStore.(*StubTodoStore)
+	stub.store[0].Tasks[0].Priority = "low"
+	stub.store[0].Tasks[1].Priority = "high"
+	stub.store[1].Tasks[0].Priority = "mid"
+
+	request, _ := http.NewRequest(http.MethodGet, "/todo?sort=priority&order=desc", nil)
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+
+	got := []models.TODO{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.Require().Len(got, 3)
+	ts.Equal([]string{"high", "mid", "low"}, []string{got[0].Priority, got[1].Priority, got[2].Priority})
+	ts.Equal("682571d1dafbee2eecbf4913", got[0].ProjID)
+}
+
+func (ts *TestSuite) TestGetProjByIDWithFilter() {
+	request, _ := http.NewRequest(http.MethodGet, "/proj/682571d1dafbee2eecbf4913?name=water", nil)
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+
+	got := models.PROJECT{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	ts.Equal("proj1", got.ProjName)
+	ts.Require().Len(got.Tasks, 1)
+	ts.Equal(objID1, *got.Tasks[0].ID)
+}
+
+func (ts *TestSuite) TestSearch() {
+	request, _ := http.NewRequest(http.MethodGet, "/search?q=water", nil)
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+
+	got := []models.SearchHit{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	ts.Require().Len(got, 1)
+	ts.Equal(models.SearchHitTodo, got[0].Kind)
+	ts.Equal(objID1, *got[0].Todo.ID)
+	ts.Equal(objID3, *got[0].Project.ID)
+	ts.Equal("proj1", got[0].Project.ProjName)
+}
+
+func (ts *TestSuite) TestSearchRanking() {
+	request, _ := http.NewRequest(http.MethodGet, "/search?q=proj2+task&limit=2", nil)
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+
+	got := []models.SearchHit{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.Require().Len(got, 2)
+	ts.GreaterOrEqual(got[0].Score, got[1].Score)
+}
+
+func (ts *TestSuite) TestSearchRequiresQuery() {
+	request, _ := http.NewRequest(http.MethodGet, "/search?q=++", nil)
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+
+	ts.assertStatusCode(http.StatusBadRequest, responseRecorder.Code)
+}
+
+func (ts *TestSuite) TestGetProjByID() {
+	getTests := []struct {
+		testname   string
+		testpath   string
+		want       models.PROJECT
+		statusCode int
+	}{
+		{"get proj 1", "/proj/682571d1dafbee2eecbf4913", proj1, http.StatusOK},
+		{"get proj 2", "/proj/68299585e7b6718ddf79b567", proj2, http.StatusOK},
+		{"get non-existent proj 3", "/proj/3", models.PROJECT{}, http.StatusNotFound},
+	}
+
+	for _, test := range getTests {
+		request, _ := http.NewRequest(http.MethodGet, test.testpath, nil)
+		responseRecorder := httptest.NewRecorder()
+
+		ts.server.ServeHTTP(responseRecorder, request)
+
+		response := responseRecorder.Result()
+
+		got := models.PROJECT{}
+
+		err := json.NewDecoder(response.Body).Decode(&got)
+		defer response.Body.Close()
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				ts.FailNow(err.Error())
+			}
+		}
+
+		ts.compareProjStructFields(test.want, got)
+
+		ts.assertStatusCode(test.statusCode, responseRecorder.Code)
+	}
+}
+
+func (ts *TestSuite) TestCreateNewProj() {
+	project := models.PROJECT{
+		ProjName: "Test Project Name",
+		Tasks:    []models.TODO{},
+	}
+
+	jsonData, err := json.Marshal(project)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	request, _ := http.NewRequest(http.MethodPost, "/proj/", bytes.NewBuffer(jsonData))
+	response := httptest.NewRecorder()
+
+	request.Header.Set("Content-Type", "application/json")
+
+	ts.server.ServeHTTP(response, request)
+
+	ts.assertStatusCode(http.StatusCreated, response.Code)
+
+	created := models.PROJECT{}
+	err = json.NewDecoder(response.Result().Body).Decode(&created)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	insertedIDString := created.ID.Hex()
+
+	ts.Equal("/proj/"+insertedIDString, response.Header().Get("Location"))
+
+	got, err := ts.server.TodoStore.GetProjByID(context.Background(), insertedIDString)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	insertedObjID, err := bson.ObjectIDFromHex(insertedIDString)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	want := models.PROJECT{ID: &insertedObjID, ProjName: "Test Project Name", Tasks: []models.TODO{}}
+
+	ts.compareProjStructFields(want, created)
+	ts.compareProjStructFields(want, got)
+}
+
+func (ts *TestSuite) TestCreateTodo() {
+	// reset seeded data
+	ts.SetupTest()
+
+	timestamp := bson.Timestamp{T: uint32(time.Now().Unix())}
+
+	todoToCreate := models.TODO{
+		Name:          "Newly Created Task",
+		Description:   "Newly Created Description",
+		DueDateString: "2020-03-20T02:00:00+08:00",
+		Priority:      "high",
+		Completed:     false,
+		Updated_at:    &timestamp,
+	}
+
+	jsonData, err := json.Marshal(todoToCreate)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	request, _ := http.NewRequest(http.MethodPost, "/proj/68299585e7b6718ddf79b567", bytes.NewBuffer(jsonData))
+	responseRecorder := httptest.NewRecorder()
+
+	request.Header.Set("Content-Type", "application/json")
+
+	ts.server.ServeHTTP(responseRecorder, request)
+
+	response := responseRecorder.Result()
+
+	created := models.TODO{}
+	err = json.NewDecoder(response.Body).Decode(&created)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	defer response.Body.Close()
+
+	ts.assertStatusCode(201, responseRecorder.Code)
+
+	insertedObjID := *created.ID
+	ts.Equal("/todo/"+insertedObjID.Hex(), responseRecorder.Header().Get("Location"))
+
+	dueDate, err := time.Parse(time.RFC3339, "2020-03-20T02:00:00+08:00")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	got, err := ts.server.TodoStore.GetProjByID(context.Background(), "68299585e7b6718ddf79b567")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	wantTodo := models.TODO{
+		ID:          &insertedObjID,
+		Name:        "Newly Created Task",
+		Description: "Newly Created Description",
+		DueDate:     &dueDate,
+		Priority:    "high",
+		Completed:   false,
+		Updated_at:  &timestamp,
+	}
+	want := models.PROJECT{ID: &objID5, ProjName: "proj2", Tasks: todos2}
+	want.Tasks = append(want.Tasks, wantTodo)
+
+	ts.compareTodoStructFields(wantTodo, created)
+	ts.compareProjStructFields(want, got)
+}
+
+func (ts *TestSuite) TestUpdateProjNameByID() {
+	request, _ := http.NewRequest(http.MethodPatch, "/proj/68299585e7b6718ddf79b567", strings.NewReader(`{"projname":"Updated Proj Name"}`))
+	responseRecorder := httptest.NewRecorder()
+
+	request.Header.Set("Content-Type", models.MergePatchType)
+
+	ts.server.ServeHTTP(responseRecorder, request)
+
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+
+	updated := models.PROJECT{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&updated)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	got, err := ts.server.TodoStore.GetProjByID(context.Background(), "68299585e7b6718ddf79b567")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	want := models.PROJECT{ID: &objID5, ProjName: "Updated Proj Name", Tasks: todos2}
+
+	ts.compareProjStructFields(want, updated)
+	ts.compareProjStructFields(want, got)
+}
+
+func (ts *TestSuite) TestUpdateTodoByID() {
+	// reset seeded data
+	ts.SetupTest()
+
+	timestamp := bson.Timestamp{T: uint32(time.Now().Unix())}
+
+	patch := `{"name":"Updated Task","description":"Updated Description","dueDateString":"2025-03-20T02:00:00+08:00","priority":"low","completed":false}`
+
+	request, _ := http.NewRequest(http.MethodPatch, "/todo/682996bc78d219298228c10a", strings.NewReader(patch))
+	responseRecorder := httptest.NewRecorder()
+
+	request.Header.Set("Content-Type", models.MergePatchType)
+
+	ts.server.ServeHTTP(responseRecorder, request)
+
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+
+	got, err := ts.server.TodoStore.GetProjByID(context.Background(), "68299585e7b6718ddf79b567")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	wantDueDate, err := time.Parse(time.RFC3339, "2025-03-20T02:00:00+08:00")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	want := models.PROJECT{
+		ID:       &objID5,
+		ProjName: "proj2",
+		Tasks: []models.TODO{
+			{ID: &objID4, Name: "Updated Task", Description: "Updated Description", DueDate: &wantDueDate, Priority: "low", Updated_at: &timestamp},
+		},
+	}
+	ts.compareProjStructFields(want, got)
+}
+
+func (ts *TestSuite) TestDeleteProjByID() {
+	request, _ := http.NewRequest(http.MethodDelete, "/proj/682571d1dafbee2eecbf4913", nil)
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+	response := responseRecorder.Result()
+
+	byteGot, err := io.ReadAll(response.Body)
+	if err != nil {
+		if err != io.EOF {
+			ts.FailNow(err.Error())
+		}
+	}
+
+	defer response.Body.Close()
+	got := string(byteGot)
+
+	ts.assertTodoText(`{"deletedCount":1}`+"\n", got)
+	ts.assertStatusCode(200, responseRecorder.Code)
+}
+
+func (ts *TestSuite) TestDeleteTodoByID() {
+	request, _ := http.NewRequest(http.MethodDelete, "/todo/67bc5c4f1e8db0c9a17efca0", nil)
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+	response := responseRecorder.Result()
+
+	byteGot, err := io.ReadAll(response.Body)
+	if err != nil {
+		if err != io.EOF {
+			ts.FailNow(err.Error())
+		}
+	}
+
+	defer response.Body.Close()
+	got := string(byteGot)
+
+	ts.assertTodoText(`{"deletedCount":1}`+"\n", got)
+	ts.assertStatusCode(200, responseRecorder.Code)
+}
+
+func (ts *TestSuite) TestTrash() {
+	// reset seeded data
+	ts.SetupTest()
+
+	todoID, projID := objID1.Hex(), objID5.Hex()
+
+	responseRecorder := ts.send(http.MethodDelete, "/todo/"+todoID, "")
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	responseRecorder = ts.send(http.MethodDelete, "/proj/"+projID, "")
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+
+	// trashed items are gone from normal reads
+	ts.assertStatusCode(http.StatusNotFound, ts.send(http.MethodGet, "/todo/"+todoID, "").Code)
+	ts.assertStatusCode(http.StatusNotFound, ts.send(http.MethodGet, "/proj/"+projID, "").Code)
+
+	responseRecorder = ts.send(http.MethodGet, "/trash", "")
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	trash := []models.TrashItem{}
+	err := json.NewDecoder(responseRecorder.Body).Decode(&trash)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Require().Len(trash, 2)
+	ts.Equal(models.TrashProject, trash[0].Kind)
+	ts.Equal(projID, trash[0].ID)
+	ts.Len(trash[0].Project.Tasks, 1)
+	ts.Equal(models.TrashTodo, trash[1].Kind)
+	ts.Equal(todoID, trash[1].ID)
+	ts.Equal("Water Plants", trash[1].Todo.Name)
+
+	responseRecorder = ts.send(http.MethodPost, "/trash/"+projID+"/restore", "")
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	proj := models.PROJECT{}
+	err = json.NewDecoder(responseRecorder.Body).Decode(&proj)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal("proj2", proj.ProjName)
+	ts.Len(proj.Tasks, 1)
+	ts.Nil(proj.DeletedAt)
+
+	responseRecorder = ts.send(http.MethodPost, "/trash/"+todoID+"/restore?kind=todo", "")
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodGet, "/todo/"+todoID, "").Code)
+	ts.Equal([]string{models.EventTodoDeleted, models.EventProjectDeleted, models.EventProjectRestored, models.EventTodoRestored}, ts.events.types)
+
+	// restored items are no longer in the trash
+	ts.assertStatusCode(http.StatusNotFound, ts.send(http.MethodPost, "/trash/"+todoID+"/restore", "").Code)
+	ts.assertStatusCode(http.StatusBadRequest, ts.send(http.MethodPost, "/trash/"+todoID+"/restore?kind=label", "").Code)
+}
+
+func (ts *TestSuite) TestHistory() {
+	// reset seeded data
+	ts.SetupTest()
+
+	responseRecorder := ts.send(http.MethodPost, "/proj/"+objID3.Hex(), `{"name":"Repot cactus"}`)
+	ts.assertStatusCode(http.StatusCreated, responseRecorder.Code)
+	created := models.TODO{}
+	err := json.NewDecoder(responseRecorder.Body).Decode(&created)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	todoID := created.ID.Hex()
+
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodPatch, "/todo/"+todoID, `{"name":"Repot all cacti"}`).Code)
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodDelete, "/todo/"+todoID, "").Code)
+
+	// the history outlives the todo
+	responseRecorder = ts.send(http.MethodGet, "/todo/"+todoID+"/history", "")
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	entries := []models.HistoryEntry{}
+	err = json.NewDecoder(responseRecorder.Body).Decode(&entries)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Require().Len(entries, 3)
+	ts.Equal([]string{models.OpCreate, models.OpUpdate, models.OpDelete}, []string{entries[0].Operation, entries[1].Operation, entries[2].Operation})
+	ts.Equal(history.Anonymous, entries[1].Actor)
+	ts.Require().Len(entries[1].Changes, 1)
+	ts.Equal("name", entries[1].Changes[0].Field)
+	ts.JSONEq(`"Repot cactus"`, string(entries[1].Changes[0].Before))
+	ts.JSONEq(`"Repot all cacti"`, string(entries[1].Changes[0].After))
+	ts.Equal("deletedAt", entries[2].Changes[0].Field)
+	ts.JSONEq(`null`, string(entries[2].Changes[0].Before))
+
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodPatch, "/proj/"+objID5.Hex(), `{"projname":"renamed"}`).Code)
+	responseRecorder = ts.send(http.MethodGet, "/proj/"+objID5.Hex()+"/history", "")
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	entries = []models.HistoryEntry{}
+	err = json.NewDecoder(responseRecorder.Body).Decode(&entries)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Require().Len(entries, 1)
+	ts.Equal(models.HistoryProject, entries[0].Kind)
+	ts.Equal([]models.FieldChange{{Field: "projname", Before: json.RawMessage(`"proj2"`), After: json.RawMessage(`"renamed"`)}}, entries[0].Changes)
+
+	// todos without recorded changes have an empty history, unknown ones have none
+	ts.Equal("[]\n", ts.send(http.MethodGet, "/todo/"+objID2.Hex()+"/history", "").Body.String())
+	ts.assertStatusCode(http.StatusNotFound, ts.send(http.MethodGet, "/todo/"+bson.NewObjectID().Hex()+"/history", "").Code)
+}
+
+// sendIfMatch sends a request with an If-Match header and returns the recorded response
+func (ts *TestSuite) sendIfMatch(method, path, body, ifMatch string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("If-Match", ifMatch)
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+	return responseRecorder
+}
+
+// sendIdempotent sends a request with an Idempotency-Key header and returns the recorded response
+func (ts *TestSuite) sendIdempotent(method, path, body, key string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Idempotency-Key", key)
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+	return responseRecorder
+}
+
+func (ts *TestSuite) TestIdempotencyKey() {
+	// reset seeded data
+	ts.SetupTest()
+
+	projs, err := ts.server.TodoStore.GetAllProjs(context.Background())
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	seeded := len(projs)
+
+	first := ts.sendIdempotent(http.MethodPost, "/proj/", `{"projname":"Retried"}`, "key-1")
+	ts.assertStatusCode(http.StatusCreated, first.Code)
+	ts.Empty(first.Header().Get("Idempotent-Replayed"))
+	events := len(ts.events.types)
+
+	// a retry gets the same response without creating the project again
+	retry := ts.sendIdempotent(http.MethodPost, "/proj/", `{"projname":"Retried"}`, "key-1")
+	ts.assertStatusCode(http.StatusCreated, retry.Code)
+	ts.Equal("true", retry.Header().Get("Idempotent-Replayed"))
+	ts.Equal(first.Header().Get("Location"), retry.Header().Get("Location"))
+	ts.Equal(first.Header().Get("ETag"), retry.Header().Get("ETag"))
+	ts.Equal("application/json", retry.Header().Get("Content-Type"))
+	ts.Equal(first.Body.String(), retry.Body.String())
+	ts.Len(ts.events.types, events)
+
+	projs, err = ts.server.TodoStore.GetAllProjs(context.Background())
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Len(projs, seeded+1)
+
+	// the same key for another request
+	responseRecorder := ts.sendIdempotent(http.MethodPost, "/proj/", `{"projname":"Different"}`, "key-1")
+	ts.assertStatusCode(http.StatusUnprocessableEntity, responseRecorder.Code)
+
+	got := errorResponse{}
+	err = json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal(codeIdempotencyReused, got.Error.Code)
+
+	responseRecorder = ts.sendIdempotent(http.MethodPost, "/proj/"+objID3.Hex(), `{"projname":"Retried"}`, "key-1")
+	ts.assertStatusCode(http.StatusUnprocessableEntity, responseRecorder.Code)
+
+	// todos are created once per key as well
+	first = ts.sendIdempotent(http.MethodPost, "/proj/"+objID3.Hex(), `{"name":"Retried todo"}`, "key-2")
+	ts.assertStatusCode(http.StatusCreated, first.Code)
+	retry = ts.sendIdempotent(http.MethodPost, "/proj/"+objID3.Hex(), `{"name":"Retried todo"}`, "key-2")
+	ts.assertStatusCode(http.StatusCreated, retry.Code)
+	ts.Equal(first.Header().Get("Location"), retry.Header().Get("Location"))
+	ts.Equal([]string{"Water Plants", "Buy socks", "Retried todo"}, ts.taskNames(objID3.Hex()))
+
+	// without a key every request runs
+	ts.assertStatusCode(http.StatusCreated, ts.send(http.MethodPost, "/proj/", `{"projname":"Twice"}`).Code)
+	ts.assertStatusCode(http.StatusCreated, ts.send(http.MethodPost, "/proj/", `{"projname":"Twice"}`).Code)
+
+	projs, err = ts.server.TodoStore.GetAllProjs(context.Background())
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Len(projs, seeded+3)
+}
+
+func (ts *TestSuite) TestIdempotencyKeyExpiry() {
+	// reset seeded data
+	ts.SetupTest()
+
+	store := ts.server.TodoStore
+	now := time.Now()
+
+	// a request that is still running
+	body := `{"projname":"Running"}`
+	running := fingerprint(httptest.NewRequest(http.MethodPost, "/proj/", nil), []byte(body))
+	err := store.CreateIdempotencyRecord(models.IdempotencyRecord{Key: "running", Fingerprint: running, CreatedAt: now, ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	responseRecorder := ts.sendIdempotent(http.MethodPost, "/proj/", body, "running")
+	ts.assertStatusCode(http.StatusConflict, responseRecorder.Code)
+
+	// an expired key can be used again
+	err = store.CreateIdempotencyRecord(models.IdempotencyRecord{Key: "expired", Fingerprint: "x", StatusCode: http.StatusCreated, CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)})
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	responseRecorder = ts.sendIdempotent(http.MethodPost, "/proj/", `{"projname":"Expired"}`, "expired")
+	ts.assertStatusCode(http.StatusCreated, responseRecorder.Code)
+	ts.Empty(responseRecorder.Header().Get("Idempotent-Replayed"))
+
+	responseRecorder = ts.sendIdempotent(http.MethodPost, "/proj/", `{"projname":"Long"}`, strings.Repeat("k", 256))
+	ts.assertStatusCode(http.StatusBadRequest, responseRecorder.Code)
+}
+
+func (ts *TestSuite) TestIdempotencyKeyReleased() {
+	// reset seeded data
+	ts.SetupTest()
+
+	send := func(key string, handler http.HandlerFunc) func() {
+		return func() {
+			request := httptest.NewRequest(http.MethodPost, "/proj/", strings.NewReader(`{"projname":"Released"}`))
+			request.Header.Set("Idempotency-Key", key)
+			ts.server.idempotent(handler)(httptest.NewRecorder(), request)
+		}
+	}
+
+	// the panic reaches net/http after the key is released
+	ts.PanicsWithError(http.ErrAbortHandler.Error(), send("aborted", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	ts.NotPanics(send("silent", func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, key := range []string{"aborted", "silent"} {
+		_, err := ts.server.TodoStore.GetIdempotencyRecord(key)
+		ts.ErrorIs(err, errs.ErrNotFound, key)
+	}
+}
+
+func (ts *TestSuite) TestTodoETag() {
+	// reset seeded data
+	ts.SetupTest()
+
+	responseRecorder := ts.send(http.MethodPost, "/proj/"+objID3.Hex(), `{"name":"Versioned"}`)
+	ts.assertStatusCode(http.StatusCreated, responseRecorder.Code)
+	ts.Equal(`"1"`, responseRecorder.Header().Get("ETag"))
+	path := responseRecorder.Header().Get("Location")
+
+	responseRecorder = ts.send(http.MethodGet, path, "")
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	ts.Equal(`"1"`, responseRecorder.Header().Get("ETag"))
+
+	// a matching ETag updates the todo and hands out the next one
+	responseRecorder = ts.sendIfMatch(http.MethodPatch, path, `{"name":"Renamed"}`, `"1"`)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	ts.Equal(`"2"`, responseRecorder.Header().Get("ETag"))
+
+	updated := models.TODO{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&updated)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal(2, updated.Version)
+
+	// the ETag from before the update is stale
+	responseRecorder = ts.sendIfMatch(http.MethodPatch, path, `{"name":"Clobbered"}`, `"1"`)
+	ts.assertStatusCode(http.StatusPreconditionFailed, responseRecorder.Code)
+
+	got := errorResponse{}
+	err = json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal(codePreconditionFailed, got.Error.Code)
+
+	responseRecorder = ts.sendIfMatch(http.MethodDelete, path, "", `"1"`)
+	ts.assertStatusCode(http.StatusPreconditionFailed, responseRecorder.Code)
+
+	todo, err := ts.server.TodoStore.GetTodoByID(context.Background(), strings.TrimPrefix(path, "/todo/"))
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal("Renamed", todo.Name)
+
+	// without If-Match the update is unconditional
+	responseRecorder = ts.send(http.MethodPatch, path, `{"name":"Unconditional"}`)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	ts.Equal(`"3"`, responseRecorder.Header().Get("ETag"))
+
+	responseRecorder = ts.sendIfMatch(http.MethodDelete, path, "", `"3"`)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+}
+
+func (ts *TestSuite) TestProjETag() {
+	// reset seeded data
+	ts.SetupTest()
+
+	responseRecorder := ts.send(http.MethodPost, "/proj/", `{"projname":"Versioned"}`)
+	ts.assertStatusCode(http.StatusCreated, responseRecorder.Code)
+	ts.Equal(`"1"`, responseRecorder.Header().Get("ETag"))
+	path := responseRecorder.Header().Get("Location")
+
+	responseRecorder = ts.sendIfMatch(http.MethodPatch, path, `{"projname":"Renamed"}`, `"1"`)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	ts.Equal(`"2"`, responseRecorder.Header().Get("ETag"))
+
+	responseRecorder = ts.send(http.MethodGet, path, "")
+	ts.Equal(`"2"`, responseRecorder.Header().Get("ETag"))
+
+	responseRecorder = ts.sendIfMatch(http.MethodPatch, path, `{"projname":"Clobbered"}`, `"1"`)
+	ts.assertStatusCode(http.StatusPreconditionFailed, responseRecorder.Code)
+
+	responseRecorder = ts.sendIfMatch(http.MethodDelete, path, "", `"1"`)
+	ts.assertStatusCode(http.StatusPreconditionFailed, responseRecorder.Code)
+
+	responseRecorder = ts.sendIfMatch(http.MethodDelete, path, "", "*")
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+}
+
+func (ts *TestSuite) TestIfMatchErrors() {
+	// reset seeded data
+	ts.SetupTest()
+
+	for _, ifMatch := range []string{`W/"1"`, `"abc"`, `"1", "2"`, "1"} {
+		responseRecorder := ts.sendIfMatch(http.MethodPatch, "/todo/"+objID4.Hex(), `{"name":"x"}`, ifMatch)
+		ts.assertStatusCode(http.StatusPreconditionFailed, responseRecorder.Code)
+	}
+
+	// a missing todo is still a 404, not a 412
+	responseRecorder := ts.sendIfMatch(http.MethodDelete, "/todo/682996bc78d219298228c999", "", `"1"`)
+	ts.assertStatusCode(http.StatusNotFound, responseRecorder.Code)
+}
+
+func (ts *TestSuite) TestBatchTodosVersion() {
+	// reset seeded data
+	ts.SetupTest()
+
+	todoID := objID4.Hex()
+	current, err := ts.server.TodoStore.GetTodoByID(context.Background(), todoID)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	batch := batchRequest{Ops: []models.BatchOp{
+		{Op: models.BatchUpdate, ID: todoID, Todo: models.TODO{Name: "stale", Version: current.Version + 1}},
+	}}
+	status, got := ts.postBatch(batch)
+	ts.assertStatusCode(http.StatusOK, status)
+	ts.Require().Len(got.Results, 1)
+	ts.Equal(http.StatusPreconditionFailed, got.Results[0].Status)
+}
+
+func (ts *TestSuite) reorder(todoID, body string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(http.MethodPost, "/todo/"+todoID+"/reorder", strings.NewReader(body))
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+	return responseRecorder
+}
+
+// taskNames returns the names of the project's tasks as GET /proj/{ID} returns them
+func (ts *TestSuite) taskNames(projID string) []string {
+	request, _ := http.NewRequest(http.MethodGet, "/proj/"+projID, nil)
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+
+	got := models.PROJECT{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	names := []string{}
+	for _, task := range got.Tasks {
+		names = append(names, task.Name)
+	}
+	return names
+}
+
+func (ts *TestSuite) TestReorderTodo() {
+	// reset seeded data
+	ts.SetupTest()
+
+	ts.Equal([]string{"Water Plants", "Buy socks"}, ts.taskNames(objID3.Hex()))
+
+	responseRecorder := ts.reorder(objID2.Hex(), `{"before":"`+objID1.Hex()+`"}`)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+
+	got := models.TODO{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.NotEmpty(got.Rank)
+	ts.Equal([]string{"Buy socks", "Water Plants"}, ts.taskNames(objID3.Hex()))
+
+	// new todos go to the end
+	_, err = ts.server.TodoStore.CreateTodo(context.Background(), objID3.Hex(), models.TODO{Name: "Last"})
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal([]string{"Buy socks", "Water Plants", "Last"}, ts.taskNames(objID3.Hex()))
+
+	responseRecorder = ts.reorder(objID1.Hex(), `{"after":"`+objID2.Hex()+`"}`)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	ts.Equal([]string{"Buy socks", "Water Plants", "Last"}, ts.taskNames(objID3.Hex()))
+
+	responseRecorder = ts.reorder(objID2.Hex(), `{"after":"`+objID1.Hex()+`"}`)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	ts.Equal([]string{"Water Plants", "Buy socks", "Last"}, ts.taskNames(objID3.Hex()))
+
+	// filtered reads of a project keep the manual order
+	request, _ := http.NewRequest(http.MethodGet, "/proj/"+objID3.Hex()+"?completed=false", nil)
+	responseRecorder = httptest.NewRecorder()
+	ts.server.ServeHTTP(responseRecorder, request)
+
+	proj := models.PROJECT{}
+	err = json.NewDecoder(responseRecorder.Result().Body).Decode(&proj)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Require().Len(proj.Tasks, 3)
+	ts.Equal("Water Plants", proj.Tasks[0].Name)
+	ts.Equal("Last", proj.Tasks[2].Name)
+}
+
+func (ts *TestSuite) TestReorderTodoErrors() {
+	// reset seeded data
+	ts.SetupTest()
+
+	cases := []struct {
+		name, body string
+		want       int
+	}{
+		{"no anchor", `{}`, http.StatusBadRequest},
+		{"both anchors", `{"before":"` + objID2.Hex() + `","after":"` + objID2.Hex() + `"}`, http.StatusBadRequest},
+		{"itself", `{"before":"` + objID1.Hex() + `"}`, http.StatusBadRequest},
+		{"other project", `{"before":"` + objID4.Hex() + `"}`, http.StatusBadRequest},
+		{"unknown anchor", `{"before":"682996bc78d219298228c999"}`, http.StatusNotFound},
+	}
+	for _, c := range cases {
+		ts.Run(c.name, func() {
+			ts.assertStatusCode(c.want, ts.reorder(objID1.Hex(), c.body).Code)
+		})
+	}
+}
+
+// itemRequest sends a request to one of the /todo/{ID}/items endpoints
+func (ts *TestSuite) itemRequest(method, path, body string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(method, "/todo/"+objID1.Hex()+"/items"+path, strings.NewReader(body))
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+	return responseRecorder
+}
+
+// checklist returns the todo objID1 as GET /proj/{ID} returns it,
+// decoded loosely so that the computed progress field can be checked
+func (ts *TestSuite) checklist() map[string]any {
+	request, _ := http.NewRequest(http.MethodGet, "/proj/"+objID3.Hex(), nil)
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+
+	got := struct {
+		Tasks []map[string]any `json:"tasks"`
+	}{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	for _, task := range got.Tasks {
+		if task["_id"] == objID1.Hex() {
+			return task
+		}
+	}
+	ts.FailNow("todo is missing from its project")
+	return nil
+}
+
+func (ts *TestSuite) TestChecklistItems() {
+	// reset seeded data
+	ts.SetupTest()
+
+	// no checklist, no progress
+	ts.NotContains(ts.checklist(), "progress")
+
+	itemIDs := []string{}
+	for _, name := range []string{"Aloe vera", "Fern"} {
+		responseRecorder := ts.itemRequest(http.MethodPost, "", `{"name":"`+name+`"}`)
+		ts.assertStatusCode(http.StatusCreated, responseRecorder.Code)
+
+		got := models.ChecklistItem{}
+		err := json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+		if err != nil {
+			ts.FailNow(err.Error())
+		}
+		ts.Equal(name, got.Name)
+		ts.False(got.Completed)
+		ts.Equal("/todo/"+objID1.Hex()+"/items/"+got.ID, responseRecorder.Header().Get("Location"))
+		itemIDs = append(itemIDs, got.ID)
+	}
+	ts.Equal(float64(0), ts.checklist()["progress"])
+
+	// toggling leaves the name alone
+	responseRecorder := ts.itemRequest(http.MethodPatch, "/"+itemIDs[0], `{"completed":true}`)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+
+	got := models.ChecklistItem{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal("Aloe vera", got.Name)
+	ts.True(got.Completed)
+	ts.Equal(float64(50), ts.checklist()["progress"])
+
+	// updating the todo keeps its checklist
+	responseRecorder = httptest.NewRecorder()
+	request, _ := http.NewRequest(http.MethodPatch, "/todo/"+objID1.Hex(), strings.NewReader(`{"name":"Water all plants"}`))
+	ts.server.ServeHTTP(responseRecorder, request)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	ts.Len(ts.checklist()["items"], 2)
+
+	responseRecorder = ts.itemRequest(http.MethodPost, "/"+itemIDs[1]+"/reorder", `{"before":"`+itemIDs[0]+`"}`)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+
+	todo := models.TODO{}
+	err = json.NewDecoder(responseRecorder.Result().Body).Decode(&todo)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Require().Len(todo.Items, 2)
+	ts.Equal("Fern", todo.Items[0].Name)
+	ts.Equal("Aloe vera", todo.Items[1].Name)
+
+	responseRecorder = ts.itemRequest(http.MethodDelete, "/"+itemIDs[1], "")
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	ts.Equal(float64(100), ts.checklist()["progress"])
+}
+
+func (ts *TestSuite) TestChecklistItemErrors() {
+	// reset seeded data
+	ts.SetupTest()
+
+	itemID, err := ts.server.TodoStore.AddItem(context.Background(), objID1.Hex(), models.ChecklistItem{Name: "Aloe vera"})
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	cases := []struct {
+		name, method, path, body string
+		want                     int
+	}{
+		{"add without name", http.MethodPost, "", `{}`, http.StatusBadRequest},
+		{"add bad json", http.MethodPost, "", `{`, http.StatusBadRequest},
+		{"empty name", http.MethodPatch, "/" + itemID, `{"name":""}`, http.StatusBadRequest},
+		{"update unknown item", http.MethodPatch, "/unknown", `{"completed":true}`, http.StatusNotFound},
+		{"reorder next to itself", http.MethodPost, "/" + itemID + "/reorder", `{"after":"` + itemID + `"}`, http.StatusBadRequest},
+		{"reorder unknown anchor", http.MethodPost, "/" + itemID + "/reorder", `{"after":"unknown"}`, http.StatusNotFound},
+		{"delete unknown item", http.MethodDelete, "/unknown", "", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		ts.Run(c.name, func() {
+			ts.assertStatusCode(c.want, ts.itemRequest(c.method, c.path, c.body).Code)
+		})
+	}
+
+	request, _ := http.NewRequest(http.MethodPost, "/todo/682996bc78d219298228c999/items", strings.NewReader(`{"name":"x"}`))
+	responseRecorder := httptest.NewRecorder()
+	ts.server.ServeHTTP(responseRecorder, request)
+	ts.assertStatusCode(http.StatusNotFound, responseRecorder.Code)
+}
+
+// send sends a request to the server and returns the recorded response
+func (ts *TestSuite) send(method, path, body string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(method, path, strings.NewReader(body))
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+	return responseRecorder
+}
+
+// createLabel creates a label through the API and returns it
+func (ts *TestSuite) createLabel(name, color string) models.Label {
+	responseRecorder := ts.send(http.MethodPost, "/label", `{"name":"`+name+`","color":"`+color+`"}`)
+	ts.assertStatusCode(http.StatusCreated, responseRecorder.Code)
+
+	label := models.Label{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&label)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	return label
+}
+
+func (ts *TestSuite) TestLabels() {
+	// reset seeded data
+	ts.SetupTest()
+
+	urgent := ts.createLabel("urgent", "#FF0000")
+	ts.NotEmpty(urgent.ID)
+	ts.Equal("#ff0000", urgent.Color)
+	home := ts.createLabel("home", "")
+
+	responseRecorder := ts.send(http.MethodGet, "/label", "")
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+
+	labels := []models.Label{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&labels)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal([]models.Label{urgent, home}, labels)
+
+	responseRecorder = ts.send(http.MethodPatch, "/label/"+home.ID, `{"color":"#00ff00"}`)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+
+	updated := models.Label{}
+	err = json.NewDecoder(responseRecorder.Result().Body).Decode(&updated)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal(models.Label{ID: home.ID, Name: "home", Color: "#00ff00"}, updated)
+
+	cases := []struct {
+		name, method, path, body string
+		want                     int
+	}{
+		{"duplicate name", http.MethodPost, "/label", `{"name":"urgent"}`, http.StatusConflict},
+		{"missing name", http.MethodPost, "/label", `{"color":"#000000"}`, http.StatusBadRequest},
+		{"bad color", http.MethodPost, "/label", `{"name":"work","color":"red"}`, http.StatusBadRequest},
+		{"blank name", http.MethodPatch, "/label/" + home.ID, `{"name":"  "}`, http.StatusBadRequest},
+		{"update unknown label", http.MethodPatch, "/label/unknown", `{"name":"x"}`, http.StatusNotFound},
+		{"delete unknown label", http.MethodDelete, "/label/unknown", "", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		ts.Run(c.name, func() {
+			ts.assertStatusCode(c.want, ts.send(c.method, c.path, c.body).Code)
+		})
+	}
+}
+
+func (ts *TestSuite) TestTodoLabels() {
+	// reset seeded data
+	ts.SetupTest()
+
+	urgent := ts.createLabel("urgent", "")
+	home := ts.createLabel("home", "")
+
+	responseRecorder := ts.send(http.MethodPatch, "/todo/"+objID1.Hex(), `{"labels":["`+urgent.ID+`","`+home.ID+`","`+home.ID+`"]}`)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+
+	got := models.TODO{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.ElementsMatch([]string{urgent.ID, home.ID}, got.Labels)
+
+	// updates without labels keep them
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodPatch, "/todo/"+objID1.Hex(), `{"name":"Water Plants"}`).Code)
+
+	responseRecorder = ts.send(http.MethodPost, "/proj/"+objID3.Hex(), `{"name":"Fix sink","labels":["`+home.ID+`"]}`)
+	ts.assertStatusCode(http.StatusCreated, responseRecorder.Code)
+
+	created := models.TODO{}
+	err = json.NewDecoder(responseRecorder.Result().Body).Decode(&created)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	queryTests := []struct {
+		testname string
+		query    string
+		wantIDs  []bson.ObjectID
+	}{
+		{"any label", "label=" + urgent.ID + "," + home.ID, []bson.ObjectID{objID1, *created.ID}},
+		{"all labels", "label=" + urgent.ID + "," + home.ID + "&labelMatch=all", []bson.ObjectID{objID1}},
+		{"single label", "label=" + home.ID, []bson.ObjectID{objID1, *created.ID}},
+	}
+	for _, test := range queryTests {
+		ts.Run(test.testname, func() {
+			responseRecorder := ts.send(http.MethodGet, "/todo?"+test.query, "")
+			ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+
+			todos := []models.TODO{}
+			err := json.NewDecoder(responseRecorder.Result().Body).Decode(&todos)
+			if err != nil {
+				ts.FailNow(err.Error())
+			}
+			gotIDs := []bson.ObjectID{}
+			for _, todo := range todos {
+				gotIDs = append(gotIDs, *todo.ID)
+			}
+			ts.Equal(test.wantIDs, gotIDs)
+		})
+	}
+
+	ts.assertStatusCode(http.StatusBadRequest, ts.send(http.MethodGet, "/todo?label="+home.ID+"&labelMatch=some", "").Code)
+	ts.assertStatusCode(http.StatusBadRequest, ts.send(http.MethodPatch, "/todo/"+objID2.Hex(), `{"labels":["unknown"]}`).Code)
+
+	// deleting a label takes it off its todos
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodDelete, "/label/"+home.ID, "").Code)
+
+	todo, err := ts.server.TodoStore.GetTodoByID(context.Background(), objID1.Hex())
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal([]string{urgent.ID}, todo.Labels)
+
+	// an empty list removes the remaining labels
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodPatch, "/todo/"+objID1.Hex(), `{"labels":[]}`).Code)
+
+	todo, err = ts.server.TodoStore.GetTodoByID(context.Background(), objID1.Hex())
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Empty(todo.Labels)
+}
+
+func (ts *TestSuite) TestRecurringTodo() {
+	// reset seeded data
+	ts.SetupTest()
+
+	// 2025-01-06 is a monday
+	responseRecorder := ts.send(http.MethodPost, "/proj/"+objID3.Hex(), `{"name":"Take out bins","dueDateString":"2025-01-06T07:00:00Z","recurrence":"freq=weekly;byday=mo,th;count=3"}`)
+	ts.assertStatusCode(http.StatusCreated, responseRecorder.Code)
+
+	created := models.TODO{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&created)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal("FREQ=WEEKLY;BYDAY=MO,TH;COUNT=3", created.Recurrence)
+	ts.Equal(1, created.Occurrence)
+
+	_, err = ts.server.TodoStore.AddItem(context.Background(), created.ID.Hex(), models.ChecklistItem{Name: "Recycling", Completed: true})
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	// complete returns the next occurrence and completing again does not create another
+	complete := func(todoID string) string {
+		responseRecorder := ts.send(http.MethodPatch, "/todo/"+todoID, `{"completed":true}`)
+		ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+		ts.assertStatusCode(http.StatusOK, ts.send(http.MethodPatch, "/todo/"+todoID, `{"completed":true}`).Code)
+
+		link := responseRecorder.Header().Get("Link")
+		if link == "" {
+			return ""
+		}
+		return strings.TrimSuffix(strings.TrimPrefix(link, "</todo/"), `>; rel="next"`)
+	}
+
+	secondID := complete(created.ID.Hex())
+	ts.Require().NotEmpty(secondID)
+
+	second, err := ts.server.TodoStore.GetTodoByID(context.Background(), secondID)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal("Take out bins", second.Name)
+	ts.False(second.Completed)
+	ts.Equal(2, second.Occurrence)
+	ts.Equal(time.Date(2025, 1, 9, 7, 0, 0, 0, time.UTC), second.DueDate.UTC())
+	ts.Require().Len(second.Items, 1)
+	ts.Equal("Recycling", second.Items[0].Name)
+	ts.False(second.Items[0].Completed)
+
+	thirdID := complete(secondID)
+	ts.Require().NotEmpty(thirdID)
+
+	third, err := ts.server.TodoStore.GetTodoByID(context.Background(), thirdID)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal(time.Date(2025, 1, 13, 7, 0, 0, 0, time.UTC), third.DueDate.UTC())
+
+	// COUNT=3 ends the series
+	ts.Empty(complete(thirdID))
+	ts.Len(ts.taskNames(objID3.Hex()), 5)
+}
+
+func (ts *TestSuite) TestRecurringTodoErrors() {
+	// reset seeded data
+	ts.SetupTest()
+
+	cases := []struct {
+		name, method, path, body string
+	}{
+		{"unsupported rule", http.MethodPost, "/proj/" + objID3.Hex(), `{"name":"x","dueDateString":"2025-01-06T07:00:00Z","recurrence":"FREQ=HOURLY"}`},
+		{"no due date", http.MethodPost, "/proj/" + objID3.Hex(), `{"name":"x","recurrence":"FREQ=DAILY"}`},
+		{"invalid rule on update", http.MethodPatch, "/todo/" + objID1.Hex(), `{"recurrence":"FREQ=DAILY;BYDAY=1MO"}`},
+	}
+	for _, c := range cases {
+		ts.Run(c.name, func() {
+			ts.assertStatusCode(http.StatusBadRequest, ts.send(c.method, c.path, c.body).Code)
+		})
+	}
+}
+
+func (ts *TestSuite) TestReminders() {
+	// reset seeded data
+	ts.SetupTest()
+
+	responseRecorder := ts.send(http.MethodPost, "/proj/"+objID3.Hex(), `{"name":"Dentist","dueDateString":"2025-01-06T09:00:00Z","reminders":[{"before":"30m"},{"before":"24h"},{"before":"0.5h"}]}`)
+	ts.assertStatusCode(http.StatusCreated, responseRecorder.Code)
+
+	created := models.TODO{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&created)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	// earliest first, duplicates dropped
+	ts.Require().Len(created.Reminders, 2)
+	ts.Equal("24h0m0s", created.Reminders[0].Before)
+	ts.Equal(time.Date(2025, 1, 5, 9, 0, 0, 0, time.UTC), created.Reminders[0].FireAt.UTC())
+	ts.Equal("30m0s", created.Reminders[1].Before)
+	ts.Equal(time.Date(2025, 1, 6, 8, 30, 0, 0, time.UTC), created.Reminders[1].FireAt.UTC())
+
+	// a sent reminder stays sent while its fire time is unchanged
+	sentAt := time.Date(2025, 1, 5, 9, 0, 0, 0, time.UTC)
+	todo, err := ts.server.TodoStore.GetTodoByID(context.Background(), created.ID.Hex())
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	todo.Reminders[0].SentAt = &sentAt
+	err = ts.server.TodoStore.UpdateTodoByID(context.Background(), created.ID.Hex(), todo)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodPatch, "/todo/"+created.ID.Hex(), `{"name":"Dentist appointment"}`).Code)
+	todo, err = ts.server.TodoStore.GetTodoByID(context.Background(), created.ID.Hex())
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Require().Len(todo.Reminders, 2)
+	ts.NotNil(todo.Reminders[0].SentAt)
+
+	// moving the due date reschedules
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodPatch, "/todo/"+created.ID.Hex(), `{"dueDateString":"2025-01-08T09:00:00Z"}`).Code)
+	todo, err = ts.server.TodoStore.GetTodoByID(context.Background(), created.ID.Hex())
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Require().Len(todo.Reminders, 2)
+	ts.Nil(todo.Reminders[0].SentAt)
+	ts.Equal(time.Date(2025, 1, 7, 9, 0, 0, 0, time.UTC), todo.Reminders[0].FireAt.UTC())
+
+	// an empty list removes them
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodPatch, "/todo/"+created.ID.Hex(), `{"reminders":[]}`).Code)
+	todo, err = ts.server.TodoStore.GetTodoByID(context.Background(), created.ID.Hex())
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Empty(todo.Reminders)
+}
+
+func (ts *TestSuite) TestReminderErrors() {
+	// reset seeded data
+	ts.SetupTest()
+
+	cases := []struct {
+		name, method, path, body string
+	}{
+		{"invalid duration", http.MethodPost, "/proj/" + objID3.Hex(), `{"name":"x","dueDateString":"2025-01-06T07:00:00Z","reminders":[{"before":"1d"}]}`},
+		{"negative duration", http.MethodPost, "/proj/" + objID3.Hex(), `{"name":"x","dueDateString":"2025-01-06T07:00:00Z","reminders":[{"before":"-5m"}]}`},
+		{"no due date", http.MethodPost, "/proj/" + objID3.Hex(), `{"name":"x","reminders":[{"before":"5m"}]}`},
+		{"invalid duration on update", http.MethodPatch, "/todo/" + objID1.Hex(), `{"reminders":[{"before":"soon"}]}`},
+	}
+	for _, c := range cases {
+		ts.Run(c.name, func() {
+			ts.assertStatusCode(http.StatusBadRequest, ts.send(c.method, c.path, c.body).Code)
+		})
+	}
+}
+
+func (ts *TestSuite) TestWebhooks() {
+	// reset seeded data
+	ts.SetupTest()
+
+	responseRecorder := ts.send(http.MethodPost, "/webhook", `{"url":"https://example.com/hook","events":["todo.created","todo.completed","todo.created"]}`)
+	ts.assertStatusCode(http.StatusCreated, responseRecorder.Code)
+
+	created := models.Webhook{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&created)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal("/webhook/"+created.ID, responseRecorder.Header().Get("Location"))
+	ts.Len(created.Secret, 64)
+	ts.True(created.Active)
+	ts.Equal([]string{models.EventTodoCompleted, models.EventTodoCreated}, created.Events)
+
+	// the secret is only returned on create
+	responseRecorder = ts.send(http.MethodGet, "/webhook/"+created.ID, "")
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	ts.NotContains(responseRecorder.Body.String(), created.Secret)
+
+	responseRecorder = ts.send(http.MethodGet, "/webhook", "")
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	ts.NotContains(responseRecorder.Body.String(), created.Secret)
+
+	// the response with the secret is not stored for replays, an Idempotency-Key is ignored
+	body := `{"url":"https://example.com/retried","events":["todo.created"]}`
+	first := ts.sendIdempotent(http.MethodPost, "/webhook", body, "webhook-key")
+	ts.assertStatusCode(http.StatusCreated, first.Code)
+	retry := ts.sendIdempotent(http.MethodPost, "/webhook", body, "webhook-key")
+	ts.assertStatusCode(http.StatusCreated, retry.Code)
+	ts.Empty(retry.Header().Get("Idempotent-Replayed"))
+	ts.NotEqual(first.Header().Get("Location"), retry.Header().Get("Location"))
+	_, err = ts.server.TodoStore.GetIdempotencyRecord("webhook-key")
+	ts.ErrorIs(err, errs.ErrNotFound)
+	for _, responseRecorder := range []*httptest.ResponseRecorder{first, retry} {
+		ts.assertStatusCode(http.StatusOK, ts.send(http.MethodDelete, responseRecorder.Header().Get("Location"), "").Code)
+	}
+
+	// re-enabling a disabled webhook clears its failures
+	stored, _ := ts.server.TodoStore.GetWebhookByID(context.Background(), created.ID)
+	stored.Active, stored.Failures = false, 10
+	ts.Require().NoError(ts.server.TodoStore.UpdateWebhook(context.Background(), created.ID, stored))
+
+	responseRecorder = ts.send(http.MethodPatch, "/webhook/"+created.ID, `{"active":true,"events":["project.deleted"]}`)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+
+	stored, _ = ts.server.TodoStore.GetWebhookByID(context.Background(), created.ID)
+	ts.True(stored.Active)
+	ts.Zero(stored.Failures)
+	ts.Equal([]string{models.EventProjectDeleted}, stored.Events)
+	ts.Equal(created.Secret, stored.Secret)
+
+	// delivery log, newest first
+	for i := range 3 {
+		ts.Require().NoError(ts.server.TodoStore.AddDelivery(models.Delivery{ID: strconv.Itoa(i), WebhookID: created.ID, Attempt: i + 1}))
+	}
+	responseRecorder = ts.send(http.MethodGet, "/webhook/"+created.ID+"/deliveries?limit=2", "")
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+
+	deliveries := []models.Delivery{}
+	err = json.NewDecoder(responseRecorder.Result().Body).Decode(&deliveries)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Require().Len(deliveries, 2)
+	ts.Equal(3, deliveries[0].Attempt)
+
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodDelete, "/webhook/"+created.ID, "").Code)
+	ts.assertStatusCode(http.StatusNotFound, ts.send(http.MethodGet, "/webhook/"+created.ID, "").Code)
+	ts.assertStatusCode(http.StatusNotFound, ts.send(http.MethodGet, "/webhook/"+created.ID+"/deliveries", "").Code)
+}
+
+func (ts *TestSuite) TestWebhookErrors() {
+	// reset seeded data
+	ts.SetupTest()
+
+	cases := []struct {
+		name, body string
+	}{
+		{"no url", `{"events":["todo.created"]}`},
+		{"relative url", `{"url":"/hook","events":["todo.created"]}`},
+		{"unsupported scheme", `{"url":"ftp://example.com","events":["todo.created"]}`},
+		{"no events", `{"url":"https://example.com/hook"}`},
+		{"unknown event", `{"url":"https://example.com/hook","events":["todo.exploded"]}`},
+		{"localhost", `{"url":"http://localhost:8080/hook","events":["todo.created"]}`},
+		{"loopback", `{"url":"http://127.0.0.1/hook","events":["todo.created"]}`},
+		{"private", `{"url":"http://192.168.1.10/hook","events":["todo.created"]}`},
+		{"link-local", `{"url":"http://169.254.169.254/latest/meta-data","events":["todo.created"]}`},
+		{"unspecified", `{"url":"http://[::]/hook","events":["todo.created"]}`},
+	}
+	for _, c := range cases {
+		ts.Run(c.name, func() {
+			ts.assertStatusCode(http.StatusBadRequest, ts.send(http.MethodPost, "/webhook", c.body).Code)
+		})
+	}
+
+	ts.assertStatusCode(http.StatusNotFound, ts.send(http.MethodPatch, "/webhook/unknown", `{"active":false}`).Code)
+}
+
+func (ts *TestSuite) TestEvents() {
+	// reset seeded data
+	ts.SetupTest()
+
+	responseRecorder := ts.send(http.MethodPost, "/proj/"+objID3.Hex(), `{"name":"Feed cat"}`)
+	ts.assertStatusCode(http.StatusCreated, responseRecorder.Code)
+	todoID := strings.TrimPrefix(responseRecorder.Header().Get("Location"), "/todo/")
+
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodPatch, "/todo/"+todoID, `{"completed":true}`).Code)
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodPatch, "/todo/"+todoID, `{"completed":true}`).Code)
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodDelete, "/todo/"+todoID, "").Code)
+
+	ts.Equal([]string{
+		models.EventTodoCreated,
+		models.EventTodoUpdated,
+		models.EventTodoCompleted,
+		models.EventTodoUpdated,
+		models.EventTodoDeleted,
+	}, ts.events.types)
+
+	created, ok := ts.events.data[0].(models.TODO)
+	ts.Require().True(ok)
+	ts.Equal("Feed cat", created.Name)
+	ts.Equal(models.DeletedData{ID: todoID}, ts.events.data[4])
+
+	// failed requests emit nothing
+	ts.events.types = nil
+	ts.assertStatusCode(http.StatusNotFound, ts.send(http.MethodDelete, "/todo/"+todoID, "").Code)
+	ts.assertStatusCode(http.StatusUnprocessableEntity, ts.send(http.MethodPatch, "/todo/"+objID1.Hex(), `{"dueDateString":"soon"}`).Code)
+	ts.Empty(ts.events.types)
+
+	// batch ops emit the same events as their endpoints
+	body := `{"ops":[
+		{"op":"create","projId":"` + objID3.Hex() + `","todo":{"name":"Vacuum"}},
+		{"op":"update","id":"` + objID1.Hex() + `","todo":{"completed":true}},
+		{"op":"delete","id":"` + objID2.Hex() + `"}
+	]}`
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodPost, "/todo/batch", body).Code)
+	ts.Equal([]string{
+		models.EventTodoCreated,
+		models.EventTodoUpdated,
+		models.EventTodoCompleted,
+		models.EventTodoDeleted,
+	}, ts.events.types)
+}
+
+func (ts *TestSuite) TestProjectEvents() {
+	// reset seeded data
+	ts.SetupTest()
+
+	responseRecorder := ts.send(http.MethodPost, "/proj/", `{"projname":"garden"}`)
+	ts.assertStatusCode(http.StatusCreated, responseRecorder.Code)
+	projID := strings.TrimPrefix(responseRecorder.Header().Get("Location"), "/proj/")
+
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodPatch, "/proj/"+projID, `{"projname":"backyard"}`).Code)
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodDelete, "/proj/"+projID, "").Code)
+
+	ts.Equal([]string{
+		models.EventProjectCreated,
+		models.EventProjectUpdated,
+		models.EventProjectDeleted,
+	}, ts.events.types)
+	ts.Equal(models.DeletedData{ID: projID}, ts.events.data[2])
+}
+
+// sseEvent is an event read from a text/event-stream response
+type sseEvent struct {
+	id, event, data string
+}
+
+// readEvents parses a text/event-stream body onto a channel, comments are skipped
+func readEvents(body io.Reader) <-chan sseEvent {
+	events := make(chan sseEvent)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(body)
+		event := sseEvent{}
+		for scanner.Scan() {
+			field, value, _ := strings.Cut(scanner.Text(), ": ")
+			switch field {
+			case "id":
+				event.id = value
+			case "event":
+				event.event = value
+			case "data":
+				event.data = value
+			case "":
+				if event.event != "" {
+					events <- event
+				}
+				event = sseEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+func (ts *TestSuite) nextEvent(events <-chan sseEvent) sseEvent {
+	ts.T().Helper()
+	select {
+	case event, ok := <-events:
+		ts.Require().True(ok, "event stream ended")
+		return event
+	case <-time.After(5 * time.Second):
+		ts.FailNow("no event received")
+	}
+	return sseEvent{}
+}
+
+// openStream connects to "GET /events" on a real server, the write timeout is shorter than the test
+func (ts *TestSuite) openStream(query string, lastEventID string) <-chan sseEvent {
+	ts.T().Helper()
+	return ts.openStreamAs("", query, lastEventID)
+}
+
+// openStreamAs is openStream with "Authorization: Bearer accessToken" unless accessToken is ""
+func (ts *TestSuite) openStreamAs(accessToken, query, lastEventID string) <-chan sseEvent {
+	ts.T().Helper()
+	httpServer := httptest.NewUnstartedServer(ts.server)
+	httpServer.Config.WriteTimeout = 100 * time.Millisecond
+	httpServer.Start()
+	ts.T().Cleanup(httpServer.Close)
+	ts.T().Cleanup(ts.server.CloseEventStreams)
+
+	request, _ := http.NewRequest(http.MethodGet, httpServer.URL+"/events"+query, nil)
+	if lastEventID != "" {
+		request.Header.Set("Last-Event-ID", lastEventID)
+	}
+	if accessToken != "" {
+		request.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		ts.FailNow(err.Error())
 	}
-	jsonData, err := json.Marshal(todoToUpdate)
+	ts.T().Cleanup(func() { response.Body.Close() })
+	ts.Equal("text/event-stream", response.Header.Get("Content-Type"))
+	return readEvents(response.Body)
+}
+
+func (ts *TestSuite) TestEventStream() {
+	// reset seeded data
+	ts.SetupTest()
+
+	events := ts.openStream("?proj="+objID3.Hex(), "")
+
+	// outlive the write timeout
+	time.Sleep(300 * time.Millisecond)
+
+	ts.assertStatusCode(http.StatusCreated, ts.send(http.MethodPost, "/proj/"+objID5.Hex(), `{"name":"other project"}`).Code)
+	ts.assertStatusCode(http.StatusCreated, ts.send(http.MethodPost, "/proj/"+objID3.Hex(), `{"name":"Feed cat"}`).Code)
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodDelete, "/todo/"+objID1.Hex(), "").Code)
+
+	created := ts.nextEvent(events)
+	ts.Equal(models.EventTodoCreated, created.event)
+
+	event := struct {
+		Type   string      `json:"type"`
+		ProjID string      `json:"projId"`
+		Data   models.TODO `json:"data"`
+	}{}
+	err := json.Unmarshal([]byte(created.data), &event)
 	if err != nil {
 		ts.FailNow(err.Error())
 	}
+	ts.Equal(objID3.Hex(), event.ProjID)
+	ts.Equal("Feed cat", event.Data.Name)
+
+	deleted := ts.nextEvent(events)
+	ts.Equal(models.EventTodoDeleted, deleted.event)
+	ts.JSONEq(`{"id":"`+objID1.Hex()+`"}`, eventData(deleted.data))
+}
+
+// eventData returns the data field of a marshalled models.Event
+func eventData(data string) string {
+	event := struct {
+		Data json.RawMessage `json:"data"`
+	}{}
+	json.Unmarshal([]byte(data), &event)
+	return string(event.Data)
+}
+
+func (ts *TestSuite) TestEventStreamResume() {
+	// reset seeded data
+	ts.SetupTest()
+
+	for _, name := range []string{"one", "two", "three"} {
+		ts.assertStatusCode(http.StatusCreated, ts.send(http.MethodPost, "/proj/"+objID3.Hex(), `{"name":"`+name+`"}`).Code)
+	}
+	ts.Require().Len(ts.server.stream.replay, 3)
+	first := ts.server.stream.replay[0].ID
+
+	events := ts.openStream("", first)
+	ts.Equal(ts.server.stream.replay[1].ID, ts.nextEvent(events).id)
+	ts.Equal(ts.server.stream.replay[2].ID, ts.nextEvent(events).id)
+
+	// ids from before a restart cannot be resumed from
+	events = ts.openStream("", "previousepoch-2")
+	ts.Equal("reset", ts.nextEvent(events).event)
+}
+
+func (ts *TestSuite) TestEventStreamReplayBuffer() {
+	stream := newEventStream()
+	for range eventReplaySize + 10 {
+		stream.Publish(models.Event{Type: models.EventTodoUpdated})
+	}
+	ts.Len(stream.replay, eventReplaySize)
+
+	// the event after the oldest buffered one can be resumed from
+	_, replay, reset := stream.subscribe(stream.replay[0].ID)
+	ts.False(reset)
+	ts.Len(replay, eventReplaySize-1)
+
+	// events dropped from the buffer cannot
+	_, _, reset = stream.subscribe(stream.epoch + "-5")
+	ts.True(reset)
+
+	// a client that falls too far behind is disconnected
+	ch, _, _ := stream.subscribe("")
+	for range streamBuffer + 1 {
+		stream.Publish(models.Event{Type: models.EventTodoUpdated})
+	}
+	received := 0
+	for range ch {
+		received++
+	}
+	ts.Equal(streamBuffer, received)
+}
+
+func (ts *TestSuite) TestMoveTodo() {
+	// reset seeded data
+	ts.SetupTest()
 
-	request, _ := http.NewRequest(http.MethodPatch, "/todo/682996bc78d219298228c10a", bytes.NewBuffer(jsonData))
+	request, _ := http.NewRequest(http.MethodPost, "/todo/67bc5c4f1e8db0c9a17efca0/move", strings.NewReader(`{"projId":"68299585e7b6718ddf79b567"}`))
 	responseRecorder := httptest.NewRecorder()
 
-	request.Header.Set("Content-Type", "application/json")
+	ts.server.ServeHTTP(responseRecorder, request)
+
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+
+	got := models.TODO{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal(objID1, *got.ID)
+	ts.Equal(objID5.Hex(), got.ProjID)
+	ts.Equal("Water Plants", got.Name)
+
+	source, err := ts.server.TodoStore.GetProjByID(context.Background(), objID3.Hex())
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Len(source.Tasks, 1)
+
+	target, err := ts.server.TodoStore.GetProjByID(context.Background(), objID5.Hex())
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Len(target.Tasks, 2)
+}
+
+func (ts *TestSuite) TestMoveTodoErrors() {
+	// reset seeded data
+	ts.SetupTest()
+
+	cases := []struct {
+		name, todoID, body string
+		want               int
+	}{
+		{"missing projId", objID1.Hex(), `{}`, http.StatusBadRequest},
+		{"unknown project", objID1.Hex(), `{"projId":"682571d1dafbee2eecbf4999"}`, http.StatusNotFound},
+		{"unknown todo", "682996bc78d219298228c999", `{"projId":"68299585e7b6718ddf79b567"}`, http.StatusNotFound},
+	}
+	for _, c := range cases {
+		ts.Run(c.name, func() {
+			request, _ := http.NewRequest(http.MethodPost, "/todo/"+c.todoID+"/move", strings.NewReader(c.body))
+			responseRecorder := httptest.NewRecorder()
+
+			ts.server.ServeHTTP(responseRecorder, request)
+
+			ts.assertStatusCode(c.want, responseRecorder.Code)
+		})
+	}
+}
+
+func (ts *TestSuite) postBatch(batch batchRequest) (int, batchResponse) {
+	jsonData, err := json.Marshal(batch)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	request, _ := http.NewRequest(http.MethodPost, "/todo/batch", bytes.NewBuffer(jsonData))
+	responseRecorder := httptest.NewRecorder()
 
 	ts.server.ServeHTTP(responseRecorder, request)
 
-	got, err := ts.server.TodoStore.GetProjByID("68299585e7b6718ddf79b567")
+	got := batchResponse{}
+	err = json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
 	if err != nil {
 		ts.FailNow(err.Error())
 	}
+	return responseRecorder.Code, got
+}
 
-	wantDueDate, err := time.Parse(time.RFC3339, "2025-03-20T02:00:00+08:00")
+func (ts *TestSuite) TestBatchTodos() {
+	// reset seeded data
+	ts.SetupTest()
+
+	status, got := ts.postBatch(batchRequest{Ops: []models.BatchOp{
+		{Op: models.BatchUpdate, ID: objID1.Hex(), Todo: models.TODO{Completed: true}},
+		{Op: models.BatchDelete, ID: objID2.Hex()},
+		{Op: models.BatchCreate, ProjID: objID5.Hex(), Todo: models.TODO{Name: "batched"}},
+		{Op: models.BatchDelete, ID: bson.NewObjectID().Hex()},
+		{Op: "archive", ID: objID4.Hex()},
+	}})
+
+	ts.assertStatusCode(http.StatusOK, status)
+	ts.Nil(got.Error)
+	ts.Require().Len(got.Results, 5)
+
+	ts.Equal(http.StatusOK, got.Results[0].Status)
+	ts.Equal(http.StatusOK, got.Results[1].Status)
+	ts.Equal(http.StatusCreated, got.Results[2].Status)
+	ts.Equal(http.StatusNotFound, got.Results[3].Status)
+	ts.Equal(codeNotFound, got.Results[3].Error.Code)
+	ts.Equal(http.StatusBadRequest, got.Results[4].Status)
+
+	updated, err := ts.server.TodoStore.GetTodoByID(context.Background(), objID1.Hex())
 	if err != nil {
 		ts.FailNow(err.Error())
 	}
+	ts.True(updated.Completed)
+	ts.Equal("Water Plants", updated.Name)
 
-	want := models.PROJECT{
-		ID:       &objID5,
-		ProjName: "proj2",
-		Tasks: []models.TODO{
-			{ID: &objID4, Name: "Updated Task", Description: "Updated Description", DueDate: &wantDueDate, Priority: "low", Updated_at: &timestamp},
-		},
+	_, err = ts.server.TodoStore.GetTodoByID(context.Background(), objID2.Hex())
+	ts.ErrorIs(err, errs.ErrNotFound)
+
+	created, err := ts.server.TodoStore.GetTodoByID(context.Background(), got.Results[2].ID)
+	if err != nil {
+		ts.FailNow(err.Error())
 	}
-	ts.compareProjStructFields(want, got)
+	ts.Equal("batched", created.Name)
 }
 
-func (ts *TestSuite) TestDeleteProjByID() {
-	request, _ := http.NewRequest(http.MethodDelete, "/proj/682571d1dafbee2eecbf4913", nil)
+func (ts *TestSuite) TestBatchTodosAtomic() {
+	// reset seeded data
+	ts.SetupTest()
+
+	missingID := bson.NewObjectID().Hex()
+
+	status, got := ts.postBatch(batchRequest{Atomic: true, Ops: []models.BatchOp{
+		{Op: models.BatchDelete, ID: objID1.Hex()},
+		{Op: models.BatchDelete, ID: missingID},
+		{Op: models.BatchDelete, ID: objID2.Hex()},
+	}})
+
+	ts.assertStatusCode(http.StatusNotFound, status)
+	ts.Require().NotNil(got.Error)
+	ts.Equal(codeNotFound, got.Error.Code)
+	ts.Require().Len(got.Results, 3)
+	ts.Equal(codeAborted, got.Results[0].Error.Code)
+	ts.Equal(http.StatusFailedDependency, got.Results[0].Status)
+	ts.Equal(codeNotFound, got.Results[1].Error.Code)
+	ts.Equal(codeAborted, got.Results[2].Error.Code)
+
+	// nothing was deleted
+	proj, err := ts.server.TodoStore.GetProjByID(context.Background(), objID3.Hex())
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Len(proj.Tasks, 2)
+}
+
+func (ts *TestSuite) TestBatchTodosAtomicRollsBackStore() {
+	// reset seeded data
+	ts.SetupTest()
+
+	// the update is checked before the batch reaches the store, the
+	// second delete of objID1 only fails inside the store
+	status, got := ts.postBatch(batchRequest{Atomic: true, Ops: []models.BatchOp{
+		{Op: models.BatchUpdate, ID: objID4.Hex(), Todo: models.TODO{Name: "renamed"}},
+		{Op: models.BatchDelete, ID: objID1.Hex()},
+		{Op: models.BatchDelete, ID: objID1.Hex()},
+	}})
+
+	ts.assertStatusCode(http.StatusNotFound, status)
+	ts.Equal(codeNotFound, got.Results[2].Error.Code)
+
+	todo, err := ts.server.TodoStore.GetTodoByID(context.Background(), objID4.Hex())
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal("Test task 3", todo.Name)
+
+	_, err = ts.server.TodoStore.GetTodoByID(context.Background(), objID1.Hex())
+	ts.NoError(err)
+}
+
+func (ts *TestSuite) TestBatchTodosLimits() {
+	for name, ops := range map[string][]models.BatchOp{
+		"empty":     {},
+		"too large": make([]models.BatchOp, maxBatchOps+1),
+	} {
+		ts.Run(name, func() {
+			status, got := ts.postBatch(batchRequest{Ops: ops})
+			ts.assertStatusCode(http.StatusBadRequest, status)
+			ts.Equal(codeValidationFailed, got.Error.Code)
+		})
+	}
+}
+
+// useAuth replaces the server with one that requires a signed in user, its tokens are signed at now
+func (ts *TestSuite) useAuth(now *time.Time) *StubTodoStore {
+	stub := &StubTodoStore{store: []models.PROJECT{proj1, proj2}}
+	issuer := auth.NewIssuer([]byte("test secret"), auth.DefaultAccessTTL, auth.DefaultRefreshTTL)
+	issuer.Now = func() time.Time { return *now }
+	ts.server = NewTodoServer(stub, WithAuth(issuer))
+	return stub
+}
+
+// sendAs sends a request with "Authorization: Bearer accessToken" and returns the recorded response
+func (ts *TestSuite) sendAs(accessToken, method, path, body string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+accessToken)
 	responseRecorder := httptest.NewRecorder()
 
 	ts.server.ServeHTTP(responseRecorder, request)
-	response := responseRecorder.Result()
+	return responseRecorder
+}
 
-	byteGot, err := io.ReadAll(response.Body)
+// login logs in through the API and returns the tokens
+func (ts *TestSuite) login(email, password string) models.TokenPair {
+	ts.T().Helper()
+	responseRecorder := ts.send(http.MethodPost, "/auth/login", `{"email":"`+email+`","password":"`+password+`"}`)
+	ts.Require().Equal(http.StatusOK, responseRecorder.Code, responseRecorder.Body.String())
+	tokens := models.TokenPair{}
+	err := json.NewDecoder(responseRecorder.Body).Decode(&tokens)
 	if err != nil {
-		if err != io.EOF {
-			ts.FailNow(err.Error())
-		}
+		ts.FailNow(err.Error())
 	}
+	return tokens
+}
 
-	defer response.Body.Close()
-	got := string(byteGot)
+func (ts *TestSuite) TestAuth() {
+	now := time.Now()
+	stub := ts.useAuth(&now)
 
-	ts.assertTodoText("Number of projects deleted: 1", got)
-	ts.assertStatusCode(200, responseRecorder.Code)
+	responseRecorder := ts.send(http.MethodGet, "/proj", "")
+	ts.assertStatusCode(http.StatusUnauthorized, responseRecorder.Code)
+	ts.Equal(`Bearer realm="todoapp"`, responseRecorder.Header().Get("WWW-Authenticate"))
+	ts.assertStatusCode(http.StatusUnauthorized, ts.sendAs("not.a.token", http.MethodDelete, "/proj/"+objID3.Hex(), "").Code)
+
+	responseRecorder = ts.send(http.MethodPost, "/auth/register", `{"email":" Ada@Example.com ","password":"correct horse"}`)
+	ts.assertStatusCode(http.StatusCreated, responseRecorder.Code)
+	user := map[string]any{}
+	err := json.NewDecoder(responseRecorder.Body).Decode(&user)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal("ada@example.com", user["email"])
+	ts.NotContains(user, "passwordHash")
+	ts.NotEqual("correct horse", stub.users[0].PasswordHash)
+
+	ts.assertStatusCode(http.StatusConflict, ts.send(http.MethodPost, "/auth/register", `{"email":"ada@example.com","password":"another one"}`).Code)
+	ts.assertStatusCode(http.StatusUnauthorized, ts.send(http.MethodPost, "/auth/login", `{"email":"ada@example.com","password":"wrong horse"}`).Code)
+	ts.assertStatusCode(http.StatusUnauthorized, ts.send(http.MethodPost, "/auth/login", `{"email":"bob@example.com","password":"correct horse"}`).Code)
+
+	tokens := ts.login("ADA@example.com", "correct horse")
+	ts.Equal("Bearer", tokens.TokenType)
+	ts.assertStatusCode(http.StatusOK, ts.sendAs(tokens.AccessToken, http.MethodGet, "/proj", "").Code)
+
+	// changes are recorded as made by the signed in user
+	ts.assertStatusCode(http.StatusOK, ts.sendAs(tokens.AccessToken, http.MethodPatch, "/proj/"+objID5.Hex(), `{"projname":"renamed"}`).Code)
+	ts.Require().Len(stub.history, 1)
+	ts.Equal(stub.users[0].ID, stub.history[0].Actor)
+
+	// access tokens expire, the refresh token gets a new one
+	now = now.Add(auth.DefaultAccessTTL)
+	ts.assertStatusCode(http.StatusUnauthorized, ts.sendAs(tokens.AccessToken, http.MethodGet, "/proj", "").Code)
+	responseRecorder = ts.send(http.MethodPost, "/auth/refresh", `{"refreshToken":"`+tokens.RefreshToken+`"}`)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	refreshed := models.TokenPair{}
+	err = json.NewDecoder(responseRecorder.Body).Decode(&refreshed)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.NotEqual(tokens.RefreshToken, refreshed.RefreshToken)
+	ts.assertStatusCode(http.StatusOK, ts.sendAs(refreshed.AccessToken, http.MethodGet, "/proj", "").Code)
 }
 
-func (ts *TestSuite) TestDeleteTodoByID() {
-	request, _ := http.NewRequest(http.MethodDelete, "/todo/67bc5c4f1e8db0c9a17efca0", nil)
+func (ts *TestSuite) TestAuthRefreshTokenReuse() {
+	now := time.Now()
+	ts.useAuth(&now)
+	ts.assertStatusCode(http.StatusCreated, ts.send(http.MethodPost, "/auth/register", `{"email":"ada@example.com","password":"correct horse"}`).Code)
+
+	tokens := ts.login("ada@example.com", "correct horse")
+	refresh := func(refreshToken string) *httptest.ResponseRecorder {
+		return ts.send(http.MethodPost, "/auth/refresh", `{"refreshToken":"`+refreshToken+`"}`)
+	}
+
+	responseRecorder := refresh(tokens.RefreshToken)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	refreshed := models.TokenPair{}
+	err := json.NewDecoder(responseRecorder.Body).Decode(&refreshed)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	// reusing a spent token signs the whole login out
+	ts.assertStatusCode(http.StatusUnauthorized, refresh(tokens.RefreshToken).Code)
+	ts.assertStatusCode(http.StatusUnauthorized, refresh(refreshed.RefreshToken).Code)
+
+	// other logins are left alone, until they log out or their refresh token expires
+	other := ts.login("ada@example.com", "correct horse")
+	again := ts.login("ada@example.com", "correct horse")
+	ts.assertStatusCode(http.StatusNoContent, ts.send(http.MethodPost, "/auth/logout", `{"refreshToken":"`+other.RefreshToken+`"}`).Code)
+	ts.assertStatusCode(http.StatusUnauthorized, refresh(other.RefreshToken).Code)
+	now = now.Add(auth.DefaultRefreshTTL)
+	ts.assertStatusCode(http.StatusUnauthorized, refresh(again.RefreshToken).Code)
+
+	ts.assertStatusCode(http.StatusUnauthorized, refresh("made-up").Code)
+	ts.assertStatusCode(http.StatusBadRequest, ts.send(http.MethodPost, "/auth/register", `{"email":"bob@example.com","password":"short"}`).Code)
+	ts.assertStatusCode(http.StatusBadRequest, ts.send(http.MethodPost, "/auth/register", `{"email":"bob","password":"correct horse"}`).Code)
+}
+
+// createPersonalToken creates a personal access token through the API and returns it
+func (ts *TestSuite) createPersonalToken(accessToken, body string) models.PersonalToken {
+	ts.T().Helper()
+	responseRecorder := ts.sendAs(accessToken, http.MethodPost, "/token", body)
+	ts.Require().Equal(http.StatusCreated, responseRecorder.Code, responseRecorder.Body.String())
+	token := models.PersonalToken{}
+	err := json.NewDecoder(responseRecorder.Body).Decode(&token)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	return token
+}
+
+func (ts *TestSuite) TestPersonalTokens() {
+	now := time.Now()
+	stub := ts.useAuth(&now)
+	ts.assertStatusCode(http.StatusCreated, ts.send(http.MethodPost, "/auth/register", `{"email":"ada@example.com","password":"correct horse"}`).Code)
+	session := ts.login("ada@example.com", "correct horse").AccessToken
+
+	token := ts.createPersonalToken(session, `{"name":" deploy script ","scope":"read-write"}`)
+	ts.True(strings.HasPrefix(token.Token, auth.PersonalTokenPrefix))
+	ts.True(strings.HasPrefix(token.Token, token.Prefix))
+	ts.Equal("deploy script", token.Name)
+	ts.Require().Len(stub.personalTokens, 1)
+	ts.Equal(auth.HashToken(token.Token), stub.personalTokens[0].Hash)
+	ts.Equal(stub.users[0].ID, stub.personalTokens[0].UserID)
+
+	// tokens sign requests in like sessions, as the user who created them
+	ts.assertStatusCode(http.StatusOK, ts.sendAs(token.Token, http.MethodPatch, "/proj/"+objID5.Hex(), `{"projname":"renamed"}`).Code)
+	ts.Require().Len(stub.history, 1)
+	ts.Equal(stub.users[0].ID, stub.history[0].Actor)
+	ts.Require().NotNil(stub.personalTokens[0].LastUsedAt)
+	ts.WithinDuration(now, *stub.personalTokens[0].LastUsedAt, time.Second)
+
+	// the list never has the tokens themselves
+	responseRecorder := ts.sendAs(session, http.MethodGet, "/token", "")
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	ts.NotContains(responseRecorder.Body.String(), token.Token)
+	ts.Contains(responseRecorder.Body.String(), token.Prefix)
+
+	// tokens cannot manage tokens
+	ts.assertStatusCode(http.StatusForbidden, ts.sendAs(token.Token, http.MethodGet, "/token", "").Code)
+	ts.assertStatusCode(http.StatusForbidden, ts.sendAs(token.Token, http.MethodPost, "/token", `{"name":"more","scope":"read-write"}`).Code)
+
+	readOnly := ts.createPersonalToken(session, `{"name":"dashboard","scope":"read"}`)
+	ts.assertStatusCode(http.StatusOK, ts.sendAs(readOnly.Token, http.MethodGet, "/proj", "").Code)
+	ts.assertStatusCode(http.StatusForbidden, ts.sendAs(readOnly.Token, http.MethodDelete, "/todo/"+objID1.Hex(), "").Code)
+
+	project := ts.createPersonalToken(session, `{"name":"garden","scope":"read-write","projectId":"`+objID3.Hex()+`"}`)
+	ts.Equal(objID3.Hex(), project.ProjectID)
+	// a request outside the scope is not a use of the token
+	ts.assertStatusCode(http.StatusForbidden, ts.sendAs(project.Token, http.MethodGet, "/proj", "").Code)
+	ts.Require().Len(stub.personalTokens, 3)
+	ts.Nil(stub.personalTokens[2].LastUsedAt)
+	for _, allowed := range []struct{ method, path, body string }{
+		{http.MethodGet, "/proj/" + objID3.Hex(), ""},
+		{http.MethodGet, "/todo/" + objID1.Hex(), ""},
+		{http.MethodGet, "/todo?project=" + objID3.Hex(), ""},
+		{http.MethodPatch, "/todo/" + objID2.Hex(), `{"completed":true}`},
+	} {
+		ts.assertStatusCode(http.StatusOK, ts.sendAs(project.Token, allowed.method, allowed.path, allowed.body).Code)
+	}
+	for _, forbidden := range []struct{ method, path, body string }{
+		{http.MethodGet, "/proj", ""},
+		{http.MethodGet, "/proj/" + objID5.Hex(), ""},
+		{http.MethodGet, "/todo/" + objID4.Hex(), ""},
+		{http.MethodGet, "/todo", ""},
+		{http.MethodGet, "/label", ""},
+		{http.MethodPost, "/todo/" + objID1.Hex() + "/move", `{"projId":"` + objID5.Hex() + `"}`},
+	} {
+		ts.assertStatusCode(http.StatusForbidden, ts.sendAs(project.Token, forbidden.method, forbidden.path, forbidden.body).Code)
+	}
+
+	// revoked tokens stop working right away
+	ts.assertStatusCode(http.StatusOK, ts.sendAs(session, http.MethodDelete, "/token/"+token.ID, "").Code)
+	ts.assertStatusCode(http.StatusUnauthorized, ts.sendAs(token.Token, http.MethodGet, "/proj", "").Code)
+	ts.assertStatusCode(http.StatusNotFound, ts.sendAs(session, http.MethodDelete, "/token/"+token.ID, "").Code)
+	ts.assertStatusCode(http.StatusUnauthorized, ts.sendAs(auth.PersonalTokenPrefix+"made-up", http.MethodGet, "/proj", "").Code)
+
+	for _, body := range []string{
+		`{"scope":"read"}`,
+		`{"name":"no scope"}`,
+		`{"name":"bad scope","scope":"admin"}`,
+		`{"name":"unknown project","scope":"read","projectId":"682571d1dafbee2eecbf4999"}`,
+	} {
+		ts.assertStatusCode(http.StatusBadRequest, ts.sendAs(session, http.MethodPost, "/token", body).Code)
+	}
+}
+
+func (ts *TestSuite) TestCollaborators() {
+	now := time.Now()
+	stub := ts.useAuth(&now)
+	sessions := map[string]string{}
+	for _, name := range []string{"ada", "bob", "carol"} {
+		ts.assertStatusCode(http.StatusCreated, ts.send(http.MethodPost, "/auth/register", `{"email":"`+name+`@example.com","password":"correct horse"}`).Code)
+		sessions[name] = ts.login(name+"@example.com", "correct horse").AccessToken
+	}
+	adaID, bobID := stub.users[0].ID, stub.users[1].ID
+
+	responseRecorder := ts.sendAs(sessions["ada"], http.MethodPost, "/proj/", `{"projname":"shared"}`)
+	ts.assertStatusCode(http.StatusCreated, responseRecorder.Code)
+	proj := models.PROJECT{}
+	err := json.NewDecoder(responseRecorder.Body).Decode(&proj)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	projPath := "/proj/" + proj.ID.Hex()
+
+	responseRecorder = ts.sendAs(sessions["ada"], http.MethodPost, projPath, `{"name":"shared task"}`)
+	ts.assertStatusCode(http.StatusCreated, responseRecorder.Code)
+	todoPath := responseRecorder.Header().Get("Location")
+
+	ts.assertStatusCode(http.StatusNotFound, ts.sendAs(sessions["bob"], http.MethodGet, projPath, "").Code)
+
+	// viewers read but cannot change
+	ts.assertStatusCode(http.StatusCreated, ts.sendAs(sessions["ada"], http.MethodPost, projPath+"/collaborators", `{"email":"Bob@example.com","role":"viewer"}`).Code)
+	ts.assertStatusCode(http.StatusConflict, ts.sendAs(sessions["ada"], http.MethodPost, projPath+"/collaborators", `{"email":"bob@example.com","role":"editor"}`).Code)
+	ts.assertStatusCode(http.StatusOK, ts.sendAs(sessions["bob"], http.MethodGet, projPath, "").Code)
+	ts.assertStatusCode(http.StatusOK, ts.sendAs(sessions["bob"], http.MethodGet, todoPath, "").Code)
+	ts.assertStatusCode(http.StatusForbidden, ts.sendAs(sessions["bob"], http.MethodPatch, projPath, `{"projname":"mine"}`).Code)
+	ts.assertStatusCode(http.StatusForbidden, ts.sendAs(sessions["bob"], http.MethodPatch, todoPath, `{"completed":true}`).Code)
+	ts.assertStatusCode(http.StatusForbidden, ts.sendAs(sessions["bob"], http.MethodDelete, todoPath, "").Code)
+	ts.assertStatusCode(http.StatusForbidden, ts.sendAs(sessions["bob"], http.MethodPost, projPath+"/collaborators", `{"email":"carol@example.com","role":"owner"}`).Code)
+
+	responseRecorder = ts.sendAs(sessions["bob"], http.MethodGet, projPath+"/collaborators", "")
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	collaborators := []models.Collaborator{}
+	err = json.NewDecoder(responseRecorder.Body).Decode(&collaborators)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal([]models.Collaborator{{UserID: adaID, Role: models.RoleOwner}, {UserID: bobID, Role: models.RoleViewer}}, collaborators)
+
+	// editors change the project and its todos, only owners delete the project
+	ts.assertStatusCode(http.StatusOK, ts.sendAs(sessions["ada"], http.MethodPatch, projPath+"/collaborators/"+bobID, `{"role":"editor"}`).Code)
+	ts.assertStatusCode(http.StatusOK, ts.sendAs(sessions["bob"], http.MethodPatch, todoPath, `{"completed":true}`).Code)
+	ts.assertStatusCode(http.StatusOK, ts.sendAs(sessions["bob"], http.MethodPatch, projPath, `{"projname":"renamed"}`).Code)
+	ts.assertStatusCode(http.StatusForbidden, ts.sendAs(sessions["bob"], http.MethodDelete, projPath, "").Code)
+	ts.assertStatusCode(http.StatusNotFound, ts.sendAs(sessions["carol"], http.MethodGet, projPath, "").Code)
+	ts.assertStatusCode(http.StatusNotFound, ts.sendAs(sessions["carol"], http.MethodDelete, todoPath, "").Code)
+
+	for _, invalid := range []struct{ method, path, body string }{
+		{http.MethodPost, projPath + "/collaborators", `{"email":"nobody@example.com","role":"viewer"}`},
+		{http.MethodPost, projPath + "/collaborators", `{"email":"carol@example.com","role":"admin"}`},
+		{http.MethodPost, projPath + "/collaborators", `{"email":"ada@example.com","role":"viewer"}`},
+		{http.MethodPatch, projPath + "/collaborators/" + adaID, `{"role":"viewer"}`},
+		{http.MethodDelete, projPath + "/collaborators/" + adaID, ""},
+	} {
+		ts.assertStatusCode(http.StatusBadRequest, ts.sendAs(sessions["ada"], invalid.method, invalid.path, invalid.body).Code)
+	}
+
+	// collaborators can leave
+	ts.assertStatusCode(http.StatusOK, ts.sendAs(sessions["bob"], http.MethodDelete, projPath+"/collaborators/"+bobID, "").Code)
+	ts.assertStatusCode(http.StatusNotFound, ts.sendAs(sessions["bob"], http.MethodGet, projPath, "").Code)
+	ts.assertStatusCode(http.StatusNotFound, ts.sendAs(sessions["ada"], http.MethodDelete, projPath+"/collaborators/"+bobID, "").Code)
+}
+
+func (ts *TestSuite) TestCollaboratorEvents() {
+	now := time.Now()
+	stub := ts.useAuth(&now)
+	sessions := map[string]string{}
+	for _, name := range []string{"ada", "bob", "carol"} {
+		ts.assertStatusCode(http.StatusCreated, ts.send(http.MethodPost, "/auth/register", `{"email":"`+name+`@example.com","password":"correct horse"}`).Code)
+		sessions[name] = ts.login(name+"@example.com", "correct horse").AccessToken
+	}
+	bobID := stub.users[1].ID
+
+	responseRecorder := ts.sendAs(sessions["ada"], http.MethodPost, "/proj/", `{"projname":"shared"}`)
+	ts.assertStatusCode(http.StatusCreated, responseRecorder.Code)
+	projPath := responseRecorder.Header().Get("Location")
+	ts.assertStatusCode(http.StatusCreated, ts.sendAs(sessions["ada"], http.MethodPost, projPath+"/collaborators", `{"email":"bob@example.com","role":"viewer"}`).Code)
+
+	bobEvents := ts.openStreamAs(sessions["bob"], "", "")
+	carolEvents := ts.openStreamAs(sessions["carol"], "", "")
+
+	// carol only sees her own project, bob also sees the todo created in the project shared with him
+	ts.assertStatusCode(http.StatusCreated, ts.sendAs(sessions["ada"], http.MethodPost, projPath, `{"name":"shared task"}`).Code)
+	ts.assertStatusCode(http.StatusCreated, ts.sendAs(sessions["carol"], http.MethodPost, "/proj/", `{"projname":"carol's"}`).Code)
+
+	created := ts.nextEvent(bobEvents)
+	ts.Equal(models.EventTodoCreated, created.event)
+	ts.Contains(created.data, "shared task")
+	ts.Equal(models.EventProjectCreated, ts.nextEvent(carolEvents).event)
+
+	// once bob is removed the project's events stop, his own still come through
+	ts.assertStatusCode(http.StatusOK, ts.sendAs(sessions["ada"], http.MethodDelete, projPath+"/collaborators/"+bobID, "").Code)
+	ts.assertStatusCode(http.StatusCreated, ts.sendAs(sessions["ada"], http.MethodPost, projPath, `{"name":"private task"}`).Code)
+	ts.assertStatusCode(http.StatusCreated, ts.sendAs(sessions["bob"], http.MethodPost, "/proj/", `{"projname":"bob's"}`).Code)
+
+	ts.Equal(models.EventProjectCreated, ts.nextEvent(bobEvents).event)
+}
+
+// sendFrom sends a request from the web page at origin and returns the recorded response
+func (ts *TestSuite) sendFrom(origin, method, path string, header http.Header) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(method, path, nil)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	request.Header.Set("Origin", origin)
 	responseRecorder := httptest.NewRecorder()
 
 	ts.server.ServeHTTP(responseRecorder, request)
-	response := responseRecorder.Result()
+	return responseRecorder
+}
 
-	byteGot, err := io.ReadAll(response.Body)
+func (ts *TestSuite) TestCORS() {
+	// reset seeded data
+	ts.SetupTest()
+
+	responseRecorder := ts.sendFrom("http://localhost:5173", http.MethodGet, "/proj", nil)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	ts.Equal("http://localhost:5173", responseRecorder.Header().Get("Access-Control-Allow-Origin"))
+	ts.Contains(responseRecorder.Header().Get("Access-Control-Expose-Headers"), "ETag")
+	ts.Contains(responseRecorder.Header().Values("Vary"), "Origin")
+
+	// other origins get the response without CORS headers, the browser keeps it from them
+	responseRecorder = ts.sendFrom("https://evil.example", http.MethodGet, "/proj", nil)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	ts.Empty(responseRecorder.Header().Get("Access-Control-Allow-Origin"))
+	ts.Contains(responseRecorder.Header().Values("Vary"), "Origin")
+
+	ts.server = NewTodoServer(ts.server.TodoStore, WithCORS(CORSConfig{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPatch},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	}))
+	preflight := http.Header{
+		"Access-Control-Request-Method":  {http.MethodPatch},
+		"Access-Control-Request-Headers": {"content-type, authorization"},
+	}
+
+	responseRecorder = ts.sendFrom("https://app.example.com", http.MethodOptions, "/todo/"+objID1.Hex(), preflight)
+	ts.assertStatusCode(http.StatusNoContent, responseRecorder.Code)
+	ts.Equal("https://app.example.com", responseRecorder.Header().Get("Access-Control-Allow-Origin"))
+	ts.Equal("true", responseRecorder.Header().Get("Access-Control-Allow-Credentials"))
+	ts.Equal("GET, PATCH", responseRecorder.Header().Get("Access-Control-Allow-Methods"))
+	ts.Equal("Content-Type, Authorization", responseRecorder.Header().Get("Access-Control-Allow-Headers"))
+	ts.Equal("3600", responseRecorder.Header().Get("Access-Control-Max-Age"))
+	ts.Equal([]string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}, responseRecorder.Header().Values("Vary"))
+
+	// errors are readable cross-origin too
+	responseRecorder = ts.sendFrom("https://eu.app.example.com", http.MethodGet, "/proj/682571d1dafbee2eecbf4999", nil)
+	ts.assertStatusCode(http.StatusNotFound, responseRecorder.Code)
+	ts.Equal("https://eu.app.example.com", responseRecorder.Header().Get("Access-Control-Allow-Origin"))
+
+	for origin, header := range map[string]http.Header{
+		"https://example.com":             preflight,
+		"http://app.example.com":          preflight,
+		"https://app.example.com.evil.io": preflight,
+		"https://app.example.com:8443":    preflight,
+		"https://app.example.com/": {
+			"Access-Control-Request-Method": {http.MethodPatch},
+		},
+	} {
+		responseRecorder = ts.sendFrom(origin, http.MethodOptions, "/todo/"+objID1.Hex(), header)
+		ts.assertStatusCode(http.StatusNoContent, responseRecorder.Code)
+		ts.Empty(responseRecorder.Header().Get("Access-Control-Allow-Origin"), origin)
+	}
+	for _, header := range []http.Header{
+		{"Access-Control-Request-Method": {http.MethodDelete}},
+		{"Access-Control-Request-Method": {http.MethodPatch}, "Access-Control-Request-Headers": {"If-Match"}},
+	} {
+		responseRecorder = ts.sendFrom("https://app.example.com", http.MethodOptions, "/todo/"+objID1.Hex(), header)
+		ts.assertStatusCode(http.StatusNoContent, responseRecorder.Code)
+		ts.Empty(responseRecorder.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+// invalidFields sends a request that has to fail validation and returns the invalid fields
+func (ts *TestSuite) invalidFields(method, path, body string) []validate.FieldError {
+	ts.T().Helper()
+	responseRecorder := ts.send(method, path, body)
+	ts.assertStatusCode(http.StatusUnprocessableEntity, responseRecorder.Code)
+
+	got := errorResponse{}
+	err := json.NewDecoder(responseRecorder.Body).Decode(&got)
 	if err != nil {
-		if err != io.EOF {
-			ts.FailNow(err.Error())
-		}
+		ts.FailNow(err.Error())
 	}
+	ts.Equal(codeInvalidFields, got.Error.Code)
+	return got.Error.Details
+}
 
-	defer response.Body.Close()
-	got := string(byteGot)
+func (ts *TestSuite) TestValidation() {
+	// reset seeded data
+	ts.SetupTest()
+	long := strings.Repeat("x", 256)
+
+	ts.Equal([]validate.FieldError{{Field: "projname", Message: "is required"}}, ts.invalidFields(http.MethodPost, "/proj/", `{"projname":""}`))
+	ts.Equal([]validate.FieldError{{Field: "color", Message: "is not a known field"}}, ts.invalidFields(http.MethodPost, "/proj/", `{"projname":"garden","color":"green"}`))
+	ts.Equal([]validate.FieldError{{Field: "projname", Message: "cannot be longer than 255 characters"}}, ts.invalidFields(http.MethodPatch, "/proj/"+objID3.Hex(), `{"projname":"`+long+`"}`))
+
+	ts.Equal([]validate.FieldError{
+		{Field: "name", Message: "is required"},
+		{Field: "description", Message: "cannot be longer than 255 characters"},
+		{Field: "priority", Message: "has to be one of low, medium, high"},
+	}, ts.invalidFields(http.MethodPost, "/proj/"+objID3.Hex(), `{"description":"`+long+`","priority":"urgent"}`))
+	ts.Equal([]validate.FieldError{{Field: "name", Message: "has to be a string"}}, ts.invalidFields(http.MethodPost, "/proj/"+objID3.Hex(), `{"name":5}`))
+	ts.Equal([]validate.FieldError{{Field: "dueDateString", Message: "has to be an RFC 3339 time"}}, ts.invalidFields(http.MethodPost, "/proj/"+objID3.Hex(), `{"name":"Feed cat","dueDateString":"tomorrow"}`))
+
+	// updates leave out what they do not change, but what they give has to be valid
+	ts.Equal([]validate.FieldError{{Field: "priority", Message: "has to be one of low, medium, high"}}, ts.invalidFields(http.MethodPatch, "/todo/"+objID1.Hex(), `{"priority":"hi"}`))
+	ts.Equal([]validate.FieldError{{Field: "nmae", Message: "is not a known field"}}, ts.invalidFields(http.MethodPatch, "/todo/"+objID1.Hex(), `{"nmae":"Water cacti"}`))
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodPatch, "/todo/"+objID1.Hex(), `{"priority":"high"}`).Code)
+
+	status, got := ts.postBatch(batchRequest{Ops: []models.BatchOp{
+		{Op: models.BatchCreate, ProjID: objID5.Hex(), Todo: models.TODO{Name: "batched", Priority: "someday"}},
+		{Op: models.BatchUpdate, ID: objID4.Hex(), Todo: models.TODO{Description: long}},
+	}})
+	ts.assertStatusCode(http.StatusOK, status)
+	ts.Require().Len(got.Results, 2)
+	for _, result := range got.Results {
+		ts.Equal(http.StatusUnprocessableEntity, result.Status)
+		ts.Require().NotNil(result.Error)
+		ts.Len(result.Error.Details, 1)
+	}
+	ts.Equal("todo.priority", got.Results[0].Error.Details[0].Field)
+	ts.Equal("todo.description", got.Results[1].Error.Details[0].Field)
+
+	// every op is decoded on its own, the fields of its todo are named after it
+	responseRecorder := ts.send(http.MethodPost, "/todo/batch", `{"ops":[
+		{"op":"create","projId":"`+objID5.Hex()+`","todo":{"name":"batched","nmae":"typo"}},
+		{"op":"update","id":"`+objID4.Hex()+`","todo":{"completed":"yes"}},
+		{"op":"delete","id":5},
+		{"op":"create","projId":"`+objID5.Hex()+`","todo":"batched"}
+	]}`)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	got = batchResponse{}
+	err := json.NewDecoder(responseRecorder.Body).Decode(&got)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Require().Len(got.Results, 4)
+	for i, want := range []validate.FieldError{
+		{Field: "todo.nmae", Message: "is not a known field"},
+		{Field: "todo.completed", Message: "has to be a boolean"},
+		{Field: "id", Message: "has to be a string"},
+		{Field: "todo", Message: "has to be an object"},
+	} {
+		ts.Equal(http.StatusUnprocessableEntity, got.Results[i].Status)
+		ts.Require().NotNil(got.Results[i].Error)
+		ts.Equal([]validate.FieldError{want}, got.Results[i].Error.Details)
+	}
+	ts.Equal(models.BatchDelete, got.Results[2].Op)
+	ts.Equal([]validate.FieldError{{Field: "ops", Message: "has to be a list"}}, ts.invalidFields(http.MethodPost, "/todo/batch", `{"ops":{}}`))
+
+	ts.Equal([]validate.FieldError{{Field: "name", Message: "is not a known field"}}, ts.invalidFields(http.MethodPost, "/todo/"+objID1.Hex()+"/move", `{"projId":"`+objID5.Hex()+`","name":"moved"}`))
+	ts.Equal([]validate.FieldError{{Field: "before", Message: "has to be a string"}}, ts.invalidFields(http.MethodPost, "/todo/"+objID1.Hex()+"/reorder", `{"before":2}`))
+	ts.Equal([]validate.FieldError{{Field: "evnts", Message: "is not a known field"}}, ts.invalidFields(http.MethodPost, "/webhook", `{"url":"https://example.com/hook","evnts":["todo.created"]}`))
+	ts.Equal([]validate.FieldError{{Field: "active", Message: "has to be a boolean"}}, ts.invalidFields(http.MethodPatch, "/webhook/unknown", `{"active":"no"}`))
+
+	// due dates in the past are fine unless the server checks them
+	yesterday := time.Now().AddDate(0, 0, -1).Format(time.RFC3339)
+	tomorrow := time.Now().AddDate(0, 0, 1).Format(time.RFC3339)
+	ts.assertStatusCode(http.StatusCreated, ts.send(http.MethodPost, "/proj/"+objID3.Hex(), `{"name":"Feed cat","dueDateString":"`+yesterday+`"}`).Code)
+
+	ts.server = NewTodoServer(ts.server.TodoStore, WithDueDateCheck())
+	ts.Equal([]validate.FieldError{{Field: "dueDateString", Message: "cannot be before the todo is created or updated"}}, ts.invalidFields(http.MethodPost, "/proj/"+objID3.Hex(), `{"name":"Feed cat","dueDateString":"`+yesterday+`"}`))
+	ts.Equal([]validate.FieldError{{Field: "dueDateString", Message: "cannot be before the todo is created or updated"}}, ts.invalidFields(http.MethodPatch, "/todo/"+objID2.Hex(), `{"dueDateString":"`+yesterday+`"}`))
+	ts.assertStatusCode(http.StatusCreated, ts.send(http.MethodPost, "/proj/"+objID3.Hex(), `{"name":"Feed cat","dueDateString":"`+tomorrow+`"}`).Code)
+	ts.assertStatusCode(http.StatusOK, ts.send(http.MethodPatch, "/todo/"+objID2.Hex(), `{"completed":true}`).Code)
+}
 
-	ts.assertTodoText("Number of todos deleted: 1", got)
-	ts.assertStatusCode(200, responseRecorder.Code)
+func (ts *TestSuite) TestMergePatch() {
+	// reset seeded data
+	ts.SetupTest()
+	todoID := objID1.Hex()
+
+	responseRecorder := ts.patch("/todo/"+todoID, `{"completed":true,"priority":"high","reminders":[{"before":"1h"}]}`)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+
+	// absent fields are untouched, null clears a field
+	responseRecorder = ts.patch("/todo/"+todoID, `{"description":null,"priority":null}`)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	todo := models.TODO{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&todo)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Equal("Water Plants", todo.Name)
+	ts.Equal("", todo.Description)
+	ts.Equal("", todo.Priority)
+	ts.True(todo.Completed)
+	ts.NotNil(todo.DueDate)
+	ts.Len(todo.Reminders, 1)
+	ts.Equal(2, todo.Version)
+
+	// reminders need a due date, so clearing it clears them too
+	ts.assertStatusCode(http.StatusBadRequest, ts.patch("/todo/"+todoID, `{"dueDate":null}`).Code)
+	ts.assertStatusCode(http.StatusOK, ts.patch("/todo/"+todoID, `{"dueDate":null,"reminders":null}`).Code)
+	todo, err = ts.server.TodoStore.GetTodoByID(context.Background(), todoID)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	ts.Nil(todo.DueDate)
+	ts.Empty(todo.Reminders)
+
+	// a patch that sets nothing changes nothing
+	responseRecorder = ts.patch("/todo/"+todoID, `{}`)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	ts.Equal(`"3"`, responseRecorder.Header().Get("ETag"))
+
+	ts.Equal([]validate.FieldError{{Field: "name", Message: "is required"}}, ts.invalidFields(http.MethodPatch, "/todo/"+todoID, `{"name":null}`))
+	ts.Equal([]validate.FieldError{{Field: "completed", Message: "has to be a boolean"}}, ts.invalidFields(http.MethodPatch, "/todo/"+todoID, `{"completed":"yes"}`))
+	ts.Equal([]validate.FieldError{{Field: "version", Message: "is not a known field"}}, ts.invalidFields(http.MethodPatch, "/todo/"+todoID, `{"version":1}`))
+	ts.Equal([]validate.FieldError{{Field: "dueDateString", Message: "cannot be set along with dueDate"}},
+		ts.invalidFields(http.MethodPatch, "/todo/"+todoID, `{"dueDate":"2025-01-06T09:00:00Z","dueDateString":"2025-01-06T09:00:00Z"}`))
+
+	// projects take merge patches too
+	responseRecorder = ts.patch("/proj/"+objID3.Hex(), `{}`)
+	ts.assertStatusCode(http.StatusOK, responseRecorder.Code)
+	ts.Equal(ts.send(http.MethodGet, "/proj/"+objID3.Hex(), "").Header().Get("ETag"), responseRecorder.Header().Get("ETag"))
+	ts.Equal([]validate.FieldError{{Field: "projname", Message: "is required"}}, ts.invalidFields(http.MethodPatch, "/proj/"+objID3.Hex(), `{"projname":null}`))
+
+	// other media types are turned away with the one that is accepted
+	request, _ := http.NewRequest(http.MethodPatch, "/todo/"+todoID, strings.NewReader(`[{"op":"remove","path":"/description"}]`))
+	request.Header.Set("Content-Type", "application/json-patch+json")
+	responseRecorder = httptest.NewRecorder()
+	ts.server.ServeHTTP(responseRecorder, request)
+	ts.assertStatusCode(http.StatusUnsupportedMediaType, responseRecorder.Code)
+	ts.Equal(models.MergePatchType, responseRecorder.Header().Get("Accept-Patch"))
+}
+
+// patch sends body as a JSON merge patch
+func (ts *TestSuite) patch(path, body string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(http.MethodPatch, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", models.MergePatchType)
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+	return responseRecorder
+}
+
+func (ts *TestSuite) TestErrorResponse() {
+	request, _ := http.NewRequest(http.MethodGet, "/proj/682571d1dafbee2eecbf4999", nil)
+	request.Header.Set("X-Request-ID", "test-request-id")
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+
+	got := errorResponse{}
+	err := json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.assertStatusCode(http.StatusNotFound, responseRecorder.Code)
+	ts.Equal("application/json", responseRecorder.Header().Get("Content-Type"))
+	ts.Equal("test-request-id", responseRecorder.Header().Get("X-Request-ID"))
+	ts.Equal(codeNotFound, got.Error.Code)
+	ts.Equal("test-request-id", got.Error.RequestID)
+	ts.NotEmpty(got.Error.Message)
+}
+
+func (ts *TestSuite) TestErrorMapping() {
+	errorTests := []struct {
+		testname   string
+		err        error
+		statusCode int
+		code       string
+	}{
+		{"not found", fmt.Errorf("%w: no rows", errs.ErrNotFound), http.StatusNotFound, codeNotFound},
+		{"invalid id", fmt.Errorf("%w: \"abc\"", errs.ErrInvalidID), http.StatusBadRequest, codeInvalidID},
+		{"validation", errs.ErrValidation, http.StatusBadRequest, codeValidationFailed},
+		{"conflict", errs.ErrConflict, http.StatusConflict, codeConflict},
+		{"id in use", errs.ErrIdAlreadyInUse, http.StatusConflict, codeConflict},
+		{"precondition", errs.ErrPreconditionFailed, http.StatusPreconditionFailed, codePreconditionFailed},
+		{"unavailable", errs.ErrUnavailable, http.StatusServiceUnavailable, codeUnavailable},
+		{"unauthorized", errs.ErrUnauthorized, http.StatusUnauthorized, codeUnauthorized},
+		{"forbidden", errs.ErrForbidden, http.StatusForbidden, codeForbidden},
+		{"invalid fields", validate.Invalid("name", "is required"), http.StatusUnprocessableEntity, codeInvalidFields},
+		{"unsupported media", errs.ErrUnsupportedMedia, http.StatusUnsupportedMediaType, codeUnsupportedMedia},
+		{"unknown", errors.New("boom"), http.StatusInternalServerError, codeInternal},
+	}
+
+	for _, test := range errorTests {
+		ts.Run(test.testname, func() {
+			request, _ := http.NewRequest(http.MethodGet, "/", nil)
+			responseRecorder := httptest.NewRecorder()
+
+			writeErr(responseRecorder, request, test.err)
+
+			got := errorResponse{}
+			err := json.NewDecoder(responseRecorder.Result().Body).Decode(&got)
+			if err != nil {
+				ts.FailNow(err.Error())
+			}
+
+			ts.assertStatusCode(test.statusCode, responseRecorder.Code)
+			ts.Equal(test.code, got.Error.Code)
+		})
+	}
+}
+
+func (ts *TestSuite) TestMalformedJSON() {
+	request, _ := http.NewRequest(http.MethodPost, "/proj/", bytes.NewBufferString("{not json"))
+	responseRecorder := httptest.NewRecorder()
+
+	ts.server.ServeHTTP(responseRecorder, request)
+
+	ts.assertStatusCode(http.StatusBadRequest, responseRecorder.Code)
 }
 
 /*
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+	"github.com/ganglinwu/todoapp-backend-v1/validate"
+)
+
+// collaboratorRequest is the body of "POST /proj/{ID}/collaborators" and "PATCH /proj/{ID}/collaborators/{userID}"
+type collaboratorRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// projRole lets a request to "/proj/{ID}" and below through if its user has at least the role least on the project,
+// the request then reaches the project as its owner, see projAccess
+func (ts TodoServer) projRole(least string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, err := ts.projAccess(r.Context(), r.PathValue("ID"), least)
+		if err != nil {
+			writeErr(w, r, err)
+			return
+		}
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// todoRole is projRole for requests to "/todo/{ID}" and below, the role is the one on the project of the todo
+func (ts TodoServer) todoRole(least string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, err := ts.todoAccess(r.Context(), r.PathValue("ID"), least)
+		if err != nil {
+			writeErr(w, r, err)
+			return
+		}
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// projAccess checks that the user of ctx has at least the role least on the project projID
+//
+// - returns ctx with the owner of the project as auth.Owner, the store keeps the project and its todos as theirs
+// - returns errs.ErrNotFound if the project is neither owned by nor shared with the user
+// - returns errs.ErrForbidden if the role of the user is below least
+func (ts TodoServer) projAccess(ctx context.Context, projID, least string) (context.Context, error) {
+	access, err := ts.TodoStore.GetProjAccess(ctx, projID)
+	if err != nil {
+		return nil, err
+	}
+	return withAccess(ctx, access, least)
+}
+
+// todoAccess is projAccess for the project of the todo todoID
+func (ts TodoServer) todoAccess(ctx context.Context, todoID, least string) (context.Context, error) {
+	access, err := ts.TodoStore.GetTodoAccess(ctx, todoID)
+	if err != nil {
+		return nil, err
+	}
+	return withAccess(ctx, access, least)
+}
+
+func withAccess(ctx context.Context, access models.Access, least string) (context.Context, error) {
+	if !models.RoleAtLeast(access.Role, least) {
+		return nil, fmt.Errorf("%w: %s of project %s, %s is required", errs.ErrForbidden, access.Role, access.ProjID, least)
+	}
+	return auth.WithOwner(ctx, access.Owner), nil
+}
+
+// handleGetCollaborators
+//
+// endpoint: "GET /proj/{ID}/collaborators"
+//
+// - lists the users the project is shared with, the owner who created it first
+// and then the collaborators by user id
+func (ts TodoServer) handleGetCollaborators(w http.ResponseWriter, r *http.Request) {
+	collaborators, err := ts.TodoStore.GetCollaborators(r.Context(), r.PathValue("ID"))
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	owner := models.Collaborator{UserID: auth.Owner(r.Context()), Role: models.RoleOwner}
+	writeJSON(w, http.StatusOK, slices.Insert(collaborators, 0, owner))
+}
+
+// handleAddCollaborator
+//
+// endpoint: "POST /proj/{ID}/collaborators"
+//
+// - takes {"email": "...", "role": "viewer" | "editor" | "owner"}, the user has to be registered
+// - only owners of the project can share it
+// - a user who already is a collaborator is a 409, change their role with handleUpdateCollaborator
+// - responds with the collaborator and its URL in the Location header
+func (ts TodoServer) handleAddCollaborator(w http.ResponseWriter, r *http.Request) {
+	body, err := decodeCollaborator(r)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	email := normalizeEmail(body.Email)
+	user, err := ts.TodoStore.GetUserByEmail(email)
+	if errors.Is(err, errs.ErrNotFound) {
+		writeErr(w, r, fmt.Errorf("%w: %q is not registered", errs.ErrValidation, email))
+		return
+	}
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	projID := r.PathValue("ID")
+	collaborator := models.Collaborator{UserID: user.ID, Role: body.Role}
+	err = checkCollaborator(r.Context(), collaborator.UserID)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	err = ts.TodoStore.AddCollaborator(r.Context(), projID, collaborator)
+	if err != nil {
+		log.Println("failed to add collaborator on data store: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+	ts.stream.accessChanged()
+
+	w.Header().Set("Location", "/proj/"+projID+"/collaborators/"+collaborator.UserID)
+	writeJSON(w, http.StatusCreated, collaborator)
+}
+
+// handleUpdateCollaborator
+//
+// endpoint: "PATCH /proj/{ID}/collaborators/{userID}"
+//
+// - takes {"role": "viewer" | "editor" | "owner"}
+// - only owners of the project can change roles, the owner who created it keeps theirs
+// - responds with the updated collaborator
+func (ts TodoServer) handleUpdateCollaborator(w http.ResponseWriter, r *http.Request) {
+	body, err := decodeCollaborator(r)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	collaborator := models.Collaborator{UserID: r.PathValue("userID"), Role: body.Role}
+	err = checkCollaborator(r.Context(), collaborator.UserID)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	err = ts.TodoStore.UpdateCollaborator(r.Context(), r.PathValue("ID"), collaborator)
+	if err != nil {
+		log.Println("failed to update collaborator on data store: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+	ts.stream.accessChanged()
+	writeJSON(w, http.StatusOK, collaborator)
+}
+
+// handleRemoveCollaborator
+//
+// endpoint: "DELETE /proj/{ID}/collaborators/{userID}"
+//
+// - only owners of the project can remove collaborators, but every collaborator can leave it
+// - the owner who created the project cannot be removed, they delete it instead
+func (ts TodoServer) handleRemoveCollaborator(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userID")
+	least := models.RoleOwner
+	if userID == auth.UserID(r.Context()) {
+		least = models.RoleViewer
+	}
+
+	ctx, err := ts.projAccess(r.Context(), r.PathValue("ID"), least)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	err = checkCollaborator(ctx, userID)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	deletedCount, err := ts.TodoStore.RemoveCollaborator(ctx, r.PathValue("ID"), userID)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	ts.stream.accessChanged()
+	writeJSON(w, http.StatusOK, deleteResponse{DeletedCount: deletedCount})
+}
+
+// decodeCollaborator decodes the body of a request to add or update a collaborator
+//
+// - returns validate.Errors for unknown fields and fields of the wrong type
+// - returns errs.ErrValidation unless the role is one of models.Roles
+func decodeCollaborator(r *http.Request) (collaboratorRequest, error) {
+	body := collaboratorRequest{}
+	err := validate.Decode(r.Body, &body)
+	if err != nil {
+		log.Println("failed to unmarshal json to collaboratorRequest struct: ", err.Error())
+		return collaboratorRequest{}, err
+	}
+	if !slices.Contains(models.Roles, body.Role) {
+		return collaboratorRequest{}, fmt.Errorf("%w: role has to be one of %v", errs.ErrValidation, models.Roles)
+	}
+	return body, nil
+}
+
+// checkCollaborator returns errs.ErrValidation if userID is the owner who created the project of ctx,
+// they are not a collaborator of their own project
+func checkCollaborator(ctx context.Context, userID string) error {
+	if userID == auth.Owner(ctx) {
+		return fmt.Errorf("%w: the owner who created the project is not a collaborator", errs.ErrValidation)
+	}
+	return nil
+}
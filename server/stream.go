@@ -0,0 +1,287 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+const (
+	// eventReplaySize is the number of events kept for clients that resume with Last-Event-ID
+	eventReplaySize = 1000
+	// streamBuffer is the number of events a slow client can fall behind before it is disconnected,
+	// it resumes from the replay buffer when it reconnects
+	streamBuffer = 64
+	// streamHeartbeat keeps idle connections open through proxies
+	streamHeartbeat = 15 * time.Second
+)
+
+// streamEvent is an event with its position in the stream
+//
+// IDs are "<epoch>-<seq>", the epoch changes on every start so ids from before a restart are not resumed from
+type streamEvent struct {
+	ID    string
+	Seq   uint64
+	Event models.Event
+}
+
+// eventStream is the EventPublisher behind "GET /events"
+//
+// it keeps the last eventReplaySize events so clients can resume after a reconnect
+type eventStream struct {
+	mu          sync.Mutex
+	epoch       string
+	seq         uint64
+	replay      []streamEvent
+	subscribers map[chan streamEvent]struct{}
+
+	// accessGen changes whenever a collaborator is added, updated or removed, see streamAccess
+	accessGen atomic.Uint64
+}
+
+func newEventStream() *eventStream {
+	return &eventStream{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		subscribers: map[chan streamEvent]struct{}{},
+	}
+}
+
+// Publish adds event to the replay buffer and sends it to every subscriber
+//
+// a subscriber that is streamBuffer events behind is closed rather than blocking the handler
+func (s *eventStream) Publish(event models.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	se := streamEvent{ID: s.epoch + "-" + strconv.FormatUint(s.seq, 10), Seq: s.seq, Event: event}
+
+	if len(s.replay) == eventReplaySize {
+		s.replay = slices.Delete(s.replay, 0, 1)
+	}
+	s.replay = append(s.replay, se)
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- se:
+		default:
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe registers a subscriber and returns the events it missed since lastEventID
+//
+// - lastEventID "" means a new client, nothing is replayed
+// - reset is true when the missed events cannot be replayed, because they were
+// dropped from the buffer or lastEventID is from before a restart,
+// the client has to reload instead
+func (s *eventStream) subscribe(lastEventID string) (ch chan streamEvent, replay []streamEvent, reset bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch = make(chan streamEvent, streamBuffer)
+	s.subscribers[ch] = struct{}{}
+
+	if lastEventID == "" {
+		return ch, nil, false
+	}
+
+	epoch, seqString, _ := strings.Cut(lastEventID, "-")
+	seq, err := strconv.ParseUint(seqString, 10, 64)
+	if err != nil || epoch != s.epoch || seq > s.seq {
+		return ch, nil, true
+	}
+
+	// the event right after lastEventID has to still be in the buffer
+	if len(s.replay) > 0 && s.replay[0].Seq > seq+1 {
+		return ch, nil, true
+	}
+	for _, se := range s.replay {
+		if se.Seq > seq {
+			replay = append(replay, se)
+		}
+	}
+	return ch, replay, false
+}
+
+// unsubscribe removes a subscriber, it is a no-op if the subscriber was already dropped
+func (s *eventStream) unsubscribe(ch chan streamEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.subscribers[ch]; ok {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// closeAll disconnects every subscriber
+func (s *eventStream) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ch := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// accessChanged empties the access every open stream remembers, see streamAccess
+func (s *eventStream) accessChanged() {
+	s.accessGen.Add(1)
+}
+
+// streamAccess remembers the access of a stream's user to the projects it had events of,
+// so that the store is asked once per project rather than once per event
+//
+// it is emptied when a collaborator of any project changes, see eventStream.accessChanged
+type streamAccess struct {
+	gen      uint64
+	projects map[string]models.Access
+}
+
+// CloseEventStreams ends every open "GET /events" response,
+// http.Server.Shutdown waits for them otherwise
+func (ts *TodoServer) CloseEventStreams() {
+	ts.stream.closeAll()
+}
+
+// handleEvents
+//
+// endpoint: "GET /events"
+//
+// - streams change events as text/event-stream, see models.Event
+// - only the events of the signed in user's data and of the projects shared with them are streamed,
+// see canSee
+// - proj=<id>,<id> only streams events of those projects
+// - the Last-Event-ID header, or lastEventId query parameter, resumes after that event,
+// when it is too old to resume a "reset" event tells the client to reload instead
+// - the server's write timeout does not apply to this endpoint
+func (ts TodoServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	projIDs := splitList(r.URL.Query().Get("proj"))
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+
+	rc := http.NewResponseController(w)
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		log.Println("failed to clear write deadline of event stream: ", err.Error())
+	}
+
+	ch, replay, reset := ts.stream.subscribe(lastEventID)
+	defer ts.stream.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	access := &streamAccess{gen: ts.stream.accessGen.Load(), projects: map[string]models.Access{}}
+	send := func(se streamEvent) error {
+		if len(projIDs) > 0 && !slices.Contains(projIDs, se.Event.ProjID) || !ts.canSee(r.Context(), access, se.Event) {
+			return nil
+		}
+		err := writeStreamEvent(w, se)
+		if err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if reset {
+		_, err = fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+		if err != nil {
+			return
+		}
+	}
+	for _, se := range replay {
+		if send(se) != nil {
+			return
+		}
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case se, ok := <-ch:
+			if !ok {
+				return
+			}
+			if send(se) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(w, ": heartbeat\n\n")
+			if err != nil || rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// canSee reports whether the user of ctx may see event
+//
+// - events of their own data are always seen
+// - events of a project owned by someone else are seen while the project is shared with them,
+// the role is looked up once per stream and project, and again after a collaborator changes,
+// so a removed collaborator stops seeing them
+func (ts TodoServer) canSee(ctx context.Context, cache *streamAccess, event models.Event) bool {
+	if event.Owner == auth.UserID(ctx) {
+		return true
+	}
+	if event.ProjID == "" {
+		return false
+	}
+
+	if gen := ts.stream.accessGen.Load(); gen != cache.gen {
+		cache.gen = gen
+		clear(cache.projects)
+	}
+	access, ok := cache.projects[event.ProjID]
+	if !ok {
+		var err error
+		access, err = ts.TodoStore.GetProjAccess(ctx, event.ProjID)
+		if errors.Is(err, errs.ErrNotFound) {
+			access = models.Access{}
+		} else if err != nil {
+			log.Println("failed to fetch project access for event stream: ", err.Error())
+			return false
+		}
+		cache.projects[event.ProjID] = access
+	}
+	return access.Owner == event.Owner && models.RoleAtLeast(access.Role, models.RoleViewer)
+}
+
+// writeStreamEvent writes an event in the text/event-stream format
+func writeStreamEvent(w http.ResponseWriter, se streamEvent) error {
+	data, err := json.Marshal(se.Event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", se.ID, se.Event.Type, data)
+	return err
+}
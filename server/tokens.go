@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// maxTokenNameLength is the longest name a personal access token can have, in characters
+const maxTokenNameLength = 100
+
+// personalTokenRequest is the body of "POST /token"
+type personalTokenRequest struct {
+	Name      string `json:"name"`
+	Scope     string `json:"scope"`
+	ProjectID string `json:"projectId"`
+}
+
+// handleGetPersonalTokens
+//
+// endpoint: "GET /token"
+//
+// - lists the personal access tokens of the signed in user, without the tokens themselves
+func (ts TodoServer) handleGetPersonalTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := ts.TodoStore.GetPersonalTokens(auth.UserID(r.Context()))
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// handleCreatePersonalToken
+//
+// endpoint: "POST /token"
+//
+// - takes {"name": "...", "scope": "read" | "read-write", "projectId": "..."}, name and scope are required
+// - a projectId limits the token to that project, it has to be a project of the signed in user or shared with them
+// - responds with the created token and its URL in the Location header,
+// this is the only time the token itself is returned
+func (ts TodoServer) handleCreatePersonalToken(w http.ResponseWriter, r *http.Request) {
+	body := personalTokenRequest{}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		log.Println("failed to unmarshal json to personalTokenRequest struct: ", err.Error())
+		writeErr(w, r, fmt.Errorf("%w: %w", errs.ErrValidation, err))
+		return
+	}
+
+	token, err := ts.validatePersonalToken(r.Context(), body)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	secret, hash, err := auth.NewPersonalToken()
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	token.UserID = auth.UserID(r.Context())
+	token.Hash = hash
+	token.Prefix = secret[:len(auth.PersonalTokenPrefix)+4]
+	token.CreatedAt = ts.issuer.Now().UTC()
+
+	token.ID, err = ts.TodoStore.CreatePersonalToken(token)
+	if err != nil {
+		log.Println("failed to create personal access token on data store: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+	token.Token = secret
+
+	w.Header().Set("Location", "/token/"+token.ID)
+	writeJSON(w, http.StatusCreated, token)
+}
+
+// handleDeletePersonalToken
+//
+// endpoint: "DELETE /token/{ID}"
+//
+// - revokes a personal access token of the signed in user, it stops working right away
+func (ts TodoServer) handleDeletePersonalToken(w http.ResponseWriter, r *http.Request) {
+	deletedCount, err := ts.TodoStore.DeletePersonalToken(auth.UserID(r.Context()), r.PathValue("ID"))
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deleteResponse{DeletedCount: deletedCount})
+}
+
+// validatePersonalToken checks the body of a create request and turns it into a token
+func (ts TodoServer) validatePersonalToken(ctx context.Context, body personalTokenRequest) (models.PersonalToken, error) {
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		return models.PersonalToken{}, fmt.Errorf("%w: name is required", errs.ErrValidation)
+	}
+	if utf8.RuneCountInString(name) > maxTokenNameLength {
+		return models.PersonalToken{}, fmt.Errorf("%w: name is longer than %d characters", errs.ErrValidation, maxTokenNameLength)
+	}
+	if body.Scope != models.ScopeRead && body.Scope != models.ScopeReadWrite {
+		return models.PersonalToken{}, fmt.Errorf("%w: scope has to be %q or %q", errs.ErrValidation, models.ScopeRead, models.ScopeReadWrite)
+	}
+
+	if body.ProjectID != "" {
+		_, err := ts.TodoStore.GetProjAccess(ctx, body.ProjectID)
+		if errors.Is(err, errs.ErrNotFound) || errors.Is(err, errs.ErrInvalidID) {
+			return models.PersonalToken{}, fmt.Errorf("%w: unknown project %q", errs.ErrValidation, body.ProjectID)
+		}
+		if err != nil {
+			return models.PersonalToken{}, err
+		}
+	}
+
+	return models.PersonalToken{Name: name, Scope: body.Scope, ProjectID: body.ProjectID}, nil
+}
+
+// usePersonalToken signs a request in with the personal access token token
+//
+// - returns errs.ErrUnauthorized if the token is unknown or was revoked
+// - returns errs.ErrForbidden if the token may not make the request, see checkScope
+// - the use is only recorded in LastUsedAt once the scope allows the request
+func (ts TodoServer) usePersonalToken(r *http.Request, token string) (context.Context, error) {
+	hash := auth.HashToken(token)
+	personalToken, err := ts.TodoStore.GetPersonalToken(hash)
+	if errors.Is(err, errs.ErrNotFound) {
+		return nil, fmt.Errorf("%w: invalid personal access token", errs.ErrUnauthorized)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := auth.WithUser(r.Context(), personalToken.UserID)
+	err = ts.checkScope(ctx, r, personalToken)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ts.TodoStore.UsePersonalToken(hash, ts.issuer.Now().UTC())
+	if errors.Is(err, errs.ErrNotFound) {
+		// revoked in the meantime
+		return nil, fmt.Errorf("%w: invalid personal access token", errs.ErrUnauthorized)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
+
+// checkScope returns errs.ErrForbidden unless the personal access token token may make the request r
+//
+// - tokens cannot list, create or revoke tokens, a leaked token must not be able to make more
+// - read tokens can only make GET and HEAD requests
+// - tokens limited to a project can only reach "/proj/{ID}" and below for that project,
+// "/todo/{ID}" and below for its todos, and "GET /todo?project={ID}".
+// moving a todo is not allowed, it takes the todo out of the project or brings one in
+func (ts TodoServer) checkScope(ctx context.Context, r *http.Request, token models.PersonalToken) error {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	if parts[0] == "token" {
+		return fmt.Errorf("%w: personal access tokens cannot manage tokens", errs.ErrForbidden)
+	}
+	if token.Scope != models.ScopeReadWrite && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return fmt.Errorf("%w: the personal access token is read only", errs.ErrForbidden)
+	}
+	if token.ProjectID == "" {
+		return nil
+	}
+
+	switch {
+	case parts[0] == "proj" && len(parts) >= 2 && parts[1] == token.ProjectID:
+		return nil
+	case parts[0] == "todo" && len(parts) == 1 && r.URL.Query().Get("project") == token.ProjectID:
+		return nil
+	case parts[0] == "todo" && len(parts) >= 2 && parts[1] != "batch" && !(len(parts) == 3 && parts[2] == "move"):
+		access, err := ts.TodoStore.GetTodoAccess(ctx, parts[1])
+		if err == nil && access.ProjID == token.ProjectID {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: the personal access token is limited to project %s", errs.ErrForbidden, token.ProjectID)
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+// handleGetTrash
+//
+// endpoint: "GET /trash"
+//
+// - lists trashed todos and projects, most recently deleted first
+// - a trashed project holds the todos that were deleted with it,
+// they are not listed separately
+// - the trash is owner-only: it holds what was deleted from the projects the signed in user owns,
+// also by their collaborators, and nothing from the projects shared with them
+func (ts TodoServer) handleGetTrash(w http.ResponseWriter, r *http.Request) {
+	trash, err := ts.TodoStore.GetTrash(r.Context())
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, trash)
+}
+
+// handleRestore
+//
+// endpoint: "POST /trash/{ID}/restore"
+//
+// - restores a trashed todo, or a trashed project together with the todos deleted with it
+// - ?kind=todo or ?kind=project is only needed when a todo and a project in the trash share the ID
+// - a todo can only be restored while its project is not in the trash
+// - a project whose name was taken by another project in the meantime is a 409
+// - only the owner of the trash can restore from it, collaborators cannot, see handleGetTrash
+// - responds with the restored todo or project
+func (ts TodoServer) handleRestore(w http.ResponseWriter, r *http.Request) {
+	ID := r.PathValue("ID")
+	kind := r.URL.Query().Get("kind")
+	if kind != "" && kind != models.TrashTodo && kind != models.TrashProject {
+		writeErr(w, r, fmt.Errorf("%w: kind must be %s or %s", errs.ErrValidation, models.TrashTodo, models.TrashProject))
+		return
+	}
+
+	item, err := ts.findTrashItem(r.Context(), ID, kind)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	if item.Kind == models.TrashProject {
+		err = ts.TodoStore.RestoreProj(r.Context(), ID)
+		if err != nil {
+			log.Println("failed to restore project on data store: ", err.Error())
+			writeErr(w, r, err)
+			return
+		}
+
+		proj, err := ts.TodoStore.GetProjByID(r.Context(), ID)
+		if err != nil {
+			writeErr(w, r, err)
+			return
+		}
+		ts.publish(r.Context(), models.EventProjectRestored, ID, proj)
+		setETag(w, proj.Version)
+		writeJSON(w, http.StatusOK, proj)
+		return
+	}
+
+	err = ts.TodoStore.RestoreTodo(r.Context(), ID)
+	if err != nil {
+		log.Println("failed to restore todo on data store: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+
+	todo, err := ts.TodoStore.GetTodoByID(r.Context(), ID)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	ts.publish(r.Context(), models.EventTodoRestored, todo.ProjID, todo)
+	setETag(w, todo.Version)
+	writeJSON(w, http.StatusOK, todo)
+}
+
+// findTrashItem returns the trashed todo or project with ID
+//
+// - kind limits the search to one kind when it is not ""
+// - a todo deleted together with its project is not found, the project has to be restored
+func (ts TodoServer) findTrashItem(ctx context.Context, ID, kind string) (models.TrashItem, error) {
+	trash, err := ts.TodoStore.GetTrash(ctx)
+	if err != nil {
+		return models.TrashItem{}, err
+	}
+
+	found := []models.TrashItem{}
+	for _, item := range trash {
+		if item.ID == ID && (kind == "" || item.Kind == kind) {
+			found = append(found, item)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return models.TrashItem{}, fmt.Errorf("%w: nothing in the trash has the id %s", errs.ErrNotFound, ID)
+	case 1:
+		return found[0], nil
+	default:
+		return models.TrashItem{}, fmt.Errorf("%w: a todo and a project in the trash have the id %s, pass kind=%s or kind=%s",
+			errs.ErrValidation, ID, models.TrashTodo, models.TrashProject)
+	}
+}
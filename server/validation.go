@@ -0,0 +1,45 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+	"github.com/ganglinwu/todoapp-backend-v1/validate"
+)
+
+// WithDueDateCheck rejects todos created with a due date before their creation,
+// and updates that move a due date before the update, see checkDueDate
+func WithDueDateCheck() Option {
+	return func(ts *TodoServer) {
+		ts.checkDueDates = true
+	}
+}
+
+// checkDueDate parses the due date in the field of a create or update request
+//
+// - returns validate.Errors for field if it is not RFC3339
+// - with WithDueDateCheck, returns validate.Errors for field if it is before now, the time of the request
+func (ts TodoServer) checkDueDate(field, dueDateString string) (*time.Time, error) {
+	dueDate, err := time.Parse(time.RFC3339, dueDateString)
+	if err != nil {
+		return nil, validate.Invalid(field, "has to be an RFC 3339 time")
+	}
+	if ts.checkDueDates && dueDate.Before(time.Now()) {
+		return nil, validate.Invalid(field, "cannot be before the todo is created or updated")
+	}
+	return &dueDate, nil
+}
+
+// decodeProj decodes the body of a request to create a project
+//
+// - returns validate.Errors for unknown fields and fields that break the rules of models.PROJECT
+func decodeProj(r *http.Request, project *models.PROJECT) error {
+	err := validate.Decode(r.Body, project)
+	if err != nil {
+		log.Println("failed to unmarshal json to PROJECT struct: ", err.Error())
+		return err
+	}
+	return validate.Struct(project)
+}
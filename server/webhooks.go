@@ -0,0 +1,266 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+	"github.com/ganglinwu/todoapp-backend-v1/validate"
+	"github.com/ganglinwu/todoapp-backend-v1/webhook"
+)
+
+// defaultDeliveryLimit is the number of deliveries returned without a limit
+const defaultDeliveryLimit = 50
+
+// webhookRequest is the body of "POST /webhook"
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// webhookPatch is the body of "PATCH /webhook/{ID}"
+//
+// pointers tell a missing field apart from its zero value
+type webhookPatch struct {
+	URL    *string   `json:"url"`
+	Secret *string   `json:"secret"`
+	Events *[]string `json:"events"`
+	Active *bool     `json:"active"`
+}
+
+// handleGetAllWebhooks
+//
+// endpoint: "GET /webhook"
+//
+// - secrets are never returned after the webhook was created
+func (ts TodoServer) handleGetAllWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := ts.TodoStore.GetAllWebhooks(r.Context())
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	writeJSON(w, http.StatusOK, hooks)
+}
+
+// handleGetWebhookByID
+//
+// endpoint: "GET /webhook/{ID}"
+func (ts TodoServer) handleGetWebhookByID(w http.ResponseWriter, r *http.Request) {
+	hook, err := ts.TodoStore.GetWebhookByID(r.Context(), r.PathValue("ID"))
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	hook.Secret = ""
+	writeJSON(w, http.StatusOK, hook)
+}
+
+// handleCreateWebhook
+//
+// endpoint: "POST /webhook"
+//
+// - takes {"url": "https://...", "events": ["todo.created", ...], "secret": "..."}
+// - a secret is generated when none is given, it is only returned in this response,
+// so the endpoint does not take an Idempotency-Key, see idempotent
+// - responds with the created webhook and its URL in the Location header
+func (ts TodoServer) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	body := webhookRequest{}
+	err := validate.Decode(r.Body, &body)
+	if err != nil {
+		log.Println("failed to unmarshal json to webhookRequest struct: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+
+	hook, err := validateWebhook(models.Webhook{
+		URL:       body.URL,
+		Secret:    body.Secret,
+		Events:    body.Events,
+		Active:    true,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	if hook.Secret == "" {
+		hook.Secret, err = newSecret()
+		if err != nil {
+			log.Println("failed to generate webhook secret: ", err.Error())
+			writeErr(w, r, err)
+			return
+		}
+	}
+
+	insertedID, err := ts.TodoStore.CreateWebhook(r.Context(), hook)
+	if err != nil {
+		log.Println("failed to create webhook on data store: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+	hook.ID = insertedID
+
+	w.Header().Set("Location", "/webhook/"+insertedID)
+	writeJSON(w, http.StatusCreated, hook)
+}
+
+// handleUpdateWebhook
+//
+// endpoint: "PATCH /webhook/{ID}"
+//
+// - takes any of url, secret, events and active, missing fields are left as they are
+// - setting active to true re-enables a disabled webhook and clears its failures
+// - responds with the updated webhook, without its secret
+func (ts TodoServer) handleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	patch := webhookPatch{}
+	err := validate.Decode(r.Body, &patch)
+	if err != nil {
+		log.Println("failed to unmarshal json to webhookPatch struct: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+
+	ID := r.PathValue("ID")
+
+	hook, err := ts.TodoStore.GetWebhookByID(r.Context(), ID)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	if patch.URL != nil {
+		hook.URL = *patch.URL
+	}
+	if patch.Secret != nil {
+		if *patch.Secret == "" {
+			writeErr(w, r, fmt.Errorf("%w: secret cannot be empty", errs.ErrValidation))
+			return
+		}
+		hook.Secret = *patch.Secret
+	}
+	if patch.Events != nil {
+		hook.Events = *patch.Events
+	}
+	if patch.Active != nil {
+		if *patch.Active && !hook.Active {
+			hook.Failures = 0
+		}
+		hook.Active = *patch.Active
+	}
+
+	hook, err = validateWebhook(hook)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	err = ts.TodoStore.UpdateWebhook(r.Context(), ID, hook)
+	if err != nil {
+		log.Println("failed to update webhook on data store: ", err.Error())
+		writeErr(w, r, err)
+		return
+	}
+	hook.Secret = ""
+	writeJSON(w, http.StatusOK, hook)
+}
+
+// handleDeleteWebhook
+//
+// endpoint: "DELETE /webhook/{ID}"
+//
+// - the delivery log of the webhook is deleted with it
+func (ts TodoServer) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	deletedCount, err := ts.TodoStore.DeleteWebhook(r.Context(), r.PathValue("ID"))
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deleteResponse{DeletedCount: deletedCount})
+}
+
+// handleGetDeliveries
+//
+// endpoint: "GET /webhook/{ID}/deliveries"
+//
+// - the delivery log, newest first, one entry per attempt
+// - limit=<1 to maxPageLimit> caps the number of entries (default 50)
+func (ts TodoServer) handleGetDeliveries(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r.URL.Query(), defaultDeliveryLimit)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	ID := r.PathValue("ID")
+
+	// an unknown webhook is a 404 rather than an empty log
+	_, err = ts.TodoStore.GetWebhookByID(r.Context(), ID)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+
+	deliveries, err := ts.TodoStore.GetDeliveries(r.Context(), ID, page.Limit)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// validateWebhook checks the url and events of a webhook and drops duplicate events
+//
+// - returns errs.ErrValidation if the url is not an absolute http(s) url,
+// there are no events or an event type is unknown, see models.EventTypes
+// - returns errs.ErrValidation if the host of the url is localhost or an address
+// that is not public, see webhook.PublicAddr, host names are checked again when delivering
+func validateWebhook(hook models.Webhook) (models.Webhook, error) {
+	hook.URL = strings.TrimSpace(hook.URL)
+	target, err := url.Parse(hook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return models.Webhook{}, fmt.Errorf("%w: url must be an absolute http or https url", errs.ErrValidation)
+	}
+	host := strings.ToLower(strings.TrimSuffix(target.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return models.Webhook{}, fmt.Errorf("%w: url cannot point to localhost", errs.ErrValidation)
+	}
+	addr, err := netip.ParseAddr(host)
+	if err == nil && !webhook.PublicAddr(addr) {
+		return models.Webhook{}, fmt.Errorf("%w: url cannot point to a loopback, private, link-local or unspecified address", errs.ErrValidation)
+	}
+
+	if len(hook.Events) == 0 {
+		return models.Webhook{}, fmt.Errorf("%w: events is required", errs.ErrValidation)
+	}
+	for _, event := range hook.Events {
+		if !slices.Contains(models.EventTypes, event) {
+			return models.Webhook{}, fmt.Errorf("%w: unknown event %q, must be one of %s", errs.ErrValidation, event, strings.Join(models.EventTypes, ", "))
+		}
+	}
+	hook.Events = slices.Clone(hook.Events)
+	slices.Sort(hook.Events)
+	hook.Events = slices.Compact(hook.Events)
+	return hook, nil
+}
+
+// newSecret generates a random webhook secret
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,54 @@
+package textsearch
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Tokenize lower cases s and splits it into words
+//
+// - anything that is not a letter or a digit separates words
+// - duplicate words are dropped, order of first appearance is kept
+func Tokenize(s string) []string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := map[string]bool{}
+	tokens := []string{}
+	for _, word := range words {
+		if !seen[word] {
+			seen[word] = true
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
+
+// Score ranks how well a document matches the query terms
+//
+// - a term matches a field when any word in the field starts with the term
+// so "sock" matches "socks"
+// - a match in an earlier field counts more than in a later one
+// e.g. Score(terms, name, description) ranks name matches above description matches
+// - the score is normalized by the number of terms, 0 means no match
+func Score(terms []string, fields ...string) float64 {
+	if len(terms) == 0 {
+		return 0
+	}
+
+	score := 0.0
+	for i, field := range fields {
+		weight := 1.0 / float64(i+1)
+		words := Tokenize(field)
+		for _, term := range terms {
+			for _, word := range words {
+				if strings.HasPrefix(word, term) {
+					score += weight
+					break
+				}
+			}
+		}
+	}
+	return score / float64(len(terms))
+}
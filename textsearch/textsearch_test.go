@@ -0,0 +1,26 @@
+package textsearch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []string{"water", "plants", "aloe", "vera"}, Tokenize("Water plants, water ALOE-vera!"))
+	assert.Equal(t, []string{}, Tokenize("  ..  "))
+}
+
+func TestScore(t *testing.T) {
+	terms := Tokenize("sock")
+
+	assert.Zero(t, Score(terms, "Water Plants", "Not too much water for aloe vera"))
+	assert.Zero(t, Score(nil, "Buy socks"))
+
+	nameMatch := Score(terms, "Buy socks", "")
+	descriptionMatch := Score(terms, "Buy shoes", "No show socks")
+	assert.Greater(t, nameMatch, descriptionMatch)
+	assert.Greater(t, descriptionMatch, 0.0)
+
+	assert.Greater(t, Score(Tokenize("buy socks"), "Buy socks"), Score(Tokenize("buy socks"), "Buy shoes"))
+}
@@ -0,0 +1,67 @@
+// Package trash empties the trash of the todo store
+//
+// deleted todos and projects are kept in the trash so they can be restored, see
+// the DeletedAt of models.TODO and models.PROJECT. the Purger periodically removes
+// the ones that have been in the trash for longer than the retention period for good
+package trash
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Store is the part of the todo store the Purger needs
+type Store interface {
+	// PurgeTrash permanently removes todos and projects trashed before before,
+	// returns the number of removed todos and projects
+	PurgeTrash(before time.Time) (int, error)
+}
+
+type Purger struct {
+	Store     Store
+	Retention time.Duration    // how long items stay in the trash
+	Interval  time.Duration    // how often the trash is purged
+	Now       func() time.Time // defaults to time.Now
+}
+
+// NewPurger returns a Purger that removes items older than retention every interval
+func NewPurger(store Store, retention, interval time.Duration) *Purger {
+	return &Purger{
+		Store:     store,
+		Retention: retention,
+		Interval:  interval,
+		Now:       time.Now,
+	}
+}
+
+// Run purges the trash every Interval until ctx is done
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.Purge()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge removes the todos and projects that have been in the trash for longer than Retention
+//
+// - returns the number of removed todos and projects
+func (p *Purger) Purge() int {
+	purged, err := p.Store.PurgeTrash(p.Now().Add(-p.Retention))
+	if err != nil {
+		log.Println("failed to purge the trash: ", err.Error())
+		return 0
+	}
+	if purged > 0 {
+		log.Printf("purged %d items from the trash\n", purged)
+	}
+	return purged
+}
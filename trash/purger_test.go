@@ -0,0 +1,65 @@
+package trash
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2025, 1, 6, 8, 30, 0, 0, time.UTC)
+
+type stubStore struct {
+	deletedAt []time.Time
+	err       error
+}
+
+func (s *stubStore) PurgeTrash(before time.Time) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	kept := []time.Time{}
+	for _, deletedAt := range s.deletedAt {
+		if !deletedAt.Before(before) {
+			kept = append(kept, deletedAt)
+		}
+	}
+	purged := len(s.deletedAt) - len(kept)
+	s.deletedAt = kept
+	return purged, nil
+}
+
+func newPurger(store Store) *Purger {
+	purger := NewPurger(store, 30*24*time.Hour, time.Hour)
+	purger.Now = func() time.Time { return now }
+	return purger
+}
+
+func TestPurge(t *testing.T) {
+	store := &stubStore{deletedAt: []time.Time{
+		now.Add(-31 * 24 * time.Hour),
+		now.Add(-30*24*time.Hour - time.Second),
+		now.Add(-29 * 24 * time.Hour),
+		now.Add(-time.Minute),
+	}}
+	purger := newPurger(store)
+
+	if purged := purger.Purge(); purged != 2 {
+		t.Fatalf("purged %d items, want 2", purged)
+	}
+	if len(store.deletedAt) != 2 {
+		t.Errorf("%d items left in the trash, want 2", len(store.deletedAt))
+	}
+
+	// items within the retention period are kept
+	if purged := purger.Purge(); purged != 0 {
+		t.Errorf("purged %d items again", purged)
+	}
+}
+
+func TestPurgeStoreError(t *testing.T) {
+	purger := newPurger(&stubStore{err: errors.New("unreachable")})
+
+	if purged := purger.Purge(); purged != 0 {
+		t.Errorf("purged %d items with a failing store", purged)
+	}
+}
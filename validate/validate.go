@@ -0,0 +1,240 @@
+// Package validate checks request bodies against the rules declared on the models
+//
+// the rules of a struct field are in its validate tag, comma separated:
+//
+//   - required: a string cannot be empty, a pointer or slice cannot be nil
+//   - max=N: a string cannot be longer than N characters
+//   - oneof=a b c: a string that is not empty has to be one of the words
+//
+// fields are named by their json name, the way the client sent them
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+)
+
+// FieldError is a field of a request body that is not valid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors lists every field of a request body that is not valid, it wraps errs.ErrInvalidFields
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, field := range e {
+		messages[i] = field.Field + " " + field.Message
+	}
+	return fmt.Sprintf("%s: %s", errs.ErrInvalidFields, strings.Join(messages, ", "))
+}
+
+func (e Errors) Unwrap() error {
+	return errs.ErrInvalidFields
+}
+
+// Invalid returns Errors for the one field that is not valid
+func Invalid(field, message string) Errors {
+	return Errors{{Field: field, Message: message}}
+}
+
+// Decode decodes the JSON object in body into v, a pointer to a struct
+//
+// - returns errs.ErrValidation if body is not a JSON object
+// - returns Errors for the fields v does not have and the fields of the wrong type,
+// the rules of the fields are not checked, see Struct
+func Decode(body io.Reader, v any) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errs.ErrValidation, err)
+	}
+
+	object := map[string]json.RawMessage{}
+	err = json.Unmarshal(data, &object)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errs.ErrValidation, err)
+	}
+
+	known := fieldNames(reflect.TypeOf(v).Elem())
+	invalid := Errors{}
+	for name := range object {
+		if !slices.Contains(known, name) {
+			invalid = append(invalid, FieldError{Field: name, Message: "is not a known field"})
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	err = decoder.Decode(v)
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			field = unnamedField(object, reflect.TypeOf(v).Elem())
+		}
+		invalid = append(invalid, FieldError{Field: field, Message: "has to be a " + jsonType(typeErr.Type)})
+	case err != nil:
+		return fmt.Errorf("%w: %w", errs.ErrValidation, err)
+	}
+
+	if len(invalid) > 0 {
+		sortErrors(invalid)
+		return invalid
+	}
+	return nil
+}
+
+// Struct checks every field of v, a struct, against the rules in its validate tag
+//
+// - returns Errors for the fields that break their rules, nil if there are none
+func Struct(v any) error {
+	return check(v, false, nil)
+}
+
+// Partial is Struct for updates, the fields that are left out of an update keep their
+// current value, so required fields are not checked
+func Partial(v any) error {
+	return check(v, true, nil)
+}
+
+// Fields is Struct for the fields of v named by their json name in names, a merge patch
+// only sets some fields and the others keep their current value, whatever it is
+func Fields(v any, names ...string) error {
+	if names == nil {
+		names = []string{}
+	}
+	return check(v, false, names)
+}
+
+// check checks the fields of v, only the ones in names unless names is nil
+func check(v any, partial bool, names []string) error {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	invalid := Errors{}
+	for i := range value.NumField() {
+		field := value.Type().Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		name := jsonName(field)
+		if names != nil && !slices.Contains(names, name) {
+			continue
+		}
+		for _, rule := range strings.Split(tag, ",") {
+			message := checkRule(value.Field(i), rule, partial)
+			if message != "" {
+				invalid = append(invalid, FieldError{Field: name, Message: message})
+				break
+			}
+		}
+	}
+	if len(invalid) > 0 {
+		return invalid
+	}
+	return nil
+}
+
+// checkRule returns why value breaks rule, or "" if it does not
+func checkRule(value reflect.Value, rule string, partial bool) string {
+	rule, arg, _ := strings.Cut(rule, "=")
+	switch rule {
+	case "required":
+		if !partial && value.IsZero() {
+			return "is required"
+		}
+	case "max":
+		limit, err := strconv.Atoi(arg)
+		if err != nil {
+			panic(fmt.Sprintf("validate: max=%s is not a number", arg))
+		}
+		if utf8.RuneCountInString(value.String()) > limit {
+			return fmt.Sprintf("cannot be longer than %d characters", limit)
+		}
+	case "oneof":
+		words := strings.Fields(arg)
+		if value.String() != "" && !slices.Contains(words, value.String()) {
+			return "has to be one of " + strings.Join(words, ", ")
+		}
+	default:
+		panic(fmt.Sprintf("validate: unknown rule %q", rule))
+	}
+	return ""
+}
+
+// unnamedField finds the field of object that does not decode into the struct type t
+//
+// encoding/json does not name the field when the field decodes itself and fails,
+// so every field is decoded on its own until one fails
+func unnamedField(object map[string]json.RawMessage, t reflect.Type) string {
+	names := slices.Sorted(maps.Keys(object))
+	for _, name := range names {
+		data, err := json.Marshal(map[string]json.RawMessage{name: object[name]})
+		if err != nil {
+			continue
+		}
+		if json.Unmarshal(data, reflect.New(t).Interface()) != nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// fieldNames lists the json names of the fields of the struct type t
+func fieldNames(t reflect.Type) []string {
+	names := []string{}
+	for i := range t.NumField() {
+		name := jsonName(t.Field(i))
+		if name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// jsonName is the name encoding/json gives field, "-" if it is left out
+func jsonName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return "-"
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// jsonType names the JSON type a value of type t is decoded from
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "list"
+	}
+	return "object"
+}
+
+func sortErrors(invalid Errors) {
+	slices.SortFunc(invalid, func(a, b FieldError) int {
+		return strings.Compare(a.Field, b.Field)
+	})
+}
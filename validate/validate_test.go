@@ -0,0 +1,87 @@
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+)
+
+type task struct {
+	Name     string   `json:"name" validate:"required,max=5"`
+	Priority string   `json:"priority,omitempty" validate:"oneof=low high"`
+	Tags     []string `json:"tags" validate:"required"`
+	Done     bool     `json:"done"`
+	Secret   string   `json:"-"`
+}
+
+func TestDecode(t *testing.T) {
+	got := task{}
+	assert.NoError(t, Decode(strings.NewReader(`{"name":"water","done":true}`), &got))
+	assert.Equal(t, task{Name: "water", Done: true}, got)
+
+	err := Decode(strings.NewReader(`{"name":"water","color":"red","Secret":"x","done":"yes"}`), &task{})
+	assert.ErrorIs(t, err, errs.ErrInvalidFields)
+	assert.Equal(t, Errors{
+		{Field: "Secret", Message: "is not a known field"},
+		{Field: "color", Message: "is not a known field"},
+		{Field: "done", Message: "has to be a boolean"},
+	}, err)
+
+	for _, body := range []string{``, `[]`, `{"name":`, `"water"`} {
+		err := Decode(strings.NewReader(body), &task{})
+		assert.ErrorIs(t, err, errs.ErrValidation, body)
+		assert.False(t, errors.Is(err, errs.ErrInvalidFields), body)
+	}
+}
+
+func TestStruct(t *testing.T) {
+	assert.NoError(t, Struct(task{Name: "water", Priority: "low", Tags: []string{}}))
+	assert.NoError(t, Struct(&task{Name: "wäter", Tags: []string{}}))
+
+	err := Struct(task{Name: "", Priority: "urgent"})
+	assert.ErrorIs(t, err, errs.ErrInvalidFields)
+	assert.Equal(t, Errors{
+		{Field: "name", Message: "is required"},
+		{Field: "priority", Message: "has to be one of low, high"},
+		{Field: "tags", Message: "is required"},
+	}, err)
+	assert.Equal(t, "the request has invalid fields: name is required, priority has to be one of low, high, tags is required", err.Error())
+
+	assert.Equal(t, Invalid("name", "cannot be longer than 5 characters"), Struct(task{Name: "plants", Tags: []string{}}))
+}
+
+func TestPartial(t *testing.T) {
+	assert.NoError(t, Partial(task{}))
+	assert.Equal(t, Invalid("name", "cannot be longer than 5 characters"), Partial(task{Name: "plants"}))
+}
+
+func TestFields(t *testing.T) {
+	// the fields that are left out are not checked, even when they break their rules
+	assert.NoError(t, Fields(task{Priority: "mid"}))
+	assert.NoError(t, Fields(task{Name: "water", Priority: "mid"}, "name", "done"))
+	assert.Equal(t, Errors{
+		{Field: "name", Message: "is required"},
+		{Field: "priority", Message: "has to be one of low, high"},
+	}, Fields(task{Priority: "mid"}, "name", "priority"))
+}
+
+type optional struct{ set bool }
+
+func (o *optional) UnmarshalJSON(data []byte) error {
+	o.set = true
+	return json.Unmarshal(data, new(bool))
+}
+
+func TestDecodeUnmarshaler(t *testing.T) {
+	// the field is named even though it decodes itself
+	err := Decode(strings.NewReader(`{"name":"water","done":"yes"}`), &struct {
+		Name string   `json:"name"`
+		Done optional `json:"done"`
+	}{})
+	assert.Equal(t, Invalid("done", "has to be a boolean"), err)
+}
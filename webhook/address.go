@@ -0,0 +1,51 @@
+package webhook
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// PublicAddr reports whether webhooks may be delivered to addr
+//
+// loopback, private, link-local and unspecified addresses are refused
+// so that a webhook cannot reach the server itself or the network it runs in
+func PublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() &&
+		!addr.IsLoopback() &&
+		!addr.IsPrivate() &&
+		!addr.IsLinkLocalUnicast() &&
+		!addr.IsLinkLocalMulticast() &&
+		!addr.IsInterfaceLocalMulticast() &&
+		!addr.IsUnspecified()
+}
+
+// newClient returns the http client of NewDispatcher
+//
+// the address is checked with PublicAddr when connecting, after the host name is resolved,
+// so a webhook url whose host resolves to a private address is refused as well,
+// the client does not use a proxy as it would connect on the webhook's behalf
+func newClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !PublicAddr(addrPort.Addr()) {
+				return fmt.Errorf("webhook: refusing to connect to %s, not a public address", address)
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
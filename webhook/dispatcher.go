@@ -0,0 +1,230 @@
+// Package webhook delivers change events to the webhooks subscribed to them
+//
+// every delivery is a POST of the json encoded models.Event with the headers
+//
+//	X-Webhook-Event: todo.created
+//	X-Webhook-Delivery: <event id>
+//	X-Webhook-Signature: sha256=<hex HMAC-SHA256 of the body, keyed with the webhook secret>
+//
+// failed attempts are retried with exponential backoff, every attempt is written
+// to the delivery log and webhooks that keep failing are disabled
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+const (
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// Store is the part of the todo store the Dispatcher needs
+//
+// the webhooks are read and updated as the owner of the event, see auth.Owner
+type Store interface {
+	GetAllWebhooks(ctx context.Context) ([]models.Webhook, error)
+	AddWebhookFailure(ctx context.Context, ID string, disableAfter int) (models.Webhook, error)
+	ResetWebhookFailures(ctx context.Context, ID string) error
+	AddDelivery(delivery models.Delivery) error
+}
+
+type Dispatcher struct {
+	Store        Store
+	Client       *http.Client  // only connects to public addresses when created by NewDispatcher, see PublicAddr
+	MaxAttempts  int           // attempts per event and webhook
+	Backoff      time.Duration // wait before the first retry, doubled for every retry after it
+	DisableAfter int           // failed deliveries in a row after which a webhook is disabled
+
+	queue chan models.Event
+	wg    sync.WaitGroup
+}
+
+// NewDispatcher returns a Dispatcher that tries every delivery 5 times,
+// starting with a 1 second backoff, and disables a webhook after 10 failed deliveries in a row
+func NewDispatcher(store Store) *Dispatcher {
+	return &Dispatcher{
+		Store:        store,
+		Client:       newClient(),
+		MaxAttempts:  5,
+		Backoff:      time.Second,
+		DisableAfter: 10,
+		queue:        make(chan models.Event, 256),
+	}
+}
+
+// Publish queues an event for delivery without blocking
+//
+// events are dropped, and logged, when the queue is full
+func (d *Dispatcher) Publish(event models.Event) {
+	select {
+	case d.queue <- event:
+	default:
+		log.Printf("webhook queue is full, dropped event %s %s\n", event.Type, event.ID)
+	}
+}
+
+// Run delivers queued events until ctx is done
+//
+// each webhook is delivered to in its own goroutine so a slow endpoint does not hold up the others,
+// Run returns once the deliveries in flight gave up
+func (d *Dispatcher) Run(ctx context.Context) {
+	defer d.wg.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-d.queue:
+			d.dispatch(ctx, event)
+		}
+	}
+}
+
+// dispatch starts a delivery of event to every active webhook subscribed to it,
+// only the webhooks of the event's owner are subscribed to it
+func (d *Dispatcher) dispatch(ctx context.Context, event models.Event) {
+	ctx = auth.WithUser(ctx, event.Owner)
+	hooks, err := d.Store.GetAllWebhooks(ctx)
+	if err != nil {
+		log.Println("failed to fetch webhooks: ", err.Error())
+		return
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Println("failed to marshal event: ", err.Error())
+		return
+	}
+
+	for _, hook := range hooks {
+		if !hook.Active || !slices.Contains(hook.Events, event.Type) {
+			continue
+		}
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.Deliver(ctx, hook, event, body)
+		}()
+	}
+}
+
+// Deliver POSTs body to hook until it succeeds or MaxAttempts is reached
+//
+// - any 2xx status is a success, anything else is retried
+// - every attempt is added to the delivery log
+// - the webhook is updated as the user of ctx
+// - returns whether the delivery succeeded
+func (d *Dispatcher) Deliver(ctx context.Context, hook models.Webhook, event models.Event, body []byte) bool {
+	backoff := d.Backoff
+
+	for attempt := 1; attempt <= d.MaxAttempts; attempt++ {
+		delivery := d.attempt(ctx, hook, event, body)
+		delivery.Attempt = attempt
+
+		err := d.Store.AddDelivery(delivery)
+		if err != nil {
+			log.Println("failed to add webhook delivery to the log: ", err.Error())
+		}
+
+		if delivery.Success {
+			d.recordResult(ctx, hook.ID, true)
+			return true
+		}
+		if attempt == d.MaxAttempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	d.recordResult(ctx, hook.ID, false)
+	return false
+}
+
+// attempt makes a single delivery, the returned Delivery has no Attempt
+func (d *Dispatcher) attempt(ctx context.Context, hook models.Webhook, event models.Event, body []byte) models.Delivery {
+	delivery := models.Delivery{
+		ID:          bson.NewObjectID().Hex(),
+		WebhookID:   hook.ID,
+		EventID:     event.ID,
+		Event:       event.Type,
+		DeliveredAt: time.Now().UTC(),
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventHeader, event.Type)
+	request.Header.Set(DeliveryHeader, event.ID)
+	request.Header.Set(SignatureHeader, Sign(hook.Secret, body))
+
+	response, err := d.Client.Do(request)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer response.Body.Close()
+
+	delivery.StatusCode = response.StatusCode
+	delivery.Success = response.StatusCode >= 200 && response.StatusCode <= 299
+	if !delivery.Success {
+		delivery.Error = fmt.Sprintf("webhook responded %s", response.Status)
+	}
+	return delivery
+}
+
+// recordResult resets the failure count of a webhook after a success,
+// or counts the failure and disables the webhook once it reaches DisableAfter
+//
+// both are single updates in the store so that concurrent deliveries and changes made through the API are kept
+func (d *Dispatcher) recordResult(ctx context.Context, ID string, success bool) {
+	if success {
+		err := d.Store.ResetWebhookFailures(ctx, ID)
+		if err != nil {
+			log.Println("failed to reset webhook failures: ", err.Error())
+		}
+		return
+	}
+
+	hook, err := d.Store.AddWebhookFailure(ctx, ID, d.DisableAfter)
+	if err != nil {
+		log.Println("failed to count webhook failure: ", err.Error())
+		return
+	}
+	if !hook.Active && hook.Failures == d.DisableAfter {
+		log.Printf("disabled webhook %s after %d failed deliveries\n", hook.ID, hook.Failures)
+	}
+}
+
+// Sign returns the X-Webhook-Signature of body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,265 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ganglinwu/todoapp-backend-v1/auth"
+	"github.com/ganglinwu/todoapp-backend-v1/errs"
+	"github.com/ganglinwu/todoapp-backend-v1/models"
+)
+
+type stubStore struct {
+	mu         sync.Mutex
+	hooks      []models.Webhook
+	deliveries []models.Delivery
+}
+
+func (s *stubStore) GetAllWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hooks := []models.Webhook{}
+	for _, hook := range s.hooks {
+		if hook.Owner == auth.UserID(ctx) {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks, nil
+}
+
+func (s *stubStore) AddWebhookFailure(ctx context.Context, ID string, disableAfter int) (models.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.hooks {
+		if s.hooks[i].ID == ID && s.hooks[i].Owner == auth.UserID(ctx) {
+			s.hooks[i].Failures++
+			s.hooks[i].Active = s.hooks[i].Active && s.hooks[i].Failures < disableAfter
+			return s.hooks[i], nil
+		}
+	}
+	return models.Webhook{}, errs.ErrNotFound
+}
+
+func (s *stubStore) ResetWebhookFailures(ctx context.Context, ID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.hooks {
+		if s.hooks[i].ID == ID && s.hooks[i].Owner == auth.UserID(ctx) {
+			s.hooks[i].Failures = 0
+			return nil
+		}
+	}
+	return errs.ErrNotFound
+}
+
+func (s *stubStore) AddDelivery(delivery models.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries = append(s.deliveries, delivery)
+	return nil
+}
+
+// receiver is a webhook endpoint that answers with the statuses in order, then 200
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+
+	status := http.StatusOK
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func newDispatcher(store *stubStore) *Dispatcher {
+	d := NewDispatcher(store)
+	d.Client = &http.Client{Timeout: time.Second} // the test endpoints listen on loopback
+	d.Backoff = time.Millisecond
+	d.MaxAttempts = 3
+	d.DisableAfter = 2
+	return d
+}
+
+var event = models.Event{ID: "e1", Type: models.EventTodoCreated, Data: models.DeletedData{ID: "1"}}
+
+func TestDeliverSigns(t *testing.T) {
+	rc := &receiver{}
+	endpoint := httptest.NewServer(rc)
+	defer endpoint.Close()
+
+	hook := models.Webhook{ID: "h1", URL: endpoint.URL, Secret: "s3cret", Active: true}
+	store := &stubStore{hooks: []models.Webhook{hook}}
+
+	if !newDispatcher(store).Deliver(context.Background(), hook, event, []byte(`{"id":"e1"}`)) {
+		t.Fatal("delivery failed")
+	}
+
+	request := rc.requests[0]
+	if got := request.Header.Get(SignatureHeader); got != Sign("s3cret", rc.bodies[0]) {
+		t.Errorf("signature %q does not match the body", got)
+	}
+	// computed independently: echo -n '{"id":"e1"}' | openssl dgst -sha256 -hmac s3cret
+	if got := Sign("s3cret", []byte(`{"id":"e1"}`)); got != "sha256=88267f36f7e6cafeb097afd76335e0a3a74ea79e2bc2e62989462bf0c75cfd0f" {
+		t.Errorf("Sign = %q", got)
+	}
+	if request.Header.Get(EventHeader) != models.EventTodoCreated || request.Header.Get(DeliveryHeader) != "e1" {
+		t.Errorf("unexpected headers %v", request.Header)
+	}
+	if len(store.deliveries) != 1 || !store.deliveries[0].Success || store.deliveries[0].StatusCode != http.StatusOK {
+		t.Errorf("unexpected delivery log %+v", store.deliveries)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	rc := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway}}
+	endpoint := httptest.NewServer(rc)
+	defer endpoint.Close()
+
+	hook := models.Webhook{ID: "h1", URL: endpoint.URL, Active: true, Failures: 1}
+	store := &stubStore{hooks: []models.Webhook{hook}}
+
+	if !newDispatcher(store).Deliver(context.Background(), hook, event, []byte(`{}`)) {
+		t.Fatal("delivery failed on the third attempt")
+	}
+	if len(store.deliveries) != 3 {
+		t.Fatalf("logged %d attempts, want 3", len(store.deliveries))
+	}
+	for i, delivery := range store.deliveries {
+		if delivery.Attempt != i+1 || delivery.Success != (i == 2) {
+			t.Errorf("unexpected delivery %+v", delivery)
+		}
+	}
+	if store.hooks[0].Failures != 0 {
+		t.Errorf("success did not reset failures: %d", store.hooks[0].Failures)
+	}
+}
+
+func TestDeliverDisablesFailingWebhook(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer endpoint.Close()
+
+	hook := models.Webhook{ID: "h1", URL: endpoint.URL, Active: true}
+	store := &stubStore{hooks: []models.Webhook{hook}}
+	d := newDispatcher(store)
+
+	if d.Deliver(context.Background(), hook, event, []byte(`{}`)) {
+		t.Fatal("delivery to a failing endpoint succeeded")
+	}
+	if !store.hooks[0].Active || store.hooks[0].Failures != 1 {
+		t.Fatalf("after one failed delivery: %+v", store.hooks[0])
+	}
+
+	d.Deliver(context.Background(), hook, event, []byte(`{}`))
+	if store.hooks[0].Active || store.hooks[0].Failures != 2 {
+		t.Errorf("webhook was not disabled: %+v", store.hooks[0])
+	}
+	if len(store.deliveries) != 6 {
+		t.Errorf("logged %d attempts, want 6", len(store.deliveries))
+	}
+}
+
+func TestDeliverRefusesPrivateAddresses(t *testing.T) {
+	rc := &receiver{}
+	endpoint := httptest.NewServer(rc)
+	defer endpoint.Close()
+
+	hook := models.Webhook{ID: "h1", URL: endpoint.URL, Active: true}
+	store := &stubStore{hooks: []models.Webhook{hook}}
+	d := NewDispatcher(store)
+	d.MaxAttempts = 1
+
+	if d.Deliver(context.Background(), hook, event, []byte(`{}`)) {
+		t.Fatal("delivered to a loopback address")
+	}
+	if len(rc.requests) != 0 {
+		t.Errorf("endpoint received %d requests", len(rc.requests))
+	}
+	if len(store.deliveries) != 1 || !strings.Contains(store.deliveries[0].Error, "not a public address") {
+		t.Errorf("unexpected delivery log %+v", store.deliveries)
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":    true,
+		"2606:2800:220::1": true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"0.0.0.0":          false,
+		"::":               false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if got := PublicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("PublicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestRunDeliversToSubscribers(t *testing.T) {
+	rc := &receiver{}
+	endpoint := httptest.NewServer(rc)
+	defer endpoint.Close()
+
+	store := &stubStore{hooks: []models.Webhook{
+		{ID: "subscribed", URL: endpoint.URL, Events: []string{models.EventTodoCreated}, Active: true},
+		{ID: "other event", URL: endpoint.URL, Events: []string{models.EventTodoDeleted}, Active: true},
+		{ID: "disabled", URL: endpoint.URL, Events: []string{models.EventTodoCreated}},
+		{ID: "other owner", URL: endpoint.URL, Events: []string{models.EventTodoCreated}, Active: true, Owner: "bob"},
+	}}
+	d := newDispatcher(store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+
+	d.Publish(event)
+	deadline := time.After(5 * time.Second)
+	for {
+		store.mu.Lock()
+		delivered := len(store.deliveries)
+		store.mu.Unlock()
+		if delivered > 0 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("event was not delivered")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	cancel()
+	<-done
+
+	if len(store.deliveries) != 1 || store.deliveries[0].WebhookID != "subscribed" {
+		t.Errorf("unexpected deliveries %+v", store.deliveries)
+	}
+}